@@ -0,0 +1,11 @@
+#!/bin/bash
+
+
+if [ $# -ne 1 ]
+then
+    echo "Usage: ./get-admin-users.sh <JSON Web Token of an admin>"
+    exit 1
+fi
+JSON_WEB_TOKEN=$1
+
+curl -v -u $JSON_WEB_TOKEN:NOT -H "Content-Type: application/json" -X GET "http://localhost:4047/admin/users?offset=0&limit=20"
@@ -0,0 +1,223 @@
+// The audit package.
+// Writes the security audit log: an append-only file of structured records, one JSON object per line.
+// Each record contains the hash of the previous record, so changing or removing a record breaks the chain (see Verify).
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcomes of the audited actions.
+const (
+	Success = "success"
+	Failure = "failure"
+	Denied  = "denied"
+)
+
+// Actor is who did the action (the email of the token, or the email given in e.g. login), Target the account
+// the action was done to. Seq, Time, PrevHash and Hash are set by the Logger.
+type Record struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Outcome   string    `json:"outcome"`
+	Ip        string    `json:"ip"`
+	RequestId string    `json:"request-id"`
+	Details   string    `json:"details,omitempty"`
+	PrevHash  string    `json:"prev-hash"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// The hash of the record is the SHA-256 of its JSON without the hash itself.
+// NOTE: The previous hash is part of the JSON, which chains the records.
+func (record Record) computeHash() (string, error) {
+	record.Hash = ""
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Logger appends the records to the audit log file.
+type Logger struct {
+	mutex    sync.Mutex
+	file     *os.File
+	seq      int64
+	prevHash string
+}
+
+// Opens the audit log file for appending. If the file already has records, the chain continues from the last record.
+func NewLogger(filename string) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, err
+	}
+	logger := &Logger{}
+	if last, ok, err := lastRecord(filename); err != nil {
+		return nil, err
+	} else if ok {
+		logger.seq, logger.prevHash = last.Seq, last.Hash
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	logger.file = file
+	return logger, nil
+}
+
+// Reads the last record of the file, ok is false if the file does not exist or is empty.
+func lastRecord(filename string) (last Record, ok bool, err error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return last, false, nil
+	} else if err != nil {
+		return last, false, err
+	}
+	defer file.Close()
+	var line []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordBytes)
+	for scanner.Scan() {
+		if !isBlank(scanner.Bytes()) {
+			line = append(line[:0], scanner.Bytes()...)
+		}
+	}
+	if err = scanner.Err(); err != nil || line == nil {
+		return last, false, err
+	}
+	if err = json.Unmarshal(line, &last); err != nil || last.Hash == "" {
+		return last, false, errors.New("The last record of the audit log " + filename + " is corrupted, run verify-audit-log")
+	}
+	return last, true, nil
+}
+
+// Appends the record to the file and syncs the file, so that the record survives a crash.
+func (logger *Logger) Log(record Record) error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	record.Seq = logger.seq + 1
+	record.Time = time.Now().UTC()
+	record.PrevHash = logger.prevHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = logger.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = logger.file.Sync(); err != nil {
+		return err
+	}
+	logger.seq, logger.prevHash = record.Seq, record.Hash
+	return nil
+}
+
+func (logger *Logger) Close() error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	return logger.file.Close()
+}
+
+// The source of the request whose handling is audited, see ContextWithSource.
+type Source struct {
+	Ip        string
+	RequestId string
+}
+
+type contextKey int
+
+const sourceKey contextKey = 0
+
+// Returns a context with the source of the request, Log takes the IP and the request id of the record from it.
+func ContextWithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey, source)
+}
+
+func SourceFromContext(ctx context.Context) (source Source, ok bool) {
+	source, ok = ctx.Value(sourceKey).(Source)
+	return source, ok
+}
+
+// Logger singleton, nil if the audit log is disabled (audit_log_file is empty).
+// NOTE: Read with currentLogger, since SetLogger may replace it while requests are being served.
+var myLogger = initLogger()
+var myLoggerMutex sync.RWMutex
+
+func currentLogger() *Logger {
+	myLoggerMutex.RLock()
+	defer myLoggerMutex.RUnlock()
+	return myLogger
+}
+
+func initLogger() *Logger {
+	defer util.LogEnter().Exit()
+	filename := util.MyConfig["audit_log_file"]
+	if filename == "" {
+		util.LogWarn("Audit log disabled, audit_log_file is empty")
+		return nil
+	}
+	logger, err := NewLogger(filename)
+	if err != nil {
+		util.LogError("Audit log disabled, couldn't open audit log: " + err.Error())
+		return nil
+	}
+	return logger
+}
+
+// Replaces the logger singleton, nil disables the audit log. Returns the previous logger.
+// Used e.g. in tests to write the audit log to a temporary file.
+func SetLogger(logger *Logger) (previous *Logger) {
+	myLoggerMutex.Lock()
+	defer myLoggerMutex.Unlock()
+	previous, myLogger = myLogger, logger
+	return previous
+}
+
+// Writes the record with the logger singleton. The IP and the request id are taken from the context unless given.
+// NOTE: A failure to write the audit log does not fail the audited action, it is logged as an error instead.
+func Log(ctx context.Context, record Record) {
+	defer util.LogEnter().Exit()
+	logger := currentLogger()
+	if logger == nil {
+		return
+	}
+	if source, ok := SourceFromContext(ctx); ok {
+		if record.Ip == "" {
+			record.Ip = source.Ip
+		}
+		if record.RequestId == "" {
+			record.RequestId = source.RequestId
+		}
+	}
+	if err := logger.Log(record); err != nil {
+		util.LogError("Couldn't write audit record " + record.Action + ": " + err.Error())
+	}
+}
+
+// Closes the audit log file. Call before the server exits.
+func Close() {
+	defer util.LogEnter().Exit()
+	if logger := currentLogger(); logger != nil {
+		logger.Close()
+	}
+}
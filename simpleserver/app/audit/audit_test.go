@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readRecords(t *testing.T, filename string) (records []Record) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Reading audit log failed: %s", err.Error())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid record %s: %s", line, err.Error())
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggerChainsRecords(t *testing.T) {
+	defer util.LogEnter().Exit()
+	filename := filepath.Join(t.TempDir(), "audit", "audit.log")
+	logger, err := NewLogger(filename)
+	if err != nil {
+		t.Fatalf("Creating logger failed: %s", err.Error())
+	}
+	logger.Log(Record{Actor: "kari.karttinen@foo.com", Action: "LOGIN", Target: "kari.karttinen@foo.com", Outcome: Success})
+	logger.Log(Record{Actor: "kari.karttinen@foo.com", Action: "PASSWORD_CHANGED", Target: "kari.karttinen@foo.com", Outcome: Success})
+	logger.Close()
+	// The chain continues after reopening.
+	if logger, err = NewLogger(filename); err != nil {
+		t.Fatalf("Reopening logger failed: %s", err.Error())
+	}
+	logger.Log(Record{Action: "LOGIN", Target: "timo.tillinen@foo.com", Outcome: Failure, Details: "invalid credentials"})
+	logger.Close()
+	records := readRecords(t, filename)
+	if len(records) != 3 {
+		t.Fatalf("Should have had 3 records, got: %v", records)
+	}
+	for i, record := range records {
+		if record.Seq != int64(i+1) || record.Hash == "" || record.Time.IsZero() {
+			t.Errorf("Record %d was not filled: %v", i, record)
+		}
+		if i > 0 && record.PrevHash != records[i-1].Hash {
+			t.Errorf("Record %d should have had the hash of the previous record", i)
+		}
+	}
+	if records[0].PrevHash != "" {
+		t.Errorf("First record should not have had a previous hash: %v", records[0])
+	}
+}
+
+func TestNewLoggerCorruptedLastRecord(t *testing.T) {
+	defer util.LogEnter().Exit()
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	ioutil.WriteFile(filename, []byte("{\"seq\":1,\"act"), 0600)
+	if _, err := NewLogger(filename); err == nil {
+		t.Errorf("Logger should not have continued a corrupted audit log")
+	}
+}
+
+func TestLogTakesSourceFromContext(t *testing.T) {
+	defer util.LogEnter().Exit()
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	logger, _ := NewLogger(filename)
+	previous := SetLogger(logger)
+	defer func() { SetLogger(previous) }()
+	ctx := ContextWithSource(context.Background(), Source{"192.0.2.1", "request-1"})
+	Log(ctx, Record{Action: "LOGIN", Outcome: Success})
+	Log(ctx, Record{Action: "ACCOUNT_LOCKED", Outcome: Denied, Ip: "192.0.2.2"})
+	Log(context.Background(), Record{Action: "TOKEN_REJECTED", Outcome: Denied})
+	logger.Close()
+	records := readRecords(t, filename)
+	if records[0].Ip != "192.0.2.1" || records[0].RequestId != "request-1" {
+		t.Errorf("Source should have been taken from the context: %v", records[0])
+	}
+	if records[1].Ip != "192.0.2.2" || records[1].RequestId != "request-1" {
+		t.Errorf("Given IP should have been kept: %v", records[1])
+	}
+	if records[2].Ip != "" || records[2].RequestId != "" {
+		t.Errorf("Record without source should not have had IP or request id: %v", records[2])
+	}
+	// Disabled audit log does nothing.
+	SetLogger(nil)
+	Log(ctx, Record{Action: "LOGIN", Outcome: Success})
+}
+
+// A log resumed after blank lines, e.g. a newline added by an editor, still verifies.
+func TestLoggerContinuesAfterBlankLines(t *testing.T) {
+	defer util.LogEnter().Exit()
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	logger, _ := NewLogger(filename)
+	logger.Log(Record{Action: "LOGIN", Outcome: Success})
+	logger.Close()
+	file, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString("\n \n")
+	file.Close()
+	logger, err := NewLogger(filename)
+	if err != nil {
+		t.Fatalf("Reopening logger failed: %s", err.Error())
+	}
+	logger.Log(Record{Action: "LOGOUT", Outcome: Success})
+	logger.Close()
+	if count, _, err := VerifyFile(filename); err != nil || count != 2 {
+		t.Errorf("Log with blank lines should have verified, got: %d, %v", count, err)
+	}
+}
+
+func TestSetLoggerWhileLogging(t *testing.T) {
+	defer util.LogEnter().Exit()
+	logger, _ := NewLogger(filepath.Join(t.TempDir(), "audit.log"))
+	defer logger.Close()
+	previous := SetLogger(nil)
+	defer func() { SetLogger(previous) }()
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			Log(context.Background(), Record{Action: "LOGIN", Outcome: Success})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SetLogger(logger)
+		SetLogger(nil)
+	}
+	<-done
+}
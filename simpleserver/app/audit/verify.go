@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+)
+
+// The longest record line Verify accepts.
+const maxRecordBytes = 1024 * 1024
+
+// Blank lines are skipped when reading the log, e.g. a newline appended to the file by an editor.
+func isBlank(line []byte) bool {
+	return len(bytes.TrimSpace(line)) == 0
+}
+
+// ChainError tells the first line where the chain is broken.
+type ChainError struct {
+	Line int
+	Msg  string
+}
+
+func (e ChainError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// Verifies the hash chain of the audit log: the records are numbered from 1, each record has the hash of the previous
+// record and the hash of each record matches its content. Blank lines are skipped like the logger skips them when
+// it continues the chain. Returns the number of records and the hash of the last record.
+// NOTE: Removing records from the end of the log does not break the chain. To detect that, compare the returned
+// count and hash to the ones of an earlier verification.
+func Verify(reader io.Reader) (count int, lastHash string, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if isBlank(scanner.Bytes()) {
+			continue
+		}
+		var record Record
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&record); err != nil {
+			return count, lastHash, ChainError{line, "not a valid record: " + err.Error()}
+		}
+		if record.Seq != int64(count+1) {
+			return count, lastHash, ChainError{line, "expected seq " + strconv.Itoa(count+1) + ", got " + strconv.FormatInt(record.Seq, 10)}
+		}
+		if record.PrevHash != lastHash {
+			return count, lastHash, ChainError{line, "prev-hash does not match the hash of the previous record"}
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return count, lastHash, ChainError{line, err.Error()}
+		}
+		if record.Hash != hash {
+			return count, lastHash, ChainError{line, "hash does not match the content of the record"}
+		}
+		count++
+		lastHash = record.Hash
+	}
+	return count, lastHash, scanner.Err()
+}
+
+func VerifyFile(filename string) (count int, lastHash string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return Verify(file)
+}
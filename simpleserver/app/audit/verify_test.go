@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes count records and returns the lines of the audit log.
+func writeTestLog(t *testing.T, count int) []string {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewLogger(filename)
+	if err != nil {
+		t.Fatalf("Creating logger failed: %s", err.Error())
+	}
+	for i := 0; i < count; i++ {
+		logger.Log(Record{Actor: "admin@foo.com", Action: "ADMIN_DISABLE_USER", Target: "timo.tillinen@foo.com", Outcome: Success})
+	}
+	logger.Close()
+	content, _ := ioutil.ReadFile(filename)
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestVerify(t *testing.T) {
+	defer util.LogEnter().Exit()
+	lines := writeTestLog(t, 3)
+	count, lastHash, err := Verify(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	if err != nil || count != 3 || lastHash == "" || !strings.Contains(lines[2], lastHash) {
+		t.Errorf("Intact log should have verified, got: %d, %s, %v", count, lastHash, err)
+	}
+	if count, _, err = Verify(strings.NewReader(lines[0] + "\n\n" + lines[1] + "\n \n")); err != nil || count != 2 {
+		t.Errorf("Blank lines should have been skipped, got: %d, %v", count, err)
+	}
+	if count, _, err = Verify(strings.NewReader("")); err != nil || count != 0 {
+		t.Errorf("Empty log should have verified, got: %d, %v", count, err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	defer util.LogEnter().Exit()
+	lines := writeTestLog(t, 3)
+	tests := []struct {
+		name  string
+		lines []string
+		line  int
+	}{
+		{"changed record", []string{lines[0], strings.Replace(lines[1], "timo.tillinen", "kari.karttinen", 1), lines[2]}, 2},
+		{"removed record", []string{lines[0], lines[2]}, 2},
+		{"reordered records", []string{lines[1], lines[0], lines[2]}, 1},
+		{"inserted record", []string{lines[0], lines[0], lines[1], lines[2]}, 2},
+		{"garbage", []string{lines[0], "not json"}, 2},
+		{"unknown field", []string{strings.Replace(lines[0], "{", "{\"x\":1,", 1)}, 1},
+	}
+	for _, test := range tests {
+		count, _, err := Verify(strings.NewReader(strings.Join(test.lines, "\n")))
+		chainError, ok := err.(ChainError)
+		if !ok || chainError.Line != test.line || count != test.line-1 {
+			t.Errorf("%s should have broken the chain at line %d, got: %d, %v", test.name, test.line, count, err)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	defer util.LogEnter().Exit()
+	if _, _, err := VerifyFile(filepath.Join(t.TempDir(), "not-found.log")); err == nil {
+		t.Errorf("Verifying a missing file should have failed")
+	}
+}
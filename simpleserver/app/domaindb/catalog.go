@@ -0,0 +1,163 @@
+package domaindb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Editing the catalog, see the admin catalog API of webserver.
+
+// CatalogEditor is implemented by the stores whose catalog can be changed.
+// The changes are optimistic: the caller gives the version of the product group or product it saw
+// (see ProductGroupVersion and ProductVersion), and the change fails with VersionConflictError
+// if somebody else has changed it in between.
+type CatalogEditor interface {
+	ProductStore
+	// Adds the product group under the parent product group (0 for the top level) with the schema of its products,
+	// pgId 0 adds it with the next free id. Returns the id. A parent that does not exist fails with InvalidParentError.
+	AddProductGroup(ctx context.Context, pgId int, parentId int, name string, schema Schema) (int, error)
+	// Renames the product group, moves it under the parent and changes its schema, a nil schema keeps the schema.
+	// The schema of a product group with products cannot be changed, it fails with ProductGroupNotEmptyError.
+	// A product group cannot be moved under itself or the product groups under it, it fails with InvalidParentError.
+	UpdateProductGroup(ctx context.Context, pgId int, parentId int, name string, schema Schema, version string) error
+	// Only an empty product group can be deleted: without products (ProductGroupNotEmptyError) and without product
+	// groups under it (ProductGroupHasChildrenError).
+	DeleteProductGroup(ctx context.Context, pgId int, version string) error
+	// Adds the product to its product group, an empty product id adds it with the next free id in the group.
+	// Returns the product as stored. A product that is not valid for the schema fails with InvalidProductError.
+	AddProduct(ctx context.Context, product []string) ([]string, error)
+	UpdateProduct(ctx context.Context, product []string, version string) error
+	DeleteProduct(ctx context.Context, pgId int, pId int, version string) error
+	// Returns the whole catalog, e.g. for WriteCatalog.
+	ExportCatalog(ctx context.Context) (Catalog, error)
+	// Replaces the whole catalog, e.g. with the catalog of ReadCatalog, which has checked it.
+	ImportCatalog(ctx context.Context, catalog Catalog) error
+}
+
+type ProductGroupNotFoundError struct {
+	PgId int
+}
+
+func (e ProductGroupNotFoundError) Error() string {
+	return "Product group not found: " + strconv.Itoa(e.PgId)
+}
+
+type ProductNotFoundError struct {
+	PgId int
+	PId  int
+}
+
+func (e ProductNotFoundError) Error() string {
+	return "Product not found: " + strconv.Itoa(e.PgId) + "/" + strconv.Itoa(e.PId)
+}
+
+// AlreadyExistsError is returned when adding a product group or a product with an id that is already used.
+type AlreadyExistsError struct {
+	What string
+}
+
+func (e AlreadyExistsError) Error() string {
+	return e.What + " already exists"
+}
+
+// VersionConflictError is returned if the product group or product has changed since the caller read it.
+type VersionConflictError struct {
+	What string
+}
+
+func (e VersionConflictError) Error() string {
+	return e.What + " has been changed by somebody else, get it again and retry"
+}
+
+type ProductGroupNotEmptyError struct {
+	PgId int
+}
+
+func (e ProductGroupNotEmptyError) Error() string {
+	return "Product group " + strconv.Itoa(e.PgId) + " has products, delete them first"
+}
+
+type ProductGroupHasChildrenError struct {
+	PgId int
+}
+
+func (e ProductGroupHasChildrenError) Error() string {
+	return "Product group " + strconv.Itoa(e.PgId) + " has product groups under it, move or delete them first"
+}
+
+// InvalidParentError is returned if the parent of a product group does not exist, or is the product group itself
+// or under it.
+type InvalidParentError struct {
+	PgId     int
+	ParentId int
+	Problem  string
+}
+
+func (e InvalidParentError) Error() string {
+	return "Invalid parent " + strconv.Itoa(e.ParentId) + " of product group " + strconv.Itoa(e.PgId) + ": " + e.Problem
+}
+
+// Parses the product group id and the product id of the product, an empty product id is 0.
+func productIds(product []string) (pgId int, pId int, err error) {
+	if len(product) < len(BaseProductFields) {
+		return 0, 0, errors.New("Product has " + strconv.Itoa(len(product)) + " fields, expected at least " + strconv.Itoa(len(BaseProductFields)))
+	}
+	if pgId, err = strconv.Atoi(product[1]); err != nil {
+		return 0, 0, errors.New("Product group id is not an integer: " + product[1])
+	}
+	if product[0] != "" {
+		if pId, err = strconv.Atoi(product[0]); err != nil {
+			return 0, 0, errors.New("Product id is not an integer: " + product[0])
+		}
+	}
+	return pgId, pId, nil
+}
+
+// NOTE: The version is a hash of the content, so it needs no storage and stays the same over restarts.
+func contentVersion(fields ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\t")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func ProductGroupVersion(pgId int, parentId int, name string, schema Schema) string {
+	fields := []string{strconv.Itoa(pgId), strconv.Itoa(parentId), name}
+	for _, row := range schema.rows() {
+		fields = append(fields, strings.Join(row, ","))
+	}
+	return contentVersion(fields...)
+}
+
+func ProductVersion(product []string) string {
+	return contentVersion(product...)
+}
+
+const maxTitleLength = 200
+const maxTextLength = 100
+
+var priceRegexp = regexp.MustCompile(`^[0-9]{1,9}(\.[0-9]{1,2})?$`)
+var yearRegexp = regexp.MustCompile(`^[0-9]{1,4}$`)
+
+// Returns what is wrong with the text: required, at most maxLength characters and on one line.
+// NOTE: Tabs and line feeds would break the TSV files.
+func validateText(text string, maxLength int) (errors []string) {
+	if strings.TrimSpace(text) == "" {
+		return []string{"is required"}
+	}
+	if len([]rune(text)) > maxLength {
+		errors = append(errors, "must be at most "+strconv.Itoa(maxLength)+" characters")
+	}
+	if strings.IndexFunc(text, unicode.IsControl) != -1 {
+		errors = append(errors, "must not contain tabs, line feeds or other control characters")
+	}
+	return errors
+}
+
+func ValidateProductGroupName(name string) []string {
+	return validateText(name, maxTextLength)
+}
@@ -0,0 +1,150 @@
+package domaindb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateProduct(t *testing.T) {
+	defer util.LogEnter().Exit()
+	store, err := NewTsvStore(ResourceDir())
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	for pgId, rawProducts := range store.rawProductsMap {
+		for _, product := range rawProducts.RawProductsList {
+			if fieldErrors := ValidateProduct(product, DefaultSchema); len(fieldErrors) > 0 {
+				t.Errorf("Product %d/%s should have been valid, got: %v", pgId, product[0], fieldErrors)
+			}
+		}
+	}
+	fieldErrors := ValidateProduct([]string{"", "1", " ", "1.234", strings.Repeat("x", 101), "year", "Fin\tland", "Finnish"}, DefaultSchema)
+	for _, field := range []string{"title", "price", "author-or-director", "year", "country"} {
+		if len(fieldErrors[field]) == 0 {
+			t.Errorf("Field %s should have been invalid, got: %v", field, fieldErrors)
+		}
+	}
+	if len(fieldErrors) != 5 {
+		t.Errorf("Only the invalid fields should have had errors, got: %v", fieldErrors)
+	}
+	if errors := ValidateProductGroupName("Games"); len(errors) > 0 {
+		t.Errorf("Name should have been valid, got: %v", errors)
+	}
+	if errors := ValidateProductGroupName("Ga\nmes"); len(errors) == 0 {
+		t.Errorf("Name with a line feed should have been invalid")
+	}
+}
+
+func TestVersions(t *testing.T) {
+	defer util.LogEnter().Exit()
+	product := []string{"10", "1", "Kalevala", "3.95", "Elias Lönnrot", "1835", "Finland", "Finnish"}
+	changed := append([]string(nil), product...)
+	changed[3] = "3.96"
+	if ProductVersion(product) != ProductVersion(product) || ProductVersion(product) == ProductVersion(changed) {
+		t.Errorf("The version should have changed only with the content")
+	}
+	if ProductGroupVersion(1, 0, "Books", DefaultSchema) == ProductGroupVersion(2, 0, "Books", DefaultSchema) {
+		t.Errorf("The version should have depended on the id")
+	}
+	if ProductGroupVersion(1, 0, "Books", DefaultSchema) == ProductGroupVersion(1, 0, "Books", DefaultSchema[:3]) {
+		t.Errorf("The version should have depended on the schema")
+	}
+}
+
+var kalevala = []string{"10", "1", "Kalevala", "3.95", "Elias Lönnrot", "1835", "Finland", "Finnish"}
+
+// The behavior every CatalogEditor must have, run against the small catalog.
+// NOTE: Each store gets files of its own, since the TSV store writes the changes to its files.
+func TestEditCatalog(t *testing.T) {
+	defer util.LogEnter().Exit()
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			testEditCatalog(t, testStore.open(t, writeTsvFiles(t, smallCatalog)))
+		})
+	}
+}
+
+func testEditCatalog(t *testing.T, store ProductStore) {
+	ctx := context.Background()
+	editor, ok := store.(CatalogEditor)
+	if !ok {
+		t.Fatalf("Store should have been a CatalogEditor: %T", store)
+	}
+	if pgId, err := editor.AddProductGroup(ctx, 0, 0, "Music", nil); err != nil || pgId != 4 {
+		t.Errorf("Product group should have got the next id 4, got: %d, %v", pgId, err)
+	}
+	if _, err := editor.AddProductGroup(ctx, 3, 0, "Games", nil); err == nil {
+		t.Errorf("Adding an existing product group should have failed")
+	} else if _, ok := err.(AlreadyExistsError); !ok {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := editor.UpdateProductGroup(ctx, 4, 0, "Records", nil, "stale"); err == nil {
+		t.Errorf("Updating with a stale version should have failed")
+	} else if _, ok := err.(VersionConflictError); !ok {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := editor.UpdateProductGroup(ctx, 4, 0, "Records", nil, ProductGroupVersion(4, 0, "Music", DefaultSchema)); err != nil {
+		t.Errorf("Updating the product group failed: %s", err.Error())
+	}
+	if _, ok := editor.UpdateProductGroup(ctx, 9, 0, "Nothing", nil, "").(ProductGroupNotFoundError); !ok {
+		t.Errorf("Updating a missing product group should have failed with ProductGroupNotFoundError")
+	}
+	if productGroups, _ := editor.GetProductGroups(ctx); productGroups.ProductGroupsMap["4"] != "Records" {
+		t.Errorf("Product group should have been updated, got: %v", productGroups)
+	}
+	// Products.
+	added, err := editor.AddProduct(ctx, []string{"", "1", "Seitsemän veljestä", "12.50", "Aleksis Kivi", "1870", "Finland", "Finnish"})
+	if err != nil || added[0] != "12" {
+		t.Errorf("Product should have got the next id 12, got: %v, %v", added, err)
+	}
+	if _, err := editor.AddProduct(ctx, kalevala); err == nil {
+		t.Errorf("Adding an existing product should have failed")
+	} else if _, ok := err.(AlreadyExistsError); !ok {
+		t.Errorf("Wrong error: %v", err)
+	}
+	missingGroup := append([]string(nil), kalevala...)
+	missingGroup[1] = "9"
+	if _, err := editor.AddProduct(ctx, missingGroup); err == nil {
+		t.Errorf("Adding a product to a missing product group should have failed")
+	} else if _, ok := err.(ProductGroupNotFoundError); !ok {
+		t.Errorf("Wrong error: %v", err)
+	}
+	changed := append([]string(nil), kalevala...)
+	changed[3] = "4.95"
+	if _, ok := editor.UpdateProduct(ctx, changed, ProductVersion(changed)).(VersionConflictError); !ok {
+		t.Errorf("Updating with the new version instead of the current one should have failed")
+	}
+	if err := editor.UpdateProduct(ctx, changed, ProductVersion(kalevala)); err != nil {
+		t.Errorf("Updating the product failed: %s", err.Error())
+	}
+	if product, _ := editor.GetProduct(ctx, 1, 10); !reflect.DeepEqual(product.Product, changed) {
+		t.Errorf("Product should have been updated, got: %v", product)
+	}
+	if products, _ := editor.GetProducts(ctx, 1); len(products.ProductsList) != 3 {
+		t.Errorf("Product group should have had 3 products, got: %v", products)
+	}
+	if _, ok := editor.DeleteProduct(ctx, 1, 99, "").(ProductNotFoundError); !ok {
+		t.Errorf("Deleting a missing product should have failed with ProductNotFoundError")
+	}
+	if _, ok := editor.DeleteProductGroup(ctx, 1, ProductGroupVersion(1, 0, "Books", DefaultSchema)).(ProductGroupNotEmptyError); !ok {
+		t.Errorf("Deleting a product group with products should have failed with ProductGroupNotEmptyError")
+	}
+	for i, product := range [][]string{added, changed, kalevala} {
+		pId, _ := strconv.Atoi(product[0])
+		if err := editor.DeleteProduct(ctx, 1, pId, ProductVersion(product)); err != nil && i < 2 {
+			t.Errorf("Deleting product %s failed: %s", product[0], err.Error())
+		} else if err == nil && i == 2 {
+			t.Errorf("Deleting an already deleted product should have failed")
+		}
+	}
+	if err := editor.DeleteProductGroup(ctx, 3, ProductGroupVersion(3, 0, "Games", DefaultSchema)); err != nil {
+		t.Errorf("Deleting an empty product group failed: %s", err.Error())
+	}
+	if products, _ := editor.GetProducts(ctx, 3); products.Ret != "" {
+		t.Errorf("Deleted product group should not have been found, got: %v", products)
+	}
+}
@@ -0,0 +1,133 @@
+package domaindb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"strconv"
+)
+
+// The product groups are nested categories, e.g. Movies > Drama > Crime: each product group may have a parent
+// product group. A product belongs to one product group, and the products of a category are the products of its
+// product group and of the product groups under it.
+// The parents are in product-group-parents.csv (id, parent id), a product group without a row is at the top level.
+
+// CategoryTree is a product group with the product groups under it, in the order of their ids.
+type CategoryTree struct {
+	PgId     int            `json:"pg-id"`
+	Name     string         `json:"name"`
+	Children []CategoryTree `json:"children"`
+}
+
+// Returns the ids of the product groups under each product group, 0 for the top level.
+func (categories Categories) children() map[int][]int {
+	children := make(map[int][]int)
+	for _, category := range categories.Categories {
+		children[category.ParentId] = append(children[category.ParentId], category.PgId)
+	}
+	return children
+}
+
+func (categories Categories) byId() map[int]Category {
+	byId := make(map[int]Category, len(categories.Categories))
+	for _, category := range categories.Categories {
+		byId[category.PgId] = category
+	}
+	return byId
+}
+
+func (categories Categories) Find(pgId int) (category Category, found bool) {
+	category, found = categories.byId()[pgId]
+	return category, found
+}
+
+// Returns the top level product groups with the product groups under them.
+func (categories Categories) Tree() []CategoryTree {
+	children, byId := categories.children(), categories.byId()
+	var subtrees func(parentId int) []CategoryTree
+	subtrees = func(parentId int) []CategoryTree {
+		trees := []CategoryTree{}
+		for _, pgId := range children[parentId] {
+			trees = append(trees, CategoryTree{pgId, byId[pgId].Name, subtrees(pgId)})
+		}
+		return trees
+	}
+	return subtrees(0)
+}
+
+// Returns the path from the top level to the product group, e.g. Movies, Drama, Crime. Nil if there is no such group.
+func (categories Categories) Breadcrumb(pgId int) []Category {
+	byId := categories.byId()
+	var path []Category
+	for category, found := byId[pgId]; found && len(path) <= len(byId); category, found = byId[category.ParentId] {
+		path = append([]Category{category}, path...)
+	}
+	return path
+}
+
+// Returns the product group and the product groups under it, depth first in the order of their ids.
+// Empty if there is no such group.
+// NOTE: The stores prevent loops, but a loop in the data (e.g. a database edited by hand) must not recurse forever.
+func (categories Categories) Descendants(pgId int) []int {
+	if _, found := categories.byId()[pgId]; !found {
+		return nil
+	}
+	children := categories.children()
+	var pgIds []int
+	visited := make(map[int]bool)
+	var visit func(id int)
+	visit = func(id int) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		pgIds = append(pgIds, id)
+		for _, child := range children[id] {
+			visit(child)
+		}
+	}
+	visit(pgId)
+	return pgIds
+}
+
+// Returns the products of the product group and the product groups under it, empty Ret if there is no such group.
+func GetCategoryProducts(ctx context.Context, store ProductStore, pgId int) (ret Products, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.GetCategoryProducts", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	categories, err := store.GetCategories(ctx)
+	if err == nil {
+		for _, id := range categories.Descendants(pgId) {
+			var products Products
+			if products, err = store.GetProducts(ctx, id); err != nil {
+				break
+			}
+			// NOTE: A product group deleted in between has no products.
+			ret = Products{append(ret.ProductsList, products.ProductsList...), "ok"}
+		}
+	}
+	span.SetError(err)
+	if err != nil {
+		return Products{}, err
+	}
+	return ret, nil
+}
+
+// Returns what is wrong with the parent of the product group, empty if nothing: the parent must be another product group
+// and not under the product group. parents has the parent of every product group, 0 for a top level product group.
+func parentProblem(pgId int, parentId int, parents map[int]int) string {
+	if parentId == 0 {
+		return ""
+	}
+	if _, found := parents[parentId]; !found {
+		return "parent product group " + strconv.Itoa(parentId) + " does not exist"
+	}
+	// NOTE: Stops at a loop of the other product groups, which is the problem of those groups.
+	for id, steps := parentId, 0; id != 0 && steps <= len(parents); id, steps = parents[id], steps+1 {
+		if id == pgId {
+			return "product group " + strconv.Itoa(pgId) + " cannot be under itself"
+		}
+	}
+	return ""
+}
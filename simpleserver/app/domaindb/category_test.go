@@ -0,0 +1,193 @@
+package domaindb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// The TSV files of movieCatalog: Movies > Drama > Crime and Movies > Comedy.
+var movieFiles = map[string]string{
+	"product-groups.csv":        "2\tMovies\n3\tCrime\n5\tDrama\n6\tComedy\n",
+	"product-group-parents.csv": "3\t5\n5\t2\n6\t2\n",
+	"pg-2-products.csv":         "1\t2\tKoyaanisqatsi\t12.50\tGodfrey Reggio\t1982\tUnited States\tEnglish\n",
+	"pg-3-products.csv":         "1\t3\tThe Godfather\t9.90\tFrancis Ford Coppola\t1972\tUnited States\tEnglish\n",
+	"pg-5-products.csv":         "2\t5\tIkiru\t14.90\tAkira Kurosawa\t1952\tJapan\tJapanese\n",
+	"pg-6-products.csv":         "",
+}
+
+func TestCategories(t *testing.T) {
+	defer util.LogEnter().Exit()
+	categories := Categories{[]Category{{1, "Books", 0}, {2, "Movies", 0}, {3, "Crime", 5}, {5, "Drama", 2}, {6, "Comedy", 2}}, "ok"}
+	expected := []CategoryTree{
+		{1, "Books", []CategoryTree{}},
+		{2, "Movies", []CategoryTree{
+			{5, "Drama", []CategoryTree{{3, "Crime", []CategoryTree{}}}},
+			{6, "Comedy", []CategoryTree{}},
+		}},
+	}
+	if tree := categories.Tree(); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("Wrong tree: %v", tree)
+	}
+	if breadcrumb := categories.Breadcrumb(3); !reflect.DeepEqual(breadcrumb, []Category{{2, "Movies", 0}, {5, "Drama", 2}, {3, "Crime", 5}}) {
+		t.Errorf("Wrong breadcrumb: %v", breadcrumb)
+	}
+	if breadcrumb := categories.Breadcrumb(4); breadcrumb != nil {
+		t.Errorf("Missing product group should have had no breadcrumb, got: %v", breadcrumb)
+	}
+	if pgIds := categories.Descendants(2); !reflect.DeepEqual(pgIds, []int{2, 5, 3, 6}) {
+		t.Errorf("Wrong descendants: %v", pgIds)
+	}
+	if pgIds := categories.Descendants(4); pgIds != nil {
+		t.Errorf("Missing product group should have had no descendants, got: %v", pgIds)
+	}
+	if category, found := categories.Find(5); !found || category.Name != "Drama" {
+		t.Errorf("Wrong category: %v, %v", category, found)
+	}
+	loop := Categories{[]Category{{7, "Loop", 8}, {8, "Pool", 7}, {9, "Under", 8}}, "ok"}
+	if pgIds := loop.Descendants(7); !reflect.DeepEqual(pgIds, []int{7, 8, 9}) {
+		t.Errorf("Loop should have been visited once, got: %v", pgIds)
+	}
+}
+
+func TestParentProblem(t *testing.T) {
+	defer util.LogEnter().Exit()
+	parents := map[int]int{2: 0, 3: 5, 5: 2, 6: 2, 8: 9, 9: 8}
+	tests := []struct {
+		pgId     int
+		parentId int
+		problem  string
+	}{
+		{3, 0, ""},
+		{6, 5, ""},
+		{7, 3, ""},
+		{2, 4, "parent product group 4 does not exist"},
+		{2, 2, "product group 2 cannot be under itself"},
+		{2, 3, "product group 2 cannot be under itself"},
+		// The loop of 8 and 9 is their problem.
+		{6, 8, ""},
+	}
+	for _, test := range tests {
+		if problem := parentProblem(test.pgId, test.parentId, parents); problem != test.problem {
+			t.Errorf("Parent %d of %d should have had the problem %q, got: %q", test.parentId, test.pgId, test.problem, problem)
+		}
+	}
+}
+
+// The behavior every store must have with nested product groups.
+// NOTE: Each store gets files of its own, since the TSV store writes the changes to its files.
+func TestEditCategories(t *testing.T) {
+	defer util.LogEnter().Exit()
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			testEditCategories(t, testStore.open(t, writeTsvFiles(t, movieFiles)).(CatalogEditor))
+		})
+	}
+}
+
+func testEditCategories(t *testing.T, editor CatalogEditor) {
+	ctx := context.Background()
+	categories, err := editor.GetCategories(ctx)
+	if err != nil || !reflect.DeepEqual(categories.Categories, []Category{{2, "Movies", 0}, {3, "Crime", 5}, {5, "Drama", 2}, {6, "Comedy", 2}}) {
+		t.Errorf("Wrong categories: %v, %v", categories, err)
+	}
+	products, err := GetCategoryProducts(ctx, editor, 2)
+	expected := [][4]string{{"1", "2", "Koyaanisqatsi", "12.50"}, {"2", "5", "Ikiru", "14.90"}, {"1", "3", "The Godfather", "9.90"}}
+	if err != nil || products.Ret != "ok" || !reflect.DeepEqual(products.ProductsList, expected) {
+		t.Errorf("Product group should have had the products of the product groups under it, got: %v, %v", products, err)
+	}
+	if products, err = GetCategoryProducts(ctx, editor, 9); err != nil || products.Ret != "" {
+		t.Errorf("Missing product group should have had no products, got: %v, %v", products, err)
+	}
+	if _, err = editor.AddProductGroup(ctx, 0, 9, "Horror", nil); err == nil {
+		t.Errorf("Adding under a missing product group should have failed")
+	} else if _, ok := err.(InvalidParentError); !ok {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if _, err = editor.AddProductGroup(ctx, 8, 6, "Slapstick", nil); err != nil {
+		t.Fatalf("Adding the product group failed: %s", err.Error())
+	}
+	err = editor.UpdateProductGroup(ctx, 2, 8, "Movies", nil, ProductGroupVersion(2, 0, "Movies", DefaultSchema))
+	if _, ok := err.(InvalidParentError); !ok {
+		t.Errorf("Moving a product group under itself should have failed, got: %v", err)
+	}
+	if err = editor.DeleteProductGroup(ctx, 6, ProductGroupVersion(6, 0, "Comedy", DefaultSchema)); err == nil {
+		t.Errorf("The version should have depended on the parent")
+	}
+	err = editor.DeleteProductGroup(ctx, 6, ProductGroupVersion(6, 2, "Comedy", DefaultSchema))
+	if _, ok := err.(ProductGroupHasChildrenError); !ok {
+		t.Errorf("Deleting a product group with product groups under it should have failed, got: %v", err)
+	}
+	if err = editor.UpdateProductGroup(ctx, 8, 0, "Slapstick", nil, ProductGroupVersion(8, 6, "Slapstick", DefaultSchema)); err != nil {
+		t.Fatalf("Moving the product group to the top level failed: %s", err.Error())
+	}
+	if err = editor.DeleteProductGroup(ctx, 6, ProductGroupVersion(6, 2, "Comedy", DefaultSchema)); err != nil {
+		t.Errorf("Deleting the product group failed: %s", err.Error())
+	}
+	categories, _ = editor.GetCategories(ctx)
+	if !reflect.DeepEqual(categories.Categories, []Category{{2, "Movies", 0}, {3, "Crime", 5}, {5, "Drama", 2}, {8, "Slapstick", 0}}) {
+		t.Errorf("Wrong categories: %v", categories)
+	}
+	if catalog, _ := editor.ExportCatalog(ctx); !reflect.DeepEqual(catalog.Parents, map[string]string{"3": "5", "5": "2"}) {
+		t.Errorf("Wrong parents: %v", catalog.Parents)
+	}
+}
+
+func TestTsvStoreSavesParents(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := writeTsvFiles(t, movieFiles)
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	ctx := context.Background()
+	if _, err = store.AddProductGroup(ctx, 4, 3, "Noir", nil); err != nil {
+		t.Fatalf("Adding the product group failed: %s", err.Error())
+	}
+	if parents, _ := ioutil.ReadFile(filepath.Join(dir, "product-group-parents.csv")); string(parents) != "3\t5\n4\t3\n5\t2\n6\t2\n" {
+		t.Errorf("Parents should have been saved to the file, got: %q", parents)
+	}
+	if err = store.ImportCatalog(ctx, trickyCatalog); err != nil {
+		t.Fatalf("Importing failed: %s", err.Error())
+	}
+	if _, err = os.Stat(filepath.Join(dir, "product-group-parents.csv")); !os.IsNotExist(err) {
+		t.Errorf("Parents file of a catalog without parents should have been removed, got: %v", err)
+	}
+}
+
+// Two concurrent moves must not together put Drama and Comedy under each other.
+func TestConcurrentMoves(t *testing.T) {
+	defer util.LogEnter().Exit()
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			editor := testStore.open(t, writeTsvFiles(t, movieFiles)).(CatalogEditor)
+			ctx := context.Background()
+			moves := []struct{ pgId, parentId int }{{5, 6}, {6, 5}}
+			errs := make([]error, len(moves))
+			var wg sync.WaitGroup
+			for i, move := range moves {
+				wg.Add(1)
+				go func(i int, pgId int, parentId int) {
+					defer wg.Done()
+					name := map[int]string{5: "Drama", 6: "Comedy"}[pgId]
+					errs[i] = editor.UpdateProductGroup(ctx, pgId, parentId, name, nil, ProductGroupVersion(pgId, 2, name, DefaultSchema))
+				}(i, move.pgId, move.parentId)
+			}
+			wg.Wait()
+			if errs[0] == nil && errs[1] == nil {
+				t.Errorf("Only one of the moves should have succeeded")
+			}
+			categories, _ := editor.GetCategories(ctx)
+			for _, pgId := range []int{5, 6} {
+				if breadcrumb := categories.Breadcrumb(pgId); len(breadcrumb) == 0 || breadcrumb[0].PgId != 2 {
+					t.Errorf("Product group %d should have been under Movies, got: %v", pgId, breadcrumb)
+				}
+			}
+		})
+	}
+}
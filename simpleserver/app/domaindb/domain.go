@@ -1,26 +1,22 @@
+// The domaindb package.
+// The product catalog: product groups and their products, served through the ProductStore interface.
+
 package domaindb
 
 import (
-	"encoding/csv"
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
 	"github.com/karimarttila/go/simpleserver/app/util"
-	"os"
-	"path"
-	"path/filepath"
-	"runtime"
-	"strconv"
-	"strings"
 )
 
-// DomainDB singleton.
-var myDomainDB = initDomainDb()
-
 type ProductGroups struct {
 	Flag             bool              `json:"-"` // Just to tell the whether we have initialized this struct or not (zero-value for bool is false, i.e. if the value is ready we know that we have initialized the struct).
 	ProductGroupsMap map[string]string `json:"product-groups"`
 }
 
 type RawProducts struct {
-	RawProductsList [][8]string `json:"raw-product-groups"`
+	RawProductsList [][]string `json:"raw-product-groups"`
 }
 
 type Products struct {
@@ -28,133 +24,57 @@ type Products struct {
 	Ret          string      `json:"ret"`
 }
 
+// The product is its id, product group id, title and price followed by its attribute values, see Schema.
 type Product struct {
-	Product [8]string `json:"product"`
-	Ret     string    `json:"ret"`
+	Product []string `json:"product"`
+	Ret     string   `json:"ret"`
 }
 
-type DomainDb struct {
-	productGroups  ProductGroups
-	rawProductsMap map[int]RawProducts
-	productsMap    map[int]Products
+// The attributes of the products of the product group, see Schema.
+type ProductGroupSchema struct {
+	PgId       int    `json:"pg-id"`
+	Attributes Schema `json:"attributes"`
+	Ret        string `json:"ret"`
 }
 
-func readCsvFile(csvFileName string) [][]string {
-	util.LogEnter()
-	var lines [][]string
-	dir, _ := os.Getwd()
-	util.LogDebug("dir: " + dir)
-	fileName := []string{"../../resources/" + csvFileName}
-	_, dirName, _, _ := runtime.Caller(0)
-	filePath := path.Join(filepath.Dir(dirName), strings.Join(fileName, ""))
-	csvFile, err := os.Open(filePath)
-	if err != nil {
-		util.LogError("Failed to open csv file: " + filePath)
-	} else {
-		reader := csv.NewReader(csvFile)
-		reader.Comma = '\t'
-		lines, err = reader.ReadAll()
-		if err != nil {
-			util.LogError("Failed to read csv file: " + filePath)
-		}
-	}
-	util.LogExit()
-	return lines
+// A product group in the category tree with its parent product group, 0 for a top level product group.
+type Category struct {
+	PgId     int    `json:"pg-id"`
+	Name     string `json:"name"`
+	ParentId int    `json:"parent-id,omitempty"`
 }
 
-func readProductGroups() ProductGroups {
-	util.LogEnter()
-	lines := readCsvFile("product-groups.csv")
-	myPG := make(map[string]string)
-	for _, line := range lines {
-		myPG[line[0]] = line[1]
-	}
-	productGroups := ProductGroups{true, myPG}
-	util.LogExit()
-	return productGroups
+// All the product groups as categories in the order of their ids, see category.go.
+type Categories struct {
+	Categories []Category `json:"categories"`
+	Ret        string     `json:"ret"`
 }
 
-// NOTE: We skip testing of all numeric/alpha conversions.
-// In real production code we should handle all these error conditions, of course.
-// But since this is an exercise, let's skip that part at least for now.
-func readProducts(pgId int) (RawProducts, Products) {
-	util.LogEnter()
-	lines := readCsvFile("pg-" + strconv.Itoa(pgId) + "-products.csv")
-	count := len(lines)
-	util.LogTrace("count: " + strconv.Itoa(count))
-	var rawProductsList [][8]string
-	var productsList [][4]string
-	//productsList := make([]Product, count)
-	i := 0
-	for _, line := range lines {
-		// NOTE: Beware of shadowing pgId => that's why we have myPgId, not pgId (which is function parameter and the variable would shadow it, not a problem here but might be in certain cases).
-		myPId := line[0]
-		myPgId := line[1]
-		myTitle := line[2]
-		myPrice := line[3]
-		myAuthorOrDirector := line[4]
-		myYear := line[5]
-		myCountry := line[6]
-		myGenreOrLanguage := line[7]
-		rawProductsList = append(rawProductsList, [8]string{myPId, myPgId, myTitle, myPrice, myAuthorOrDirector, myYear, myCountry, myGenreOrLanguage})
-		productsList = append(productsList, [4]string{myPId, myPgId, myTitle, myPrice})
-		i++
-	}
-	rawProducts := RawProducts{rawProductsList}
-	products := Products{productsList, "ok"}
-	util.LogExit()
-	return rawProducts, products
+// ProductStore is where the webserver gets the products from.
+// NOTE: A missing product group or product is not an error: GetProducts and GetSchema return an empty Ret
+// and GetProduct a Product without fields. The errors are failures of the store itself.
+type ProductStore interface {
+	GetProductGroups(ctx context.Context) (ProductGroups, error)
+	GetProducts(ctx context.Context, pgId int) (Products, error)
+	GetProduct(ctx context.Context, pgId int, pId int) (Product, error)
+	GetSchema(ctx context.Context, pgId int) (ProductGroupSchema, error)
+	GetCategories(ctx context.Context) (Categories, error)
 }
 
-func initDomainDb() DomainDb {
-	util.LogEnter()
-	myProductGroups := readProductGroups()
-	pgMap := myProductGroups.ProductGroupsMap
-	rawProductsMap := make(map[int]RawProducts)
-	productsMap := make(map[int]Products)
-	for key := range pgMap {
-		pgId, _ := strconv.Atoi(key)
-		rawProducts, products := readProducts(pgId)
-		rawProductsMap[pgId] = rawProducts
-		productsMap[pgId] = products
-	}
-	ret := DomainDb{
-		productGroups: myProductGroups, rawProductsMap: rawProductsMap, productsMap: productsMap}
-	util.LogExit()
-	return ret
-}
-
-// Gets product groups.
-func GetProductGroups() ProductGroups {
-	util.LogEnter()
-	ret := myDomainDB.productGroups
-	util.LogExit()
-	return ret
-}
-
-// Gets products
-func GetProducts(pgId int) Products {
-	util.LogEnter()
-	ret := myDomainDB.productsMap[pgId]
-	util.LogExit()
-	return ret
-}
-
-// Gets product
-func GetProduct(pgId int, pId int) Product {
-	util.LogEnter()
-	rawProductsMap := myDomainDB.rawProductsMap
-	rawProducts := rawProductsMap[pgId]
-	rawProductsList := rawProducts.RawProductsList
-	var found [8]string
-	wantedPid := strconv.Itoa(pId)
-	for _, product := range rawProductsList {
-		if product[0] == wantedPid {
-			found = product
-			break
+// Opens the store configured with product_store: tsv (the TSV files of resource_dir), sqlite (the database of sqlite_file)
+// or postgres (the database of postgres_url). The TSV files of resource_dir are imported to a new SQL database.
+func OpenProductStore(ctx context.Context) (ProductStore, error) {
+	defer util.LogEnter().Exit()
+	switch storeType := util.MyConfig["product_store"]; storeType {
+	case "", "tsv":
+		return NewTsvStore(ResourceDir())
+	case sqldb.Sqlite, sqldb.Postgres:
+		db, err := sqldb.Default(storeType)
+		if err != nil {
+			return nil, err
 		}
+		return NewSqlStore(ctx, db, ResourceDir())
+	default:
+		return nil, errors.New("Unknown product_store: " + storeType)
 	}
-	ret := Product{found, "ok"}
-	util.LogExit()
-	return ret
 }
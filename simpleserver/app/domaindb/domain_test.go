@@ -1,49 +1,163 @@
 package domaindb
 
 import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/sqldb/sqldbtest"
 	"github.com/karimarttila/go/simpleserver/app/util"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
-func TestGetProductGroups(t *testing.T) {
-	util.LogEnter()
-	myProductGroups := GetProductGroups()
-	myPGMap := myProductGroups.ProductGroupsMap
-	if len(myPGMap) != 2 {
-		t.Errorf("There should be exactly two product groups, got: %d", len(myPGMap))
+// The stores created from the TSV files of a directory. The store tests run against each of them.
+var testStores = []struct {
+	name string
+	open func(t *testing.T, dir string) ProductStore
+}{
+	{"tsv", func(t *testing.T, dir string) ProductStore {
+		store, err := NewTsvStore(dir)
+		if err != nil {
+			t.Fatalf("Loading products failed: %s", err.Error())
+		}
+		return store
+	}},
+	{"sql", func(t *testing.T, dir string) ProductStore {
+		return newTestSqlStore(t, openTestDb(t, t.TempDir()), dir)
+	}},
+	// NOTE: Skipped if there is no PostgreSQL server for the tests, see sqldbtest.
+	{"postgres", func(t *testing.T, dir string) ProductStore {
+		return newTestSqlStore(t, sqldbtest.OpenPostgres(t), dir)
+	}},
+}
+
+func newTestSqlStore(t *testing.T, db *sqldb.DB, dir string) ProductStore {
+	store, err := NewSqlStore(context.Background(), db, dir)
+	if err != nil {
+		t.Fatalf("Importing products failed: %s", err.Error())
 	}
-	if myPGMap["1"] != "Books" || myPGMap["2"] != "Movies" {
-		t.Errorf("There were wrong values for product groups: %s", myPGMap)
+	return store
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	sqldbtest.StopPostgres()
+	os.Exit(code)
+}
+
+// Runs the test against each store of the TSV files of dir.
+func forEachStore(t *testing.T, dir string, test func(t *testing.T, store ProductStore)) {
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			test(t, testStore.open(t, dir))
+		})
 	}
+}
+
+func TestGetProductGroups(t *testing.T) {
+	util.LogEnter()
+	forEachStore(t, ResourceDir(), func(t *testing.T, store ProductStore) {
+		myProductGroups, _ := store.GetProductGroups(context.Background())
+		myPGMap := myProductGroups.ProductGroupsMap
+		if len(myPGMap) != 2 {
+			t.Errorf("There should be exactly two product groups, got: %d", len(myPGMap))
+		}
+		if myPGMap["1"] != "Books" || myPGMap["2"] != "Movies" {
+			t.Errorf("There were wrong values for product groups: %s", myPGMap)
+		}
+	})
 	util.LogExit()
 }
 
 func TestGetProducts(t *testing.T) {
 	util.LogEnter()
-	myProductsPg_1 := GetProducts(1)
-	myProductsPg_2 := GetProducts(2)
-	myProductsListPg_1 := myProductsPg_1.ProductsList
-	myProductsListPg_2 := myProductsPg_2.ProductsList
-	if len(myProductsListPg_1) != 35 {
-		t.Errorf("There should be exactly 35 products in product group 1, got: %d", len(myProductsListPg_1))
-	}
-	if len(myProductsListPg_2) != 169 {
-		t.Errorf("There should be exactly 169 products in product group 2, got: %d", len(myProductsListPg_2))
-	}
-	expectedTitle := "Once Upon a Time in the West"
-	if myProductsListPg_2[48][2] != expectedTitle {
-		t.Errorf("Didn't find expected product: expected: %s, got: %s", expectedTitle, myProductsListPg_2[48][2])
-	}
+	forEachStore(t, ResourceDir(), func(t *testing.T, store ProductStore) {
+		myProductsPg_1, _ := store.GetProducts(context.Background(), 1)
+		myProductsPg_2, _ := store.GetProducts(context.Background(), 2)
+		myProductsListPg_1 := myProductsPg_1.ProductsList
+		myProductsListPg_2 := myProductsPg_2.ProductsList
+		if len(myProductsListPg_1) != 35 {
+			t.Errorf("There should be exactly 35 products in product group 1, got: %d", len(myProductsListPg_1))
+		}
+		if len(myProductsListPg_2) != 169 {
+			t.Fatalf("There should be exactly 169 products in product group 2, got: %d", len(myProductsListPg_2))
+		}
+		expectedTitle := "Once Upon a Time in the West"
+		if myProductsListPg_2[48][2] != expectedTitle {
+			t.Errorf("Didn't find expected product: expected: %s, got: %s", expectedTitle, myProductsListPg_2[48][2])
+		}
+	})
 	util.LogExit()
 }
 
 func TestGetProduct(t *testing.T) {
 	util.LogEnter()
-	// What a coincidence! The chosen movie is the best western of all times!
-	expectedTitle := "Once Upon a Time in the West"
-	product := GetProduct(2, 49)
-	if product.Product[2] != expectedTitle {
-		t.Errorf("Didn't find expected product: expected: %s, got: %s", expectedTitle, product.Product[2])
-	}
+	forEachStore(t, ResourceDir(), func(t *testing.T, store ProductStore) {
+		// What a coincidence! The chosen movie is the best western of all times!
+		expectedTitle := "Once Upon a Time in the West"
+		product, _ := store.GetProduct(context.Background(), 2, 49)
+		if product.Product[2] != expectedTitle {
+			t.Errorf("Didn't find expected product: expected: %s, got: %s", expectedTitle, product.Product[2])
+		}
+	})
 	util.LogExit()
 }
+
+// A catalog with an empty product group, tests both the found and the not found cases.
+var smallCatalog = map[string]string{
+	"product-groups.csv": "1\tBooks\n3\tGames\n",
+	"pg-1-products.csv":  "10\t1\tKalevala\t3.95\tElias Lönnrot\t1835\tFinland\tFinnish\n11\t1\tMoby Dick\t45.35\tHerman Melville\t1851\tUnited States\tEnglish\n",
+	"pg-3-products.csv":  "",
+}
+
+func TestSmallCatalog(t *testing.T) {
+	defer util.LogEnter().Exit()
+	forEachStore(t, writeTsvFiles(t, smallCatalog), func(t *testing.T, store ProductStore) {
+		ctx := context.Background()
+		productGroups, _ := store.GetProductGroups(ctx)
+		if len(productGroups.ProductGroupsMap) != 2 || productGroups.ProductGroupsMap["3"] != "Games" {
+			t.Errorf("Wrong product groups: %v", productGroups)
+		}
+		products, _ := store.GetProducts(ctx, 1)
+		if products.Ret != "ok" || len(products.ProductsList) != 2 || products.ProductsList[1] != [4]string{"11", "1", "Moby Dick", "45.35"} {
+			t.Errorf("Wrong products: %v", products)
+		}
+		if products, _ = store.GetProducts(ctx, 3); products.Ret != "ok" || len(products.ProductsList) != 0 {
+			t.Errorf("Product group without products should have been ok, got: %v", products)
+		}
+		if products, _ = store.GetProducts(ctx, 2); products.Ret != "" {
+			t.Errorf("Unknown product group should have returned zero-value Products, got: %v", products)
+		}
+		if product, _ := store.GetProduct(ctx, 1, 10); !reflect.DeepEqual(product.Product, kalevala) {
+			t.Errorf("Wrong product: %v", product)
+		}
+		if product, _ := store.GetProduct(ctx, 3, 10); len(product.Product) != 0 || product.Ret != "ok" {
+			t.Errorf("Product should not have been found in another product group, got: %v", product)
+		}
+	})
+}
+
+func TestOpenProductStore(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousStore, previousFile := util.MyConfig["product_store"], util.MyConfig["sqlite_file"]
+	defer func() {
+		util.MyConfig["product_store"], util.MyConfig["sqlite_file"] = previousStore, previousFile
+		sqldb.Close()
+	}()
+	util.MyConfig["product_store"] = "tsv"
+	if store, err := OpenProductStore(context.Background()); err != nil || store == nil {
+		t.Errorf("Opening the tsv store failed: %v", err)
+	}
+	util.MyConfig["product_store"] = "sqlite"
+	util.MyConfig["sqlite_file"] = filepath.Join(t.TempDir(), "products.db")
+	if store, err := OpenProductStore(context.Background()); err != nil {
+		t.Errorf("Opening the sqlite store failed: %v", err)
+	} else if _, ok := store.(*SqlStore); !ok {
+		t.Errorf("Store should have been a SqlStore, got: %T", store)
+	}
+	util.MyConfig["product_store"] = "unknown"
+	if _, err := OpenProductStore(context.Background()); err == nil {
+		t.Errorf("Opening an unknown store should have failed")
+	}
+}
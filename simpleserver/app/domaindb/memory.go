@@ -0,0 +1,461 @@
+package domaindb
+
+import (
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MemoryStore keeps the products in maps. The TSV store loads the files into a MemoryStore, and tests can create one directly.
+type MemoryStore struct {
+	mutex          sync.RWMutex
+	productGroups  ProductGroups
+	rawProductsMap map[int]RawProducts
+	productsMap    map[int]Products
+	schemas        map[int]Schema
+	// The parent of every product group, 0 for a top level product group.
+	parents map[int]int
+	// Called with the ids of the changed product groups while the store is locked, e.g. TsvStore writes its files.
+	// If it fails, the change is undone.
+	persist func(pgIds ...int) error
+}
+
+// Creates a store of the catalog. The products keep their order within the group.
+// Each product is: id, product group id, title, price and the values of the attributes of the schema of its group.
+func NewMemoryStore(catalog Catalog) (*MemoryStore, error) {
+	defer util.LogEnter().Exit()
+	store := &MemoryStore{
+		productGroups:  ProductGroups{true, make(map[string]string)},
+		rawProductsMap: make(map[int]RawProducts),
+		productsMap:    make(map[int]Products),
+		schemas:        make(map[int]Schema),
+		parents:        make(map[int]int),
+	}
+	for key, name := range catalog.ProductGroups {
+		pgId, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, errors.New("Product group id is not an integer: " + key)
+		}
+		store.productGroups.ProductGroupsMap[key] = name
+		store.rawProductsMap[pgId] = RawProducts{}
+		store.productsMap[pgId] = Products{nil, "ok"}
+		store.schemas[pgId] = catalog.schema(key)
+		store.parents[pgId] = catalog.parent(key)
+	}
+	for pgId, parentId := range store.parents {
+		if problem := parentProblem(pgId, parentId, store.parents); problem != "" {
+			return nil, InvalidParentError{pgId, parentId, problem}
+		}
+	}
+	for _, product := range catalog.Products {
+		_, _, err := productIds(product)
+		if err != nil {
+			return nil, err
+		}
+		pgId, _ := strconv.Atoi(product[1])
+		if _, ok := store.productsMap[pgId]; !ok {
+			return nil, errors.New("Product " + product[0] + " has an unknown product group: " + product[1])
+		}
+		if len(product) != len(BaseProductFields)+len(store.schemas[pgId]) {
+			return nil, errors.New("Product " + product[1] + "/" + product[0] + " does not have the attributes of its product group")
+		}
+		rawProducts := store.rawProductsMap[pgId]
+		rawProducts.RawProductsList = append(rawProducts.RawProductsList, product)
+		store.rawProductsMap[pgId] = rawProducts
+		products := store.productsMap[pgId]
+		products.ProductsList = append(products.ProductsList, [4]string{product[0], product[1], product[2], product[3]})
+		store.productsMap[pgId] = products
+	}
+	return store, nil
+}
+
+func (store *MemoryStore) GetProductGroups(ctx context.Context) (ProductGroups, error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.GetProductGroups", tracing.SpanKindInternal)
+	defer span.End()
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	// NOTE: A copy, since the map changes when the catalog is edited.
+	ret := ProductGroups{true, make(map[string]string, len(store.productGroups.ProductGroupsMap))}
+	for key, name := range store.productGroups.ProductGroupsMap {
+		ret.ProductGroupsMap[key] = name
+	}
+	return ret, nil
+}
+
+func (store *MemoryStore) GetProducts(ctx context.Context, pgId int) (Products, error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.GetProducts", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	return store.productsMap[pgId], nil
+}
+
+func (store *MemoryStore) GetProduct(ctx context.Context, pgId int, pId int) (Product, error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.GetProduct", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	span.SetAttribute("product.p_id", pId)
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	var found []string
+	wantedPid := strconv.Itoa(pId)
+	for _, product := range store.rawProductsMap[pgId].RawProductsList {
+		if product[0] == wantedPid {
+			found = product
+			break
+		}
+	}
+	return Product{found, "ok"}, nil
+}
+
+func (store *MemoryStore) GetSchema(ctx context.Context, pgId int) (ProductGroupSchema, error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.GetSchema", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	schema, found := store.schemas[pgId]
+	if !found {
+		return ProductGroupSchema{}, nil
+	}
+	return ProductGroupSchema{pgId, schema, "ok"}, nil
+}
+
+func (store *MemoryStore) GetCategories(ctx context.Context) (Categories, error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.GetCategories", tracing.SpanKindInternal)
+	defer span.End()
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	categories := Categories{[]Category{}, "ok"}
+	for _, pgId := range store.pgIds() {
+		categories.Categories = append(categories.Categories,
+			Category{pgId, store.productGroups.ProductGroupsMap[strconv.Itoa(pgId)], store.parents[pgId]})
+	}
+	return categories, nil
+}
+
+// Returns the ids of the product groups in order.
+func (store *MemoryStore) pgIds() []int {
+	var pgIds []int
+	for pgId := range store.rawProductsMap {
+		pgIds = append(pgIds, pgId)
+	}
+	sort.Ints(pgIds)
+	return pgIds
+}
+
+func (store *MemoryStore) productCount() (count int) {
+	for _, rawProducts := range store.rawProductsMap {
+		count += len(rawProducts.RawProductsList)
+	}
+	return count
+}
+
+// A product group being edited, found is false for a new or a deleted group.
+type groupEdit struct {
+	found    bool
+	parent   int
+	name     string
+	schema   Schema
+	products [][]string
+}
+
+// Replaces the product group.
+// NOTE: The lists are always new, so the lists already returned by GetProducts do not change.
+func (store *MemoryStore) setGroup(pgId int, group groupEdit) {
+	key := strconv.Itoa(pgId)
+	if !group.found {
+		delete(store.productGroups.ProductGroupsMap, key)
+		delete(store.rawProductsMap, pgId)
+		delete(store.productsMap, pgId)
+		delete(store.schemas, pgId)
+		delete(store.parents, pgId)
+		return
+	}
+	store.productGroups.ProductGroupsMap[key] = group.name
+	store.schemas[pgId] = group.schema
+	store.parents[pgId] = group.parent
+	store.rawProductsMap[pgId] = RawProducts{group.products}
+	products := Products{nil, "ok"}
+	for _, product := range group.products {
+		products.ProductsList = append(products.ProductsList, [4]string{product[0], product[1], product[2], product[3]})
+	}
+	store.productsMap[pgId] = products
+}
+
+// Changes the product group with the store locked and persists the change, undoing it if persisting fails.
+func (store *MemoryStore) editLocked(pgId int, change func(group *groupEdit) error) error {
+	name, found := store.productGroups.ProductGroupsMap[strconv.Itoa(pgId)]
+	old := groupEdit{found, store.parents[pgId], name, store.schemas[pgId], store.rawProductsMap[pgId].RawProductsList}
+	group := old
+	group.products = append([][]string(nil), old.products...)
+	if err := change(&group); err != nil {
+		return err
+	}
+	if problem := parentProblem(pgId, group.parent, store.parents); group.found && problem != "" {
+		return InvalidParentError{pgId, group.parent, problem}
+	}
+	store.setGroup(pgId, group)
+	if store.persist != nil {
+		if err := store.persist(pgId); err != nil {
+			store.setGroup(pgId, old)
+			return errors.New("Couldn't save the catalog: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) edit(pgId int, change func(group *groupEdit) error) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.editLocked(pgId, change)
+}
+
+// Returns the index of the product in the list, -1 if not found.
+func productIndex(products [][]string, pId int) int {
+	wantedPid := strconv.Itoa(pId)
+	for i, product := range products {
+		if product[0] == wantedPid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (store *MemoryStore) AddProductGroup(ctx context.Context, pgId int, parentId int, name string, schema Schema) (ret int, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.AddProductGroup", tracing.SpanKindInternal)
+	defer span.End()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if pgId == 0 {
+		for id := range store.rawProductsMap {
+			if id > pgId {
+				pgId = id
+			}
+		}
+		pgId++
+	}
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.editLocked(pgId, func(group *groupEdit) error {
+		if group.found {
+			return AlreadyExistsError{"Product group " + strconv.Itoa(pgId)}
+		}
+		group.found, group.parent, group.name, group.schema = true, parentId, name, schemaOrDefault(schema)
+		return nil
+	})
+	span.SetError(err)
+	return pgId, err
+}
+
+func (store *MemoryStore) UpdateProductGroup(ctx context.Context, pgId int, parentId int, name string, schema Schema, version string) (err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.UpdateProductGroup", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.edit(pgId, func(group *groupEdit) error {
+		if !group.found {
+			return ProductGroupNotFoundError{pgId}
+		}
+		if ProductGroupVersion(pgId, group.parent, group.name, group.schema) != version {
+			return VersionConflictError{"Product group " + strconv.Itoa(pgId)}
+		}
+		if schema != nil && !schema.Equal(group.schema) {
+			if len(group.products) > 0 {
+				return ProductGroupNotEmptyError{pgId}
+			}
+			group.schema = schemaOrDefault(schema)
+		}
+		group.parent, group.name = parentId, name
+		return nil
+	})
+	span.SetError(err)
+	return err
+}
+
+func (store *MemoryStore) DeleteProductGroup(ctx context.Context, pgId int, version string) (err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.DeleteProductGroup", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.edit(pgId, func(group *groupEdit) error {
+		if !group.found {
+			return ProductGroupNotFoundError{pgId}
+		}
+		if ProductGroupVersion(pgId, group.parent, group.name, group.schema) != version {
+			return VersionConflictError{"Product group " + strconv.Itoa(pgId)}
+		}
+		if len(group.products) > 0 {
+			return ProductGroupNotEmptyError{pgId}
+		}
+		for _, parentId := range store.parents {
+			if parentId == pgId {
+				return ProductGroupHasChildrenError{pgId}
+			}
+		}
+		group.found = false
+		return nil
+	})
+	span.SetError(err)
+	return err
+}
+
+func (store *MemoryStore) AddProduct(ctx context.Context, product []string) (ret []string, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.AddProduct", tracing.SpanKindInternal)
+	defer span.End()
+	pgId, pId, err := productIds(product)
+	if err != nil {
+		return ret, err
+	}
+	span.SetAttribute("product.pg_id", pgId)
+	product = append([]string(nil), product...)
+	err = store.edit(pgId, func(group *groupEdit) error {
+		if !group.found {
+			return ProductGroupNotFoundError{pgId}
+		}
+		if err := checkProduct(product, group.schema); err != nil {
+			return err
+		}
+		if pId == 0 {
+			maxId := 0
+			for _, existing := range group.products {
+				if id, _ := strconv.Atoi(existing[0]); id > maxId {
+					maxId = id
+				}
+			}
+			product[0] = strconv.Itoa(maxId + 1)
+		} else if productIndex(group.products, pId) != -1 {
+			return AlreadyExistsError{"Product " + product[1] + "/" + product[0]}
+		}
+		group.products = append(group.products, product)
+		return nil
+	})
+	span.SetError(err)
+	if err != nil {
+		return ret, err
+	}
+	return product, nil
+}
+
+// Replaces the product if the version matches, or removes it if product is nil.
+func (store *MemoryStore) changeProduct(pgId int, pId int, version string, product []string) error {
+	return store.edit(pgId, func(group *groupEdit) error {
+		i := productIndex(group.products, pId)
+		if i == -1 {
+			return ProductNotFoundError{pgId, pId}
+		}
+		if ProductVersion(group.products[i]) != version {
+			return VersionConflictError{"Product " + strconv.Itoa(pgId) + "/" + strconv.Itoa(pId)}
+		}
+		if product == nil {
+			group.products = append(group.products[:i], group.products[i+1:]...)
+		} else if err := checkProduct(product, group.schema); err != nil {
+			return err
+		} else {
+			group.products[i] = append([]string(nil), product...)
+		}
+		return nil
+	})
+}
+
+func (store *MemoryStore) UpdateProduct(ctx context.Context, product []string, version string) (err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.UpdateProduct", tracing.SpanKindInternal)
+	defer span.End()
+	pgId, pId, err := productIds(product)
+	if err != nil {
+		return err
+	}
+	span.SetAttribute("product.pg_id", pgId)
+	span.SetAttribute("product.p_id", pId)
+	err = store.changeProduct(pgId, pId, version, product)
+	span.SetError(err)
+	return err
+}
+
+func (store *MemoryStore) DeleteProduct(ctx context.Context, pgId int, pId int, version string) (err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.DeleteProduct", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	span.SetAttribute("product.p_id", pId)
+	err = store.changeProduct(pgId, pId, version, nil)
+	span.SetError(err)
+	return err
+}
+
+func (store *MemoryStore) ExportCatalog(ctx context.Context) (Catalog, error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.ExportCatalog", tracing.SpanKindInternal)
+	defer span.End()
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	catalog := Catalog{make(map[string]string), make(map[string]string), make(map[string]Schema), nil}
+	for key, name := range store.productGroups.ProductGroupsMap {
+		pgId, _ := strconv.Atoi(key)
+		catalog.ProductGroups[key] = name
+		catalog.Schemas[key] = store.schemas[pgId]
+		if parentId := store.parents[pgId]; parentId != 0 {
+			catalog.Parents[key] = strconv.Itoa(parentId)
+		}
+	}
+	pgIds, _ := catalog.groups()
+	for _, pgId := range pgIds {
+		catalog.Products = append(catalog.Products, store.rawProductsMap[pgId].RawProductsList...)
+	}
+	return catalog, nil
+}
+
+func (store *MemoryStore) ImportCatalog(ctx context.Context, catalog Catalog) error {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "domaindb.ImportCatalog", tracing.SpanKindInternal)
+	defer span.End()
+	imported, err := NewMemoryStore(catalog)
+	if err == nil {
+		err = store.replace(imported)
+	}
+	span.SetError(err)
+	return err
+}
+
+// Replaces the whole catalog with the catalog of the other store and persists all the product groups of both.
+func (store *MemoryStore) replace(other *MemoryStore) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var pgIds []int
+	for pgId := range store.rawProductsMap {
+		pgIds = append(pgIds, pgId)
+	}
+	for pgId := range other.rawProductsMap {
+		if _, found := store.rawProductsMap[pgId]; !found {
+			pgIds = append(pgIds, pgId)
+		}
+	}
+	old := &MemoryStore{productGroups: store.productGroups, rawProductsMap: store.rawProductsMap, productsMap: store.productsMap,
+		schemas: store.schemas, parents: store.parents}
+	store.setCatalog(other)
+	if store.persist != nil {
+		if err := store.persist(pgIds...); err != nil {
+			store.setCatalog(old)
+			return errors.New("Couldn't save the catalog: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Takes the catalog of the other store, with the store locked.
+func (store *MemoryStore) setCatalog(other *MemoryStore) {
+	store.productGroups, store.rawProductsMap, store.productsMap = other.productGroups, other.rawProductsMap, other.productsMap
+	store.schemas, store.parents = other.schemas, other.parents
+}
@@ -0,0 +1,78 @@
+package domaindb
+
+import (
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	store, err := NewMemoryStore(Catalog{map[string]string{"1": "Books", "3": "Games"}, nil, nil, [][]string{
+		{"10", "1", "Kalevala", "3.95", "Elias Lönnrot", "1835", "Finland", "Finnish"},
+		{"11", "1", "Moby Dick", "45.35", "Herman Melville", "1851", "United States", "English"},
+	}})
+	if err != nil {
+		t.Fatalf("Creating store failed: %s", err.Error())
+	}
+	productGroups, _ := store.GetProductGroups(ctx)
+	if len(productGroups.ProductGroupsMap) != 2 || productGroups.ProductGroupsMap["3"] != "Games" {
+		t.Errorf("Wrong product groups: %v", productGroups)
+	}
+	products, _ := store.GetProducts(ctx, 1)
+	if products.Ret != "ok" || len(products.ProductsList) != 2 || products.ProductsList[1] != [4]string{"11", "1", "Moby Dick", "45.35"} {
+		t.Errorf("Wrong products: %v", products)
+	}
+	if products, _ = store.GetProducts(ctx, 3); products.Ret != "ok" || len(products.ProductsList) != 0 {
+		t.Errorf("Product group without products should have been ok, got: %v", products)
+	}
+	if products, _ = store.GetProducts(ctx, 2); products.Ret != "" {
+		t.Errorf("Unknown product group should have returned zero-value Products, got: %v", products)
+	}
+	if product, _ := store.GetProduct(ctx, 1, 10); product.Product[2] != "Kalevala" || product.Product[7] != "Finnish" {
+		t.Errorf("Wrong product: %v", product)
+	}
+	if product, _ := store.GetProduct(ctx, 3, 10); len(product.Product) != 0 {
+		t.Errorf("Product should not have been found in another product group, got: %v", product)
+	}
+}
+
+func TestNewMemoryStoreInvalidProducts(t *testing.T) {
+	defer util.LogEnter().Exit()
+	tests := []struct {
+		name          string
+		productGroups map[string]string
+		products      [][]string
+	}{
+		{"group id", map[string]string{"x": "Books"}, nil},
+		{"product id", map[string]string{"1": "Books"}, [][]string{{"x", "1", "Kalevala"}}},
+		{"unknown group", map[string]string{"1": "Books"}, [][]string{{"10", "2", "Kalevala"}}},
+		{"attributes", map[string]string{"1": "Books"}, [][]string{{"10", "1", "Kalevala", "3.95"}}},
+	}
+	for _, test := range tests {
+		if _, err := NewMemoryStore(Catalog{test.productGroups, nil, nil, test.products}); err == nil {
+			t.Errorf("Invalid %s should have been rejected", test.name)
+		}
+	}
+}
+
+func TestMemoryStoreUndoesFailedChange(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	store, _ := NewMemoryStore(Catalog{map[string]string{"1": "Books"}, nil, nil, [][]string{kalevala}})
+	store.persist = func(pgIds ...int) error { return errors.New("disk full") }
+	if _, err := store.AddProductGroup(ctx, 2, 0, "Movies", nil); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Adding should have failed with the persist error, got: %v", err)
+	}
+	if err := store.DeleteProduct(ctx, 1, 10, ProductVersion(kalevala)); err == nil {
+		t.Errorf("Deleting should have failed")
+	}
+	productGroups, _ := store.GetProductGroups(ctx)
+	if product, _ := store.GetProduct(ctx, 1, 10); len(productGroups.ProductGroupsMap) != 1 || !reflect.DeepEqual(product.Product, kalevala) {
+		t.Errorf("Failed changes should have been undone, got: %v, %v", productGroups, product)
+	}
+}
@@ -0,0 +1,249 @@
+package domaindb
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The catalog as an OpenDocument spreadsheet (ODF 1.2), e.g. for LibreOffice Calc: a sheet per TSV file, named like
+// the file without .csv, with a header row. Only the text of the cells is read, so the formatting can be changed freely.
+
+const odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+const odsManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+ <manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="` + odsMimeType + `"/>
+ <manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+</manifest:manifest>
+`
+
+// Writes the text of a cell as a paragraph.
+// NOTE: A reader collapses the spaces of a paragraph, so the spaces next to each other and at the ends are written as <text:s/>.
+func writeOdsText(buf *bytes.Buffer, text string) {
+	buf.WriteString("<text:p>")
+	runes := []rune(text)
+	for i, r := range runes {
+		if r == ' ' && (i == 0 || i == len(runes)-1 || runes[i-1] == ' ' || runes[i+1] == ' ') {
+			buf.WriteString("<text:s/>")
+			continue
+		}
+		xml.EscapeText(buf, []byte(string(r)))
+	}
+	buf.WriteString("</text:p>")
+}
+
+func writeOdsCatalog(writer io.Writer, catalog Catalog) error {
+	var content bytes.Buffer
+	content.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"` +
+		` xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0"` +
+		` xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" office:version="1.2"><office:body><office:spreadsheet>`)
+	for _, file := range catalogFiles(catalog) {
+		content.WriteString(`<table:table table:name="` + file.name + `">`)
+		for _, row := range append([][]string{file.header}, file.rows...) {
+			content.WriteString("<table:table-row>")
+			for _, cell := range row {
+				content.WriteString(`<table:table-cell office:value-type="string">`)
+				writeOdsText(&content, cell)
+				content.WriteString("</table:table-cell>")
+			}
+			content.WriteString("</table:table-row>")
+		}
+		content.WriteString("</table:table>")
+	}
+	content.WriteString("</office:spreadsheet></office:body></office:document-content>\n")
+	zipWriter := zip.NewWriter(writer)
+	// NOTE: The mimetype must be the first file and not compressed.
+	parts := []struct {
+		name    string
+		method  uint16
+		content []byte
+	}{
+		{"mimetype", zip.Store, []byte(odsMimeType)},
+		{"META-INF/manifest.xml", zip.Deflate, []byte(odsManifest)},
+		{"content.xml", zip.Deflate, content.Bytes()},
+	}
+	for _, part := range parts {
+		fileWriter, err := zipWriter.CreateHeader(&zip.FileHeader{Name: part.name, Method: part.method})
+		if err != nil {
+			return err
+		}
+		if _, err = fileWriter.Write(part.content); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+// The text of a paragraph, with the spaces, tabs and line breaks of <text:s/>, <text:tab/> and <text:line-break/>,
+// and the text of the spans and links.
+type odsText string
+
+func (text *odsText) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	var buf strings.Builder
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			// NOTE: The white space of the XML is collapsed to one space like the ODF readers do.
+			space := false
+			for _, r := range string(token) {
+				isSpace := r == ' ' || r == '\t' || r == '\n' || r == '\r'
+				if !isSpace {
+					buf.WriteRune(r)
+				} else if !space {
+					buf.WriteRune(' ')
+				}
+				space = isSpace
+			}
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "s":
+				count := 1
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "c" {
+						count, _ = strconv.Atoi(attr.Value)
+					}
+				}
+				buf.WriteString(strings.Repeat(" ", count))
+			case "tab":
+				buf.WriteString("\t")
+			case "line-break":
+				buf.WriteString("\n")
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				*text = odsText(buf.String())
+				return nil
+			}
+			depth--
+		}
+	}
+}
+
+type odsCell struct {
+	Repeated   int       `xml:"number-columns-repeated,attr"`
+	Value      string    `xml:"value,attr"`
+	Paragraphs []odsText `xml:"p"`
+}
+
+type odsRow struct {
+	Repeated int       `xml:"number-rows-repeated,attr"`
+	Cells    []odsCell `xml:",any"`
+}
+
+type odsTable struct {
+	Name string   `xml:"name,attr"`
+	Rows []odsRow `xml:"table-row"`
+}
+
+type odsContent struct {
+	Tables []odsTable `xml:"body>spreadsheet>table"`
+}
+
+// Returns the text of the cells of the row, without the empty cells at the end.
+func (row odsRow) texts() (texts []string) {
+	for _, cell := range row.Cells {
+		text := cell.Value
+		if len(cell.Paragraphs) > 0 {
+			lines := make([]string, len(cell.Paragraphs))
+			for i, paragraph := range cell.Paragraphs {
+				lines[i] = string(paragraph)
+			}
+			text = strings.Join(lines, "\n")
+		}
+		for i := 0; i < cell.Repeated || i == 0; i++ {
+			texts = append(texts, text)
+		}
+	}
+	for len(texts) > 0 && texts[len(texts)-1] == "" {
+		texts = texts[:len(texts)-1]
+	}
+	return texts
+}
+
+// Returns the rows of the table that have fieldCount cells, the first row must be the header.
+// The empty rows are skipped, e.g. the rest of the sheet is often written as one repeated empty row.
+func (table odsTable) rows(header []string, fieldCount int, errs *CatalogErrors) (rows []catalogRow) {
+	line := 0
+	for _, tableRow := range table.Rows {
+		texts := tableRow.texts()
+		for i := 0; i < tableRow.Repeated || i == 0; i++ {
+			line++
+			if len(texts) == 0 {
+				continue
+			}
+			if header != nil {
+				if strings.Join(texts, "\t") != strings.Join(header, "\t") {
+					errs.add(table.Name, line, "expected the header: "+strings.Join(header, ","))
+					return nil
+				}
+				header = nil
+				continue
+			}
+			if len(texts) > fieldCount {
+				errs.add(table.Name, line, "expected "+strconv.Itoa(fieldCount)+" columns, got "+strconv.Itoa(len(texts)))
+				continue
+			}
+			// NOTE: The empty cells at the end were dropped.
+			fields := append(texts, make([]string, fieldCount-len(texts))...)
+			rows = append(rows, catalogRow{table.Name, line, fields})
+		}
+	}
+	if header != nil {
+		errs.add(table.Name, 0, "header is missing, expected: "+strings.Join(header, ","))
+	}
+	return rows
+}
+
+func readOdsCatalog(data []byte) (Catalog, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Catalog{}, CatalogErrors{{"catalog.ods", 0, err.Error()}}
+	}
+	var content odsContent
+	err = CatalogErrors{{"catalog.ods", 0, "content.xml is missing"}}
+	for _, file := range zipReader.File {
+		if file.Name == "content.xml" {
+			err = readOdsContent(file, &content)
+		}
+	}
+	if err != nil {
+		return Catalog{}, err
+	}
+	tables := make(map[string]odsTable)
+	for _, table := range content.Tables {
+		tables[table.Name] = table
+	}
+	return readCatalog(func(name string, header []string, optional bool, errs *CatalogErrors) ([]catalogRow, bool) {
+		table, found := tables[name]
+		if !found {
+			if !optional {
+				errs.add(name, 0, "sheet is missing")
+			}
+			return nil, false
+		}
+		return table.rows(header, len(header), errs), true
+	})
+}
+
+func readOdsContent(file *zip.File, content *odsContent) error {
+	reader, err := file.Open()
+	if err != nil {
+		return CatalogErrors{{"catalog.ods", 0, err.Error()}}
+	}
+	defer reader.Close()
+	if err = xml.NewDecoder(reader).Decode(content); err != nil {
+		return CatalogErrors{{"catalog.ods", 0, "content.xml: " + err.Error()}}
+	}
+	return nil
+}
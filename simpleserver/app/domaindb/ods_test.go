@@ -0,0 +1,87 @@
+package domaindb
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Like LibreOffice Calc saves a sheet: repeated cells and rows, spans, spaces, numbers and the empty rest of the sheet.
+const calcContent = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+ xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">
+<office:body><office:spreadsheet>
+<table:table table:name="product-groups">
+ <table:table-row>
+  <table:table-cell office:value-type="string"><text:p>pg-id</text:p></table:table-cell>
+  <table:table-cell office:value-type="string"><text:p>name</text:p></table:table-cell>
+  <table:table-cell table:number-columns-repeated="1022"/>
+ </table:table-row>
+ <table:table-row>
+  <table:table-cell office:value-type="float" office:value="1"><text:p>1</text:p></table:table-cell>
+  <table:table-cell office:value-type="string"><text:p>Great<text:s text:c="2"/><text:span text:style-name="T1">Books</text:span></text:p></table:table-cell>
+ </table:table-row>
+ <table:table-row table:number-rows-repeated="1048574"><table:table-cell table:number-columns-repeated="1024"/></table:table-row>
+</table:table>
+<table:table table:name="pg-1-products">
+ <table:table-row>
+  <table:table-cell><text:p>p-id</text:p></table:table-cell><table:table-cell><text:p>pg-id</text:p></table:table-cell>
+  <table:table-cell><text:p>title</text:p></table:table-cell><table:table-cell><text:p>price</text:p></table:table-cell>
+  <table:table-cell><text:p>author-or-director</text:p></table:table-cell><table:table-cell><text:p>year</text:p></table:table-cell>
+  <table:table-cell><text:p>country</text:p></table:table-cell><table:table-cell><text:p>genre-or-language</text:p></table:table-cell>
+ </table:table-row>
+ <table:table-row table:number-rows-repeated="2"><table:table-cell/></table:table-row>
+ <table:table-row>
+  <table:table-cell office:value-type="float" office:value="10"/>
+  <table:table-cell office:value-type="float" office:value="1"><text:p>1</text:p></table:table-cell>
+  <table:table-cell><text:p>Kalevala</text:p></table:table-cell>
+  <table:table-cell office:value-type="float" office:value="3.95"><text:p>3.95</text:p></table:table-cell>
+  <table:table-cell><text:p>Elias
+   Lönnrot</text:p></table:table-cell>
+  <table:table-cell><text:p>1835</text:p></table:table-cell>
+  <table:table-cell table:number-columns-repeated="2"><text:p>Finnish</text:p></table:table-cell>
+ </table:table-row>
+ <table:table-row>
+  <table:table-cell><text:p>11</text:p></table:table-cell><table:table-cell><text:p>1</text:p></table:table-cell>
+  <table:table-cell><text:p>Short</text:p></table:table-cell>
+ </table:table-row>
+</table:table>
+</office:spreadsheet></office:body></office:document-content>`
+
+func calcFile(content string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	writer, _ := zipWriter.Create("content.xml")
+	writer.Write([]byte(content))
+	zipWriter.Close()
+	return &buf
+}
+
+func TestReadOdsCatalog(t *testing.T) {
+	defer util.LogEnter().Exit()
+	_, err := ReadCatalog(calcFile(calcContent), "ods")
+	if err == nil || err.Error() != "pg-1-products:5: price is required\n"+
+		"pg-1-products:5: author-or-director is required\npg-1-products:5: year is required\npg-1-products:5: country is required\n"+
+		"pg-1-products:5: genre-or-language is required" {
+		t.Errorf("Short row should have failed, got: %v", err)
+	}
+	complete := strings.Replace(calcContent, "<text:p>Short</text:p>", "<text:p>Short</text:p></table:table-cell>"+
+		"<table:table-cell><text:p>1</text:p></table:table-cell><table:table-cell><text:p>a</text:p></table:table-cell>"+
+		`<table:table-cell><text:p>1</text:p></table:table-cell><table:table-cell table:number-columns-repeated="2"><text:p>b</text:p>`, 1)
+	catalog, err := ReadCatalog(calcFile(complete), "ods")
+	expected := Catalog{
+		map[string]string{"1": "Great  Books"},
+		map[string]string{},
+		map[string]Schema{"1": DefaultSchema},
+		[][]string{
+			{"10", "1", "Kalevala", "3.95", "Elias Lönnrot", "1835", "Finnish", "Finnish"},
+			{"11", "1", "Short", "1", "a", "1", "b", "b"},
+		},
+	}
+	if err != nil || !reflect.DeepEqual(catalog, expected) {
+		t.Errorf("Wrong catalog: %v, %v", catalog, err)
+	}
+}
@@ -0,0 +1,108 @@
+package domaindb
+
+import (
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reloading the TSV files when they are changed while the server is running, e.g. by the content team.
+
+// Returns the names, sizes and modification times of the catalog files in the directory.
+// It changes when a file is written, added or removed.
+func tsvFingerprint(dir string) (string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "pg-*.csv"))
+	if err != nil {
+		return "", err
+	}
+	names = append(names, filepath.Join(dir, "product-groups.csv"))
+	// NOTE: The parents file is optional, see TsvStore.
+	if _, err := os.Stat(filepath.Join(dir, "product-group-parents.csv")); err == nil {
+		names = append(names, filepath.Join(dir, "product-group-parents.csv"))
+	}
+	sort.Strings(names)
+	var fingerprint strings.Builder
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		fingerprint.WriteString(filepath.Base(name) + "\t" + strconv.FormatInt(info.Size(), 10) + "\t" +
+			strconv.FormatInt(info.ModTime().UnixNano(), 10) + "\n")
+	}
+	return fingerprint.String(), nil
+}
+
+// Reloads the catalog if the files have changed. A change is loaded once the files have stayed the same
+// for one poll, so that a half copied file is not loaded. The new catalog replaces the old one only if it is valid,
+// otherwise the error reports all the problems (see readTsvFiles) and the same files are not tried again.
+func (store *TsvStore) reloadIfChanged() (reloaded bool, err error) {
+	defer util.LogEnter().Exit()
+	current, err := tsvFingerprint(store.dir)
+	if err != nil {
+		return false, err
+	}
+	store.mutex.RLock()
+	loaded := store.fingerprint
+	store.mutex.RUnlock()
+	if current == loaded || current == store.rejected {
+		store.pending = ""
+		return false, nil
+	}
+	if current != store.pending {
+		store.pending = current
+		return false, nil
+	}
+	store.pending = ""
+	newStore, err := readTsvFiles(store.dir)
+	if err != nil {
+		store.rejected = current
+		return false, errors.New("Rejected the changed catalog files in " + store.dir + ", keeping the current catalog:\n" + err.Error())
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	// NOTE: The files may have changed while reading them, or the catalog may have been edited through the API.
+	if after, err := tsvFingerprint(store.dir); err != nil || after != current {
+		return false, err
+	}
+	store.setCatalog(newStore)
+	store.fingerprint = current
+	util.LogInfo("Reloaded " + strconv.Itoa(store.productCount()) + " products in " + strconv.Itoa(len(store.rawProductsMap)) + " product groups from " + store.dir)
+	return true, nil
+}
+
+// Polls the files every interval and reloads the catalog when they change, see reloadIfChanged.
+// The readers see either the old or the new catalog, never a half loaded one. Returns a function that stops the polling.
+func (store *TsvStore) WatchFiles(interval time.Duration) (stop func()) {
+	defer util.LogEnter().Exit()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := store.reloadIfChanged(); err != nil {
+					util.LogError(err.Error())
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
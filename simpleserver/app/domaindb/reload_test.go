@@ -0,0 +1,98 @@
+package domaindb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testModTime = time.Now()
+
+// Replaces the file like the content team would.
+// NOTE: The modification time is moved forward, since the file system may not see writes within the same tick.
+func changeTsvFile(t *testing.T, dir string, name string, content string) {
+	fileName := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatalf("Writing %s failed: %s", name, err.Error())
+	}
+	testModTime = testModTime.Add(time.Second)
+	if err := os.Chtimes(fileName, testModTime, testModTime); err != nil {
+		t.Fatalf("Touching %s failed: %s", name, err.Error())
+	}
+}
+
+func TestReloadIfChanged(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := writeTsvFiles(t, map[string]string{
+		"product-groups.csv": "1\tBooks\n",
+		"pg-1-products.csv":  strings.Join(kalevala[:], "\t") + "\n",
+	})
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	if reloaded, err := store.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Unchanged files should not have been reloaded: %v, %v", reloaded, err)
+	}
+	changeTsvFile(t, dir, "product-groups.csv", "1\tBooks\n2\tMovies\n")
+	changeTsvFile(t, dir, "pg-2-products.csv", "1\t2\tLawrence of Arabia\t9.99\tDavid Lean\t1962\tUK\tAdventure\n")
+	if reloaded, err := store.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Files should have been reloaded only after they have stayed the same: %v, %v", reloaded, err)
+	}
+	if reloaded, err := store.reloadIfChanged(); !reloaded || err != nil {
+		t.Fatalf("Changed files should have been reloaded: %v, %v", reloaded, err)
+	}
+	if product, _ := store.GetProduct(context.Background(), 2, 1); product.Product[2] != "Lawrence of Arabia" {
+		t.Errorf("Reloaded product should have been found, got: %v", product)
+	}
+	// The changes made through the store are not reloaded, and they are still saved to the files.
+	if _, err := store.AddProductGroup(context.Background(), 0, 0, "Music", nil); err != nil {
+		t.Fatalf("Adding the product group failed: %s", err.Error())
+	}
+	store.reloadIfChanged()
+	if reloaded, err := store.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Own changes should not have been reloaded: %v, %v", reloaded, err)
+	}
+	changeTsvFile(t, dir, "pg-1-products.csv", "2001\t1\tKalevala\tfree\tElias Lönnrot\t1835\tFinland\tFinnish\n2001\t1\tx\t1\t\t1\ty\tz\n")
+	store.reloadIfChanged()
+	_, err = store.reloadIfChanged()
+	for _, problem := range []string{"pg-1-products.csv:1: price", "pg-1-products.csv:2: duplicate product id 2001, also at line 1", "pg-1-products.csv:2: author-or-director is required"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Invalid files should have been rejected with %s, got: %v", problem, err)
+		}
+	}
+	if product, _ := store.GetProduct(context.Background(), 1, 10); product.Product[3] != "3.95" {
+		t.Errorf("Rejected files should not have changed the catalog, got: %v", product)
+	}
+	if groups, _ := store.GetProductGroups(context.Background()); groups.ProductGroupsMap["3"] != "Music" {
+		t.Errorf("Rejected files should not have changed the catalog, got: %v", groups)
+	}
+	if reloaded, err := store.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Rejected files should not have been tried again: %v, %v", reloaded, err)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := writeTsvFiles(t, map[string]string{"product-groups.csv": "1\tBooks\n", "pg-1-products.csv": ""})
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	stop := store.WatchFiles(10 * time.Millisecond)
+	defer stop()
+	changeTsvFile(t, dir, "product-groups.csv", "1\tNovels\n")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if groups, _ := store.GetProductGroups(context.Background()); groups.ProductGroupsMap["1"] == "Novels" {
+			stop()
+			stop()
+			return
+		}
+	}
+	t.Errorf("Changed file should have been reloaded")
+}
@@ -0,0 +1,216 @@
+package domaindb
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The attributes of the products of a product group, so that a new kind of product group, e.g. Music or Games,
+// needs no code changes. A product is its id, product group id, title and price followed by the values of the attributes
+// in the order of the schema of its product group. All the values are text like in the TSV files, the type of an attribute
+// tells which texts are valid.
+// The schema of a product group is in pg-<id>-attributes.csv (name, type, required). A product group without the file
+// has DefaultSchema, the attributes of the books and movies.
+
+type AttributeType string
+
+const (
+	StringAttribute  AttributeType = "string"
+	IntegerAttribute AttributeType = "integer"
+	DecimalAttribute AttributeType = "decimal"
+	YearAttribute    AttributeType = "year"
+	BooleanAttribute AttributeType = "boolean"
+)
+
+type Attribute struct {
+	Name     string        `json:"name"`
+	Type     AttributeType `json:"type"`
+	Required bool          `json:"required"`
+}
+
+// Schema is the attributes of the products of a product group in their order.
+// NOTE: An empty schema is Schema{}, the readers treat a missing schema (nil) as DefaultSchema.
+type Schema []Attribute
+
+var DefaultSchema = Schema{
+	{"author-or-director", StringAttribute, true},
+	{"year", YearAttribute, true},
+	{"country", StringAttribute, true},
+	{"genre-or-language", StringAttribute, true},
+}
+
+// The JSON names of the fields every product has before its attributes.
+var BaseProductFields = []string{"p-id", "pg-id", "title", "price"}
+
+// The header of the attributes files: name, type, required.
+var attributeFields = []string{"name", "type", "required"}
+
+// Returns the JSON names of the product fields in the order of a product, e.g. for the field errors of ValidateProduct.
+func (schema Schema) ProductFields() []string {
+	fields := append([]string(nil), BaseProductFields...)
+	for _, attribute := range schema {
+		fields = append(fields, attribute.Name)
+	}
+	return fields
+}
+
+func (schema Schema) Equal(other Schema) bool {
+	if len(schema) != len(other) {
+		return false
+	}
+	for i := range schema {
+		if schema[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (schema Schema) Has(name string) bool {
+	for _, attribute := range schema {
+		if attribute.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the schema, DefaultSchema for a missing (nil) schema.
+func schemaOrDefault(schema Schema) Schema {
+	if schema == nil {
+		return DefaultSchema
+	}
+	return append(Schema{}, schema...)
+}
+
+// The rows of the attributes file.
+func (schema Schema) rows() [][]string {
+	rows := [][]string{}
+	for _, attribute := range schema {
+		rows = append(rows, []string{attribute.Name, string(attribute.Type), strconv.FormatBool(attribute.Required)})
+	}
+	return rows
+}
+
+// The valid values of an attribute type, nil for any text, and the problem of an invalid value.
+var attributeTypes = map[AttributeType]struct {
+	valueRegexp *regexp.Regexp
+	problem     string
+}{
+	StringAttribute:  {nil, ""},
+	IntegerAttribute: {regexp.MustCompile(`^-?[0-9]{1,15}$`), "must be an integer, e.g. 42"},
+	DecimalAttribute: {regexp.MustCompile(`^-?[0-9]{1,15}(\.[0-9]{1,6})?$`), "must be a decimal number, e.g. 12.50"},
+	YearAttribute:    {yearRegexp, "must be a year, e.g. 1968"},
+	BooleanAttribute: {regexp.MustCompile(`^(true|false)$`), "must be true or false"},
+}
+
+func AttributeTypes() (types []string) {
+	for attributeType := range attributeTypes {
+		types = append(types, string(attributeType))
+	}
+	sort.Strings(types)
+	return types
+}
+
+const maxAttributes = 20
+const maxAttributeNameLength = 50
+
+var attributeNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// Returns what is wrong with the attribute, seen has the names of the attributes before it.
+func attributeProblems(attribute Attribute, seen map[string]bool) (problems []string) {
+	if !attributeNameRegexp.MatchString(attribute.Name) || len(attribute.Name) > maxAttributeNameLength {
+		problems = append(problems, "name must be lower case words separated by dashes, at most "+
+			strconv.Itoa(maxAttributeNameLength)+" characters, e.g. release-date: "+attribute.Name)
+	} else if seen[attribute.Name] {
+		problems = append(problems, "duplicate name "+attribute.Name)
+	} else {
+		for _, field := range BaseProductFields {
+			if attribute.Name == field {
+				problems = append(problems, "name "+attribute.Name+" is a product field")
+			}
+		}
+	}
+	if _, found := attributeTypes[attribute.Type]; !found {
+		problems = append(problems, "type must be one of: "+strings.Join(AttributeTypes(), ", "))
+	}
+	return problems
+}
+
+// Returns what is wrong with the schema by the position of the attribute, e.g. "attributes[1]",
+// or "attributes" for the schema as a whole.
+func ValidateSchema(schema Schema) map[string][]string {
+	fieldErrors := make(map[string][]string)
+	if len(schema) > maxAttributes {
+		fieldErrors["attributes"] = []string{"must have at most " + strconv.Itoa(maxAttributes) + " attributes"}
+	}
+	seen := make(map[string]bool)
+	for i, attribute := range schema {
+		if problems := attributeProblems(attribute, seen); len(problems) > 0 {
+			fieldErrors["attributes["+strconv.Itoa(i)+"]"] = problems
+		}
+		seen[attribute.Name] = true
+	}
+	return fieldErrors
+}
+
+// Returns what is wrong with the fields of the product by the field names of schema.ProductFields().
+// The ids are checked by the stores: the product group must exist, and an empty product id means the next free id.
+// An optional attribute may be empty.
+func ValidateProduct(product []string, schema Schema) map[string][]string {
+	fieldErrors := make(map[string][]string)
+	if len(product) != len(BaseProductFields)+len(schema) {
+		fieldErrors["attributes"] = []string{"expected " + strconv.Itoa(len(schema)) + " attributes, got " +
+			strconv.Itoa(len(product)-len(BaseProductFields))}
+		return fieldErrors
+	}
+	if errors := validateText(product[2], maxTitleLength); len(errors) > 0 {
+		fieldErrors["title"] = errors
+	}
+	if errors := validateText(product[3], maxTextLength); len(errors) > 0 {
+		fieldErrors["price"] = errors
+	} else if !priceRegexp.MatchString(product[3]) {
+		fieldErrors["price"] = []string{"must be a non-negative decimal number with at most 2 decimals, e.g. 12.50"}
+	}
+	for i, attribute := range schema {
+		value := product[len(BaseProductFields)+i]
+		if value == "" && !attribute.Required {
+			continue
+		}
+		errors := validateText(value, maxTextLength)
+		if attributeType := attributeTypes[attribute.Type]; len(errors) == 0 && attributeType.valueRegexp != nil &&
+			!attributeType.valueRegexp.MatchString(value) {
+			errors = []string{attributeType.problem}
+		}
+		if len(errors) > 0 {
+			fieldErrors[attribute.Name] = errors
+		}
+	}
+	return fieldErrors
+}
+
+// InvalidProductError is returned by the stores if the product is not valid for the schema of its product group,
+// e.g. if the schema was changed after the product was validated.
+type InvalidProductError struct {
+	Fields map[string][]string
+}
+
+func (e InvalidProductError) Error() string {
+	var problems []string
+	for field, errors := range e.Fields {
+		for _, problem := range errors {
+			problems = append(problems, field+" "+problem)
+		}
+	}
+	sort.Strings(problems)
+	return "Invalid product: " + strings.Join(problems, ", ")
+}
+
+func checkProduct(product []string, schema Schema) error {
+	if fieldErrors := ValidateProduct(product, schema); len(fieldErrors) > 0 {
+		return InvalidProductError{fieldErrors}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package domaindb
+
+import (
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	defer util.LogEnter().Exit()
+	if fieldErrors := ValidateSchema(DefaultSchema); len(fieldErrors) > 0 {
+		t.Errorf("Default schema should have been valid, got: %v", fieldErrors)
+	}
+	if fieldErrors := ValidateSchema(Schema{}); len(fieldErrors) > 0 {
+		t.Errorf("Empty schema should have been valid, got: %v", fieldErrors)
+	}
+	fieldErrors := ValidateSchema(Schema{
+		{"release-date", YearAttribute, true},
+		{"release-date", StringAttribute, false},
+		{"price", DecimalAttribute, true},
+		{"Label", "text", false},
+	})
+	expected := map[string][]string{
+		"attributes[1]": {"duplicate name release-date"},
+		"attributes[2]": {"name price is a product field"},
+		"attributes[3]": {
+			"name must be lower case words separated by dashes, at most 50 characters, e.g. release-date: Label",
+			"type must be one of: boolean, decimal, integer, string, year",
+		},
+	}
+	if !reflect.DeepEqual(fieldErrors, expected) {
+		t.Errorf("Wrong field errors: %v", fieldErrors)
+	}
+	tooMany := make(Schema, maxAttributes+1)
+	for i := range tooMany {
+		tooMany[i] = Attribute{"a" + strings.Repeat("b", i), StringAttribute, false}
+	}
+	if fieldErrors := ValidateSchema(tooMany); len(fieldErrors["attributes"]) != 1 {
+		t.Errorf("Too many attributes should have been invalid, got: %v", fieldErrors)
+	}
+}
+
+func TestValidateProductAttributes(t *testing.T) {
+	defer util.LogEnter().Exit()
+	schema := Schema{
+		{"tracks", IntegerAttribute, true},
+		{"weight", DecimalAttribute, false},
+		{"vinyl", BooleanAttribute, false},
+		{"label", StringAttribute, false},
+	}
+	if fieldErrors := ValidateProduct([]string{"1", "3", "Kind of Blue", "19.90", "-5", "0.18", "true", "Columbia"}, schema); len(fieldErrors) > 0 {
+		t.Errorf("Product should have been valid, got: %v", fieldErrors)
+	}
+	// Optional attributes may be empty.
+	if fieldErrors := ValidateProduct([]string{"", "3", "Blue Train", "9.90", "5", "", "", ""}, schema); len(fieldErrors) > 0 {
+		t.Errorf("Product should have been valid, got: %v", fieldErrors)
+	}
+	fieldErrors := ValidateProduct([]string{"", "3", "Blue Train", "9.90", "", "1,5", "yes", " "}, schema)
+	expected := map[string][]string{
+		"tracks": {"is required"},
+		"weight": {"must be a decimal number, e.g. 12.50"},
+		"vinyl":  {"must be true or false"},
+		"label":  fieldErrors["label"],
+	}
+	if !reflect.DeepEqual(fieldErrors, expected) || len(fieldErrors["label"]) != 1 {
+		t.Errorf("Wrong field errors: %v", fieldErrors)
+	}
+	fieldErrors = ValidateProduct([]string{"", "3", "Blue Train", "9.90", "5"}, schema)
+	if !reflect.DeepEqual(fieldErrors, map[string][]string{"attributes": {"expected 4 attributes, got 1"}}) {
+		t.Errorf("Wrong field errors: %v", fieldErrors)
+	}
+	if err := checkProduct([]string{"", "3", "", "9.90", "x", "", "", ""}, schema); err == nil ||
+		err.Error() != "Invalid product: title is required, tracks must be an integer, e.g. 42" {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
@@ -0,0 +1,660 @@
+package domaindb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"strconv"
+)
+
+// SqlStore serves the products from the product_groups, product_group_attributes and products tables of the database,
+// see sqldb. The attribute values of a product are a JSON array of text in the order of the attributes, and the parent
+// of a product group is NULL for a top level product group.
+type SqlStore struct {
+	db *sqldb.DB
+}
+
+// Creates a store of the migrated database. The TSV files of importDir are imported to a new database once,
+// after that the database is the catalog and the files are not read again.
+func NewSqlStore(ctx context.Context, db *sqldb.DB, importDir string) (*SqlStore, error) {
+	defer util.LogEnter().Exit()
+	_, err := sqldb.RunOnce(ctx, db, "catalog-tsv", func(tx *sql.Tx) error {
+		tsvStore, err := NewTsvStore(importDir)
+		if err != nil {
+			return err
+		}
+		return importProducts(ctx, tx, tsvStore.MemoryStore)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SqlStore{db}, nil
+}
+
+func importProducts(ctx context.Context, tx *sql.Tx, store *MemoryStore) error {
+	// NOTE: The ids have been validated by NewMemoryStore. The parents are set when all the groups are there,
+	// since a parent may have a greater id than the groups under it.
+	for key, name := range store.productGroups.ProductGroupsMap {
+		pgId, _ := strconv.Atoi(key)
+		if _, err := tx.ExecContext(ctx, `INSERT INTO product_groups (pg_id, name) VALUES ($1, $2)`, pgId, name); err != nil {
+			return err
+		}
+		if err := insertSchema(ctx, tx, pgId, store.schemas[pgId]); err != nil {
+			return err
+		}
+		for _, p := range store.rawProductsMap[pgId].RawProductsList {
+			if err := insertProduct(ctx, tx, p); err != nil {
+				return err
+			}
+		}
+	}
+	for pgId, parentId := range store.parents {
+		if parentId == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE product_groups SET parent_id = $1 WHERE pg_id = $2`, parentId, pgId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertSchema(ctx context.Context, tx *sql.Tx, pgId int, schema Schema) error {
+	for i, attribute := range schema {
+		_, err := tx.ExecContext(ctx, `INSERT INTO product_group_attributes (pg_id, position, name, type, required)
+VALUES ($1, $2, $3, $4, $5)`, pgId, i+1, attribute.Name, string(attribute.Type), attribute.Required)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The attribute values of the product as stored in products.attributes.
+func attributesJson(product []string) string {
+	data, _ := json.Marshal(product[len(BaseProductFields):])
+	return string(data)
+}
+
+// Returns the product of the columns of the products table.
+func productOfRow(pId int, pgId int, title string, price string, attributes string) ([]string, error) {
+	var values []string
+	if err := json.Unmarshal([]byte(attributes), &values); err != nil {
+		return nil, err
+	}
+	return append([]string{strconv.Itoa(pId), strconv.Itoa(pgId), title, price}, values...), nil
+}
+
+func insertProduct(ctx context.Context, tx *sql.Tx, product []string) error {
+	pgId, pId, _ := productIds(product)
+	_, err := tx.ExecContext(ctx, `INSERT INTO products (p_id, pg_id, title, price, attributes) VALUES ($1, $2, $3, $4, $5)`,
+		pId, pgId, product[2], product[3], attributesJson(product))
+	return err
+}
+
+func (store *SqlStore) GetProductGroups(ctx context.Context) (ret ProductGroups, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.GetProductGroups", tracing.SpanKindInternal)
+	defer span.End()
+	groups := make(map[string]string)
+	err = store.db.Retry(ctx, func() error {
+		rows, err := store.db.QueryContext(ctx, `SELECT pg_id, name FROM product_groups`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var pgId int
+			var name string
+			if err = rows.Scan(&pgId, &name); err != nil {
+				return err
+			}
+			groups[strconv.Itoa(pgId)] = name
+		}
+		return rows.Err()
+	})
+	span.SetError(err)
+	if err != nil {
+		return ret, err
+	}
+	return ProductGroups{true, groups}, nil
+}
+
+func (store *SqlStore) GetProducts(ctx context.Context, pgId int) (ret Products, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.GetProducts", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.db.Retry(ctx, func() error {
+		ret = Products{}
+		var name string
+		err := store.db.QueryRowContext(ctx, `SELECT name FROM product_groups WHERE pg_id = $1`, pgId).Scan(&name)
+		if err == sql.ErrNoRows {
+			// No such product group.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		rows, err := store.db.QueryContext(ctx, `SELECT p_id, title, price FROM products WHERE pg_id = $1 ORDER BY p_id`, pgId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		ret = Products{nil, "ok"}
+		for rows.Next() {
+			var pId int
+			var title, price string
+			if err = rows.Scan(&pId, &title, &price); err != nil {
+				return err
+			}
+			ret.ProductsList = append(ret.ProductsList, [4]string{strconv.Itoa(pId), strconv.Itoa(pgId), title, price})
+		}
+		return rows.Err()
+	})
+	span.SetError(err)
+	if err != nil {
+		return Products{}, err
+	}
+	return ret, nil
+}
+
+// Returns the parent of every product group, 0 for a top level product group.
+func selectParents(ctx context.Context, q queryer) (map[int]int, error) {
+	rows, err := q.QueryContext(ctx, `SELECT pg_id, COALESCE(parent_id, 0) FROM product_groups`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := make(map[int]int)
+	for rows.Next() {
+		var pgId, parentId int
+		if err = rows.Scan(&pgId, &parentId); err != nil {
+			return nil, err
+		}
+		parents[pgId] = parentId
+	}
+	return parents, rows.Err()
+}
+
+// Returns the parent id as a column value, NULL for the top level.
+func parentColumn(parentId int) interface{} {
+	if parentId == 0 {
+		return nil
+	}
+	return parentId
+}
+
+// An arbitrary key for the advisory lock of the parent changes, see checkParent.
+const parentsLockKey = 4048
+
+// Checks the new parent of the product group, see parentProblem.
+// NOTE: In PostgreSQL two concurrent moves could each see the parents without the other move, e.g. 2 under 3 and
+// 3 under 2, and together commit a loop. So the parent changes wait for each other with a lock held until the end
+// of the transaction, and the parents are selected after the lock, so with the default isolation (read committed)
+// they include the moves committed before. SQLite has a single connection, so nothing is needed.
+func (store *SqlStore) checkParent(ctx context.Context, tx *sql.Tx, pgId int, parentId int) error {
+	if parentId == 0 {
+		return nil
+	}
+	if store.db.Dialect() == sqldb.Postgres {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, parentsLockKey); err != nil {
+			return err
+		}
+	}
+	parents, err := selectParents(ctx, tx)
+	if err != nil {
+		return err
+	} else if problem := parentProblem(pgId, parentId, parents); problem != "" {
+		return InvalidParentError{pgId, parentId, problem}
+	}
+	return nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Returns the product, found is false if there is no such product. lock is appended to the query, see forUpdate.
+func selectProduct(ctx context.Context, q queryer, pgId int, pId int, lock string) (p []string, found bool, err error) {
+	var title, price, attributes string
+	err = q.QueryRowContext(ctx, `SELECT title, price, attributes FROM products WHERE pg_id = $1 AND p_id = $2`+lock,
+		pgId, pId).Scan(&title, &price, &attributes)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	p, err = productOfRow(pId, pgId, title, price, attributes)
+	return p, err == nil, err
+}
+
+// Returns the schema of the product group, empty if there is no such group.
+func selectSchema(ctx context.Context, q queryer, pgId int) (Schema, error) {
+	rows, err := q.QueryContext(ctx, `SELECT name, type, required FROM product_group_attributes WHERE pg_id = $1 ORDER BY position`, pgId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schema := Schema{}
+	for rows.Next() {
+		var attribute Attribute
+		var attributeType string
+		if err = rows.Scan(&attribute.Name, &attributeType, &attribute.Required); err != nil {
+			return nil, err
+		}
+		attribute.Type = AttributeType(attributeType)
+		schema = append(schema, attribute)
+	}
+	return schema, rows.Err()
+}
+
+func (store *SqlStore) GetProduct(ctx context.Context, pgId int, pId int) (ret Product, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.GetProduct", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	span.SetAttribute("product.p_id", pId)
+	var p []string
+	err = store.db.Retry(ctx, func() (err error) {
+		p, _, err = selectProduct(ctx, store.db, pgId, pId, "")
+		return err
+	})
+	if err != nil {
+		span.SetError(err)
+		return ret, err
+	}
+	// NOTE: A product without fields if not found.
+	return Product{p, "ok"}, nil
+}
+
+func (store *SqlStore) GetSchema(ctx context.Context, pgId int) (ret ProductGroupSchema, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.GetSchema", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.db.Retry(ctx, func() error {
+		ret = ProductGroupSchema{}
+		var name string
+		err := store.db.QueryRowContext(ctx, `SELECT name FROM product_groups WHERE pg_id = $1`, pgId).Scan(&name)
+		if err == sql.ErrNoRows {
+			// No such product group.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		schema, err := selectSchema(ctx, store.db, pgId)
+		if err == nil {
+			ret = ProductGroupSchema{pgId, schema, "ok"}
+		}
+		return err
+	})
+	span.SetError(err)
+	if err != nil {
+		return ProductGroupSchema{}, err
+	}
+	return ret, nil
+}
+
+func (store *SqlStore) GetCategories(ctx context.Context) (ret Categories, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.GetCategories", tracing.SpanKindInternal)
+	defer span.End()
+	err = store.db.Retry(ctx, func() error {
+		ret = Categories{[]Category{}, "ok"}
+		rows, err := store.db.QueryContext(ctx, `SELECT pg_id, name, COALESCE(parent_id, 0) FROM product_groups ORDER BY pg_id`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var category Category
+			if err = rows.Scan(&category.PgId, &category.Name, &category.ParentId); err != nil {
+				return err
+			}
+			ret.Categories = append(ret.Categories, category)
+		}
+		return rows.Err()
+	})
+	span.SetError(err)
+	if err != nil {
+		return Categories{}, err
+	}
+	return ret, nil
+}
+
+// Locks the selected rows in PostgreSQL until the end of the transaction, so that a concurrent change waits
+// for the version check and the change of the first one. SQLite has a single connection, so nothing is needed.
+func (store *SqlStore) forUpdate() string {
+	if store.db.Dialect() == sqldb.Postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// Returns the name and the parent of the product group, found is false if there is no such group.
+func (store *SqlStore) selectGroup(ctx context.Context, tx *sql.Tx, pgId int) (name string, parentId int, found bool, err error) {
+	err = tx.QueryRowContext(ctx, `SELECT name, COALESCE(parent_id, 0) FROM product_groups WHERE pg_id = $1`+store.forUpdate(),
+		pgId).Scan(&name, &parentId)
+	if err == sql.ErrNoRows {
+		return "", 0, false, nil
+	}
+	return name, parentId, err == nil, err
+}
+
+// Selects the product group and checks its version. Returns the schema of the product group.
+func (store *SqlStore) checkGroupVersion(ctx context.Context, tx *sql.Tx, pgId int, version string) (Schema, error) {
+	name, parentId, found, err := store.selectGroup(ctx, tx, pgId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, ProductGroupNotFoundError{pgId}
+	}
+	schema, err := selectSchema(ctx, tx, pgId)
+	if err != nil {
+		return nil, err
+	} else if ProductGroupVersion(pgId, parentId, name, schema) != version {
+		return nil, VersionConflictError{"Product group " + strconv.Itoa(pgId)}
+	}
+	return schema, nil
+}
+
+func productCount(ctx context.Context, tx *sql.Tx, pgId int) (count int, err error) {
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM products WHERE pg_id = $1`, pgId).Scan(&count)
+	return count, err
+}
+
+// Selects the product and checks its version.
+func (store *SqlStore) checkProductVersion(ctx context.Context, tx *sql.Tx, pgId int, pId int, version string) error {
+	current, found, err := selectProduct(ctx, tx, pgId, pId, store.forUpdate())
+	if err != nil {
+		return err
+	} else if !found {
+		return ProductNotFoundError{pgId, pId}
+	} else if ProductVersion(current) != version {
+		return VersionConflictError{"Product " + strconv.Itoa(pgId) + "/" + strconv.Itoa(pId)}
+	}
+	return nil
+}
+
+func (store *SqlStore) AddProductGroup(ctx context.Context, pgId int, parentId int, name string, schema Schema) (ret int, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.AddProductGroup", tracing.SpanKindInternal)
+	defer span.End()
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		ret = pgId
+		if ret == 0 {
+			if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(pg_id), 0) + 1 FROM product_groups`).Scan(&ret); err != nil {
+				return err
+			}
+		} else if _, _, found, err := store.selectGroup(ctx, tx, ret); err != nil {
+			return err
+		} else if found {
+			return AlreadyExistsError{"Product group " + strconv.Itoa(ret)}
+		}
+		if err := store.checkParent(ctx, tx, ret, parentId); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO product_groups (pg_id, name, parent_id) VALUES ($1, $2, $3)`,
+			ret, name, parentColumn(parentId))
+		if sqldb.IsUniqueViolation(err) {
+			// Another server added the same id first.
+			return AlreadyExistsError{"Product group " + strconv.Itoa(ret)}
+		} else if err != nil {
+			return err
+		}
+		return insertSchema(ctx, tx, ret, schemaOrDefault(schema))
+	})
+	span.SetAttribute("product.pg_id", ret)
+	span.SetError(err)
+	return ret, err
+}
+
+func (store *SqlStore) UpdateProductGroup(ctx context.Context, pgId int, parentId int, name string, schema Schema, version string) (err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.UpdateProductGroup", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		current, err := store.checkGroupVersion(ctx, tx, pgId, version)
+		if err != nil {
+			return err
+		}
+		if schema != nil && !schema.Equal(current) {
+			if count, err := productCount(ctx, tx, pgId); err != nil {
+				return err
+			} else if count > 0 {
+				return ProductGroupNotEmptyError{pgId}
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM product_group_attributes WHERE pg_id = $1`, pgId); err != nil {
+				return err
+			}
+			if err := insertSchema(ctx, tx, pgId, schema); err != nil {
+				return err
+			}
+		}
+		if err := store.checkParent(ctx, tx, pgId, parentId); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE product_groups SET name = $1, parent_id = $2 WHERE pg_id = $3`,
+			name, parentColumn(parentId), pgId)
+		return err
+	})
+	span.SetError(err)
+	return err
+}
+
+func (store *SqlStore) DeleteProductGroup(ctx context.Context, pgId int, version string) (err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.DeleteProductGroup", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		if _, err := store.checkGroupVersion(ctx, tx, pgId, version); err != nil {
+			return err
+		}
+		if count, err := productCount(ctx, tx, pgId); err != nil {
+			return err
+		} else if count > 0 {
+			return ProductGroupNotEmptyError{pgId}
+		}
+		var children int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM product_groups WHERE parent_id = $1`, pgId).Scan(&children); err != nil {
+			return err
+		} else if children > 0 {
+			return ProductGroupHasChildrenError{pgId}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM product_group_attributes WHERE pg_id = $1`, pgId); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM product_groups WHERE pg_id = $1`, pgId)
+		return err
+	})
+	span.SetError(err)
+	return err
+}
+
+func (store *SqlStore) AddProduct(ctx context.Context, product []string) (ret []string, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.AddProduct", tracing.SpanKindInternal)
+	defer span.End()
+	pgId, pId, err := productIds(product)
+	if err != nil {
+		return ret, err
+	}
+	span.SetAttribute("product.pg_id", pgId)
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		// NOTE: Locks the product group, so that concurrent adds get different ids.
+		if _, _, found, err := store.selectGroup(ctx, tx, pgId); err != nil {
+			return err
+		} else if !found {
+			return ProductGroupNotFoundError{pgId}
+		}
+		if schema, err := selectSchema(ctx, tx, pgId); err != nil {
+			return err
+		} else if err = checkProduct(product, schema); err != nil {
+			return err
+		}
+		id := pId
+		if id == 0 {
+			if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(p_id), 0) + 1 FROM products WHERE pg_id = $1`, pgId).Scan(&id); err != nil {
+				return err
+			}
+		} else if _, found, err := selectProduct(ctx, tx, pgId, id, ""); err != nil {
+			return err
+		} else if found {
+			return AlreadyExistsError{"Product " + product[1] + "/" + product[0]}
+		}
+		ret = append([]string{strconv.Itoa(id)}, product[1:]...)
+		return insertProduct(ctx, tx, ret)
+	})
+	span.SetError(err)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (store *SqlStore) UpdateProduct(ctx context.Context, product []string, version string) (err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.UpdateProduct", tracing.SpanKindInternal)
+	defer span.End()
+	pgId, pId, err := productIds(product)
+	if err != nil {
+		return err
+	}
+	span.SetAttribute("product.pg_id", pgId)
+	span.SetAttribute("product.p_id", pId)
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := store.checkProductVersion(ctx, tx, pgId, pId, version); err != nil {
+			return err
+		}
+		if schema, err := selectSchema(ctx, tx, pgId); err != nil {
+			return err
+		} else if err = checkProduct(product, schema); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE products SET title = $1, price = $2, attributes = $3 WHERE pg_id = $4 AND p_id = $5`,
+			product[2], product[3], attributesJson(product), pgId, pId)
+		return err
+	})
+	span.SetError(err)
+	return err
+}
+
+func (store *SqlStore) DeleteProduct(ctx context.Context, pgId int, pId int, version string) (err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.DeleteProduct", tracing.SpanKindInternal)
+	defer span.End()
+	span.SetAttribute("product.pg_id", pgId)
+	span.SetAttribute("product.p_id", pId)
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := store.checkProductVersion(ctx, tx, pgId, pId, version); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM products WHERE pg_id = $1 AND p_id = $2`, pgId, pId)
+		return err
+	})
+	span.SetError(err)
+	return err
+}
+
+// NOTE: The products are in the order of their ids, the database does not keep the order of the TSV files.
+func (store *SqlStore) ExportCatalog(ctx context.Context) (ret Catalog, err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.ExportCatalog", tracing.SpanKindInternal)
+	defer span.End()
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		ret = Catalog{make(map[string]string), make(map[string]string), make(map[string]Schema), nil}
+		rows, err := tx.QueryContext(ctx, `SELECT pg_id, name, COALESCE(parent_id, 0) FROM product_groups`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var pgId, parentId int
+			var name string
+			if err = rows.Scan(&pgId, &name, &parentId); err != nil {
+				return err
+			}
+			ret.ProductGroups[strconv.Itoa(pgId)] = name
+			ret.Schemas[strconv.Itoa(pgId)] = Schema{}
+			if parentId != 0 {
+				ret.Parents[strconv.Itoa(pgId)] = strconv.Itoa(parentId)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		attributeRows, err := tx.QueryContext(ctx, `SELECT pg_id, name, type, required FROM product_group_attributes ORDER BY pg_id, position`)
+		if err != nil {
+			return err
+		}
+		defer attributeRows.Close()
+		for attributeRows.Next() {
+			var pgId int
+			var attribute Attribute
+			var attributeType string
+			if err = attributeRows.Scan(&pgId, &attribute.Name, &attributeType, &attribute.Required); err != nil {
+				return err
+			}
+			attribute.Type = AttributeType(attributeType)
+			ret.Schemas[strconv.Itoa(pgId)] = append(ret.Schemas[strconv.Itoa(pgId)], attribute)
+		}
+		if err = attributeRows.Err(); err != nil {
+			return err
+		}
+		productRows, err := tx.QueryContext(ctx, `SELECT p_id, pg_id, title, price, attributes FROM products ORDER BY pg_id, p_id`)
+		if err != nil {
+			return err
+		}
+		defer productRows.Close()
+		for productRows.Next() {
+			var pId, pgId int
+			var title, price, attributes string
+			if err = productRows.Scan(&pId, &pgId, &title, &price, &attributes); err != nil {
+				return err
+			}
+			p, err := productOfRow(pId, pgId, title, price, attributes)
+			if err != nil {
+				return err
+			}
+			ret.Products = append(ret.Products, p)
+		}
+		return productRows.Err()
+	})
+	span.SetError(err)
+	if err != nil {
+		return Catalog{}, err
+	}
+	return ret, nil
+}
+
+func (store *SqlStore) ImportCatalog(ctx context.Context, catalog Catalog) (err error) {
+	defer util.LogEnter().Exit()
+	ctx, span := tracing.StartSpan(ctx, "domaindb.ImportCatalog", tracing.SpanKindInternal)
+	defer span.End()
+	imported, err := NewMemoryStore(catalog)
+	if err == nil {
+		err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM products`); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM product_group_attributes`); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE product_groups SET parent_id = NULL`); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM product_groups`); err != nil {
+				return err
+			}
+			return importProducts(ctx, tx, imported)
+		})
+	}
+	span.SetError(err)
+	return err
+}
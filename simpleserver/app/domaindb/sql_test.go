@@ -0,0 +1,62 @@
+package domaindb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDb(t *testing.T, dir string) *sqldb.DB {
+	db, err := sqldb.OpenSqlite(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Opening the database failed: %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSqlStoreImportsOnce(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	dbDir, tsvDir := t.TempDir(), writeTsvFiles(t, smallCatalog)
+	if _, err := NewSqlStore(ctx, openTestDb(t, dbDir), filepath.Join(tsvDir, "missing")); err == nil {
+		t.Errorf("Importing missing files should have failed")
+	}
+	if _, err := NewSqlStore(ctx, openTestDb(t, dbDir), tsvDir); err != nil {
+		t.Fatalf("Importing the products failed: %s", err.Error())
+	}
+	// After the import the database is the catalog: changed or removed files are not read again.
+	ioutil.WriteFile(filepath.Join(tsvDir, "product-groups.csv"), []byte("1\tBooks\n"), 0600)
+	os.Remove(filepath.Join(tsvDir, "pg-3-products.csv"))
+	store, err := NewSqlStore(ctx, openTestDb(t, dbDir), tsvDir)
+	if err != nil {
+		t.Fatalf("Reopening the store failed: %s", err.Error())
+	}
+	if productGroups, _ := store.GetProductGroups(ctx); len(productGroups.ProductGroupsMap) != 2 {
+		t.Errorf("Products should have been imported only once, got: %v", productGroups)
+	}
+}
+
+func TestSqlStoreErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	db := openTestDb(t, t.TempDir())
+	store, err := NewSqlStore(ctx, db, writeTsvFiles(t, smallCatalog))
+	if err != nil {
+		t.Fatalf("Importing the products failed: %s", err.Error())
+	}
+	db.Close()
+	if _, err := store.GetProductGroups(ctx); err == nil {
+		t.Errorf("Getting product groups of a closed database should have failed")
+	}
+	if _, err := store.GetProducts(ctx, 1); err == nil {
+		t.Errorf("Getting products of a closed database should have failed")
+	}
+	if _, err := store.GetProduct(ctx, 1, 10); err == nil {
+		t.Errorf("Getting a product of a closed database should have failed")
+	}
+}
@@ -0,0 +1,653 @@
+package domaindb
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exporting and importing the whole catalog, so that the merchandisers can edit it in other tools than a TSV editor.
+// The formats are json, csv (one comma separated file with a header), zip (the TSV files as comma separated
+// files with headers) and ods (an OpenDocument spreadsheet with a sheet per TSV file, see ods.go).
+// NOTE: All the fields are kept as text, e.g. the price 3.90 stays 3.90, so a round trip does not change the catalog.
+
+// Catalog is the whole catalog: the product groups (id => name), the parents of the product groups that are not
+// at the top level (id => parent id, see category.go), the schemas of their products (id => schema, a missing schema
+// is DefaultSchema) and the products in their order within each product group.
+type Catalog struct {
+	ProductGroups map[string]string
+	Parents       map[string]string
+	Schemas       map[string]Schema
+	Products      [][]string
+}
+
+func (catalog Catalog) schema(pgId string) Schema {
+	return schemaOrDefault(catalog.Schemas[pgId])
+}
+
+// Returns the parent of the product group, 0 for a top level product group.
+func (catalog Catalog) parent(pgId string) int {
+	parentId, _ := strconv.Atoi(catalog.Parents[pgId])
+	return parentId
+}
+
+// Returns the product group ids in order and the products of each product group.
+func (catalog Catalog) groups() (pgIds []int, products map[int][][]string) {
+	products = make(map[int][][]string)
+	for key := range catalog.ProductGroups {
+		pgId, _ := strconv.Atoi(key)
+		pgIds = append(pgIds, pgId)
+	}
+	sort.Ints(pgIds)
+	for _, product := range catalog.Products {
+		pgId, _ := strconv.Atoi(product[1])
+		products[pgId] = append(products[pgId], product)
+	}
+	return pgIds, products
+}
+
+// CatalogError is a problem in a catalog file at the line, line 0 is the whole file.
+type CatalogError struct {
+	File    string
+	Line    int
+	Problem string
+}
+
+func (e CatalogError) Error() string {
+	if e.Line == 0 {
+		return e.File + ": " + e.Problem
+	}
+	return e.File + ":" + strconv.Itoa(e.Line) + ": " + e.Problem
+}
+
+// CatalogErrors are all the problems found in the catalog files, one per line.
+type CatalogErrors []CatalogError
+
+func (e CatalogErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, catalogError := range e {
+		lines[i] = catalogError.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *CatalogErrors) add(file string, line int, problem string) {
+	*e = append(*e, CatalogError{file, line, problem})
+}
+
+// Sorts the errors by line within each file, keeping the files in the order they were read.
+func (e CatalogErrors) sort() {
+	fileOrder := make(map[string]int)
+	for _, catalogError := range e {
+		if _, found := fileOrder[catalogError.File]; !found {
+			fileOrder[catalogError.File] = len(fileOrder)
+		}
+	}
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].File != e[j].File {
+			return fileOrder[e[i].File] < fileOrder[e[j].File]
+		}
+		return e[i].Line < e[j].Line
+	})
+}
+
+// UnsupportedAttributesError is returned when writing a catalog with other attributes than DefaultSchema
+// in a format that has only the default attributes.
+type UnsupportedAttributesError struct {
+	Format string
+	PgId   int
+}
+
+func (e UnsupportedAttributesError) Error() string {
+	return "The " + e.Format + " format has only the default attributes, product group " + strconv.Itoa(e.PgId) +
+		" has others, use one of: json, ods, zip"
+}
+
+// UnsupportedCategoriesError is returned when writing a catalog with nested product groups in a format that has
+// only top level product groups.
+type UnsupportedCategoriesError struct {
+	Format string
+	PgId   int
+}
+
+func (e UnsupportedCategoriesError) Error() string {
+	return "The " + e.Format + " format has only top level product groups, product group " + strconv.Itoa(e.PgId) +
+		" has a parent, use one of: json, ods, zip"
+}
+
+type UnknownCatalogFormatError struct {
+	Format string
+}
+
+func (e UnknownCatalogFormatError) Error() string {
+	return "Unknown catalog format: " + e.Format + ", formats: " + strings.Join(CatalogFormats(), ", ")
+}
+
+// A row of a catalog file with its position.
+type catalogRow struct {
+	file   string
+	line   int
+	fields []string
+}
+
+// Reads the CSV rows that have fieldCount fields, adding the other rows to the errors.
+// If header is given, the first row must be the header, and it is not returned.
+func readCsvRows(reader io.Reader, file string, comma rune, header []string, fieldCount int, errs *CatalogErrors) (rows []catalogRow) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = comma
+	csvReader.FieldsPerRecord = -1
+	for {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			if header != nil {
+				errs.add(file, 0, "header is missing, expected: "+strings.Join(header, ","))
+			}
+			return rows
+		}
+		if parseError, ok := err.(*csv.ParseError); ok {
+			errs.add(file, parseError.Line, parseError.Err.Error())
+			continue
+		}
+		if err != nil {
+			errs.add(file, 0, err.Error())
+			return rows
+		}
+		line, _ := csvReader.FieldPos(0)
+		if header != nil {
+			if strings.Join(fields, "\t") != strings.Join(header, "\t") {
+				errs.add(file, line, "expected the header: "+strings.Join(header, ","))
+				return nil
+			}
+			header = nil
+			continue
+		}
+		if len(fields) != fieldCount {
+			errs.add(file, line, "expected "+strconv.Itoa(fieldCount)+" columns, got "+strconv.Itoa(len(fields)))
+			continue
+		}
+		rows = append(rows, catalogRow{file, line, fields})
+	}
+}
+
+// Writes the rows as CSV, e.g. comma ',' and the header first.
+func writeCsvRows(writer io.Writer, comma rune, rows [][]string) error {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Comma = comma
+	csvWriter.WriteAll(rows)
+	return csvWriter.Error()
+}
+
+// Parses a product group or product id, which must be a positive integer in its plain form, e.g. not 007.
+func parseCatalogId(id string) (int, bool) {
+	value, err := strconv.Atoi(id)
+	return value, err == nil && value > 0 && strconv.Itoa(value) == id
+}
+
+// Builds a catalog from the rows read in any format, checking the ids, duplicates and the fields (see ValidateProduct).
+// The problems are collected with the positions of the rows.
+type catalogBuilder struct {
+	errs         CatalogErrors
+	catalog      Catalog
+	groupLines   map[string]int
+	productLines map[[2]string]int
+	// The rows of the parents, checked when all the product groups have been added.
+	parentRows map[string]catalogRow
+}
+
+func newCatalogBuilder() *catalogBuilder {
+	return &catalogBuilder{
+		catalog:      Catalog{make(map[string]string), make(map[string]string), make(map[string]Schema), nil},
+		groupLines:   make(map[string]int),
+		productLines: make(map[[2]string]int),
+		parentRows:   make(map[string]catalogRow),
+	}
+}
+
+func alsoAt(line int) string {
+	if line == 0 {
+		return ""
+	}
+	return ", also at line " + strconv.Itoa(line)
+}
+
+// Adds the product group, returns false if its id is not valid or is already used.
+func (builder *catalogBuilder) addProductGroup(row catalogRow, pgId string, name string) bool {
+	if _, ok := parseCatalogId(pgId); !ok {
+		builder.errs.add(row.file, row.line, "product group id is not a positive integer: "+pgId)
+		return false
+	}
+	if line, found := builder.groupLines[pgId]; found {
+		builder.errs.add(row.file, row.line, "duplicate product group id "+pgId+alsoAt(line))
+		return false
+	}
+	builder.groupLines[pgId] = row.line
+	for _, problem := range ValidateProductGroupName(name) {
+		builder.errs.add(row.file, row.line, "name "+problem)
+	}
+	builder.catalog.ProductGroups[pgId] = name
+	builder.catalog.Schemas[pgId] = DefaultSchema
+	return true
+}
+
+// Reads the schema of the product group from the rows of its attributes file: name, type, required.
+func (builder *catalogBuilder) addSchema(pgId string, rows []catalogRow) {
+	schema := Schema{}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		attribute := Attribute{row.fields[0], AttributeType(row.fields[1]), row.fields[2] == "true"}
+		problems := attributeProblems(attribute, seen)
+		if row.fields[2] != "true" && row.fields[2] != "false" {
+			problems = append(problems, "required must be true or false")
+		}
+		for _, problem := range problems {
+			builder.errs.add(row.file, row.line, problem)
+		}
+		seen[attribute.Name] = true
+		schema = append(schema, attribute)
+	}
+	if len(schema) > maxAttributes {
+		builder.errs.add(rows[0].file, 0, "must have at most "+strconv.Itoa(maxAttributes)+" attributes")
+	}
+	builder.catalog.Schemas[pgId] = schema
+}
+
+// Sets the schema of the product group, which is where in the catalog, see ValidateSchema.
+func (builder *catalogBuilder) setSchema(pgId string, where string, schema Schema) {
+	fieldErrors := ValidateSchema(schema)
+	var positions []string
+	for position := range fieldErrors {
+		positions = append(positions, position)
+	}
+	sort.Strings(positions)
+	for _, position := range positions {
+		for _, problem := range fieldErrors[position] {
+			builder.errs.add(where+"."+position, 0, problem)
+		}
+	}
+	builder.catalog.Schemas[pgId] = schemaOrDefault(schema)
+}
+
+// Sets the parent of the product group, which must have been added. An empty parent id is the top level.
+func (builder *catalogBuilder) setParent(row catalogRow, pgId string, parentId string) {
+	if _, found := builder.groupLines[pgId]; !found {
+		builder.errs.add(row.file, row.line, "unknown product group id: "+pgId)
+	} else if _, found := builder.parentRows[pgId]; found {
+		builder.errs.add(row.file, row.line, "duplicate product group id "+pgId+alsoAt(builder.parentRows[pgId].line))
+	} else if _, ok := parseCatalogId(parentId); !ok && parentId != "" {
+		builder.errs.add(row.file, row.line, "parent product group id is not a positive integer: "+parentId)
+	} else if parentId != "" {
+		builder.parentRows[pgId] = row
+		builder.catalog.Parents[pgId] = parentId
+	}
+}
+
+// Checks that the parents are product groups and that no product group is under itself.
+func (builder *catalogBuilder) checkParents() {
+	parents := make(map[int]int)
+	for key := range builder.catalog.ProductGroups {
+		pgId, _ := strconv.Atoi(key)
+		parents[pgId] = builder.catalog.parent(key)
+	}
+	// NOTE: In the order of the ids, since the problems of a JSON catalog have no lines to sort by.
+	var pgIds []int
+	for key := range builder.parentRows {
+		pgId, _ := strconv.Atoi(key)
+		pgIds = append(pgIds, pgId)
+	}
+	sort.Ints(pgIds)
+	for _, pgId := range pgIds {
+		row := builder.parentRows[strconv.Itoa(pgId)]
+		if problem := parentProblem(pgId, parents[pgId], parents); problem != "" {
+			builder.errs.add(row.file, row.line, problem)
+		}
+	}
+}
+
+// Adds the product to its product group, which must have been added with its schema. If the row is from the products file
+// of a product group, filePgId is its id and the product must be in it.
+func (builder *catalogBuilder) addProduct(row catalogRow, product []string, filePgId string) {
+	key := [2]string{product[1], product[0]}
+	if _, ok := parseCatalogId(product[0]); !ok {
+		builder.errs.add(row.file, row.line, "product id is not a positive integer: "+product[0])
+	} else if line, found := builder.productLines[key]; found {
+		builder.errs.add(row.file, row.line, "duplicate product id "+product[0]+alsoAt(line))
+	} else {
+		builder.productLines[key] = row.line
+	}
+	schemaPgId := product[1]
+	if filePgId != "" && product[1] != filePgId {
+		builder.errs.add(row.file, row.line, "product group id "+product[1]+" does not match the file")
+		schemaPgId = filePgId
+	} else if _, found := builder.groupLines[product[1]]; !found {
+		builder.errs.add(row.file, row.line, "unknown product group id: "+product[1])
+	}
+	// NOTE: The fields of a product of an unknown product group cannot be checked.
+	if schema, found := builder.catalog.Schemas[schemaPgId]; found {
+		fieldErrors := ValidateProduct(product, schema)
+		for _, field := range append([]string{"attributes"}, schema.ProductFields()[2:]...) {
+			for _, problem := range fieldErrors[field] {
+				builder.errs.add(row.file, row.line, field+" "+problem)
+			}
+		}
+	}
+	builder.catalog.Products = append(builder.catalog.Products, product)
+}
+
+// Returns the catalog, or CatalogErrors with all the problems found.
+func (builder *catalogBuilder) result() (Catalog, error) {
+	builder.checkParents()
+	if len(builder.errs) > 0 {
+		builder.errs.sort()
+		return Catalog{}, builder.errs
+	}
+	return builder.catalog, nil
+}
+
+// Reads the rows of a catalog file, e.g. "pg-1-products", that have the fields of the header, the formats which have
+// headers check it. The problems go to errs. found is false if there is no such file, which is a problem
+// unless the file is optional.
+type catalogFileReader func(name string, header []string, optional bool, errs *CatalogErrors) (rows []catalogRow, found bool)
+
+var productGroupFields = []string{"pg-id", "name"}
+var parentFields = []string{"pg-id", "parent-id"}
+
+// Reads the catalog of the files like the TSV files: product-groups (id, name), for each product group the optional
+// pg-<id>-attributes (name, type, required) and pg-<id>-products, and the optional product-group-parents (id, parent id),
+// checking every row: the number of columns, the ids, the attributes, the product group of the products,
+// duplicate ids, the fields and the parents.
+// The error is CatalogErrors with all the problems found.
+func readCatalog(read catalogFileReader) (Catalog, error) {
+	builder := newCatalogBuilder()
+	rows, _ := read("product-groups", productGroupFields, false, &builder.errs)
+	for _, row := range rows {
+		pgId := row.fields[0]
+		if !builder.addProductGroup(row, pgId, row.fields[1]) {
+			continue
+		}
+		if attributeRows, found := read("pg-"+pgId+"-attributes", attributeFields, true, &builder.errs); found {
+			builder.addSchema(pgId, attributeRows)
+		}
+		productRows, _ := read("pg-"+pgId+"-products", builder.catalog.Schemas[pgId].ProductFields(), false, &builder.errs)
+		for _, productRow := range productRows {
+			builder.addProduct(productRow, productRow.fields, pgId)
+		}
+	}
+	parentRows, _ := read("product-group-parents", parentFields, true, &builder.errs)
+	for _, row := range parentRows {
+		builder.setParent(row, row.fields[0], row.fields[1])
+	}
+	return builder.result()
+}
+
+// A catalog file like the TSV files, e.g. "pg-1-products", with the header of the formats which have headers.
+type catalogFile struct {
+	name   string
+	header []string
+	rows   [][]string
+}
+
+// Returns the files of the catalog like the TSV files. A product group with DefaultSchema has no attributes file,
+// and a catalog with only top level product groups has no parents file.
+func catalogFiles(catalog Catalog) []catalogFile {
+	pgIds, products := catalog.groups()
+	groups := catalogFile{"product-groups", productGroupFields, [][]string{}}
+	parents := catalogFile{"product-group-parents", parentFields, [][]string{}}
+	var files []catalogFile
+	for _, pgId := range pgIds {
+		key := strconv.Itoa(pgId)
+		groups.rows = append(groups.rows, []string{key, catalog.ProductGroups[key]})
+		if parentId := catalog.parent(key); parentId != 0 {
+			parents.rows = append(parents.rows, []string{key, strconv.Itoa(parentId)})
+		}
+		schema := catalog.schema(key)
+		if !schema.Equal(DefaultSchema) {
+			files = append(files, catalogFile{"pg-" + key + "-attributes", attributeFields, schema.rows()})
+		}
+		productsFile := catalogFile{"pg-" + key + "-products", schema.ProductFields(), [][]string{}}
+		for _, product := range products[pgId] {
+			productsFile.rows = append(productsFile.rows, append([]string(nil), product...))
+		}
+		files = append(files, productsFile)
+	}
+	if len(parents.rows) > 0 {
+		files = append(files, parents)
+	}
+	return append([]catalogFile{groups}, files...)
+}
+
+type jsonCatalog struct {
+	ProductGroups []jsonProductGroup `json:"product-groups"`
+}
+
+// NOTE: Without the attributes the product group has DefaultSchema, and without the parent it is at the top level.
+type jsonProductGroup struct {
+	PgId       int           `json:"pg-id"`
+	ParentId   int           `json:"parent-id,omitempty"`
+	Name       string        `json:"name"`
+	Attributes Schema        `json:"attributes"`
+	Products   []jsonProduct `json:"products"`
+}
+
+// NOTE: The price and the attribute values are strings like in the TSV files, an empty value is left out.
+type jsonProduct struct {
+	PId        int               `json:"p-id"`
+	Title      string            `json:"title"`
+	Price      string            `json:"price"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func writeJsonCatalog(writer io.Writer, catalog Catalog) error {
+	pgIds, products := catalog.groups()
+	document := jsonCatalog{[]jsonProductGroup{}}
+	for _, pgId := range pgIds {
+		key := strconv.Itoa(pgId)
+		schema := catalog.schema(key)
+		group := jsonProductGroup{pgId, catalog.parent(key), catalog.ProductGroups[key], schema, []jsonProduct{}}
+		for _, p := range products[pgId] {
+			pId, _ := strconv.Atoi(p[0])
+			attributes := make(map[string]string)
+			for i, attribute := range schema {
+				if value := p[len(BaseProductFields)+i]; value != "" {
+					attributes[attribute.Name] = value
+				}
+			}
+			group.Products = append(group.Products, jsonProduct{pId, p[2], p[3], attributes})
+		}
+		document.ProductGroups = append(document.ProductGroups, group)
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+// NOTE: JSON has no lines, so the position of a problem is the path of the product group or the product.
+func readJsonCatalog(data []byte) (Catalog, error) {
+	var document jsonCatalog
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&document); err != nil {
+		return Catalog{}, CatalogErrors{{"catalog.json", 0, err.Error()}}
+	}
+	builder := newCatalogBuilder()
+	for i, group := range document.ProductGroups {
+		where := "catalog.json product-groups[" + strconv.Itoa(i) + "]"
+		pgId := strconv.Itoa(group.PgId)
+		if !builder.addProductGroup(catalogRow{where, 0, nil}, pgId, group.Name) {
+			continue
+		}
+		if group.ParentId != 0 {
+			builder.setParent(catalogRow{where, 0, nil}, pgId, strconv.Itoa(group.ParentId))
+		}
+		builder.setSchema(pgId, where, group.Attributes)
+		schema := builder.catalog.Schemas[pgId]
+		for j, p := range group.Products {
+			position := catalogRow{where + ".products[" + strconv.Itoa(j) + "]", 0, nil}
+			product := []string{strconv.Itoa(p.PId), pgId, p.Title, p.Price}
+			for _, attribute := range schema {
+				product = append(product, p.Attributes[attribute.Name])
+			}
+			var unknown []string
+			for name := range p.Attributes {
+				if !schema.Has(name) {
+					unknown = append(unknown, name)
+				}
+			}
+			sort.Strings(unknown)
+			for _, name := range unknown {
+				builder.errs.add(position.file, 0, "unknown attribute "+name)
+			}
+			builder.addProduct(position, product, pgId)
+		}
+	}
+	return builder.result()
+}
+
+// One row per product with its product group, and a row without the product fields for an empty product group.
+// NOTE: The columns are the same for all the product groups, so the product groups must have DefaultSchema,
+// and there is no column for the parent of a product group.
+var csvCatalogHeader = []string{"pg-id", "product-group", "p-id", "title", "price", "author-or-director", "year", "country", "genre-or-language"}
+
+func writeCsvCatalog(writer io.Writer, catalog Catalog) error {
+	pgIds, products := catalog.groups()
+	rows := [][]string{csvCatalogHeader}
+	for _, pgId := range pgIds {
+		key := strconv.Itoa(pgId)
+		if !catalog.schema(key).Equal(DefaultSchema) {
+			return UnsupportedAttributesError{"csv", pgId}
+		}
+		if catalog.parent(key) != 0 {
+			return UnsupportedCategoriesError{"csv", pgId}
+		}
+		if len(products[pgId]) == 0 {
+			rows = append(rows, []string{key, catalog.ProductGroups[key], "", "", "", "", "", "", ""})
+		}
+		for _, p := range products[pgId] {
+			rows = append(rows, []string{key, catalog.ProductGroups[key], p[0], p[2], p[3], p[4], p[5], p[6], p[7]})
+		}
+	}
+	return writeCsvRows(writer, ',', rows)
+}
+
+func readCsvCatalog(data []byte) (Catalog, error) {
+	builder := newCatalogBuilder()
+	names := make(map[string]string)
+	valid := make(map[string]bool)
+	for _, row := range readCsvRows(bytes.NewReader(data), "catalog.csv", ',', csvCatalogHeader, len(csvCatalogHeader), &builder.errs) {
+		pgId, name := row.fields[0], row.fields[1]
+		if firstName, found := names[pgId]; !found {
+			names[pgId] = name
+			valid[pgId] = builder.addProductGroup(row, pgId, name)
+		} else if name != firstName {
+			builder.errs.add(row.file, row.line, "product group "+pgId+" has another name on an earlier line: "+firstName)
+		}
+		if !valid[pgId] || strings.Join(row.fields[2:], "") == "" {
+			continue
+		}
+		product := append([]string{row.fields[2], pgId}, row.fields[3:]...)
+		builder.addProduct(row, product, "")
+	}
+	return builder.result()
+}
+
+func writeZipCatalog(writer io.Writer, catalog Catalog) error {
+	zipWriter := zip.NewWriter(writer)
+	for _, file := range catalogFiles(catalog) {
+		fileWriter, err := zipWriter.Create(file.name + ".csv")
+		if err != nil {
+			return err
+		}
+		if err = writeCsvRows(fileWriter, ',', append([][]string{file.header}, file.rows...)); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+// NOTE: The files may be in a directory of the zip file.
+func readZipCatalog(data []byte) (Catalog, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Catalog{}, CatalogErrors{{"catalog.zip", 0, err.Error()}}
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range zipReader.File {
+		files[path.Base(file.Name)] = file
+	}
+	return readCatalog(func(name string, header []string, optional bool, errs *CatalogErrors) ([]catalogRow, bool) {
+		file, found := files[name+".csv"]
+		if !found {
+			if !optional {
+				errs.add(name+".csv", 0, "file is missing")
+			}
+			return nil, false
+		}
+		reader, err := file.Open()
+		if err != nil {
+			errs.add(name+".csv", 0, err.Error())
+			return nil, true
+		}
+		defer reader.Close()
+		return readCsvRows(reader, name+".csv", ',', header, len(header), errs), true
+	})
+}
+
+type catalogFormat struct {
+	contentType string
+	write       func(writer io.Writer, catalog Catalog) error
+	read        func(data []byte) (Catalog, error)
+}
+
+// The catalog formats by name, the name is also the file extension.
+var catalogFormats = map[string]catalogFormat{
+	"json": {"application/json", writeJsonCatalog, readJsonCatalog},
+	"csv":  {"text/csv; charset=utf-8", writeCsvCatalog, readCsvCatalog},
+	"zip":  {"application/zip", writeZipCatalog, readZipCatalog},
+	"ods":  {"application/vnd.oasis.opendocument.spreadsheet", writeOdsCatalog, readOdsCatalog},
+}
+
+func CatalogFormats() (formats []string) {
+	for format := range catalogFormats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Returns the content type of the format, empty for an unknown format.
+func CatalogContentType(format string) string {
+	return catalogFormats[format].contentType
+}
+
+// Writes the catalog in the format, see CatalogFormats.
+func WriteCatalog(writer io.Writer, format string, catalog Catalog) error {
+	defer util.LogEnter().Exit()
+	catalogFormat, found := catalogFormats[format]
+	if !found {
+		return UnknownCatalogFormatError{format}
+	}
+	return catalogFormat.write(writer, catalog)
+}
+
+// Reads the catalog in the format, checking it like the TSV files are checked. The error is CatalogErrors
+// with all the problems found, or UnknownCatalogFormatError.
+func ReadCatalog(reader io.Reader, format string) (Catalog, error) {
+	defer util.LogEnter().Exit()
+	catalogFormat, found := catalogFormats[format]
+	if !found {
+		return Catalog{}, UnknownCatalogFormatError{format}
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return Catalog{}, err
+	}
+	return catalogFormat.read(data)
+}
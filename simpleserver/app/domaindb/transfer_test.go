@@ -0,0 +1,225 @@
+package domaindb
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Text the formats must keep as it is: quotes, separators, markup and spaces.
+var trickyCatalog = Catalog{
+	map[string]string{"1": `Books, "Classics"`, "7": "  Spaced  out ", "8": "Empty"},
+	map[string]string{},
+	map[string]Schema{"1": DefaultSchema, "7": DefaultSchema, "8": DefaultSchema},
+	[][]string{
+		{"2", "1", `The "Kalevala", 2nd ed.`, "3.90", "Elias Lönnrot", "0835", "Finland", "Finnish; <b>&amp;</b>"},
+		{"1", "1", "Moby Dick", "45", "Herman Melville", "1851", "United States", "English"},
+		{"5", "7", " a  b   c ", "0.5", "x'y", "1", "=SUM(A1)", "日本語"},
+	},
+}
+
+// A product group with attributes of its own, see Schema.
+var musicCatalog = Catalog{
+	map[string]string{"1": "Books", "3": "Music"},
+	map[string]string{},
+	map[string]Schema{"1": DefaultSchema, "3": {
+		{"artist", StringAttribute, true},
+		{"tracks", IntegerAttribute, true},
+		{"weight", DecimalAttribute, false},
+		{"vinyl", BooleanAttribute, false},
+	}},
+	[][]string{
+		{"1", "1", "Moby Dick", "45", "Herman Melville", "1851", "United States", "English"},
+		{"2", "3", "Kind of Blue", "19.90", "Miles Davis", "5", "0.18", "true"},
+		{"3", "3", "Blue Train", "9.90", "John Coltrane", "5", "", ""},
+	},
+}
+
+// Nested product groups: Movies > Drama > Crime, the parent of Crime has a greater id.
+var movieCatalog = Catalog{
+	map[string]string{"2": "Movies", "3": "Crime", "5": "Drama", "6": "Comedy"},
+	map[string]string{"3": "5", "5": "2", "6": "2"},
+	map[string]Schema{"2": DefaultSchema, "3": DefaultSchema, "5": DefaultSchema, "6": DefaultSchema},
+	[][]string{
+		{"1", "2", "Koyaanisqatsi", "12.50", "Godfrey Reggio", "1982", "United States", "English"},
+		{"1", "3", "The Godfather", "9.90", "Francis Ford Coppola", "1972", "United States", "English"},
+		{"2", "5", "Ikiru", "14.90", "Akira Kurosawa", "1952", "Japan", "Japanese"},
+	},
+}
+
+func TestCatalogRoundTrip(t *testing.T) {
+	defer util.LogEnter().Exit()
+	store, err := NewTsvStore(ResourceDir())
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	resources, _ := store.ExportCatalog(context.Background())
+	for _, format := range CatalogFormats() {
+		for _, catalog := range []Catalog{resources, trickyCatalog, musicCatalog, movieCatalog} {
+			var buf bytes.Buffer
+			err := WriteCatalog(&buf, format, catalog)
+			if _, ok := err.(UnsupportedAttributesError); ok && format == "csv" && !catalog.Schemas["3"].Equal(DefaultSchema) {
+				continue
+			} else if _, ok := err.(UnsupportedCategoriesError); ok && format == "csv" && len(catalog.Parents) > 0 {
+				continue
+			} else if err != nil {
+				t.Fatalf("Writing %s failed: %s", format, err.Error())
+			}
+			read, err := ReadCatalog(&buf, format)
+			if err != nil {
+				t.Fatalf("Reading %s failed: %s", format, err.Error())
+			}
+			if !reflect.DeepEqual(read, catalog) {
+				t.Errorf("Round trip of %s should not have changed the catalog, got:\n%v\nexpected:\n%v", format, read, catalog)
+			}
+		}
+	}
+	if _, err := ReadCatalog(strings.NewReader(""), "xlsx"); err == nil || err.Error() != "Unknown catalog format: xlsx, formats: csv, json, ods, zip" {
+		t.Errorf("Unknown format should have failed, got: %v", err)
+	}
+}
+
+// The files written after importing an export are the same as the original files.
+func TestImportedTsvFiles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	store, err := NewTsvStore(writeTsvFiles(t, smallCatalog))
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	resources, _ := NewTsvStore(ResourceDir())
+	for _, format := range CatalogFormats() {
+		catalog, _ := resources.ExportCatalog(context.Background())
+		var buf bytes.Buffer
+		WriteCatalog(&buf, format, catalog)
+		if catalog, err = ReadCatalog(&buf, format); err == nil {
+			err = store.ImportCatalog(context.Background(), catalog)
+		}
+		if err != nil {
+			t.Fatalf("Importing %s failed: %s", format, err.Error())
+		}
+		for _, name := range []string{"product-groups.csv", "pg-1-products.csv", "pg-2-products.csv"} {
+			original, _ := ioutil.ReadFile(filepath.Join(ResourceDir(), name))
+			if imported, _ := ioutil.ReadFile(filepath.Join(store.Dir(), name)); !bytes.Equal(imported, original) {
+				t.Errorf("Imported %s should have been the same as the original", name)
+			}
+		}
+		if matches, _ := filepath.Glob(filepath.Join(store.Dir(), "pg-3-products.csv")); len(matches) > 0 {
+			t.Errorf("Products file of a product group not in the import should have been removed")
+		}
+	}
+}
+
+func TestReadCatalogReportsProblems(t *testing.T) {
+	defer util.LogEnter().Exit()
+	var zipFile bytes.Buffer
+	zipWriter := zip.NewWriter(&zipFile)
+	writer, _ := zipWriter.Create("catalog/product-groups.csv")
+	writer.Write([]byte("pg-id,name\n1,Books\n2,Movies\n"))
+	writer, _ = zipWriter.Create("catalog/pg-1-products.csv")
+	writer.Write([]byte("p-id,pg-id,title\n"))
+	writer, _ = zipWriter.Create("catalog/product-group-parents.csv")
+	writer.Write([]byte("pg-id,parent-id\n1,2\n2,1\n2,3\n4,1\n"))
+	writer, _ = zipWriter.Create("catalog/pg-2-attributes.csv")
+	writer.Write([]byte("name,type,required\ndirector,string,yes\nyear,date,true\n"))
+	zipWriter.Close()
+	tests := []struct {
+		format   string
+		data     string
+		problems []string
+	}{
+		{"json", `{"product-groups": [{"pg-id": 1, "name": "Books", "products": [{"p-id": 1, "title": "Kalevala", "price": "3.95"}]}, {"pg-id": 1, "name": "Films"}]}`,
+			[]string{
+				"catalog.json product-groups[0].products[0]: author-or-director is required",
+				"catalog.json product-groups[0].products[0]: year is required",
+				"catalog.json product-groups[0].products[0]: country is required",
+				"catalog.json product-groups[0].products[0]: genre-or-language is required",
+				"catalog.json product-groups[1]: duplicate product group id 1",
+			}},
+		{"json", `{"product-groups": [{"pg-id": 3, "parent-id": 2, "name": "Drama"}, {"pg-id": 2, "name": "Movies"}, {"pg-id": 4, "parent-id": 9, "name": "Crime"}]}`,
+			[]string{"catalog.json product-groups[2]: parent product group 9 does not exist"}},
+		{"json", `{"product-groups": [{"id": 1}]}`, []string{`catalog.json: json: unknown field "id"`}},
+		{"json", `{"product-groups": [{"pg-id": 3, "name": "Music", "attributes": [{"name": "Artist", "type": "string"}, {"name": "tracks", "type": "integer", "required": true}], ` +
+			`"products": [{"p-id": 1, "title": "Kind of Blue", "price": "19.90", "attributes": {"tracks": "five", "label": "Columbia"}}]}]}`,
+			[]string{
+				"catalog.json product-groups[0].attributes[0]: name must be lower case words separated by dashes, at most 50 characters, e.g. release-date: Artist",
+				"catalog.json product-groups[0].products[0]: unknown attribute label",
+				"catalog.json product-groups[0].products[0]: tracks must be an integer, e.g. 42",
+			}},
+		{"csv", "pg-id,product-group,p-id,title,price,author-or-director,year,country,genre-or-language\n" +
+			"1,Books,1,Kalevala,3.95,Elias Lönnrot,1835,Finland,Finnish\n" +
+			"1,Novels,1,Kalevala,3.95,Elias Lönnrot,1835,Finland,Finnish\n" +
+			"2,Movies,,,,,,,\n" +
+			"x,Games,1,Tetris,1,Alexey Pajitnov,1984,Soviet Union,Puzzle\n" +
+			"2,Movies,1,Short\n",
+			[]string{
+				"catalog.csv:3: product group 1 has another name on an earlier line: Books",
+				"catalog.csv:3: duplicate product id 1, also at line 2",
+				"catalog.csv:5: product group id is not a positive integer: x",
+				"catalog.csv:6: expected 9 columns, got 4",
+			}},
+		{"csv", "id,name\n", []string{"catalog.csv:1: expected the header: pg-id,product-group,p-id,title,price,author-or-director,year,country,genre-or-language"}},
+		{"zip", zipFile.String(), []string{
+			"pg-1-products.csv:1: expected the header: p-id,pg-id,title,price,author-or-director,year,country,genre-or-language",
+			"pg-2-attributes.csv:2: required must be true or false",
+			"pg-2-attributes.csv:3: type must be one of: boolean, decimal, integer, string, year",
+			"pg-2-products.csv: file is missing",
+			"product-group-parents.csv:2: product group 1 cannot be under itself",
+			"product-group-parents.csv:3: product group 2 cannot be under itself",
+			"product-group-parents.csv:4: duplicate product group id 2, also at line 3",
+			"product-group-parents.csv:5: unknown product group id: 4",
+		}},
+		{"ods", "not a zip", []string{"catalog.ods: zip: not a valid zip file"}},
+	}
+	for _, test := range tests {
+		_, err := ReadCatalog(strings.NewReader(test.data), test.format)
+		if _, ok := err.(CatalogErrors); !ok || err.Error() != strings.Join(test.problems, "\n") {
+			t.Errorf("Reading %s should have failed with:\n%s\ngot:\n%v", test.format, strings.Join(test.problems, "\n"), err)
+		}
+	}
+}
+
+// The behavior every CatalogEditor must have.
+func TestImportExportCatalog(t *testing.T) {
+	defer util.LogEnter().Exit()
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			ctx := context.Background()
+			editor := testStore.open(t, writeTsvFiles(t, smallCatalog)).(CatalogEditor)
+			catalog, err := editor.ExportCatalog(ctx)
+			if err != nil || len(catalog.ProductGroups) != 2 || len(catalog.Products) != 2 || catalog.Products[1][2] != "Moby Dick" {
+				t.Errorf("Wrong catalog: %v, %v", catalog, err)
+			}
+			if err = editor.ImportCatalog(ctx, trickyCatalog); err != nil {
+				t.Fatalf("Importing failed: %s", err.Error())
+			}
+			if productGroups, _ := editor.GetProductGroups(ctx); !reflect.DeepEqual(productGroups.ProductGroupsMap, trickyCatalog.ProductGroups) {
+				t.Errorf("Product groups should have been replaced, got: %v", productGroups)
+			}
+			if product, _ := editor.GetProduct(ctx, 1, 10); len(product.Product) != 0 {
+				t.Errorf("Old product should have been removed, got: %v", product)
+			}
+			if product, _ := editor.GetProduct(ctx, 7, 5); !reflect.DeepEqual(product.Product, trickyCatalog.Products[2]) {
+				t.Errorf("Imported product should have been found, got: %v", product)
+			}
+			catalog, _ = editor.ExportCatalog(ctx)
+			if len(catalog.Products) != 3 {
+				t.Errorf("Wrong products: %v", catalog.Products)
+			}
+			if err = editor.ImportCatalog(ctx, musicCatalog); err != nil {
+				t.Fatalf("Importing attributes failed: %s", err.Error())
+			}
+			if schema, _ := editor.GetSchema(ctx, 3); !schema.Attributes.Equal(musicCatalog.Schemas["3"]) {
+				t.Errorf("Imported schema should have been found, got: %v", schema)
+			}
+			if catalog, _ = editor.ExportCatalog(ctx); !reflect.DeepEqual(catalog, musicCatalog) {
+				t.Errorf("Export should have been the same as the import, got:\n%v\nexpected:\n%v", catalog, musicCatalog)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package domaindb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// TsvStore is a MemoryStore loaded from the tab separated files of a resource directory: product-groups.csv (id, name),
+// for each product group pg-<id>-products.csv and pg-<id>-attributes.csv if it has other attributes than DefaultSchema,
+// and product-group-parents.csv (id, parent id) if some product groups are not at the top level.
+// The changes to the catalog are written back to the files.
+type TsvStore struct {
+	*MemoryStore
+	dir string
+	// The files the catalog was loaded from or last written to, see reload.go. Guarded by the store mutex.
+	fingerprint string
+	// The changed files seen by the last poll and the files rejected by the last reload, used by the watcher only.
+	pending  string
+	rejected string
+}
+
+// Returns resource_dir, or the resources directory of the source tree if resource_dir is not set.
+// NOTE: The source tree default is for development and tests, a deployed binary should set resource_dir.
+func ResourceDir() string {
+	if dir := util.MyConfig["resource_dir"]; dir != "" {
+		return dir
+	}
+	_, fileName, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(fileName), "..", "..", "resources")
+}
+
+// Reads the rows of the file that have fieldCount fields, adding the other rows to the errors.
+// found is false if there is no such file, which is a problem unless the file is optional.
+func readTsvFile(dir string, name string, fieldCount int, optional bool, errs *CatalogErrors) (rows []catalogRow, found bool) {
+	defer util.LogEnter().Exit()
+	file, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		if !optional {
+			errs.add(name, 0, "file is missing")
+		}
+		return nil, false
+	}
+	if err != nil {
+		errs.add(name, 0, err.Error())
+		return nil, true
+	}
+	defer file.Close()
+	return readCsvRows(file, name, '\t', nil, fieldCount, errs), true
+}
+
+// Reads the product groups and their products from the files of the directory, checking every row (see readCatalog).
+// The error is CatalogErrors with all the problems found.
+func readTsvFiles(dir string) (*MemoryStore, error) {
+	defer util.LogEnter().Exit()
+	// NOTE: The files have no headers.
+	catalog, err := readCatalog(func(name string, header []string, optional bool, errs *CatalogErrors) ([]catalogRow, bool) {
+		return readTsvFile(dir, name+".csv", len(header), optional, errs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewMemoryStore(catalog)
+}
+
+// Checks the catalog files of the directory like they are checked when loaded, see readTsvFiles.
+// Returns the number of product groups and products.
+func ValidateTsvFiles(dir string) (groupCount int, productCount int, err error) {
+	defer util.LogEnter().Exit()
+	store, err := readTsvFiles(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(store.rawProductsMap), store.productCount(), nil
+}
+
+func NewTsvStore(dir string) (*TsvStore, error) {
+	defer util.LogEnter().Exit()
+	util.LogDebug("Loading products from: " + dir)
+	// NOTE: Taken before reading, so that a file changed during the reading is reloaded by the watcher.
+	fingerprint, err := tsvFingerprint(dir)
+	if err != nil {
+		return nil, err
+	}
+	store, err := readTsvFiles(dir)
+	if err != nil {
+		return nil, errors.New("Invalid catalog files in " + dir + ":\n" + err.Error())
+	}
+	util.LogDebug("Loaded " + strconv.Itoa(store.productCount()) + " products in " + strconv.Itoa(len(store.rawProductsMap)) + " product groups")
+	tsvStore := &TsvStore{MemoryStore: store, dir: dir, fingerprint: fingerprint}
+	store.persist = tsvStore.writeGroups
+	return tsvStore, nil
+}
+
+func (store *TsvStore) Dir() string {
+	return store.dir
+}
+
+// Formats the lines like the catalog files are formatted.
+func tsvContent(lines [][]string) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Comma = '\t'
+	writer.WriteAll(lines)
+	return buffer.Bytes(), writer.Error()
+}
+
+// Writes the content to a temporary file next to the file, returns the name of the temporary file.
+func writeTempTsvFile(fileName string, content []byte) (tmpName string, err error) {
+	file, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+"-")
+	if err != nil {
+		return "", err
+	}
+	if _, err = file.Write(content); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Returns an unused name for the backup of the file, next to the file.
+func backupTsvName(fileName string) (string, error) {
+	file, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+"-backup-")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), os.Remove(file.Name())
+}
+
+// Renames the files when saving, replaced in the tests to make saving fail halfway.
+var renameFile = os.Rename
+
+// A catalog file to save: the new lines, or remove the file.
+type tsvFileChange struct {
+	fileName string
+	lines    [][]string
+	remove   bool
+	// The new content, written before any file is replaced.
+	tmpName string
+	// The old file, kept until all the files are saved, so that it can be restored.
+	backupName string
+}
+
+// Saves the files all or nothing. The new contents are written to temporary files and the old files are linked to
+// backup files first, then the files are replaced (or removed) in order. If replacing a file fails, the files replaced
+// so far are restored from the backups. The files whose content does not change are not touched.
+// changed tells whether any file was replaced, also when the files were restored after a failure.
+func saveTsvFiles(changes []tsvFileChange) (changed bool, err error) {
+	var steps []*tsvFileChange
+	defer func() {
+		for _, step := range steps {
+			if step.tmpName != "" {
+				os.Remove(step.tmpName)
+			}
+			if step.backupName != "" {
+				os.Remove(step.backupName)
+			}
+		}
+	}()
+	for i := range changes {
+		change := &changes[i]
+		old, err := ioutil.ReadFile(change.fileName)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		exists := err == nil
+		if change.remove && !exists {
+			continue
+		}
+		var content []byte
+		if !change.remove {
+			if content, err = tsvContent(change.lines); err != nil {
+				return false, err
+			}
+			if exists && bytes.Equal(old, content) {
+				continue
+			}
+		}
+		steps = append(steps, change)
+		if !change.remove {
+			if change.tmpName, err = writeTempTsvFile(change.fileName, content); err != nil {
+				return false, err
+			}
+		}
+		if exists {
+			backupName, err := backupTsvName(change.fileName)
+			if err != nil {
+				return false, err
+			}
+			// NOTE: The removed files are renamed to the backups, the replaced files stay in place until replaced.
+			if !change.remove {
+				if err = os.Link(change.fileName, backupName); err != nil {
+					return false, err
+				}
+			}
+			change.backupName = backupName
+		}
+	}
+	for done, step := range steps {
+		if step.remove {
+			err = renameFile(step.fileName, step.backupName)
+		} else {
+			err = renameFile(step.tmpName, step.fileName)
+		}
+		if err != nil {
+			restoreTsvFiles(steps[:done])
+			return done > 0, err
+		}
+		step.tmpName = ""
+	}
+	return len(steps) > 0, nil
+}
+
+// Undoes the replaced and removed files in reverse order.
+func restoreTsvFiles(steps []*tsvFileChange) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		var err error
+		if step.backupName != "" {
+			err = os.Rename(step.backupName, step.fileName)
+			step.backupName = ""
+		} else {
+			err = os.Remove(step.fileName)
+		}
+		if err != nil {
+			util.LogError("Couldn't restore " + step.fileName + ": " + err.Error())
+		}
+	}
+}
+
+// Writes the changed product groups to the files, called by MemoryStore with the store locked.
+// The files are saved all or nothing, see saveTsvFiles, and a reader sees either the old or the new file.
+// The products files of new groups are in place before product-groups.csv lists the groups, and the files of deleted
+// groups are removed after it. product-group-parents.csv is written after product-groups.csv, since it refers to the groups.
+func (store *TsvStore) writeGroups(changedPgIds ...int) error {
+	defer util.LogEnter().Exit()
+	groupsFile := filepath.Join(store.dir, "product-groups.csv")
+	parentsFile := filepath.Join(store.dir, "product-group-parents.csv")
+	var changes []tsvFileChange
+	var removes []string
+	for _, pgId := range changedPgIds {
+		productsFile := filepath.Join(store.dir, "pg-"+strconv.Itoa(pgId)+"-products.csv")
+		attributesFile := filepath.Join(store.dir, "pg-"+strconv.Itoa(pgId)+"-attributes.csv")
+		if _, found := store.productGroups.ProductGroupsMap[strconv.Itoa(pgId)]; !found {
+			removes = append(removes, productsFile, attributesFile)
+			continue
+		}
+		if schema := store.schemas[pgId]; schema.Equal(DefaultSchema) {
+			// NOTE: The schema changes only while the product group is empty, so the files are valid in between.
+			removes = append(removes, attributesFile)
+		} else {
+			changes = append(changes, tsvFileChange{fileName: attributesFile, lines: schema.rows()})
+		}
+		changes = append(changes, tsvFileChange{fileName: productsFile, lines: store.rawProductsMap[pgId].RawProductsList})
+	}
+	var groupLines, parentLines [][]string
+	for _, id := range store.pgIds() {
+		key := strconv.Itoa(id)
+		groupLines = append(groupLines, []string{key, store.productGroups.ProductGroupsMap[key]})
+		if parentId := store.parents[id]; parentId != 0 {
+			parentLines = append(parentLines, []string{key, strconv.Itoa(parentId)})
+		}
+	}
+	changes = append(changes, tsvFileChange{fileName: groupsFile, lines: groupLines})
+	if len(parentLines) == 0 {
+		removes = append([]string{parentsFile}, removes...)
+	} else {
+		changes = append(changes, tsvFileChange{fileName: parentsFile, lines: parentLines})
+	}
+	for _, file := range removes {
+		changes = append(changes, tsvFileChange{fileName: file, remove: true})
+	}
+	changed, err := saveTsvFiles(changes)
+	// NOTE: Our own changes are not reloaded by the watcher, neither are the files restored after a failure.
+	if changed {
+		if fingerprint, fingerprintErr := tsvFingerprint(store.dir); fingerprintErr == nil {
+			store.fingerprint = fingerprint
+		}
+	}
+	if err != nil {
+		return err
+	}
+	util.LogInfo("Saved " + strconv.Itoa(len(changedPgIds)) + " product groups to " + store.dir)
+	return nil
+}
@@ -0,0 +1,252 @@
+package domaindb
+
+import (
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTsvFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Writing %s failed: %s", name, err.Error())
+		}
+	}
+	return dir
+}
+
+func TestNewTsvStore(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := writeTsvFiles(t, map[string]string{
+		"product-groups.csv": "1\tBooks\n",
+		"pg-1-products.csv":  "10\t1\tKalevala\t3.95\tElias Lönnrot\t1835\tFinland\tFinnish\n",
+	})
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	if store.Dir() != dir {
+		t.Errorf("Wrong dir: %s", store.Dir())
+	}
+	if product, _ := store.GetProduct(context.Background(), 1, 10); product.Product[4] != "Elias Lönnrot" {
+		t.Errorf("Wrong product: %v", product)
+	}
+}
+
+func TestNewTsvStoreAttributes(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := writeTsvFiles(t, map[string]string{
+		"product-groups.csv":  "1\tBooks\n3\tMusic\n",
+		"pg-1-products.csv":   "",
+		"pg-3-attributes.csv": "artist\tstring\ttrue\ntracks\tinteger\ttrue\nvinyl\tboolean\tfalse\n",
+		"pg-3-products.csv":   "20\t3\tKind of Blue\t19.90\tMiles Davis\t5\t\n",
+	})
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	ctx := context.Background()
+	if schema, _ := store.GetSchema(ctx, 3); len(schema.Attributes) != 3 || schema.Attributes[1] != (Attribute{"tracks", IntegerAttribute, true}) {
+		t.Errorf("Wrong schema: %v", schema)
+	}
+	if schema, _ := store.GetSchema(ctx, 1); !schema.Attributes.Equal(DefaultSchema) {
+		t.Errorf("Product group without an attributes file should have had the default schema, got: %v", schema)
+	}
+	if _, err := store.AddProductGroup(ctx, 4, 0, "Games", Schema{}); err != nil {
+		t.Fatalf("Adding the product group failed: %s", err.Error())
+	}
+	if attributes, err := ioutil.ReadFile(filepath.Join(dir, "pg-4-attributes.csv")); err != nil || len(attributes) != 0 {
+		t.Errorf("Empty schema should have been saved to an empty file, got: %q, %v", attributes, err)
+	}
+	if err := store.DeleteProductGroup(ctx, 4, ProductGroupVersion(4, 0, "Games", Schema{})); err != nil {
+		t.Fatalf("Deleting the product group failed: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pg-4-attributes.csv")); !os.IsNotExist(err) {
+		t.Errorf("Attributes file of a deleted product group should have been removed, got: %v", err)
+	}
+}
+
+func TestNewTsvStoreInvalidFiles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	tests := []struct {
+		name  string
+		files map[string]string
+		error string
+	}{
+		{"missing groups", map[string]string{}, "product-groups.csv"},
+		{"missing products", map[string]string{"product-groups.csv": "1\tBooks\n"}, "pg-1-products.csv"},
+		{"missing field", map[string]string{"product-groups.csv": "1\tBooks\n", "pg-1-products.csv": "10\t1\tKalevala\n"}, "pg-1-products.csv"},
+		{"wrong attributes", map[string]string{"product-groups.csv": "1\tBooks\n", "pg-1-attributes.csv": "artist\tstring\ttrue\n", "pg-1-products.csv": "10\t1\tKalevala\t3.95\tElias Lönnrot\t1835\n"}, "pg-1-products.csv:1: expected 5 columns, got 6"},
+		{"wrong group", map[string]string{"product-groups.csv": "1\tBooks\n", "pg-1-products.csv": "10\t2\tKalevala\t3.95\ta\t1835\tb\tc\n"}, "pg-1-products.csv:1: product group id 2 does not match the file"},
+	}
+	for _, test := range tests {
+		_, err := NewTsvStore(writeTsvFiles(t, test.files))
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Loading %s should have failed with %s, got: %v", test.name, test.error, err)
+		}
+	}
+}
+
+func TestReadTsvFilesReportsAllProblems(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := writeTsvFiles(t, map[string]string{
+		"product-groups.csv": "1\tBooks\nx\tMovies\n1\tNovels\n3\n4\tMu\\tsic\n\"5\tMusic\n",
+		"pg-1-products.csv": "10\t1\tKalevala\t3.95\tElias Lönnrot\t1835\tFinland\tFinnish\n" +
+			"10\t1\tKalevala\t3.95\tElias Lönnrot\t1835\tFinland\tFinnish\n" +
+			"11\t1\tShort\n" +
+			"12\t2\tMoby Dick\t45.35\tHerman Melville\t1851\tUnited States\tEnglish\n" +
+			"-1\t1\t\tfree\tNobody\tMCM\tNowhere\tNone\n",
+	})
+	_, err := readTsvFiles(dir)
+	errs, ok := err.(CatalogErrors)
+	if !ok {
+		t.Fatalf("Loading should have failed with CatalogErrors, got: %v", err)
+	}
+	expected := []string{
+		"product-groups.csv:2: product group id is not a positive integer: x",
+		"product-groups.csv:3: duplicate product group id 1, also at line 1",
+		"product-groups.csv:4: expected 2 columns, got 1",
+		"product-groups.csv:6: extraneous or missing \" in quoted-field",
+		"pg-1-products.csv:2: duplicate product id 10, also at line 1",
+		"pg-1-products.csv:3: expected 8 columns, got 3",
+		"pg-1-products.csv:4: product group id 2 does not match the file",
+		"pg-1-products.csv:5: product id is not a positive integer: -1",
+		"pg-1-products.csv:5: title is required",
+		"pg-1-products.csv:5: price must be a non-negative decimal number with at most 2 decimals, e.g. 12.50",
+		"pg-1-products.csv:5: year must be a year, e.g. 1968",
+		"pg-4-products.csv: file is missing",
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("Wrong problems, expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), err.Error())
+	}
+	if len(errs) != len(expected) || errs[0].File != "product-groups.csv" || errs[0].Line != 2 {
+		t.Errorf("Wrong errors: %#v", errs)
+	}
+}
+
+func TestValidateTsvFiles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	groupCount, productCount, err := ValidateTsvFiles(ResourceDir())
+	if err != nil || groupCount != 2 || productCount == 0 {
+		t.Errorf("Resource files should have been valid, got: %d, %d, %v", groupCount, productCount, err)
+	}
+}
+
+func TestResourceDir(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous, ok := util.MyConfig["resource_dir"]
+	defer func() {
+		if ok {
+			util.MyConfig["resource_dir"] = previous
+		} else {
+			delete(util.MyConfig, "resource_dir")
+		}
+	}()
+	util.MyConfig["resource_dir"] = "/opt/simpleserver/resources"
+	if dir := ResourceDir(); dir != "/opt/simpleserver/resources" {
+		t.Errorf("resource_dir should have been used, got: %s", dir)
+	}
+	util.MyConfig["resource_dir"] = ""
+	if dir := ResourceDir(); !strings.HasSuffix(dir, "resources") {
+		t.Errorf("Source tree resources should have been the default, got: %s", dir)
+	}
+}
+
+func TestTsvStoreSavesChanges(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	dir := writeTsvFiles(t, smallCatalog)
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	store.AddProductGroup(ctx, 0, 0, "Music", nil)
+	store.AddProduct(ctx, []string{"", "4", "Kind of Blue", "9.90", "Miles Davis", "1959", "United States", "Jazz"})
+	store.DeleteProductGroup(ctx, 3, ProductGroupVersion(3, 0, "Games", DefaultSchema))
+	store.UpdateProductGroup(ctx, 1, 0, "Books \"and\" more", nil, ProductGroupVersion(1, 0, "Books", DefaultSchema))
+	groups, _ := ioutil.ReadFile(filepath.Join(dir, "product-groups.csv"))
+	if string(groups) != "1\t\"Books \"\"and\"\" more\"\n4\tMusic\n" {
+		t.Errorf("Wrong product groups file: %q", groups)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pg-3-products.csv")); !os.IsNotExist(err) {
+		t.Errorf("Products file of the deleted product group should have been removed, got: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, ".*")); len(files) != 0 {
+		t.Errorf("Temporary files should have been renamed, got: %v", files)
+	}
+	reloaded, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Reloading products failed: %s", err.Error())
+	}
+	if product, _ := reloaded.GetProduct(ctx, 4, 1); product.Product[2] != "Kind of Blue" {
+		t.Errorf("Added product should have been saved, got: %v", product)
+	}
+	if productGroups, _ := reloaded.GetProductGroups(ctx); len(productGroups.ProductGroupsMap) != 2 || productGroups.ProductGroupsMap["1"] != "Books \"and\" more" {
+		t.Errorf("Wrong product groups: %v", productGroups)
+	}
+}
+
+func TestTsvStoreSavesOnlyChangedFiles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	dir := writeTsvFiles(t, smallCatalog)
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	groupsFile := filepath.Join(dir, "product-groups.csv")
+	before, _ := os.Stat(groupsFile)
+	if _, err := store.AddProduct(ctx, []string{"", "1", "Seitsemän veljestä", "12.50", "Aleksis Kivi", "1870", "Finland", "Finnish"}); err != nil {
+		t.Fatalf("Adding the product failed: %s", err.Error())
+	}
+	if after, _ := os.Stat(groupsFile); !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Product groups file should not have been rewritten for a product")
+	}
+	if products, _ := ioutil.ReadFile(filepath.Join(dir, "pg-1-products.csv")); !strings.Contains(string(products), "Seitsemän veljestä") {
+		t.Errorf("Products file should have been saved, got: %q", products)
+	}
+}
+
+// A failure in the middle of saving restores the files saved so far, and the restored files are not reloaded.
+func TestTsvStoreSaveFailure(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	dir := writeTsvFiles(t, smallCatalog)
+	store, err := NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	// NOTE: The products file of a deleted group is removed after product-groups.csv is replaced.
+	defer func() { renameFile = os.Rename }()
+	renameFile = func(from string, to string) error {
+		if filepath.Base(from) == "pg-3-products.csv" {
+			return errors.New("disk failed")
+		}
+		return os.Rename(from, to)
+	}
+	if err := store.DeleteProductGroup(ctx, 3, ProductGroupVersion(3, 0, "Games", DefaultSchema)); err == nil || !strings.Contains(err.Error(), "disk failed") {
+		t.Fatalf("Deleting the product group should have failed, got: %v", err)
+	}
+	if groups, _ := ioutil.ReadFile(filepath.Join(dir, "product-groups.csv")); string(groups) != smallCatalog["product-groups.csv"] {
+		t.Errorf("Product groups file should have been restored, got: %q", groups)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pg-3-products.csv")); err != nil {
+		t.Errorf("Products file of the group should have been kept: %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, ".*")); len(files) != 0 {
+		t.Errorf("Temporary and backup files should have been removed, got: %v", files)
+	}
+	if productGroups, _ := store.GetProductGroups(ctx); productGroups.ProductGroupsMap["3"] != "Games" {
+		t.Errorf("Failed delete should have been undone, got: %v", productGroups)
+	}
+	store.reloadIfChanged()
+	if reloaded, err := store.reloadIfChanged(); reloaded || err != nil {
+		t.Errorf("Restored files should not have been reloaded: %v, %v", reloaded, err)
+	}
+}
@@ -0,0 +1,92 @@
+package mail
+
+import (
+	"context"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes each mail as an .eml file to the outbox directory, i.e. nothing is sent.
+// Useful in development and tests: the mails can be read from the directory.
+type FileMailer struct {
+	dir     string
+	mutex   sync.Mutex
+	counter int
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "simpleserver-outbox")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9@._-]`)
+
+func (mailer *FileMailer) Send(ctx context.Context, message Message) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	now := time.Now()
+	mailer.counter++
+	// NOTE: The timestamp and the counter keep the file names unique and in sending order.
+	name := now.UTC().Format("20060102T150405.000000000") + "-" + strconv.Itoa(mailer.counter) + "-" +
+		unsafeFileNameChars.ReplaceAllString(message.To, "_") + ".eml"
+	tmpName := filepath.Join(mailer.dir, "."+name)
+	// Write and rename, so that a reader never sees a half written mail.
+	if err := ioutil.WriteFile(tmpName, format(message, now), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(mailer.dir, name))
+}
+
+func (mailer *FileMailer) Dir() string {
+	return mailer.dir
+}
+
+// Reads the mails in the outbox in sending order.
+func (mailer *FileMailer) Messages() (messages []Message, err error) {
+	files, err := filepath.Glob(filepath.Join(mailer.dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	decoder := new(mime.WordDecoder)
+	for _, file := range files {
+		content, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := mail.ReadMessage(content)
+		var body []byte
+		if err == nil {
+			body, err = ioutil.ReadAll(parsed.Body)
+		}
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+		subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject"))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, Message{
+			From:    parsed.Header.Get("From"),
+			To:      parsed.Header.Get("To"),
+			Subject: subject,
+			Body:    strings.Replace(string(body), "\r\n", "\n", -1),
+		})
+	}
+	return messages, nil
+}
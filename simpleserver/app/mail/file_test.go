@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %s", err.Error())
+	}
+	sent := []Message{
+		{"from@foo.com", "kari.karttinen@foo.com", "Salasanan vaihto – ohjeet", "Hei Kari,\n\nvaihda salasana.\n"},
+		{"from@foo.com", "../timo tillinen@foo.com", "Second", "Second mail"},
+	}
+	for _, message := range sent {
+		if err = mailer.Send(context.Background(), message); err != nil {
+			t.Fatalf("Send failed: %s", err.Error())
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Errorf("Outbox should have had 2 files, got: %v", files)
+	}
+	messages, err := mailer.Messages()
+	if err != nil {
+		t.Fatalf("Messages failed: %s", err.Error())
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Should have read 2 mails, got: %d", len(messages))
+	}
+	for i, message := range messages {
+		if message != sent[i] {
+			t.Errorf("Mail %d was not read back as sent, expected: %v, got: %v", i+1, sent[i], message)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// The mail package.
+// Sends mails to the users, e.g. password reset links.
+
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"mime"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string // Plain text.
+}
+
+// Using Mailer interface the callers do not need to know how the mails are delivered.
+// SmtpMailer sends the mails, FileMailer writes them to an outbox directory for development and tests.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// Mailer singleton.
+var myMailer = initMailer()
+
+func initMailer() Mailer {
+	defer util.LogEnter().Exit()
+	var mailer Mailer
+	var err error
+	switch sender := util.MyConfig["mail_sender"]; sender {
+	case "", "file":
+		mailer, err = NewFileMailer(util.MyConfig["mail_outbox_dir"])
+	case "smtp":
+		timeout := time.Duration(util.MyConfig.GetInt("smtp_timeout_ms", 10000)) * time.Millisecond
+		mailer, err = NewSmtpMailer(util.MyConfig["smtp_host"], util.MyConfig.GetInt("smtp_port", 587),
+			util.MyConfig["smtp_username"], util.MyConfig["smtp_password"], timeout)
+	default:
+		err = errors.New("Unknown mail_sender: " + sender)
+	}
+	if err != nil {
+		util.LogError("Mail disabled, couldn't create mailer: " + err.Error())
+		return nil
+	}
+	return mailer
+}
+
+// Sends the message with the mailer singleton. From defaults to mail_from.
+func Send(ctx context.Context, message Message) error {
+	defer util.LogEnter().Exit()
+	if myMailer == nil {
+		return errors.New("Mail is disabled, see mail_sender")
+	}
+	if message.From == "" {
+		message.From = util.MyConfig["mail_from"]
+	}
+	return myMailer.Send(ctx, message)
+}
+
+// The mails being composed or sent in the background, see SendInBackground and ComposeInBackground.
+var myPending sync.WaitGroup
+
+// Sends the message with Send in a background goroutine, so that the caller does not wait for the mail server.
+// E.g. the response time of an API would otherwise reveal whether it sent a mail. Failures are only logged.
+// NOTE: The mail is sent even if ctx is canceled, e.g. when the request has been served.
+func SendInBackground(ctx context.Context, message Message) {
+	defer util.LogEnter().Exit()
+	ComposeInBackground(ctx, func(ctx context.Context) (Message, bool) {
+		return message, true
+	})
+}
+
+// Like SendInBackground, but also composes the message in the background goroutine, e.g. when composing it creates
+// a token for the user, which would otherwise reveal in the response time whether the user exists.
+// compose returns false if there is nothing to send, it logs its own failures.
+func ComposeInBackground(ctx context.Context, compose func(ctx context.Context) (message Message, ok bool)) {
+	defer util.LogEnter().Exit()
+	ctx = context.WithoutCancel(ctx)
+	myPending.Add(1)
+	go func() {
+		defer myPending.Done()
+		message, ok := compose(ctx)
+		if !ok {
+			return
+		}
+		if err := Send(ctx, message); err != nil {
+			util.LogError("Couldn't send mail \"" + message.Subject + "\" to " + message.To + ": " + err.Error())
+		}
+	}()
+}
+
+// Waits for the mails being sent in the background, e.g. before the server exits.
+func Wait() {
+	defer util.LogEnter().Exit()
+	myPending.Wait()
+}
+
+// Replaces the mailer singleton. Returns the previous mailer.
+// Used e.g. in tests to read the mails from a temporary outbox.
+func SetMailer(mailer Mailer) (previous Mailer) {
+	previous = myMailer
+	myMailer = mailer
+	return previous
+}
+
+// Formats the message as an RFC 5322 mail with UTF-8 plain text body.
+func format(message Message, date time.Time) []byte {
+	var buf bytes.Buffer
+	// NOTE: Strip line breaks so that the values cannot inject headers.
+	header := func(name string, value string) {
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", message.From)
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+	// NOTE: SMTP requires CRLF line endings.
+	buf.WriteString(strings.Replace(strings.Replace(message.Body, "\r\n", "\n", -1), "\n", "\r\n", -1))
+	return buf.Bytes()
+}
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	defer util.LogEnter().Exit()
+	date := time.Date(2018, 11, 6, 20, 0, 0, 0, time.UTC)
+	formatted := string(format(Message{"from@foo.com", "to@foo.com\r\nBcc: evil@foo.com", "Salasanan vaihto", "Line 1\nLine 2"}, date))
+	if strings.Contains(formatted, "\r\nBcc:") {
+		t.Errorf("Line breaks in headers should have been stripped: %s", formatted)
+	}
+	if !strings.Contains(formatted, "\r\n\r\nLine 1\r\nLine 2") {
+		t.Errorf("Body should have had CRLF line endings: %q", formatted)
+	}
+	if !strings.Contains(formatted, "Date: Tue, 06 Nov 2018 20:00:00 +0000\r\n") {
+		t.Errorf("Wrong date header: %s", formatted)
+	}
+}
+
+func TestSend(t *testing.T) {
+	defer util.LogEnter().Exit()
+	mailer, err := NewFileMailer(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %s", err.Error())
+	}
+	previous := SetMailer(mailer)
+	defer SetMailer(previous)
+	if err = Send(context.Background(), Message{To: "to@foo.com", Subject: "Hello", Body: "Hello!"}); err != nil {
+		t.Fatalf("Send failed: %s", err.Error())
+	}
+	messages, _ := mailer.Messages()
+	if len(messages) != 1 || messages[0].From != util.MyConfig["mail_from"] {
+		t.Errorf("From should have defaulted to mail_from, got: %v", messages)
+	}
+	SetMailer(nil)
+	if err = Send(context.Background(), Message{To: "to@foo.com"}); err == nil {
+		t.Errorf("Send should have failed when mail is disabled")
+	}
+}
+
+func TestSendInBackground(t *testing.T) {
+	defer util.LogEnter().Exit()
+	mailer, _ := NewFileMailer(t.TempDir())
+	previous := SetMailer(mailer)
+	defer SetMailer(previous)
+	ctx, cancel := context.WithCancel(context.Background())
+	SendInBackground(ctx, Message{To: "to@foo.com", Subject: "Hello", Body: "Hello!"})
+	// The mail is sent although the request has ended.
+	cancel()
+	Wait()
+	if messages, _ := mailer.Messages(); len(messages) != 1 || messages[0].To != "to@foo.com" {
+		t.Errorf("Mail should have been sent, got: %v", messages)
+	}
+}
+
+func TestComposeInBackground(t *testing.T) {
+	defer util.LogEnter().Exit()
+	mailer, _ := NewFileMailer(t.TempDir())
+	previous := SetMailer(mailer)
+	defer SetMailer(previous)
+	release := make(chan bool)
+	ComposeInBackground(context.Background(), func(ctx context.Context) (Message, bool) {
+		<-release
+		return Message{To: "to@foo.com", Subject: "Composed", Body: "Hello!"}, true
+	})
+	ComposeInBackground(context.Background(), func(ctx context.Context) (Message, bool) {
+		return Message{To: "nothing@foo.com"}, false
+	})
+	// NOTE: The call returned although composing the first mail is still waiting.
+	close(release)
+	Wait()
+	if messages, _ := mailer.Messages(); len(messages) != 1 || messages[0].Subject != "Composed" {
+		t.Errorf("Only the composed mail should have been sent, got: %v", messages)
+	}
+}
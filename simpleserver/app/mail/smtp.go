@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SmtpMailer sends the mails with SMTP. STARTTLS is used if the server supports it,
+// and the credentials are sent only over TLS (or to localhost).
+type SmtpMailer struct {
+	host     string
+	addr     string
+	username string
+	password string
+	timeout  time.Duration
+}
+
+func NewSmtpMailer(host string, port int, username string, password string, timeout time.Duration) (*SmtpMailer, error) {
+	if host == "" {
+		return nil, errors.New("smtp_host is not set")
+	}
+	return &SmtpMailer{host, net.JoinHostPort(host, strconv.Itoa(port)), username, password, timeout}, nil
+}
+
+func (mailer *SmtpMailer) Send(ctx context.Context, message Message) (err error) {
+	// NOTE: smtp.SendMail has no timeout, so we dial ourselves and set a deadline for the whole conversation.
+	dialer := net.Dialer{Timeout: mailer.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", mailer.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(mailer.timeout))
+	client, err := smtp.NewClient(conn, mailer.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: mailer.host}); err != nil {
+			return err
+		}
+	}
+	if mailer.username != "" {
+		if err = client.Auth(smtp.PlainAuth("", mailer.username, mailer.password, mailer.host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(message.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(message.To); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = data.Write(format(message, time.Now())); err != nil {
+		return err
+	}
+	if err = data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
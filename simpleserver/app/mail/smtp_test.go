@@ -0,0 +1,114 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minimal SMTP server which accepts one mail, just enough for net/smtp.
+type fakeSmtpServer struct {
+	listener net.Listener
+	commands []string
+	data     string
+	done     chan bool
+}
+
+func startFakeSmtpServer(t *testing.T, rejectRcpt bool) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err.Error())
+	}
+	server := &fakeSmtpServer{listener: listener, done: make(chan bool)}
+	go func() {
+		defer close(server.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP fake")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			server.commands = append(server.commands, command)
+			switch {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(command, "RCPT") && rejectRcpt:
+				reply("550 No such user")
+			case command == "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil || dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				server.data = data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return server
+}
+
+func (server *fakeSmtpServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestSmtpMailer(t *testing.T) {
+	defer util.LogEnter().Exit()
+	server := startFakeSmtpServer(t, false)
+	defer server.listener.Close()
+	mailer, _ := NewSmtpMailer("127.0.0.1", server.port(), "", "", 5*time.Second)
+	err := mailer.Send(context.Background(), Message{"from@foo.com", "to@foo.com", "Hello", "Hello SMTP!"})
+	if err != nil {
+		t.Fatalf("Send failed: %s", err.Error())
+	}
+	<-server.done
+	commands := strings.Join(server.commands, "|")
+	if !strings.Contains(commands, "MAIL FROM:<from@foo.com>") || !strings.Contains(commands, "RCPT TO:<to@foo.com>") {
+		t.Errorf("Wrong SMTP commands: %s", commands)
+	}
+	if !strings.Contains(server.data, "Subject: Hello\r\n") || !strings.HasSuffix(server.data, "\r\n\r\nHello SMTP!\r\n") {
+		t.Errorf("Wrong mail data: %q", server.data)
+	}
+}
+
+func TestSmtpMailerErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	server := startFakeSmtpServer(t, true)
+	defer server.listener.Close()
+	mailer, _ := NewSmtpMailer("127.0.0.1", server.port(), "", "", 5*time.Second)
+	if err := mailer.Send(context.Background(), Message{"from@foo.com", "nobody@foo.com", "Hello", "Hello"}); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Rejected recipient should have failed with 550, got: %v", err)
+	}
+	// Nobody listens on the port anymore.
+	server.listener.Close()
+	<-server.done
+	mailer, _ = NewSmtpMailer("127.0.0.1", server.port(), "", "", time.Second)
+	if err := mailer.Send(context.Background(), Message{"from@foo.com", "to@foo.com", "Hello", "Hello"}); err == nil {
+		t.Errorf("Sending to a closed port should have failed")
+	}
+	if _, err := NewSmtpMailer("", 25, "", "", time.Second); err == nil {
+		t.Errorf("Empty host should have failed")
+	}
+}
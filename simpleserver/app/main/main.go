@@ -2,21 +2,256 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/domaindb"
+	"github.com/karimarttila/go/simpleserver/app/mail"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
 	"github.com/karimarttila/go/simpleserver/app/util"
 	"github.com/karimarttila/go/simpleserver/app/webserver"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // The main entry point to the file.
 // Just calls the webserver package to start the http server.
+// With arguments runs the command instead, e.g.: simpleserver verify-audit-log
 func main() {
-	util.LogEnter()
+	if len(os.Args) > 1 {
+		status := runCommand(os.Args[1:], os.Stdout)
+		util.CloseLog()
+		os.Exit(status)
+	}
+	span := util.LogEnter()
 	util.LogDebug("Starting server...")
 	util.LogDebug("- port: " + util.MyConfig["port"])
 	util.LogDebug("- report_caller: " + util.MyConfig["report_caller"])
 	util.LogDebug("- log_level: " + util.MyConfig["log_level"])
 	util.LogDebug("- log_file: " + util.MyConfig["log_file"])
-	webserver.StartServer()
-	util.LogExit()
+	util.LogDebug("- trace_file: " + util.MyConfig["trace_file"])
+	util.LogDebug("- audit_log_file: " + util.MyConfig["audit_log_file"])
+	util.LogDebug("- product_store: " + util.MyConfig["product_store"])
+	util.LogDebug("- user_store: " + util.MyConfig["user_store"])
+	util.LogDebug("- sqlite_file: " + util.MyConfig["sqlite_file"])
+	ctx := context.Background()
+	productStore, err := domaindb.OpenProductStore(ctx)
+	if err != nil {
+		exitWithError("Couldn't open product store: " + err.Error())
+	}
+	stopWatching := func() {}
+	if tsvStore, ok := productStore.(*domaindb.TsvStore); ok {
+		if interval := util.MyConfig.GetInt("catalog_reload_interval_ms", 0); interval > 0 {
+			stopWatching = tsvStore.WatchFiles(time.Duration(interval) * time.Millisecond)
+		}
+	}
+	userStore, sessionStore, err := userdb.OpenStore(ctx)
+	if err != nil {
+		exitWithError("Couldn't open user store: " + err.Error())
+	}
+	userdb.SetStore(userStore)
+	serverErr := webserver.StartServer(productStore, sessionStore)
+	if serverErr != nil {
+		util.LogError("Server failed: " + serverErr.Error())
+	}
+	span.Exit()
+	stopWatching()
+	// Send the mails being sent in the background.
+	mail.Wait()
+	// Export the pending spans.
+	tracing.Shutdown()
+	audit.Close()
+	sqldb.Close()
 	// Finally close the log file.
 	util.CloseLog()
+	if serverErr != nil {
+		os.Exit(1)
+	}
+}
+
+// NOTE: LogFatal only logs, so we exit ourselves.
+func exitWithError(msg string) {
+	util.LogFatal(msg)
+	util.CloseLog()
+	os.Exit(1)
+}
+
+// The commands get the arguments after the command name and return the exit status.
+var commands = map[string]func(args []string, out io.Writer) int{
+	"add-admin":        addAdmin,
+	"export-catalog":   exportCatalog,
+	"import-catalog":   importCatalog,
+	"validate-catalog": validateCatalog,
+	"verify-audit-log": verifyAuditLog,
+}
+
+func runCommand(args []string, out io.Writer) int {
+	defer util.LogEnter().Exit()
+	command, ok := commands[args[0]]
+	if !ok {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(out, "Unknown command: "+args[0]+", commands: "+strings.Join(names, ", "))
+		return 2
+	}
+	return command(args[1:], out)
+}
+
+// Where the commands read their input, e.g. the password of add-admin.
+var commandInput io.Reader = os.Stdin
+
+// Usage: add-admin email first-name last-name, the password is read from the first line of the standard input.
+// Adds an admin to the configured user_store, sqlite or postgres, e.g. the first admin of a new database.
+// NOTE: The password is not an argument, so that it does not end up in the shell history or the process list.
+func addAdmin(args []string, out io.Writer) int {
+	defer util.LogEnter().Exit()
+	defer sqldb.Close()
+	if len(args) != 3 {
+		fmt.Fprintln(out, "Give the email, the first name and the last name, and the password in the standard input")
+		return 2
+	}
+	line, _ := bufio.NewReader(commandInput).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		fmt.Fprintln(out, "Couldn't read the password from the standard input")
+		return 2
+	}
+	if storeType := util.MyConfig["user_store"]; storeType == "" || storeType == "memory" {
+		fmt.Fprintln(out, "The memory user store is lost when the command exits, set user_store to sqlite or postgres")
+		return 2
+	}
+	ctx := context.Background()
+	store, _, err := userdb.OpenStore(ctx)
+	if err != nil {
+		fmt.Fprintln(out, "Couldn't open user store: "+err.Error())
+		return 1
+	}
+	previous := userdb.SetStore(store)
+	defer userdb.SetStore(previous)
+	admin, err := userdb.AddAdmin(ctx, args[0], args[1], args[2], password)
+	if err != nil {
+		fmt.Fprintln(out, "Couldn't add the admin: "+err.Error())
+		return 1
+	}
+	fmt.Fprintf(out, "Added admin %s with user id %d\n", admin.Email, admin.UserId)
+	return 0
+}
+
+// Usage: verify-audit-log [file], the file defaults to audit_log_file.
+func verifyAuditLog(args []string, out io.Writer) int {
+	defer util.LogEnter().Exit()
+	filename := util.MyConfig["audit_log_file"]
+	if len(args) > 0 {
+		filename = args[0]
+	}
+	count, lastHash, err := audit.VerifyFile(filename)
+	if err != nil {
+		fmt.Fprintf(out, "Audit log %s is NOT intact after %d records: %s\n", filename, count, err.Error())
+		return 1
+	}
+	fmt.Fprintf(out, "Audit log %s is intact: %d records, last hash: %s\n", filename, count, lastHash)
+	return 0
+}
+
+// Usage: validate-catalog [dir], the directory defaults to resource_dir.
+// Checks the TSV files like the server does when it loads them, and lists all the problems.
+func validateCatalog(args []string, out io.Writer) int {
+	defer util.LogEnter().Exit()
+	dir := domaindb.ResourceDir()
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	groupCount, productCount, err := domaindb.ValidateTsvFiles(dir)
+	if err != nil {
+		fmt.Fprintf(out, "Catalog %s is NOT valid:\n%s\n", dir, err.Error())
+		return 1
+	}
+	fmt.Fprintf(out, "Catalog %s is valid: %d product groups, %d products\n", dir, groupCount, productCount)
+	return 0
+}
+
+// Opens the configured product store for the catalog commands, the format of the file is its extension, e.g. catalog.ods.
+func openCatalogEditor(args []string, out io.Writer) (editor domaindb.CatalogEditor, format string, ok bool) {
+	if len(args) != 1 {
+		fmt.Fprintln(out, "Give the file, the formats: "+strings.Join(domaindb.CatalogFormats(), ", "))
+		return nil, "", false
+	}
+	format = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+	if domaindb.CatalogContentType(format) == "" {
+		fmt.Fprintln(out, "Unknown catalog format: "+args[0]+", formats: "+strings.Join(domaindb.CatalogFormats(), ", "))
+		return nil, "", false
+	}
+	store, err := domaindb.OpenProductStore(context.Background())
+	if err != nil {
+		fmt.Fprintln(out, "Couldn't open product store: "+err.Error())
+		return nil, "", false
+	}
+	if editor, ok = store.(domaindb.CatalogEditor); !ok {
+		fmt.Fprintln(out, "The catalog of this product store cannot be exported or imported")
+	}
+	return editor, format, ok
+}
+
+// Usage: export-catalog file, e.g. export-catalog catalog.ods
+func exportCatalog(args []string, out io.Writer) int {
+	defer util.LogEnter().Exit()
+	defer sqldb.Close()
+	editor, format, ok := openCatalogEditor(args, out)
+	if !ok {
+		return 2
+	}
+	var buf bytes.Buffer
+	catalog, err := editor.ExportCatalog(context.Background())
+	if err == nil {
+		err = domaindb.WriteCatalog(&buf, format, catalog)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(args[0], buf.Bytes(), 0600)
+	}
+	if err != nil {
+		fmt.Fprintln(out, "Couldn't export the catalog: "+err.Error())
+		return 1
+	}
+	fmt.Fprintf(out, "Exported %d product groups, %d products to %s\n", len(catalog.ProductGroups), len(catalog.Products), args[0])
+	return 0
+}
+
+// Usage: import-catalog file, e.g. import-catalog catalog.ods
+// Replaces the whole catalog. If the file has problems, lists them and imports nothing.
+func importCatalog(args []string, out io.Writer) int {
+	defer util.LogEnter().Exit()
+	defer sqldb.Close()
+	editor, format, ok := openCatalogEditor(args, out)
+	if !ok {
+		return 2
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintln(out, "Couldn't import the catalog: "+err.Error())
+		return 1
+	}
+	defer file.Close()
+	catalog, err := domaindb.ReadCatalog(file, format)
+	if err != nil {
+		fmt.Fprintf(out, "Catalog %s is NOT valid, nothing was imported:\n%s\n", args[0], err.Error())
+		return 1
+	}
+	if err = editor.ImportCatalog(context.Background(), catalog); err != nil {
+		fmt.Fprintln(out, "Couldn't import the catalog: "+err.Error())
+		return 1
+	}
+	fmt.Fprintf(out, "Imported %d product groups, %d products from %s\n", len(catalog.ProductGroups), len(catalog.Products), args[0])
+	return 0
 }
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/domaindb"
 	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -13,3 +19,108 @@ func TestMainDummy(t *testing.T) {
 	}
 	util.LogExit()
 }
+
+func TestVerifyAuditLogCommand(t *testing.T) {
+	defer util.LogEnter().Exit()
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	logger, _ := audit.NewLogger(filename)
+	logger.Log(audit.Record{Actor: "kari.karttinen@foo.com", Action: "LOGIN", Target: "kari.karttinen@foo.com", Outcome: audit.Success})
+	logger.Log(audit.Record{Actor: "kari.karttinen@foo.com", Action: "LOGIN", Target: "kari.karttinen@foo.com", Outcome: audit.Success})
+	logger.Close()
+	var out bytes.Buffer
+	if status := runCommand([]string{"verify-audit-log", filename}, &out); status != 0 || !strings.Contains(out.String(), "intact: 2 records") {
+		t.Errorf("Intact audit log should have verified, got: %d, %s", status, out.String())
+	}
+	content, _ := ioutil.ReadFile(filename)
+	ioutil.WriteFile(filename, bytes.Replace(content, []byte("kari.karttinen"), []byte("timo.tillinen"), 1), 0600)
+	out.Reset()
+	if status := runCommand([]string{"verify-audit-log", filename}, &out); status != 1 || !strings.Contains(out.String(), "line 1") {
+		t.Errorf("Tampered audit log should have failed at line 1, got: %d, %s", status, out.String())
+	}
+	out.Reset()
+	if status := runCommand([]string{"unknown"}, &out); status != 2 || !strings.Contains(out.String(), "verify-audit-log") {
+		t.Errorf("Unknown command should have listed the commands, got: %d, %s", status, out.String())
+	}
+}
+
+func TestValidateCatalogCommand(t *testing.T) {
+	defer util.LogEnter().Exit()
+	var out bytes.Buffer
+	if status := runCommand([]string{"validate-catalog"}, &out); status != 0 || !strings.Contains(out.String(), "is valid: 2 product groups") {
+		t.Errorf("Resource files should have been valid, got: %d, %s", status, out.String())
+	}
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "product-groups.csv"), []byte("1\tBooks\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "pg-1-products.csv"), []byte("10\t1\tKalevala\n"), 0600)
+	out.Reset()
+	if status := runCommand([]string{"validate-catalog", dir}, &out); status != 1 || !strings.Contains(out.String(), "pg-1-products.csv:1: expected 8 columns, got 3") {
+		t.Errorf("Invalid files should have been reported, got: %d, %s", status, out.String())
+	}
+}
+
+func TestExportImportCatalogCommands(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := t.TempDir()
+	for _, name := range []string{"product-groups.csv", "pg-1-products.csv", "pg-2-products.csv"} {
+		content, _ := ioutil.ReadFile(filepath.Join(domaindb.ResourceDir(), name))
+		ioutil.WriteFile(filepath.Join(dir, name), content, 0600)
+	}
+	previous := util.MyConfig["resource_dir"]
+	util.MyConfig["resource_dir"] = dir
+	defer func() { util.MyConfig["resource_dir"] = previous }()
+	fileName := filepath.Join(t.TempDir(), "catalog.ods")
+	var out bytes.Buffer
+	if status := runCommand([]string{"export-catalog", fileName}, &out); status != 0 || !strings.Contains(out.String(), "Exported 2 product groups") {
+		t.Fatalf("Exporting should have succeeded, got: %d, %s", status, out.String())
+	}
+	original, _ := ioutil.ReadFile(filepath.Join(dir, "pg-2-products.csv"))
+	ioutil.WriteFile(filepath.Join(dir, "pg-2-products.csv"), nil, 0600)
+	out.Reset()
+	if status := runCommand([]string{"import-catalog", fileName}, &out); status != 0 || !strings.Contains(out.String(), "Imported 2 product groups") {
+		t.Errorf("Importing should have succeeded, got: %d, %s", status, out.String())
+	}
+	if imported, _ := ioutil.ReadFile(filepath.Join(dir, "pg-2-products.csv")); string(imported) != string(original) {
+		t.Errorf("Import should have written the original file")
+	}
+	csvName := filepath.Join(t.TempDir(), "catalog.csv")
+	ioutil.WriteFile(csvName, []byte("pg-id,name\n"), 0600)
+	out.Reset()
+	if status := runCommand([]string{"import-catalog", csvName}, &out); status != 1 || !strings.Contains(out.String(), "catalog.csv:1: expected the header") {
+		t.Errorf("Invalid file should have been reported, got: %d, %s", status, out.String())
+	}
+	out.Reset()
+	if status := runCommand([]string{"export-catalog", "catalog.xlsx"}, &out); status != 2 || !strings.Contains(out.String(), "formats: csv, json, ods, zip") {
+		t.Errorf("Unknown format should have been reported, got: %d, %s", status, out.String())
+	}
+}
+
+func TestAddAdminCommand(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousStore, previousFile, previousInput := util.MyConfig["user_store"], util.MyConfig["sqlite_file"], commandInput
+	defer func() {
+		util.MyConfig["user_store"], util.MyConfig["sqlite_file"], commandInput = previousStore, previousFile, previousInput
+	}()
+	var out bytes.Buffer
+	util.MyConfig["user_store"] = "memory"
+	commandInput = strings.NewReader("FirstAdminPassword\n")
+	if status := runCommand([]string{"add-admin", "first.admin@foo.com", "First", "Admin"}, &out); status != 2 || !strings.Contains(out.String(), "user_store") {
+		t.Errorf("Adding an admin to the memory store should have been refused, got: %d, %s", status, out.String())
+	}
+	out.Reset()
+	util.MyConfig["user_store"] = "sqlite"
+	util.MyConfig["sqlite_file"] = filepath.Join(t.TempDir(), "users.db")
+	commandInput = strings.NewReader("")
+	if status := runCommand([]string{"add-admin", "first.admin@foo.com", "First", "Admin"}, &out); status != 2 || !strings.Contains(out.String(), "password") {
+		t.Errorf("Missing password should have been reported, got: %d, %s", status, out.String())
+	}
+	out.Reset()
+	commandInput = strings.NewReader("FirstAdminPassword\n")
+	if status := runCommand([]string{"add-admin", "first.admin@foo.com", "First", "Admin"}, &out); status != 0 || !strings.Contains(out.String(), "user id 1") {
+		t.Fatalf("Adding the first admin should have succeeded, got: %d, %s", status, out.String())
+	}
+	out.Reset()
+	commandInput = strings.NewReader("FirstAdminPassword\n")
+	if status := runCommand([]string{"add-admin", "first.admin@foo.com", "First", "Admin"}, &out); status != 1 || !strings.Contains(out.String(), "Email already exists") {
+		t.Errorf("Adding the admin again should have failed, got: %d, %s", status, out.String())
+	}
+}
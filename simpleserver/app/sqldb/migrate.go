@@ -0,0 +1,137 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The schema migrations are versioned with the code, by dialect: migrations/<dialect>/<version>_<name>.sql,
+// e.g. migrations/sqlite/0002_users.sql. The dialects have the same versions, only the SQL differs where needed.
+// NOTE: A released migration must never be changed, schema changes go to a new migration.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	script  string
+}
+
+// Returns the migrations of the dialect ordered by version.
+func migrations(dialect string) (ret []migration, err error) {
+	migrationDir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(migrationDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil || version <= 0 {
+			return nil, errors.New("Migration name does not start with a version: " + entry.Name())
+		}
+		script, err := migrationFiles.ReadFile(path.Join(migrationDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, migration{version, name, string(script)})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].version < ret[j].version })
+	for i := 1; i < len(ret); i++ {
+		if ret[i].version == ret[i-1].version {
+			return nil, errors.New("Duplicate migration version: " + ret[i].name)
+		}
+	}
+	return ret, nil
+}
+
+// Something to query a row with: *sql.DB, *sql.Conn or *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, db rowQueryer) (version int, err error) {
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Returns the version of the latest migration applied to the database, 0 if none.
+func SchemaVersion(ctx context.Context, db *DB) (version int, err error) {
+	defer util.LogEnter().Exit()
+	return schemaVersion(ctx, db)
+}
+
+// Applies the migrations newer than the schema version of the database, each in its own transaction.
+// Returns the names of the applied migrations.
+func Migrate(ctx context.Context, db *DB) (applied []string, err error) {
+	defer util.LogEnter().Exit()
+	all, err := migrations(db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: One connection for the lock and the migrations, the lock belongs to the connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if db.dialect == Postgres {
+		unlock, err := lockPostgresMigrations(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT    NOT NULL,
+    applied_at BIGINT  NOT NULL
+)`)
+	if err != nil {
+		return nil, err
+	}
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: An older server must not use a database migrated by a newer one, it does not know the schema.
+	if latest := all[len(all)-1].version; current > latest {
+		return nil, errors.New("Database schema version " + strconv.Itoa(current) + " is newer than the latest known version " + strconv.Itoa(latest))
+	}
+	for _, m := range all {
+		if m.version <= current {
+			continue
+		}
+		if err = applyMigration(ctx, conn, m); err != nil {
+			return applied, errors.New("Migration " + m.name + " failed: " + err.Error())
+		}
+		applied = append(applied, m.name)
+	}
+	return applied, nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, m.script); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		m.version, m.name, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package sqldb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	defer util.LogEnter().Exit()
+	sqlite, err := migrations(Sqlite)
+	if err != nil || len(sqlite) == 0 {
+		t.Fatalf("Reading the sqlite migrations failed: %v", err)
+	}
+	for i, m := range sqlite {
+		if m.version != i+1 || m.script == "" {
+			t.Errorf("Migrations should have been numbered from 1 without gaps, got: %d %s", m.version, m.name)
+		}
+	}
+	// The dialects have the same migrations, only the SQL may differ.
+	postgres, err := migrations(Postgres)
+	if err != nil || len(postgres) != len(sqlite) {
+		t.Fatalf("There should have been as many postgres migrations as sqlite migrations, got: %d, %v", len(postgres), err)
+	}
+	for i := range postgres {
+		if postgres[i].name != sqlite[i].name {
+			t.Errorf("Migration %s should have been %s in postgres", postgres[i].name, sqlite[i].name)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	db := openTestDb(t)
+	all, _ := migrations(Sqlite)
+	latest := all[len(all)-1].version
+	if version, _ := SchemaVersion(ctx, db); version != latest {
+		t.Errorf("Schema should have been at version %d, got: %d", latest, version)
+	}
+	if applied, err := Migrate(ctx, db); err != nil || len(applied) != 0 {
+		t.Errorf("Migrating a migrated database should have done nothing, applied: %v, err: %v", applied, err)
+	}
+	for _, table := range []string{"imports", "users", "user_tokens", "sessions", "product_groups", "products"} {
+		if _, err := db.Exec(`SELECT COUNT(*) FROM ` + table); err != nil {
+			t.Errorf("Table %s should have been created: %s", table, err.Error())
+		}
+	}
+	db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, 'from_the_future', 0)`, latest+1)
+	if _, err := Migrate(ctx, db); err == nil {
+		t.Errorf("Migrating a database of a newer schema should have failed")
+	}
+}
@@ -0,0 +1,5 @@
+-- The one-time imports that have been run, see sqldb.RunOnce.
+CREATE TABLE imports (
+    name        TEXT PRIMARY KEY,
+    imported_at BIGINT NOT NULL
+);
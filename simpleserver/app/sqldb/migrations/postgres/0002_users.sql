@@ -0,0 +1,24 @@
+-- Users and their single-use tokens, see userdb.SqlStore.
+-- email_key is the lowercased email: emails are case-insensitive but stored as given.
+CREATE TABLE users (
+    user_id                 BIGINT  GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    email                   TEXT    NOT NULL,
+    email_key               TEXT    NOT NULL UNIQUE,
+    first_name              TEXT    NOT NULL,
+    last_name               TEXT    NOT NULL,
+    hashed_password         TEXT    NOT NULL,
+    roles                   TEXT    NOT NULL,
+    disabled                BOOLEAN NOT NULL DEFAULT FALSE,
+    password_reset_required BOOLEAN NOT NULL DEFAULT FALSE,
+    email_verified          BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Only the hashes of the tokens are stored. Expiration times are unix seconds.
+CREATE TABLE user_tokens (
+    token_hash TEXT    PRIMARY KEY,
+    purpose    TEXT    NOT NULL,
+    user_id    BIGINT  NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    expires    BIGINT  NOT NULL
+);
+
+CREATE INDEX user_tokens_user_id ON user_tokens (user_id);
@@ -0,0 +1,9 @@
+-- Login sessions, see userdb.SqlSessionStore. Only the hashes of the session tokens are stored.
+CREATE TABLE sessions (
+    token_hash TEXT   PRIMARY KEY,
+    email      TEXT   NOT NULL,
+    token_id   TEXT   NOT NULL,
+    expires    BIGINT NOT NULL
+);
+
+CREATE INDEX sessions_email ON sessions (email);
@@ -0,0 +1,25 @@
+-- The attributes of the products of each product group, see domaindb.Schema. The attribute values of a product are
+-- in products.attributes as a JSON array of text in the order of the attributes, replacing the book and movie columns.
+CREATE TABLE product_group_attributes (
+    pg_id    INTEGER NOT NULL REFERENCES product_groups (pg_id),
+    position INTEGER NOT NULL,
+    name     TEXT    NOT NULL,
+    type     TEXT    NOT NULL,
+    required BOOLEAN NOT NULL,
+    PRIMARY KEY (pg_id, position)
+);
+
+INSERT INTO product_group_attributes (pg_id, position, name, type, required)
+SELECT pg_id, 1, 'author-or-director', 'string', TRUE FROM product_groups
+UNION ALL SELECT pg_id, 2, 'year', 'year', TRUE FROM product_groups
+UNION ALL SELECT pg_id, 3, 'country', 'string', TRUE FROM product_groups
+UNION ALL SELECT pg_id, 4, 'genre-or-language', 'string', TRUE FROM product_groups;
+
+ALTER TABLE products ADD COLUMN attributes TEXT NOT NULL DEFAULT '[]';
+
+UPDATE products SET attributes = json_build_array(author_or_director, year, country, genre_or_language)::TEXT;
+
+ALTER TABLE products DROP COLUMN author_or_director;
+ALTER TABLE products DROP COLUMN year;
+ALTER TABLE products DROP COLUMN country;
+ALTER TABLE products DROP COLUMN genre_or_language;
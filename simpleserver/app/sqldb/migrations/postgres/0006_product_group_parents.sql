@@ -0,0 +1,3 @@
+-- The product groups are nested categories, see domaindb.Categories: the parent of a product group,
+-- NULL for a top level product group.
+ALTER TABLE product_groups ADD COLUMN parent_id INTEGER REFERENCES product_groups (pg_id);
@@ -0,0 +1,17 @@
+-- The product catalog, see domaindb.SqlStore. The product fields are kept as text like in the TSV files.
+CREATE TABLE product_groups (
+    pg_id INTEGER PRIMARY KEY,
+    name  TEXT    NOT NULL
+);
+
+CREATE TABLE products (
+    pg_id              INTEGER NOT NULL REFERENCES product_groups (pg_id),
+    p_id               INTEGER NOT NULL,
+    title              TEXT    NOT NULL,
+    price              TEXT    NOT NULL,
+    author_or_director TEXT    NOT NULL,
+    year               TEXT    NOT NULL,
+    country            TEXT    NOT NULL,
+    genre_or_language  TEXT    NOT NULL,
+    PRIMARY KEY (pg_id, p_id)
+);
@@ -0,0 +1,199 @@
+// The sqldb package.
+// The SQL database of the SQL stores (see userdb and domaindb): opening SQLite and PostgreSQL databases,
+// schema migrations, one-time imports and retrying on transient errors.
+
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The supported SQL dialects, also the values of product_store and user_store for the SQL stores.
+const (
+	Sqlite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// DB is an opened and migrated database: the connection pool with the dialect and the retry policy of the database.
+// NOTE: The stores write SQL that works in both dialects, e.g. $1 placeholders and RETURNING.
+// The placeholders must be numbered in the order they appear: SQLite binds them by their position, not by their number.
+type DB struct {
+	*sql.DB
+	dialect string
+	retry   RetryPolicy
+	// Tells whether the failed operation can be retried, see Retry.
+	isTransient func(err error) bool
+}
+
+// RetryPolicy tells how many times an operation is tried, and how long to wait between the attempts.
+// The delay doubles after each attempt up to MaxDelay.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (db *DB) Dialect() string {
+	return db.dialect
+}
+
+// Runs the operation, running it again if it fails with a transient error, e.g. the database is restarting.
+// NOTE: Only errors after which the operation surely had no effect are transient, so the operation does not have to be idempotent.
+func (db *DB) Retry(ctx context.Context, operation func() error) (err error) {
+	delay := db.retry.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		if err == nil || attempt >= db.retry.Attempts || !db.isTransient(err) {
+			return err
+		}
+		util.LogWarn("Retrying a database operation after a transient error: " + err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > db.retry.MaxDelay {
+			delay = db.retry.MaxDelay
+		}
+	}
+}
+
+// Runs the function in a transaction and commits it if the function succeeds. The whole transaction is retried
+// on transient errors, so the function may run more than once.
+func (db *DB) InTx(ctx context.Context, run func(tx *sql.Tx) error) error {
+	return db.Retry(ctx, func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// NOTE: Does nothing after Commit.
+		defer tx.Rollback()
+		if err = run(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// Opens the SQLite database file, creating it if needed, and migrates the schema to the latest version.
+func OpenSqlite(fileName string) (*DB, error) {
+	defer util.LogEnter().Exit()
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return nil, err
+	}
+	sqlDb, err := sql.Open(sqliteDriver, sqliteDsn(fileName))
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: SQLite has one writer at a time. With a single connection the stores never see a locked database,
+	// which is fast enough for a single-node deployment. Nothing is retried.
+	sqlDb.SetMaxOpenConns(1)
+	db := &DB{sqlDb, Sqlite, RetryPolicy{Attempts: 1}, func(err error) bool { return false }}
+	if err = migrateOpened(db, fileName); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrates the opened database, closing it if the migration fails.
+func migrateOpened(db *DB, name string) error {
+	applied, err := Migrate(context.Background(), db)
+	if err != nil {
+		db.Close()
+		return errors.New("Couldn't migrate " + name + ": " + err.Error())
+	}
+	for _, migration := range applied {
+		util.LogInfo("Applied migration " + migration + " to " + name)
+	}
+	return nil
+}
+
+// The databases shared by the stores, by dialect, see Default.
+var myDbs = struct {
+	mutex sync.Mutex
+	dbs   map[string]*DB
+}{dbs: make(map[string]*DB)}
+
+// Returns the database of the dialect: sqlite_file or postgres_url, opening it on the first call.
+// All stores configured to use the dialect share the database.
+func Default(dialect string) (*DB, error) {
+	defer util.LogEnter().Exit()
+	myDbs.mutex.Lock()
+	defer myDbs.mutex.Unlock()
+	if db, ok := myDbs.dbs[dialect]; ok {
+		return db, nil
+	}
+	var db *DB
+	var err error
+	switch dialect {
+	case Sqlite:
+		fileName := util.MyConfig["sqlite_file"]
+		if fileName == "" {
+			return nil, errors.New("sqlite_file is not set")
+		}
+		db, err = OpenSqlite(fileName)
+	case Postgres:
+		url := util.MyConfig["postgres_url"]
+		if url == "" {
+			return nil, errors.New("postgres_url is not set")
+		}
+		db, err = OpenPostgres(url, PostgresOptionsFromConfig())
+	default:
+		err = errors.New("Unknown SQL dialect: " + dialect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	myDbs.dbs[dialect] = db
+	return db, nil
+}
+
+// Closes the databases opened by Default.
+func Close() {
+	defer util.LogEnter().Exit()
+	myDbs.mutex.Lock()
+	defer myDbs.mutex.Unlock()
+	for dialect, db := range myDbs.dbs {
+		if err := db.Close(); err != nil {
+			util.LogError("Couldn't close the " + dialect + " database: " + err.Error())
+		}
+		delete(myDbs.dbs, dialect)
+	}
+}
+
+// Runs the function in a transaction, unless a function with the same name has already been run successfully.
+// For one-time imports, e.g. seeding the users of a new database. ran tells whether the function was run.
+// NOTE: If several servers start at the same time, the others wait for the import of the first one and skip it.
+func RunOnce(ctx context.Context, db *DB, name string, run func(tx *sql.Tx) error) (ran bool, err error) {
+	defer util.LogEnter().Exit()
+	err = db.InTx(ctx, func(tx *sql.Tx) error {
+		ran = false
+		result, err := tx.ExecContext(ctx, `INSERT INTO imports (name, imported_at) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`,
+			name, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err != nil || count == 0 {
+			return err
+		}
+		if err = run(tx); err != nil {
+			return errors.New("Import " + name + " failed: " + err.Error())
+		}
+		ran = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if ran {
+		util.LogInfo("Imported " + name)
+	}
+	return ran, nil
+}
@@ -0,0 +1,148 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestDb(t *testing.T) *DB {
+	db, err := OpenSqlite(filepath.Join(t.TempDir(), "sub", "test.db"))
+	if err != nil {
+		t.Fatalf("Opening the database failed: %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOpenSqlite(t *testing.T) {
+	defer util.LogEnter().Exit()
+	db := openTestDb(t)
+	var foreignKeys int
+	if err := db.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil || foreignKeys != 1 {
+		t.Errorf("Foreign keys should have been on, got: %d, %v", foreignKeys, err)
+	}
+	if version, err := SchemaVersion(context.Background(), db); err != nil || version == 0 {
+		t.Errorf("Opening should have migrated the schema, version: %d, %v", version, err)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousFile, previousUrl := util.MyConfig["sqlite_file"], util.MyConfig["postgres_url"]
+	defer func() { util.MyConfig["sqlite_file"], util.MyConfig["postgres_url"] = previousFile, previousUrl }()
+	util.MyConfig["sqlite_file"], util.MyConfig["postgres_url"] = "", ""
+	for _, dialect := range []string{Sqlite, Postgres, "unknown"} {
+		if _, err := Default(dialect); err == nil {
+			t.Errorf("Default %s should have failed without configuration", dialect)
+		}
+	}
+	util.MyConfig["sqlite_file"] = filepath.Join(t.TempDir(), "default.db")
+	db, err := Default(Sqlite)
+	if err != nil {
+		t.Fatalf("Opening the default database failed: %s", err.Error())
+	}
+	if again, _ := Default(Sqlite); again != db || db.Dialect() != Sqlite {
+		t.Errorf("Default should have returned the same sqlite database")
+	}
+	Close()
+	if err = db.Ping(); err == nil {
+		t.Errorf("Close should have closed the database")
+	}
+}
+
+// A database whose errors containing "transient" are transient.
+func retryTestDb(t *testing.T) *DB {
+	db := openTestDb(t)
+	db.retry = RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	db.isTransient = func(err error) bool { return strings.Contains(err.Error(), "transient") }
+	return db
+}
+
+func TestRetry(t *testing.T) {
+	defer util.LogEnter().Exit()
+	db := retryTestDb(t)
+	attempts := 0
+	failing := func(failures int, msg string) func() error {
+		attempts = 0
+		return func() error {
+			if attempts++; attempts <= failures {
+				return errors.New(msg)
+			}
+			return nil
+		}
+	}
+	if err := db.Retry(context.Background(), failing(2, "transient")); err != nil || attempts != 3 {
+		t.Errorf("Transient errors should have been retried, attempts: %d, err: %v", attempts, err)
+	}
+	if err := db.Retry(context.Background(), failing(3, "transient")); err == nil || attempts != 3 {
+		t.Errorf("Retrying should have stopped after 3 attempts, attempts: %d, err: %v", attempts, err)
+	}
+	if err := db.Retry(context.Background(), failing(1, "permanent")); err == nil || attempts != 1 {
+		t.Errorf("Permanent error should not have been retried, attempts: %d, err: %v", attempts, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.Retry(ctx, failing(1, "transient")); err == nil || attempts != 1 {
+		t.Errorf("Cancelled context should have stopped retrying, attempts: %d, err: %v", attempts, err)
+	}
+}
+
+func TestInTx(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	db := retryTestDb(t)
+	db.Exec(`CREATE TABLE numbers (n INTEGER)`)
+	attempts := 0
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		tx.Exec(`INSERT INTO numbers (n) VALUES (1)`)
+		if attempts++; attempts == 1 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM numbers`).Scan(&count)
+	if err != nil || attempts != 2 || count != 1 {
+		t.Errorf("Failed transaction should have been rolled back and retried, attempts: %d, rows: %d, err: %v", attempts, count, err)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	db := openTestDb(t)
+	if _, err := db.Exec(`CREATE TABLE numbers (n INTEGER)`); err != nil {
+		t.Fatalf("Creating table failed: %s", err.Error())
+	}
+	insert := func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO numbers (n) VALUES (1)`)
+		return err
+	}
+	fail := func(tx *sql.Tx) error {
+		insert(tx)
+		return errors.New("failed")
+	}
+	if ran, err := RunOnce(ctx, db, "failing", fail); ran || err == nil {
+		t.Errorf("Failing import should have returned the error, ran: %t", ran)
+	}
+	if ran, err := RunOnce(ctx, db, "numbers", insert); !ran || err != nil {
+		t.Errorf("Import should have been run, ran: %t, err: %v", ran, err)
+	}
+	if ran, err := RunOnce(ctx, db, "numbers", insert); ran || err != nil {
+		t.Errorf("Import should have been run only once, ran: %t, err: %v", ran, err)
+	}
+	// The failed import was rolled back, so it runs again.
+	if ran, err := RunOnce(ctx, db, "failing", insert); !ran || err != nil {
+		t.Errorf("Failed import should have been run again, ran: %t, err: %v", ran, err)
+	}
+	var count int
+	if db.QueryRow(`SELECT COUNT(*) FROM numbers`).Scan(&count); count != 2 {
+		t.Errorf("There should have been 2 rows, got: %d", count)
+	}
+}
@@ -0,0 +1,145 @@
+// Package sqldbtest provides PostgreSQL databases for the store tests. The tests use the server of
+// SIMPLESERVER_TEST_POSTGRES_URL, or start a throwaway server from the PostgreSQL binaries if they are installed
+// (initdb and pg_ctl in POSTGRES_BIN_DIR or PATH), and skip if neither is available.
+package sqldbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The server the tests use, started once per test binary.
+var server struct {
+	once    sync.Once
+	url     string
+	skipped string // Why the tests are skipped.
+	pgCtl   string
+	dataDir string
+}
+
+func findBinary(name string) (string, error) {
+	if dir := os.Getenv("POSTGRES_BIN_DIR"); dir != "" {
+		return exec.LookPath(filepath.Join(dir, name))
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	// NOTE: Debian and Ubuntu do not put the server binaries in PATH.
+	matches, _ := filepath.Glob("/usr/lib/postgresql/*/bin/" + name)
+	if len(matches) == 0 {
+		return "", errors.New(name + " not found")
+	}
+	return matches[len(matches)-1], nil
+}
+
+// Starts a server listening only to a Unix socket in a temporary directory, i.e. no ports are needed.
+func startServer() (serverUrl string, err error) {
+	if os.Geteuid() == 0 {
+		return "", errors.New("PostgreSQL refuses to run as root")
+	}
+	initDb, err := findBinary("initdb")
+	if err != nil {
+		return "", err
+	}
+	if server.pgCtl, err = findBinary("pg_ctl"); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "simpleserver-postgres")
+	if err != nil {
+		return "", err
+	}
+	server.dataDir = filepath.Join(dir, "data")
+	if output, err := exec.Command(initDb, "-D", server.dataDir, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.New("initdb failed: " + string(output))
+	}
+	options := "-k " + dir + " -c listen_addresses='' -F"
+	if output, err := exec.Command(server.pgCtl, "-D", server.dataDir, "-o", options, "-l", filepath.Join(dir, "server.log"), "-w", "start").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.New("pg_ctl start failed: " + string(output))
+	}
+	return "postgres://postgres@/postgres?sslmode=disable&host=" + url.QueryEscape(dir), nil
+}
+
+func serverUrl(t *testing.T) string {
+	server.once.Do(func() {
+		if server.url = os.Getenv("SIMPLESERVER_TEST_POSTGRES_URL"); server.url != "" {
+			return
+		}
+		var err error
+		if server.url, err = startServer(); err != nil {
+			server.skipped = "No PostgreSQL for the tests (set SIMPLESERVER_TEST_POSTGRES_URL or install PostgreSQL): " + err.Error()
+		}
+	})
+	if server.skipped != "" {
+		t.Skip(server.skipped)
+	}
+	return server.url
+}
+
+// Returns a new migrated database of the test server, skipping the test if there is no server.
+// NOTE: Every database is a schema of its own, dropped when the test ends, so the tests do not see each other's data.
+func OpenPostgres(t *testing.T) *sqldb.DB {
+	baseUrl := serverUrl(t)
+	// NOTE: sqldb registers the pgx driver.
+	admin, err := sql.Open("pgx", baseUrl)
+	if err != nil {
+		t.Fatalf("Opening the test server failed: %s", err.Error())
+	}
+	defer admin.Close()
+	random := make([]byte, 8)
+	rand.Read(random)
+	schema := "test_" + hex.EncodeToString(random)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err = admin.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("Creating schema %s failed: %s", schema, err.Error())
+	}
+	t.Cleanup(func() {
+		if admin, err := sql.Open("pgx", baseUrl); err == nil {
+			admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			admin.Close()
+		}
+	})
+	parsed, err := url.Parse(baseUrl)
+	if err != nil {
+		t.Fatalf("Invalid test server url: %s", err.Error())
+	}
+	query := parsed.Query()
+	query.Set("search_path", schema)
+	parsed.RawQuery = query.Encode()
+	options := sqldb.PostgresOptions{
+		MaxOpenConns:     5,
+		MaxIdleConns:     2,
+		ConnectTimeout:   5 * time.Second,
+		StatementTimeout: 10 * time.Second,
+		Retry:            sqldb.RetryPolicy{Attempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond},
+	}
+	db, err := sqldb.OpenPostgres(parsed.String(), options)
+	if err != nil {
+		t.Fatalf("Opening the test database failed: %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Stops the server started for the tests, if any. Call from TestMain after the tests.
+func StopPostgres() {
+	if server.dataDir != "" {
+		exec.Command(server.pgCtl, "-D", server.dataDir, "-m", "immediate", "stop").Run()
+		os.RemoveAll(filepath.Dir(server.dataDir))
+		server.dataDir = ""
+	}
+}
@@ -0,0 +1,74 @@
+package sqldbtest
+
+import (
+	"context"
+	"database/sql"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	StopPostgres()
+	os.Exit(code)
+}
+
+func TestOpenPostgres(t *testing.T) {
+	ctx := context.Background()
+	db := OpenPostgres(t)
+	if version, err := sqldb.SchemaVersion(ctx, db); err != nil || version == 0 || db.Dialect() != sqldb.Postgres {
+		t.Errorf("Opening should have migrated the schema, version: %d, %v", version, err)
+	}
+	if applied, err := sqldb.Migrate(ctx, db); err != nil || len(applied) != 0 {
+		t.Errorf("Migrating a migrated database should have done nothing, applied: %v, err: %v", applied, err)
+	}
+	// The databases are separate.
+	other := OpenPostgres(t)
+	db.Exec(`INSERT INTO imports (name, imported_at) VALUES ('only-in-db', 0)`)
+	var count int
+	if other.QueryRow(`SELECT COUNT(*) FROM imports`).Scan(&count); count != 0 {
+		t.Errorf("Test databases should have been separate, got: %d imports", count)
+	}
+}
+
+func TestRunOnceConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := OpenPostgres(t)
+	var mutex sync.Mutex
+	runs := 0
+	var wait sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			sqldb.RunOnce(ctx, db, "concurrent", func(tx *sql.Tx) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				runs++
+				return nil
+			})
+		}()
+	}
+	wait.Wait()
+	if runs != 1 {
+		t.Errorf("Import should have been run once by concurrent servers, runs: %d", runs)
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	db := OpenPostgres(t)
+	// OpenPostgres sets a 10 second statement timeout, a shorter one for this transaction.
+	err := db.InTx(context.Background(), func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`SET LOCAL statement_timeout = 50`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`SELECT pg_sleep(1)`)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "statement timeout") {
+		t.Errorf("Long statement should have been cancelled, got: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package sqldb
+
+import (
+	// NOTE: A pure Go SQLite driver, so the server builds without cgo.
+	_ "modernc.org/sqlite"
+)
+
+const sqliteDriver = "sqlite"
+
+// Foreign keys are off by default in SQLite. The busy timeout makes a locked database wait instead of failing.
+func sqliteDsn(fileName string) string {
+	return "file:" + fileName + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
@@ -0,0 +1,208 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Using Exporter interface the tracer does not need to know where the spans go.
+type Exporter interface {
+	Export(serviceName string, spans []*Span) error
+	Close() error
+}
+
+// OTLP/JSON entities, see: https://github.com/open-telemetry/opentelemetry-proto
+// NOTE: In OTLP/JSON trace and span ids are hex strings and 64 bit integers are strings.
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func toOtlpValue(value interface{}) (ret otlpAnyValue) {
+	switch v := value.(type) {
+	case string:
+		ret.StringValue = &v
+	case bool:
+		ret.BoolValue = &v
+	case int:
+		str := strconv.Itoa(v)
+		ret.IntValue = &str
+	case int64:
+		str := strconv.FormatInt(v, 10)
+		ret.IntValue = &str
+	case float64:
+		ret.DoubleValue = &v
+	default:
+		str := "unsupported attribute type"
+		ret.StringValue = &str
+	}
+	return ret
+}
+
+func toOtlpKeyValues(attributes []Attribute) []otlpKeyValue {
+	var ret []otlpKeyValue
+	for _, attribute := range attributes {
+		ret = append(ret, otlpKeyValue{attribute.Key, toOtlpValue(attribute.Value)})
+	}
+	return ret
+}
+
+// Builds the OTLP/JSON ExportTraceServiceRequest.
+func toOtlpRequest(serviceName string, spans []*Span) otlpExportRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mutex.Lock()
+		otlpSpan := otlpSpan{
+			TraceId:           span.Context.TraceId.String(),
+			SpanId:            span.Context.SpanId.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        toOtlpKeyValues(span.Attributes),
+			Status:            otlpStatus{span.StatusCode, span.StatusMessage},
+		}
+		if span.ParentSpanId.IsValid() {
+			otlpSpan.ParentSpanId = span.ParentSpanId.String()
+		}
+		span.mutex.Unlock()
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+	resource := otlpResource{toOtlpKeyValues([]Attribute{{"service.name", serviceName}})}
+	scopeSpans := otlpScopeSpans{otlpScope{"github.com/karimarttila/go/simpleserver/app/tracing"}, otlpSpans}
+	return otlpExportRequest{[]otlpResourceSpans{{resource, []otlpScopeSpans{scopeSpans}}}}
+}
+
+// OtlpHttpExporter posts the spans in OTLP/HTTP JSON format to a collector.
+type OtlpHttpExporter struct {
+	url    string
+	client *http.Client
+}
+
+// collectorUrl is the collector base url, e.g. http://localhost:4318 (the spans are posted to /v1/traces).
+func NewOtlpHttpExporter(collectorUrl string, timeout time.Duration) (*OtlpHttpExporter, error) {
+	defer util.LogEnter().Exit()
+	if collectorUrl == "" {
+		return nil, errors.New("tracing_otlp_url is empty")
+	}
+	url := strings.TrimRight(collectorUrl, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url = url + "/v1/traces"
+	}
+	return &OtlpHttpExporter{url, &http.Client{Timeout: timeout}}, nil
+}
+
+func (e *OtlpHttpExporter) Export(serviceName string, spans []*Span) error {
+	defer util.LogEnter().Exit()
+	body, err := json.Marshal(toOtlpRequest(serviceName, spans))
+	if err != nil {
+		return err
+	}
+	response, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		return errors.New("Collector returned status: " + strconv.Itoa(response.StatusCode))
+	}
+	return nil
+}
+
+func (e *OtlpHttpExporter) Close() error {
+	return nil
+}
+
+// FileExporter appends the spans to a local file, one OTLP/JSON export request per line
+// (the same format the OpenTelemetry Collector file exporter writes).
+type FileExporter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileExporter(filename string) (*FileExporter, error) {
+	defer util.LogEnter().Exit()
+	if filename == "" {
+		return nil, errors.New("tracing_file is empty")
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: file}, nil
+}
+
+func (e *FileExporter) Export(serviceName string, spans []*Span) error {
+	defer util.LogEnter().Exit()
+	line, err := json.Marshal(toOtlpRequest(serviceName, spans))
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+func (e *FileExporter) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/tracing/tracingtest"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOtlpHttpExporter(t *testing.T) {
+	defer util.LogEnter().Exit()
+	collector := tracingtest.NewCollector()
+	defer collector.Close()
+	exporter, err := NewOtlpHttpExporter(collector.Url(), time.Second)
+	if err != nil {
+		t.Fatalf("Creating exporter failed: %s", err.Error())
+	}
+	previous := SetTracer(NewTracer(exporter, "simpleserver-test", 10, time.Hour))
+	defer SetTracer(previous)
+	ctx, parent := StartSpan(context.Background(), "parent", SpanKindServer)
+	_, child := StartSpan(ctx, "child", SpanKindInternal)
+	child.SetAttribute("count", 42)
+	child.SetAttribute("ok", true)
+	child.End()
+	parent.End()
+	myTracer.Shutdown()
+	spans := collector.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Collector should have received exactly 2 spans, got: %d", len(spans))
+	}
+	received := collector.Span("child")
+	if received == nil {
+		t.Fatal("Collector didn't receive the child span")
+	}
+	if received.ServiceName != "simpleserver-test" {
+		t.Errorf("Wrong service name: %s", received.ServiceName)
+	}
+	if received.TraceId != parent.Context.TraceId.String() || received.ParentSpanId != parent.Context.SpanId.String() {
+		t.Errorf("Wrong trace or parent span id: %v", received)
+	}
+	if received.Kind != int(SpanKindInternal) {
+		t.Errorf("Wrong span kind: %d", received.Kind)
+	}
+	// NOTE: OTLP/JSON encodes 64 bit integers as strings.
+	if received.Attributes["count"] != "42" || received.Attributes["ok"] != true {
+		t.Errorf("Wrong attributes: %v", received.Attributes)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir, err := ioutil.TempDir("", "simpleserver-tracing")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "spans.json")
+	exporter, err := NewFileExporter(filename)
+	if err != nil {
+		t.Fatalf("Creating exporter failed: %s", err.Error())
+	}
+	previous := SetTracer(NewTracer(exporter, "simpleserver-test", 10, time.Hour))
+	defer SetTracer(previous)
+	_, span := StartSpan(context.Background(), "file-span", SpanKindInternal)
+	span.End()
+	myTracer.Shutdown()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Couldn't open spans file: %s", err.Error())
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lines := 0
+	for scanner.Scan() {
+		var request otlpExportRequest
+		err = json.Unmarshal(scanner.Bytes(), &request)
+		if err != nil {
+			t.Fatalf("Line is not an OTLP/JSON export request: %s", err.Error())
+		}
+		name := request.ResourceSpans[0].ScopeSpans[0].Spans[0].Name
+		if name != "file-span" {
+			t.Errorf("Wrong span name: %s", name)
+		}
+		lines++
+	}
+	if lines != 1 {
+		t.Errorf("There should be exactly one line in the spans file, got: %d", lines)
+	}
+}
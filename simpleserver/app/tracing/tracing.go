@@ -0,0 +1,398 @@
+// Distributed tracing package.
+// Provides OpenTelemetry compatible spans: W3C traceparent propagation and
+// export in OTLP/HTTP JSON format to a collector or to a local file.
+// NOTE: Implemented with the Go standard library just like our logger,
+// we need only a small subset of the OpenTelemetry SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+func (id TraceId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceId) IsValid() bool {
+	return id != TraceId{}
+}
+
+func (id SpanId) IsValid() bool {
+	return id != SpanId{}
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId.IsValid() && sc.SpanId.IsValid()
+}
+
+// Formats the span context as W3C traceparent header value, e.g.:
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceId.String() + "-" + sc.SpanId.String() + "-" + flags
+}
+
+// Parses W3C traceparent header value.
+func ParseTraceParent(header string) (sc SpanContext, err error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errors.New("Invalid traceparent: " + header)
+	}
+	// Version ff is forbidden. Version 00 must have exactly four parts, future versions may have more.
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, errors.New("Invalid traceparent version: " + header)
+	}
+	_, err = hex.Decode(sc.TraceId[:], []byte(parts[1]))
+	if err == nil {
+		_, err = hex.Decode(sc.SpanId[:], []byte(parts[2]))
+	}
+	var flags uint64
+	if err == nil {
+		flags, err = strconv.ParseUint(parts[3], 16, 8)
+	}
+	if err != nil {
+		return SpanContext{}, errors.New("Invalid traceparent: " + header + ", error: " + err.Error())
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("Invalid traceparent, all zero id: " + header)
+	}
+	sc.Sampled = flags&1 == 1
+	return sc, nil
+}
+
+type SpanKind int
+
+// Values as in OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// Values as in OTLP.
+const (
+	StatusUnset StatusCode = 0
+	StatusOk    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+type Attribute struct {
+	Key   string
+	Value interface{} // string, bool, int, int64 or float64.
+}
+
+// Span is a timed operation. All methods are nil-safe: when tracing is disabled
+// StartSpan returns a nil span and instrumenting the code costs practically nothing.
+type Span struct {
+	mutex         sync.Mutex
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	ParentSpanId  SpanId
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    []Attribute
+	StatusCode    StatusCode
+	StatusMessage string
+	ended         bool
+	tracer        *Tracer
+}
+
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.Attributes = append(span.Attributes, Attribute{key, value})
+	span.mutex.Unlock()
+}
+
+// Marks the span failed if err is not nil.
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.StatusCode = StatusError
+	span.StatusMessage = err.Error()
+	span.mutex.Unlock()
+}
+
+func (span *Span) SetStatus(code StatusCode, msg string) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.StatusCode = code
+	span.StatusMessage = msg
+	span.mutex.Unlock()
+}
+
+// Ends the span and hands it to the exporter, unless the trace is not sampled. Calling End twice does nothing.
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	span.EndTime = time.Now()
+	span.mutex.Unlock()
+	if span.Context.Sampled {
+		span.tracer.enqueue(span)
+	}
+}
+
+func (span *Span) SpanContext() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.Context
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteParentKey
+)
+
+// Returns the current span in ctx, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// Sets the remote parent (e.g. parsed from the traceparent header) for the next span started from ctx.
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	if !parent.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteParentKey, parent)
+}
+
+// Starts a new span, a child of the current span in ctx (or of the remote parent) if there is one.
+// The span follows the sampling decision of its parent, a new trace is sampled.
+// Returns ctx with the new span as the current span. Remember to call span.End().
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	tracer := currentTracer()
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{Name: name, Kind: kind, StartTime: time.Now(), tracer: tracer}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.Context.TraceId = parent.Context.TraceId
+		span.ParentSpanId = parent.Context.SpanId
+		span.Context.Sampled = parent.Context.Sampled
+	} else if remote, ok := ctx.Value(remoteParentKey).(SpanContext); ok {
+		span.Context.TraceId = remote.TraceId
+		span.ParentSpanId = remote.SpanId
+		span.Context.Sampled = remote.Sampled
+	} else {
+		rand.Read(span.Context.TraceId[:])
+		span.Context.Sampled = true
+	}
+	rand.Read(span.Context.SpanId[:])
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Tracer batches the ended spans and hands them to the exporter in a background goroutine.
+type Tracer struct {
+	exporter      Exporter
+	serviceName   string
+	batchSize     int
+	flushInterval time.Duration
+	spans         chan *Span
+	flushes       chan chan bool
+	// NOTE: spans is never closed, since a span may end after Shutdown. Shutdown closes stop, and run closes done when it has exported the spans.
+	stop      chan bool
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func NewTracer(exporter Exporter, serviceName string, batchSize int, flushInterval time.Duration) *Tracer {
+	tracer := &Tracer{
+		exporter:      exporter,
+		serviceName:   serviceName,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		spans:         make(chan *Span, batchSize*4),
+		flushes:       make(chan chan bool),
+		stop:          make(chan bool),
+		done:          make(chan bool),
+	}
+	go tracer.run()
+	return tracer
+}
+
+func (tracer *Tracer) enqueue(span *Span) {
+	select {
+	case <-tracer.stop:
+		util.LogDebug("Tracer stopped, dropping span: " + span.Name)
+		return
+	default:
+	}
+	select {
+	case tracer.spans <- span:
+	default:
+		// NOTE: Never block the request processing because the collector is slow, just drop the span.
+		util.LogWarn("Tracing queue full, dropping span: " + span.Name)
+	}
+}
+
+func (tracer *Tracer) run() {
+	var batch []*Span
+	ticker := time.NewTicker(tracer.flushInterval)
+	defer ticker.Stop()
+	export := func() {
+		if len(batch) > 0 {
+			err := tracer.exporter.Export(tracer.serviceName, batch)
+			if err != nil {
+				util.LogError("Exporting spans failed: " + err.Error())
+			}
+			batch = nil
+		}
+	}
+	drain := func() {
+		for pending := len(tracer.spans); pending > 0; pending-- {
+			batch = append(batch, <-tracer.spans)
+		}
+	}
+	for {
+		select {
+		case <-tracer.stop:
+			drain()
+			export()
+			close(tracer.done)
+			return
+		case span := <-tracer.spans:
+			batch = append(batch, span)
+			if len(batch) >= tracer.batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-tracer.flushes:
+			// Drain the queue first.
+			drain()
+			export()
+			flushed <- true
+		}
+	}
+}
+
+// Exports all ended spans now. Does nothing after Shutdown, which has exported them.
+func (tracer *Tracer) Flush() {
+	flushed := make(chan bool)
+	select {
+	case tracer.flushes <- flushed:
+		<-flushed
+	case <-tracer.done:
+	}
+}
+
+// Exports all ended spans and stops the tracer. The spans ending after this are dropped.
+func (tracer *Tracer) Shutdown() {
+	tracer.closeOnce.Do(func() {
+		close(tracer.stop)
+		<-tracer.done
+		tracer.exporter.Close()
+	})
+}
+
+// Tracer singleton, nil if tracing is disabled (tracing_exporter=none).
+// NOTE: Read with currentTracer, since SetTracer may replace it while requests are being served.
+var myTracer = initTracer()
+var myTracerMutex sync.RWMutex
+
+func currentTracer() *Tracer {
+	myTracerMutex.RLock()
+	defer myTracerMutex.RUnlock()
+	return myTracer
+}
+
+func initTracer() *Tracer {
+	defer util.LogEnter().Exit()
+	exporterType := util.MyConfig["tracing_exporter"]
+	var exporter Exporter
+	var err error
+	switch exporterType {
+	case "", "none":
+		return nil
+	case "otlp":
+		exporter, err = NewOtlpHttpExporter(util.MyConfig["tracing_otlp_url"], configDuration("tracing_otlp_timeout_ms", 5000))
+	case "file":
+		exporter, err = NewFileExporter(util.MyConfig["tracing_file"])
+	default:
+		err = errors.New("Unknown tracing_exporter: " + exporterType)
+	}
+	if err != nil {
+		util.LogError("Tracing disabled, couldn't create exporter: " + err.Error())
+		return nil
+	}
+	serviceName := util.MyConfig["tracing_service_name"]
+	if serviceName == "" {
+		serviceName = "simpleserver"
+	}
+	batchSize := util.MyConfig.GetInt("tracing_batch_size", 100)
+	if batchSize < 1 {
+		batchSize = 100
+	}
+	util.LogInfo("Tracing enabled, exporter: " + exporterType)
+	return NewTracer(exporter, serviceName, batchSize, configDuration("tracing_flush_interval_ms", 1000))
+}
+
+func configDuration(key string, defaultMillis int) time.Duration {
+	millis := util.MyConfig.GetInt(key, defaultMillis)
+	if millis < 1 {
+		millis = defaultMillis
+	}
+	return time.Duration(millis) * time.Millisecond
+}
+
+// Replaces the tracer singleton, nil disables tracing. Returns the previous tracer.
+// Used e.g. in tests to export the spans to an in-process collector.
+func SetTracer(tracer *Tracer) (previous *Tracer) {
+	myTracerMutex.Lock()
+	defer myTracerMutex.Unlock()
+	previous, myTracer = myTracer, tracer
+	return previous
+}
+
+// Exports the pending spans and stops tracing. Call before the server exits.
+func Shutdown() {
+	defer util.LogEnter().Exit()
+	if tracer := currentTracer(); tracer != nil {
+		tracer.Shutdown()
+	}
+}
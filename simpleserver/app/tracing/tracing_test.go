@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	defer util.LogEnter().Exit()
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(header)
+	if err != nil {
+		t.Fatalf("Parsing valid traceparent failed: %s", err.Error())
+	}
+	if sc.TraceId.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("Wrong span context: %s", sc.TraceParent())
+	}
+	if sc.TraceParent() != header {
+		t.Errorf("Formatting span context failed, expected: %s, got: %s", header, sc.TraceParent())
+	}
+	invalidHeaders := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, invalid := range invalidHeaders {
+		if _, err := ParseTraceParent(invalid); err == nil {
+			t.Errorf("Parsing invalid traceparent should have failed: %s", invalid)
+		}
+	}
+	// Future versions may have more fields.
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); err != nil {
+		t.Errorf("Parsing future version traceparent failed: %s", err.Error())
+	}
+}
+
+type recordingExporter struct {
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(serviceName string, spans []*Span) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Close() error {
+	return nil
+}
+
+func TestStartSpan(t *testing.T) {
+	defer util.LogEnter().Exit()
+	exporter := &recordingExporter{}
+	previous := SetTracer(NewTracer(exporter, "test", 10, time.Hour))
+	defer SetTracer(previous)
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithRemoteParent(context.Background(), remote)
+	ctx, server := StartSpan(ctx, "server", SpanKindServer)
+	_, child := StartSpan(ctx, "child", SpanKindInternal)
+	child.SetError(errors.New("failure"))
+	child.End()
+	server.End()
+	server.End() // Second End does nothing.
+	myTracer.Flush()
+	if len(exporter.spans) != 2 {
+		t.Fatalf("There should be exactly 2 exported spans, got: %d", len(exporter.spans))
+	}
+	if server.Context.TraceId != remote.TraceId || server.ParentSpanId != remote.SpanId {
+		t.Error("Server span should continue the remote trace")
+	}
+	if child.Context.TraceId != remote.TraceId || child.ParentSpanId != server.Context.SpanId {
+		t.Error("Child span should be a child of the server span")
+	}
+	if child.StatusCode != StatusError || child.StatusMessage != "failure" {
+		t.Errorf("Child span status should be error, got: %d", child.StatusCode)
+	}
+	myTracer.Shutdown()
+}
+
+func TestDisabledTracing(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := SetTracer(nil)
+	defer SetTracer(previous)
+	ctx := context.Background()
+	newCtx, span := StartSpan(ctx, "disabled", SpanKindInternal)
+	if span != nil || newCtx != ctx {
+		t.Error("StartSpan should do nothing when tracing is disabled")
+	}
+	// Nil span is safe to use.
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failure"))
+	span.End()
+}
+
+func TestSpanEndingAfterShutdown(t *testing.T) {
+	defer util.LogEnter().Exit()
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter, "test", 10, time.Hour)
+	previous := SetTracer(tracer)
+	defer SetTracer(previous)
+	_, late := StartSpan(context.Background(), "late", SpanKindInternal)
+	_, ended := StartSpan(context.Background(), "ended", SpanKindInternal)
+	ended.End()
+	Shutdown()
+	// Neither may panic or block, and the late span is dropped.
+	late.End()
+	tracer.Flush()
+	tracer.Shutdown()
+	if len(exporter.spans) != 1 || exporter.spans[0].Name != "ended" {
+		t.Errorf("Only the span ended before Shutdown should have been exported, got: %v", exporter.spans)
+	}
+}
+
+func TestSetTracerWhileTracing(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := SetTracer(nil)
+	defer SetTracer(previous)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, span := StartSpan(context.Background(), "concurrent", SpanKindInternal)
+				span.End()
+			}
+		}()
+	}
+	tracers := []*Tracer{NewTracer(&recordingExporter{}, "test", 10, time.Hour), NewTracer(&recordingExporter{}, "test", 10, time.Hour)}
+	for _, tracer := range tracers {
+		SetTracer(tracer)
+	}
+	SetTracer(nil)
+	wg.Wait()
+	for _, tracer := range tracers {
+		tracer.Shutdown()
+	}
+}
+
+func TestUnsampledRemoteParent(t *testing.T) {
+	defer util.LogEnter().Exit()
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter, "test", 10, time.Hour)
+	previous := SetTracer(tracer)
+	defer SetTracer(previous)
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, server := StartSpan(ContextWithRemoteParent(context.Background(), remote), "server", SpanKindServer)
+	_, child := StartSpan(ctx, "child", SpanKindInternal)
+	if server.Context.Sampled || child.Context.Sampled || server.Context.TraceParent()[53:] != "00" {
+		t.Errorf("Spans should have followed the sampling decision of the remote parent, got: %s", child.Context.TraceParent())
+	}
+	child.End()
+	server.End()
+	tracer.Shutdown()
+	if len(exporter.spans) != 0 {
+		t.Errorf("Spans of a trace that is not sampled should not have been exported, got: %d", len(exporter.spans))
+	}
+}
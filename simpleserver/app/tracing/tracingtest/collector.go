@@ -0,0 +1,129 @@
+// Package tracingtest provides an in-process stand-in for an OpenTelemetry
+// collector, so that the tracing can be tested without running a real collector.
+package tracingtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// CollectedSpan is a span as received in the OTLP/HTTP JSON export request.
+type CollectedSpan struct {
+	ServiceName  string
+	TraceId      string
+	SpanId       string
+	ParentSpanId string
+	Name         string
+	Kind         int
+	StatusCode   int
+	Attributes   map[string]interface{}
+}
+
+type exportRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceId      string     `json:"traceId"`
+				SpanId       string     `json:"spanId"`
+				ParentSpanId string     `json:"parentSpanId"`
+				Name         string     `json:"name"`
+				Kind         int        `json:"kind"`
+				Attributes   []keyValue `json:"attributes"`
+				Status       struct {
+					Code int `json:"code"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type keyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// Collector accepts OTLP/HTTP JSON export requests at /v1/traces.
+type Collector struct {
+	Server *httptest.Server
+	mutex  sync.Mutex
+	spans  []CollectedSpan
+}
+
+// Starts the collector, remember to Close it.
+func NewCollector() *Collector {
+	collector := &Collector{}
+	collector.Server = httptest.NewServer(http.HandlerFunc(collector.handle))
+	return collector
+}
+
+func (c *Collector) handle(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/v1/traces" || request.Method != "POST" {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if request.Header.Get("Content-Type") != "application/json" {
+		writer.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	var export exportRequest
+	err := json.NewDecoder(request.Body).Decode(&export)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mutex.Lock()
+	for _, resourceSpans := range export.ResourceSpans {
+		serviceName := ""
+		for _, attribute := range resourceSpans.Resource.Attributes {
+			if attribute.Key == "service.name" {
+				serviceName, _ = attribute.Value["stringValue"].(string)
+			}
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				attributes := make(map[string]interface{})
+				for _, attribute := range span.Attributes {
+					for _, value := range attribute.Value {
+						attributes[attribute.Key] = value
+					}
+				}
+				c.spans = append(c.spans, CollectedSpan{serviceName, span.TraceId, span.SpanId,
+					span.ParentSpanId, span.Name, span.Kind, span.Status.Code, attributes})
+			}
+		}
+	}
+	c.mutex.Unlock()
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write([]byte("{}"))
+}
+
+// Base url of the collector, use as tracing_otlp_url.
+func (c *Collector) Url() string {
+	return c.Server.URL
+}
+
+// Returns the spans received so far.
+func (c *Collector) Spans() []CollectedSpan {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]CollectedSpan(nil), c.spans...)
+}
+
+// Returns the received span with the given name, nil if not found.
+func (c *Collector) Span(name string) *CollectedSpan {
+	for _, span := range c.Spans() {
+		if span.Name == name {
+			return &span
+		}
+	}
+	return nil
+}
+
+func (c *Collector) Close() {
+	c.Server.Close()
+}
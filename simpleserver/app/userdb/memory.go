@@ -0,0 +1,164 @@
+package userdb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the users in maps, i.e. the users are lost when the server stops.
+// NOTE: The web server serves requests concurrently, so the maps are guarded by a mutex.
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	usersMap map[int]User
+	// User ids by emailKey, so that finding a user by email does not have to go through all users.
+	emailIndex map[string]int
+	// Password reset and email verification tokens by token hash, see tokens.go.
+	tokens map[string]Token
+	nextId func() int
+}
+
+// NOTE: Counter and Go closure example (compare to equivalent Python closure, practically the same).
+func createCounter(i int) func() int {
+	return func() int {
+		i++
+		return i
+	}
+}
+
+// Creates a store with the seed users.
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		usersMap:   make(map[int]User),
+		emailIndex: make(map[string]int),
+		tokens:     make(map[string]Token),
+	}
+	maxId := 0
+	for _, user := range seedUsers() {
+		store.usersMap[user.UserId] = user
+		store.emailIndex[emailKey(user.email)] = user.UserId
+		if user.UserId > maxId {
+			maxId = user.UserId
+		}
+	}
+	store.nextId = createCounter(maxId)
+	return store
+}
+
+// NOTE: The caller must hold the mutex.
+func (store *MemoryStore) findByEmail(email string) (ret User, ok bool) {
+	id, ok := store.emailIndex[emailKey(email)]
+	if ok {
+		ret = store.usersMap[id]
+	}
+	return ret, ok
+}
+
+func (store *MemoryStore) AddUser(ctx context.Context, user User) (User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, exists := store.findByEmail(user.email); exists {
+		return User{}, EmailExistsError{user.email}
+	}
+	user.UserId = store.nextId()
+	store.usersMap[user.UserId] = user
+	store.emailIndex[emailKey(user.email)] = user.UserId
+	return user, nil
+}
+
+func (store *MemoryStore) GetUserById(ctx context.Context, userId int) (User, bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	user, ok := store.usersMap[userId]
+	return user, ok, nil
+}
+
+func (store *MemoryStore) GetUserByEmail(ctx context.Context, email string) (User, bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	user, ok := store.findByEmail(email)
+	return user, ok, nil
+}
+
+func (store *MemoryStore) ListUsers(ctx context.Context, offset int, limit int) (users []User, total int, err error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	ids := make([]int, 0, len(store.usersMap))
+	for id := range store.usersMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	total = len(ids)
+	users = []User{}
+	for i := offset; i < total && len(users) < limit; i++ {
+		users = append(users, store.usersMap[ids[i]])
+	}
+	return users, total, nil
+}
+
+func (store *MemoryStore) UpdateUser(ctx context.Context, userId int, modify func(user *User) error) (User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	user, ok := store.usersMap[userId]
+	if !ok {
+		return User{}, UserNotFoundError{userId}
+	}
+	if err := modify(&user); err != nil {
+		return User{}, err
+	}
+	store.usersMap[userId] = user
+	return user, nil
+}
+
+func (store *MemoryStore) DeleteUser(ctx context.Context, userId int) (User, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	user, ok := store.usersMap[userId]
+	if !ok {
+		return User{}, UserNotFoundError{userId}
+	}
+	delete(store.usersMap, userId)
+	delete(store.emailIndex, emailKey(user.email))
+	for hash, token := range store.tokens {
+		if token.UserId == userId {
+			delete(store.tokens, hash)
+		}
+	}
+	return user, nil
+}
+
+func (store *MemoryStore) AddToken(ctx context.Context, token Token) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
+	for hash, existing := range store.tokens {
+		if (existing.UserId == token.UserId && existing.Purpose == token.Purpose) || now.After(existing.Expires) {
+			delete(store.tokens, hash)
+		}
+	}
+	store.tokens[token.Hash] = token
+	return nil
+}
+
+func (store *MemoryStore) TakeToken(ctx context.Context, hash string, purpose string) (Token, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	token, ok := store.tokens[hash]
+	if !ok || token.Purpose != purpose {
+		return Token{}, false, nil
+	}
+	delete(store.tokens, hash)
+	return token, true, nil
+}
+
+func (store *MemoryStore) GetTokens(ctx context.Context, userId int) (ret []Token, err error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	for _, token := range store.tokens {
+		if token.UserId == userId {
+			ret = append(ret, token)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,28 @@
+package userdb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	store, other := NewMemoryStore(), NewMemoryStore()
+	added, err := store.AddUser(ctx, User{0, "memory.test@foo.com", "Memory", "Test", hashString("MemoryPassword"), []string{RoleCustomer}, false, false, false})
+	if err != nil || added.UserId != len(seedUsers())+1 {
+		t.Fatalf("Adding user should have got the id after the seed users, got: %v, %v", added, err)
+	}
+	if _, ok, _ := other.GetUserById(ctx, added.UserId); ok {
+		t.Errorf("Stores should have been independent")
+	}
+	// NOTE: modify gets a copy, the stored user changes only if modify succeeds.
+	store.UpdateUser(ctx, added.UserId, func(user *User) error {
+		user.firstName = "Changed"
+		return WrongPasswordError{}
+	})
+	if user, _, _ := store.GetUserById(ctx, added.UserId); user.firstName != "Memory" {
+		t.Errorf("Failed update should not have changed the user, got: %v", user)
+	}
+}
@@ -0,0 +1,163 @@
+package userdb
+
+import (
+	"context"
+	"database/sql"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"sync"
+	"time"
+)
+
+// Session is a login session of the web server, i.e. a JSON web token created for the user.
+type Session struct {
+	Email   string
+	Id      string // The token id (jti).
+	Expires time.Time
+}
+
+// SessionStore keeps the sessions by their tokens. The web server accepts only the tokens found in the store.
+// NOTE: Keeping the email makes it possible to revoke all sessions of a user.
+type SessionStore interface {
+	AddSession(ctx context.Context, token string, session Session) error
+	// ok is false if there is no such session.
+	GetSession(ctx context.Context, token string) (session Session, ok bool, err error)
+	RemoveSession(ctx context.Context, token string) error
+	// Removes the sessions of the user except the given token (empty: remove all). Returns the number of removed sessions.
+	RemoveSessions(ctx context.Context, email string, exceptToken string) (count int, err error)
+	// Returns the sessions of the user, expired sessions included.
+	ListSessions(ctx context.Context, email string) ([]Session, error)
+}
+
+// MemorySessionStore keeps the sessions in a map, i.e. all sessions end when the server stops.
+type MemorySessionStore struct {
+	mutex  sync.Mutex
+	tokens map[string]Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{tokens: make(map[string]Session)}
+}
+
+func (store *MemorySessionStore) AddSession(ctx context.Context, token string, session Session) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.tokens[token] = session
+	return nil
+}
+
+func (store *MemorySessionStore) GetSession(ctx context.Context, token string) (Session, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	session, ok := store.tokens[token]
+	return session, ok, nil
+}
+
+func (store *MemorySessionStore) RemoveSession(ctx context.Context, token string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.tokens, token)
+	return nil
+}
+
+func (store *MemorySessionStore) RemoveSessions(ctx context.Context, email string, exceptToken string) (count int, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for token, session := range store.tokens {
+		if session.Email == email && token != exceptToken {
+			delete(store.tokens, token)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *MemorySessionStore) ListSessions(ctx context.Context, email string) (ret []Session, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, session := range store.tokens {
+		if session.Email == email {
+			ret = append(ret, session)
+		}
+	}
+	return ret, nil
+}
+
+// SqlSessionStore keeps the sessions in the sessions table of the database, so the sessions survive restarts
+// and all servers of the database accept the same sessions.
+// NOTE: Only the hashes of the tokens are stored, like the single-use tokens, see hashToken.
+type SqlSessionStore struct {
+	db *sqldb.DB
+}
+
+func NewSqlSessionStore(db *sqldb.DB) *SqlSessionStore {
+	return &SqlSessionStore{db}
+}
+
+// Also removes the expired sessions, so that the table does not grow forever.
+func (store *SqlSessionStore) AddSession(ctx context.Context, token string, session Session) error {
+	return store.db.InTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE expires < $1`, time.Now().Unix()); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO sessions (token_hash, email, token_id, expires) VALUES ($1, $2, $3, $4)`,
+			hashToken(token), session.Email, session.Id, session.Expires.Unix())
+		return err
+	})
+}
+
+func (store *SqlSessionStore) GetSession(ctx context.Context, token string) (session Session, ok bool, err error) {
+	var expires int64
+	err = store.db.Retry(ctx, func() error {
+		return store.db.QueryRowContext(ctx, `SELECT email, token_id, expires FROM sessions WHERE token_hash = $1`,
+			hashToken(token)).Scan(&session.Email, &session.Id, &expires)
+	})
+	if err == sql.ErrNoRows {
+		return Session{}, false, nil
+	} else if err != nil {
+		return Session{}, false, err
+	}
+	session.Expires = time.Unix(expires, 0).UTC()
+	return session, true, nil
+}
+
+func (store *SqlSessionStore) RemoveSession(ctx context.Context, token string) error {
+	return store.db.Retry(ctx, func() error {
+		_, err := store.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = $1`, hashToken(token))
+		return err
+	})
+}
+
+func (store *SqlSessionStore) RemoveSessions(ctx context.Context, email string, exceptToken string) (count int, err error) {
+	err = store.db.Retry(ctx, func() error {
+		result, err := store.db.ExecContext(ctx, `DELETE FROM sessions WHERE email = $1 AND token_hash <> $2`, email, hashToken(exceptToken))
+		if err != nil {
+			return err
+		}
+		removed, err := result.RowsAffected()
+		count = int(removed)
+		return err
+	})
+	return count, err
+}
+
+func (store *SqlSessionStore) ListSessions(ctx context.Context, email string) (ret []Session, err error) {
+	err = store.db.Retry(ctx, func() error {
+		rows, err := store.db.QueryContext(ctx, `SELECT token_id, expires FROM sessions WHERE email = $1`, email)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		ret = nil
+		for rows.Next() {
+			session := Session{Email: email}
+			var expires int64
+			if err = rows.Scan(&session.Id, &expires); err != nil {
+				return err
+			}
+			session.Expires = time.Unix(expires, 0).UTC()
+			ret = append(ret, session)
+		}
+		return rows.Err()
+	})
+	return ret, err
+}
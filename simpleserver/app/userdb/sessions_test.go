@@ -0,0 +1,54 @@
+package userdb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/sqldb/sqldbtest"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"testing"
+	"time"
+)
+
+// The behavior every session store must have.
+func testSessionStore(t *testing.T, store SessionStore) {
+	ctx := context.Background()
+	email := "session.test@foo.com"
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	store.AddSession(ctx, "token-1", Session{email, "id-1", expires})
+	store.AddSession(ctx, "token-2", Session{email, "id-2", expires.Add(time.Minute)})
+	store.AddSession(ctx, "other-token", Session{"other@foo.com", "id-3", expires})
+	if session, ok, err := store.GetSession(ctx, "token-1"); err != nil || !ok || session != (Session{email, "id-1", expires}) {
+		t.Errorf("Session should have been found as added, got: %v, %t, %v", session, ok, err)
+	}
+	if _, ok, err := store.GetSession(ctx, "unknown-token"); ok || err != nil {
+		t.Errorf("Unknown session should not have been found, err: %v", err)
+	}
+	if sessions, err := store.ListSessions(ctx, email); err != nil || len(sessions) != 2 {
+		t.Errorf("User should have had 2 sessions, got: %v, %v", sessions, err)
+	}
+	if count, err := store.RemoveSessions(ctx, email, "token-2"); err != nil || count != 1 {
+		t.Errorf("Should have removed 1 session, removed: %d, %v", count, err)
+	}
+	if _, ok, _ := store.GetSession(ctx, "token-2"); !ok {
+		t.Errorf("Excepted session should have been kept")
+	}
+	store.RemoveSession(ctx, "token-2")
+	if sessions, _ := store.ListSessions(ctx, email); len(sessions) != 0 {
+		t.Errorf("All sessions of the user should have been removed, got: %v", sessions)
+	}
+	if count, _ := store.RemoveSessions(ctx, "other@foo.com", ""); count != 1 {
+		t.Errorf("Empty except token should have removed all sessions, removed: %d", count)
+	}
+}
+
+func TestSessionStores(t *testing.T) {
+	defer util.LogEnter().Exit()
+	t.Run("memory", func(t *testing.T) {
+		testSessionStore(t, NewMemorySessionStore())
+	})
+	t.Run("sql", func(t *testing.T) {
+		testSessionStore(t, NewSqlSessionStore(openTestDb(t, t.TempDir())))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testSessionStore(t, NewSqlSessionStore(sqldbtest.OpenPostgres(t)))
+	})
+}
@@ -0,0 +1,227 @@
+package userdb
+
+import (
+	"context"
+	"database/sql"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"strings"
+	"time"
+)
+
+// SqlStore keeps the users in the users and user_tokens tables of the database, see sqldb.
+// NOTE: Works with every dialect of sqldb, and retries on transient errors, see sqldb.DB.Retry.
+type SqlStore struct {
+	db *sqldb.DB
+}
+
+// Creates a store of the migrated database. A new database has no users, the first admin is added with AddAdmin.
+func NewSqlStore(db *sqldb.DB) *SqlStore {
+	defer util.LogEnter().Exit()
+	return &SqlStore{db}
+}
+
+const userColumns = `email, email_key, first_name, last_name, hashed_password, roles, disabled, password_reset_required, email_verified`
+
+// Inserts the user and returns the id the database gave to the user.
+func insertUser(ctx context.Context, tx *sql.Tx, user User) (userId int, err error) {
+	err = tx.QueryRowContext(ctx, `INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING user_id`,
+		user.email, emailKey(user.email), user.firstName, user.lastName, user.hashedPassword,
+		strings.Join(user.roles, ","), user.disabled, user.passwordResetRequired, user.emailVerified).Scan(&userId)
+	return userId, err
+}
+
+// NOTE: The roles are kept comma separated, the roles are known words without commas.
+func splitRoles(roles string) []string {
+	if roles == "" {
+		return []string{}
+	}
+	return strings.Split(roles, ",")
+}
+
+// Something to scan a row from: *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (user User, err error) {
+	var emailKey, roles string
+	err = row.Scan(&user.UserId, &user.email, &emailKey, &user.firstName, &user.lastName, &user.hashedPassword,
+		&roles, &user.disabled, &user.passwordResetRequired, &user.emailVerified)
+	user.roles = splitRoles(roles)
+	return user, err
+}
+
+// Something to query a row with: *sql.DB or *sql.Tx.
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Returns the user found with the where clause, ok is false if there is no such user.
+func getUser(ctx context.Context, db rowQueryer, where string, args ...interface{}) (User, bool, error) {
+	user, err := scanUser(db.QueryRowContext(ctx, `SELECT user_id, `+userColumns+` FROM users WHERE `+where, args...))
+	if err == sql.ErrNoRows {
+		return User{}, false, nil
+	}
+	return user, err == nil, err
+}
+
+// Gets the user outside of a transaction, with retries.
+func (store *SqlStore) getUser(ctx context.Context, where string, args ...interface{}) (user User, ok bool, err error) {
+	err = store.db.Retry(ctx, func() error {
+		user, ok, err = getUser(ctx, store.db, where, args...)
+		return err
+	})
+	return user, ok, err
+}
+
+func (store *SqlStore) AddUser(ctx context.Context, user User) (added User, err error) {
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		if _, exists, err := getUser(ctx, tx, `email_key = $1`, emailKey(user.email)); err != nil {
+			return err
+		} else if exists {
+			return EmailExistsError{user.email}
+		}
+		added = user
+		added.UserId, err = insertUser(ctx, tx, user)
+		// NOTE: Another server may have added the email after the check above.
+		if sqldb.IsUniqueViolation(err) {
+			return EmailExistsError{user.email}
+		}
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return added, nil
+}
+
+func (store *SqlStore) GetUserById(ctx context.Context, userId int) (User, bool, error) {
+	return store.getUser(ctx, `user_id = $1`, userId)
+}
+
+func (store *SqlStore) GetUserByEmail(ctx context.Context, email string) (User, bool, error) {
+	return store.getUser(ctx, `email_key = $1`, emailKey(email))
+}
+
+func (store *SqlStore) ListUsers(ctx context.Context, offset int, limit int) (users []User, total int, err error) {
+	err = store.db.Retry(ctx, func() error {
+		if err := store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&total); err != nil {
+			return err
+		}
+		rows, err := store.db.QueryContext(ctx, `SELECT user_id, `+userColumns+` FROM users ORDER BY user_id LIMIT $1 OFFSET $2`, limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		users = []User{}
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (store *SqlStore) UpdateUser(ctx context.Context, userId int, modify func(user *User) error) (updated User, err error) {
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		user, ok, err := getUser(ctx, tx, `user_id = $1`, userId)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return UserNotFoundError{userId}
+		}
+		if err = modify(&user); err != nil {
+			return err
+		}
+		// NOTE: The email cannot be changed, so email_key stays as it is.
+		_, err = tx.ExecContext(ctx, `UPDATE users SET first_name = $1, last_name = $2, hashed_password = $3, roles = $4,
+    disabled = $5, password_reset_required = $6, email_verified = $7 WHERE user_id = $8`,
+			user.firstName, user.lastName, user.hashedPassword, strings.Join(user.roles, ","),
+			user.disabled, user.passwordResetRequired, user.emailVerified, userId)
+		updated = user
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return updated, nil
+}
+
+func (store *SqlStore) DeleteUser(ctx context.Context, userId int) (deleted User, err error) {
+	err = store.db.InTx(ctx, func(tx *sql.Tx) error {
+		user, ok, err := getUser(ctx, tx, `user_id = $1`, userId)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return UserNotFoundError{userId}
+		}
+		// NOTE: The tokens are deleted by ON DELETE CASCADE.
+		_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE user_id = $1`, userId)
+		deleted = user
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return deleted, nil
+}
+
+func (store *SqlStore) AddToken(ctx context.Context, token Token) error {
+	return store.db.InTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM user_tokens WHERE (user_id = $1 AND purpose = $2) OR expires < $3`,
+			token.UserId, token.Purpose, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO user_tokens (token_hash, purpose, user_id, expires) VALUES ($1, $2, $3, $4)`,
+			token.Hash, token.Purpose, token.UserId, token.Expires.Unix())
+		return err
+	})
+}
+
+func (store *SqlStore) TakeToken(ctx context.Context, hash string, purpose string) (token Token, ok bool, err error) {
+	var expires int64
+	err = store.db.Retry(ctx, func() error {
+		return store.db.QueryRowContext(ctx, `DELETE FROM user_tokens WHERE token_hash = $1 AND purpose = $2 RETURNING user_id, expires`,
+			hash, purpose).Scan(&token.UserId, &expires)
+	})
+	if err == sql.ErrNoRows {
+		return Token{}, false, nil
+	} else if err != nil {
+		return Token{}, false, err
+	}
+	token.Hash, token.Purpose, token.Expires = hash, purpose, time.Unix(expires, 0)
+	return token, true, nil
+}
+
+func (store *SqlStore) GetTokens(ctx context.Context, userId int) (ret []Token, err error) {
+	err = store.db.Retry(ctx, func() error {
+		rows, err := store.db.QueryContext(ctx, `SELECT token_hash, purpose, expires FROM user_tokens WHERE user_id = $1`, userId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		ret = nil
+		for rows.Next() {
+			token := Token{UserId: userId}
+			var expires int64
+			if err = rows.Scan(&token.Hash, &token.Purpose, &expires); err != nil {
+				return err
+			}
+			token.Expires = time.Unix(expires, 0)
+			ret = append(ret, token)
+		}
+		return rows.Err()
+	})
+	return ret, err
+}
@@ -0,0 +1,32 @@
+package userdb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"testing"
+)
+
+func TestSqlStoreSurvivesRestart(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := NewSqlStore(openTestDb(t, dir))
+	// A new database has no seed users, so that there is no admin with a known password.
+	if _, total, err := store.ListUsers(ctx, 0, 10); err != nil || total != 0 {
+		t.Fatalf("New database should have had no users, got: %d, %v", total, err)
+	}
+	for _, email := range []string{"first.test@foo.com", "restart.test@foo.com"} {
+		if _, err := store.AddUser(ctx, User{0, email, "Restart", "Test", hashString("RestartPassword"), []string{RoleCustomer}, false, false, false}); err != nil {
+			t.Fatalf("Adding user failed: %s", err.Error())
+		}
+	}
+	store.DeleteUser(ctx, 1)
+	store = NewSqlStore(openTestDb(t, dir))
+	users, total, err := store.ListUsers(ctx, 0, 10)
+	if err != nil || total != 1 || users[0].UserId != 2 || users[0].email != "restart.test@foo.com" {
+		t.Errorf("Users should have survived the restart, got: %v, %d, %v", users, total, err)
+	}
+	if user, ok, _ := store.GetUserByEmail(ctx, "RESTART.test@foo.com"); !ok || user.hashedPassword != hashString("RestartPassword") || len(user.roles) != 1 {
+		t.Errorf("User should have been found with all the fields, got: %v", user)
+	}
+}
@@ -0,0 +1,84 @@
+package userdb
+
+import (
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"time"
+)
+
+// Store keeps the users and their single-use tokens. The package functions (AddUser, CheckCredentials etc.)
+// implement the rules, e.g. password hashing, and use the store set with SetStore for the data.
+// NOTE: The methods get and return whole users, so the store is implemented in this package: MemoryStore and SqlStore.
+type Store interface {
+	// Adds the user with a new user id and returns the added user. Returns EmailExistsError if the email is in use.
+	AddUser(ctx context.Context, user User) (User, error)
+	// ok is false if there is no such user.
+	GetUserById(ctx context.Context, userId int) (user User, ok bool, err error)
+	// Finds the user case-insensitively, see emailKey. ok is false if there is no such user.
+	GetUserByEmail(ctx context.Context, email string) (user User, ok bool, err error)
+	// Returns the users ordered by user id, starting from offset, at most limit users, and the total number of users.
+	ListUsers(ctx context.Context, offset int, limit int) (users []User, total int, err error)
+	// Applies modify to the user and saves the user, unless modify returns an error. Returns the saved user.
+	// Returns UserNotFoundError if there is no such user.
+	UpdateUser(ctx context.Context, userId int, modify func(user *User) error) (User, error)
+	// Deletes the user with the user's tokens and returns the deleted user. Returns UserNotFoundError if there is no such user.
+	DeleteUser(ctx context.Context, userId int) (User, error)
+	// Saves the token, removing the user's previous tokens with the same purpose and all expired tokens.
+	AddToken(ctx context.Context, token Token) error
+	// Removes the token with the hash and the purpose and returns it. ok is false if there is no such token.
+	TakeToken(ctx context.Context, hash string, purpose string) (token Token, ok bool, err error)
+	// Returns the user's tokens, expired tokens included.
+	GetTokens(ctx context.Context, userId int) ([]Token, error)
+}
+
+// Token is a single-use token as stored: only the hash of the token is kept.
+type Token struct {
+	Hash    string
+	Purpose string
+	UserId  int
+	Expires time.Time
+}
+
+// The seed users of a new memory store, for development and the tests.
+// NOTE: Customers only, an admin with a known password would be an admin of every server using the memory store.
+// The SQL stores are not seeded, their first admin is added with AddAdmin.
+func seedUsers() []User {
+	customer := []string{RoleCustomer}
+	return []User{
+		{1, "kari.karttinen@foo.com", "Kari", "Karttinen", "2842551024", customer, false, false, true},
+		{2, "timo.tillinen@foo.com", "Timo", "Tillinen", "3655654034", customer, false, false, true},
+		//{3, "erkka.erkkila@foo.com", "Erkka", "Erkkila", "2077629983", customer, false, false, true},
+		// Used in testing manually.
+		{3, "i", "Erkka", "Erkkila", "3960223172", customer, false, false, true}, // password: "i"
+	}
+}
+
+// The store of the package functions.
+var myStore Store = NewMemoryStore()
+
+// Sets the store of the package functions and returns the previous store.
+func SetStore(store Store) (previous Store) {
+	defer util.LogEnter().Exit()
+	previous, myStore = myStore, store
+	return previous
+}
+
+// Opens the stores configured with user_store: memory (the default, lost on restart), sqlite (the database of sqlite_file)
+// or postgres (the database of postgres_url). The session store of the web server is of the same kind as the user store.
+func OpenStore(ctx context.Context) (Store, SessionStore, error) {
+	defer util.LogEnter().Exit()
+	switch storeType := util.MyConfig["user_store"]; storeType {
+	case "", "memory":
+		return NewMemoryStore(), NewMemorySessionStore(), nil
+	case sqldb.Sqlite, sqldb.Postgres:
+		db, err := sqldb.Default(storeType)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewSqlStore(db), NewSqlSessionStore(db), nil
+	default:
+		return nil, nil, errors.New("Unknown user_store: " + storeType)
+	}
+}
@@ -0,0 +1,118 @@
+package userdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/karimarttila/go/simpleserver/app/sqldb"
+	"github.com/karimarttila/go/simpleserver/app/sqldb/sqldbtest"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The behavior every store must have: the tests of the package functions. They run against the default
+// memory store as such, and against a new store of each kind in TestStores.
+var storeTests = []struct {
+	name string
+	test func(t *testing.T)
+}{
+	{"GetUserByEmail", TestGetUserByEmail},
+	{"AddUser", TestAddUser},
+	{"AddAdmin", TestAddAdmin},
+	{"CheckCredentials", TestCheckCredentials},
+	{"EmailCaseInsensitive", TestEmailCaseInsensitive},
+	{"GetRoles", TestGetRoles},
+	{"ListUsers", TestListUsers},
+	{"UserAdministration", TestUserAdministration},
+	{"UpdateProfileAndChangePassword", TestUpdateProfileAndChangePassword},
+	{"PasswordResetToken", TestPasswordResetToken},
+	{"EmailVerificationToken", TestEmailVerificationToken},
+	{"GetUserTokensAndDeleteUser", TestGetUserTokensAndDeleteUser},
+}
+
+// Opens a new database in the test's temporary directory.
+func openTestDb(t *testing.T, dir string) *sqldb.DB {
+	db, err := sqldb.OpenSqlite(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Opening the database failed: %s", err.Error())
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// The admin the tests of the package functions expect in addition to the seed users, user id 4.
+var testAdmin = User{0, "admin@foo.com", "Admin", "Adminen", hashString("Admin"), []string{RoleAdmin}, false, false, true}
+
+// Creates a memory store with the seed users and the test admin.
+func newTestMemoryStore(t *testing.T) Store {
+	store := NewMemoryStore()
+	if _, err := store.AddUser(context.Background(), testAdmin); err != nil {
+		t.Fatalf("Adding the test admin failed: %s", err.Error())
+	}
+	return store
+}
+
+// Creates a store with the seed users of the memory store and the test admin.
+func newTestSqlStore(t *testing.T, db *sqldb.DB) Store {
+	store := NewSqlStore(db)
+	for _, user := range append(seedUsers(), testAdmin) {
+		if _, err := store.AddUser(context.Background(), user); err != nil {
+			t.Fatalf("Adding the seed users failed: %s", err.Error())
+		}
+	}
+	return store
+}
+
+// NOTE: The postgres tests are skipped if there is no PostgreSQL server for the tests, see sqldbtest.
+var testStores = map[string]func(t *testing.T) Store{
+	"memory":   newTestMemoryStore,
+	"sql":      func(t *testing.T) Store { return newTestSqlStore(t, openTestDb(t, t.TempDir())) },
+	"postgres": func(t *testing.T) Store { return newTestSqlStore(t, sqldbtest.OpenPostgres(t)) },
+}
+
+func TestMain(m *testing.M) {
+	if _, err := myStore.AddUser(context.Background(), testAdmin); err != nil {
+		fmt.Println("Adding the test admin failed: " + err.Error())
+		os.Exit(1)
+	}
+	code := m.Run()
+	sqldbtest.StopPostgres()
+	os.Exit(code)
+}
+
+func TestStores(t *testing.T) {
+	defer util.LogEnter().Exit()
+	for storeName, newStore := range testStores {
+		t.Run(storeName, func(t *testing.T) {
+			previous := SetStore(newStore(t))
+			defer SetStore(previous)
+			for _, storeTest := range storeTests {
+				t.Run(storeTest.name, storeTest.test)
+			}
+		})
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousStore, previousFile := util.MyConfig["user_store"], util.MyConfig["sqlite_file"]
+	defer func() {
+		util.MyConfig["user_store"], util.MyConfig["sqlite_file"] = previousStore, previousFile
+		sqldb.Close()
+	}()
+	util.MyConfig["user_store"] = "memory"
+	if store, sessions, err := OpenStore(context.Background()); err != nil || store == nil || sessions == nil {
+		t.Errorf("Opening the memory store failed: %v", err)
+	}
+	util.MyConfig["user_store"] = "sqlite"
+	util.MyConfig["sqlite_file"] = filepath.Join(t.TempDir(), "users.db")
+	store, sessions, err := OpenStore(context.Background())
+	if _, ok := store.(*SqlStore); err != nil || !ok || sessions == nil {
+		t.Errorf("Opening the sqlite store failed: %v", err)
+	}
+	util.MyConfig["user_store"] = "unknown"
+	if _, _, err := OpenStore(context.Background()); err == nil {
+		t.Errorf("Opening an unknown store should have failed")
+	}
+}
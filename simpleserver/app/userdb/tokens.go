@@ -0,0 +1,155 @@
+package userdb
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"sort"
+	"time"
+)
+
+// Single-use tokens mailed to the users: password reset and email verification tokens.
+
+const (
+	passwordResetPurpose     = "password-reset"
+	emailVerificationPurpose = "email-verification"
+)
+
+// InvalidTokenError is returned if the token is unknown, used or expired.
+type InvalidTokenError struct{}
+
+func (e InvalidTokenError) Error() string {
+	return "Token is invalid or expired"
+}
+
+// TokenInfo tells which tokens the user has without revealing the tokens themselves, see GetUserTokens.
+type TokenInfo struct {
+	Purpose string    `json:"purpose"`
+	Expires time.Time `json:"expires"`
+}
+
+// NOTE: Only the hash of the token is stored, so the tokens cannot be read from the database.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates a token for the user, invalidating the user's previous tokens with the same purpose.
+func createToken(ctx context.Context, purpose string, userId int, ttl time.Duration) (token string, err error) {
+	bytes := make([]byte, 32)
+	if _, err = rand.Read(bytes); err != nil {
+		return "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(bytes)
+	return token, myStore.AddToken(ctx, Token{hashToken(token), purpose, userId, time.Now().Add(ttl)})
+}
+
+// Returns the user's unexpired tokens ordered by expiration time.
+func GetUserTokens(ctx context.Context, userId int) (ret []TokenInfo, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.GetUserTokens", tracing.SpanKindInternal)
+	defer span.End()
+	tokens, err := myStore.GetTokens(ctx, userId)
+	span.SetError(err)
+	now := time.Now()
+	ret = []TokenInfo{}
+	for _, token := range tokens {
+		if !now.After(token.Expires) {
+			ret = append(ret, TokenInfo{token.Purpose, token.Expires.UTC()})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Expires.Before(ret[j].Expires) })
+	return ret, err
+}
+
+// Consumes the token and returns its user. ok is false if the token is invalid or expired, or the user is disabled.
+func consumeToken(ctx context.Context, purpose string, token string) (user User, ok bool, err error) {
+	// NOTE: The token is single-use, it is removed even if it has expired.
+	stored, found, err := myStore.TakeToken(ctx, hashToken(token), purpose)
+	if err != nil || !found {
+		return user, false, err
+	}
+	user, found, err = myStore.GetUserById(ctx, stored.UserId)
+	return user, found && !user.disabled && !time.Now().After(stored.Expires), err
+}
+
+// Creates a token for the user unless skip tells otherwise. ok is false if the user was not found or was skipped.
+func createUserToken(ctx context.Context, userEmail string, purpose string, ttl time.Duration, skip func(user User) bool) (token string, ok bool, err error) {
+	user, ok, err := myStore.GetUserByEmail(ctx, userEmail)
+	if err != nil || !ok || skip(user) {
+		return "", false, err
+	}
+	token, err = createToken(ctx, purpose, user.UserId, ttl)
+	return token, err == nil, err
+}
+
+// Creates a single-use password reset token valid for ttl. Creating a new token invalidates the user's previous tokens.
+// ok is false if the user was not found or is disabled.
+func CreatePasswordResetToken(ctx context.Context, userEmail string, ttl time.Duration) (token string, ok bool, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.CreatePasswordResetToken", tracing.SpanKindInternal)
+	defer span.End()
+	token, ok, err = createUserToken(ctx, userEmail, passwordResetPurpose, ttl, func(user User) bool {
+		return user.disabled
+	})
+	span.SetError(err)
+	return token, ok, err
+}
+
+// Consumes the password reset token and sets the new password. The caller validates the new password.
+// Resetting the password also fulfills a forced password reset, and proves that the user owns the email.
+func ResetPassword(ctx context.Context, token string, newPassword string) (ret UserInfo, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.ResetPassword", tracing.SpanKindInternal)
+	defer span.End()
+	user, ok, err := consumeToken(ctx, passwordResetPurpose, token)
+	if err == nil && !ok {
+		err = InvalidTokenError{}
+	} else if err == nil {
+		user, err = myStore.UpdateUser(ctx, user.UserId, func(user *User) error {
+			user.hashedPassword = hashString(newPassword)
+			user.passwordResetRequired = false
+			user.emailVerified = true
+			return nil
+		})
+		ret = user.info()
+	}
+	span.SetError(err)
+	return ret, err
+}
+
+// Creates a single-use email verification token valid for ttl. Creating a new token invalidates the user's previous tokens.
+// ok is false if the user was not found, is disabled or has already verified the email.
+func CreateEmailVerificationToken(ctx context.Context, userEmail string, ttl time.Duration) (token string, ok bool, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.CreateEmailVerificationToken", tracing.SpanKindInternal)
+	defer span.End()
+	token, ok, err = createUserToken(ctx, userEmail, emailVerificationPurpose, ttl, func(user User) bool {
+		return user.disabled || user.emailVerified
+	})
+	span.SetError(err)
+	return token, ok, err
+}
+
+// Consumes the email verification token and marks the user's email verified.
+func VerifyEmail(ctx context.Context, token string) (ret UserInfo, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.VerifyEmail", tracing.SpanKindInternal)
+	defer span.End()
+	user, ok, err := consumeToken(ctx, emailVerificationPurpose, token)
+	if err == nil && !ok {
+		err = InvalidTokenError{}
+	} else if err == nil {
+		user, err = myStore.UpdateUser(ctx, user.UserId, func(user *User) error {
+			user.emailVerified = true
+			return nil
+		})
+		ret = user.info()
+	}
+	span.SetError(err)
+	return ret, err
+}
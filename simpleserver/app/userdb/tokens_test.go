@@ -0,0 +1,108 @@
+package userdb
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"testing"
+	"time"
+)
+
+func TestPasswordResetToken(t *testing.T) {
+	util.LogEnter()
+	ctx := context.Background()
+	email := "reset.token.test@foo.com"
+	AddUser(ctx, email, "Reset", "Test", "OldPassword")
+	if _, ok, _ := CreatePasswordResetToken(ctx, "not.found@foo.com", time.Minute); ok {
+		t.Errorf("Token should not have been created for an unknown user")
+	}
+	oldToken, _, _ := CreatePasswordResetToken(ctx, email, time.Minute)
+	token, ok, err := CreatePasswordResetToken(ctx, email, time.Minute)
+	if !ok || err != nil || len(token) < 40 || token == oldToken {
+		t.Fatalf("Creating token failed: %s, %t, %v", token, ok, err)
+	}
+	if _, err = ResetPassword(ctx, oldToken, "NewPassword"); err == nil {
+		t.Errorf("New token should have invalidated the old token")
+	}
+	user, _, _ := GetUserByEmail(ctx, email)
+	RequirePasswordReset(ctx, user.UserId)
+	if user, err = ResetPassword(ctx, token, "NewPassword"); err != nil || user.PasswordResetRequired {
+		t.Errorf("Reset should have succeeded and cleared the reset flag, got: %v, %v", user, err)
+	}
+	if _, ok, _ := CheckCredentials(ctx, email, "NewPassword"); !ok {
+		t.Errorf("Password should have been changed")
+	}
+	if _, err = ResetPassword(ctx, token, "OtherPassword"); err == nil {
+		t.Errorf("Token should have been single-use")
+	} else if _, ok := err.(InvalidTokenError); !ok {
+		t.Errorf("Error should have been InvalidTokenError, got: %T", err)
+	}
+	expiredToken, _, _ := CreatePasswordResetToken(ctx, email, -time.Second)
+	if _, err = ResetPassword(ctx, expiredToken, "OtherPassword"); err == nil {
+		t.Errorf("Expired token should have been rejected")
+	}
+	disabledToken, _, _ := CreatePasswordResetToken(ctx, email, time.Minute)
+	SetDisabled(ctx, user.UserId, true)
+	if _, err = ResetPassword(ctx, disabledToken, "OtherPassword"); err == nil {
+		t.Errorf("Disabled user should not have been able to reset the password")
+	}
+	if _, ok, _ = CreatePasswordResetToken(ctx, email, time.Minute); ok {
+		t.Errorf("Token should not have been created for a disabled user")
+	}
+	util.LogExit()
+}
+
+func TestEmailVerificationToken(t *testing.T) {
+	util.LogEnter()
+	ctx := context.Background()
+	email := "verification.token.test@foo.com"
+	AddUser(ctx, email, "Verification", "Test", "VerificationPassword")
+	if user, _, _ := GetUserByEmail(ctx, email); user.EmailVerified {
+		t.Errorf("New user should not have been verified")
+	}
+	if _, ok, _ := CreateEmailVerificationToken(ctx, "kari.karttinen@foo.com", time.Minute); ok {
+		t.Errorf("Token should not have been created for a verified user")
+	}
+	token, ok, err := CreateEmailVerificationToken(ctx, email, time.Minute)
+	if !ok || err != nil {
+		t.Fatalf("Creating token failed: %t, %v", ok, err)
+	}
+	// The purposes are separate: a verification token cannot reset the password.
+	if _, err = ResetPassword(ctx, token, "NewPassword"); err == nil {
+		t.Errorf("Verification token should not have been accepted as a reset token")
+	}
+	user, err := VerifyEmail(ctx, token)
+	if err != nil || !user.EmailVerified {
+		t.Errorf("Verifying email failed: %v, %v", user, err)
+	}
+	if _, err = VerifyEmail(ctx, token); err == nil {
+		t.Errorf("Token should have been single-use")
+	}
+	if _, ok, _ = CreateEmailVerificationToken(ctx, email, time.Minute); ok {
+		t.Errorf("Token should not have been created after verification")
+	}
+	util.LogExit()
+}
+
+func TestGetUserTokensAndDeleteUser(t *testing.T) {
+	util.LogEnter()
+	ctx := context.Background()
+	email := "user.tokens.test@foo.com"
+	AddUser(ctx, email, "Tokens", "Test", "TokensPassword")
+	user, _, _ := GetUserByEmail(ctx, email)
+	CreateEmailVerificationToken(ctx, email, time.Hour)
+	resetToken, _, _ := CreatePasswordResetToken(ctx, email, time.Minute)
+	tokens, err := GetUserTokens(ctx, user.UserId)
+	if err != nil || len(tokens) != 2 || tokens[0].Purpose != string(passwordResetPurpose) || tokens[1].Purpose != string(emailVerificationPurpose) {
+		t.Errorf("User should have had a reset and a verification token ordered by expiration, got: %v", tokens)
+	}
+	if _, err := DeleteUser(ctx, user.UserId); err != nil {
+		t.Fatalf("Deleting user failed: %s", err.Error())
+	}
+	if tokens, err = GetUserTokens(ctx, user.UserId); err != nil || len(tokens) != 0 {
+		t.Errorf("Deleting user should have deleted the tokens, got: %v", tokens)
+	}
+	if _, err := ResetPassword(ctx, resetToken, "OtherPassword"); err == nil {
+		t.Errorf("Token of a deleted user should have been rejected")
+	}
+	util.LogExit()
+}
@@ -1,18 +1,46 @@
 package userdb
 
 import (
-	"errors"
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
 	"github.com/karimarttila/go/simpleserver/app/util"
 	"hash/fnv"
 	"strconv"
+	"strings"
 )
 
+// User roles. New users get RoleCustomer, admins are added with AddAdmin or SetRoles.
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
+// All valid roles.
+var Roles = []string{RoleCustomer, RoleAdmin}
+
 type User struct {
 	UserId         int
 	email          string
 	firstName      string
 	lastName       string
 	hashedPassword string
+	roles          []string
+	disabled       bool
+	// The user has to reset the password before logging in again.
+	passwordResetRequired bool
+	emailVerified         bool
+}
+
+// UserInfo is the public view of a user, i.e. everything but the password.
+type UserInfo struct {
+	UserId                int      `json:"user-id"`
+	Email                 string   `json:"email"`
+	FirstName             string   `json:"first-name"`
+	LastName              string   `json:"last-name"`
+	Roles                 []string `json:"roles"`
+	Disabled              bool     `json:"disabled"`
+	PasswordResetRequired bool     `json:"password-reset-required"`
+	EmailVerified         bool     `json:"email-verified"`
 }
 
 type AddUserResponse struct {
@@ -20,8 +48,29 @@ type AddUserResponse struct {
 	Email string
 }
 
-type UsersDb struct {
-	usersMap map[int]User
+// EmailExistsError is returned by AddUser if the email is already in use.
+type EmailExistsError struct {
+	Email string
+}
+
+func (e EmailExistsError) Error() string {
+	return "Email already exists: " + e.Email
+}
+
+// UserNotFoundError is returned by the functions modifying a user if there is no such user.
+type UserNotFoundError struct {
+	UserId int
+}
+
+func (e UserNotFoundError) Error() string {
+	return "User not found: " + strconv.Itoa(e.UserId)
+}
+
+// WrongPasswordError is returned by ChangePassword if the current password is not correct.
+type WrongPasswordError struct{}
+
+func (e WrongPasswordError) Error() string {
+	return "Current password is not correct"
 }
 
 func hashString(myStr string) string {
@@ -32,76 +81,194 @@ func hashString(myStr string) string {
 	return ret
 }
 
-// UsersDB singleton.
-var myUsersDB = initUsersDb()
+func (user User) info() UserInfo {
+	// NOTE: Copy the roles so that the caller cannot modify the user's roles.
+	roles := append([]string(nil), user.roles...)
+	return UserInfo{user.UserId, user.email, user.firstName, user.lastName, roles, user.disabled, user.passwordResetRequired, user.emailVerified}
+}
+
+// Emails are case-insensitive, i.e. Kari.Karttinen@foo.com is the same user as kari.karttinen@foo.com.
+// NOTE: Strictly speaking the local part of an email may be case-sensitive, but in practice no mail server treats it so.
+func emailKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 
-var nextId = createCounter()
+func AddUser(ctx context.Context, email string, firstName string, lastName string, password string) (ret AddUserResponse, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.AddUser", tracing.SpanKindInternal)
+	defer span.End()
+	// NOTE: The email is not verified yet, see CreateEmailVerificationToken.
+	_, err = myStore.AddUser(ctx, User{0, email, firstName, lastName, hashString(password), []string{RoleCustomer}, false, false, false})
+	if err == nil {
+		ret = AddUserResponse{"ok", email}
+	} else if _, exists := err.(EmailExistsError); exists {
+		util.LogWarn(err.Error())
+	}
+	span.SetError(err)
+	return ret, err
+}
 
-// NOTE: Counter and Go closure example (compare to equivalent Python closure, practically the same).
-func createCounter() func() int {
-	i := 3 // Initialize to test user count.
-	return func() int {
-		i++
-		return i
+// Adds an admin, e.g. the first admin of a new database. The email counts as verified, since the admin is added by the operator.
+func AddAdmin(ctx context.Context, email string, firstName string, lastName string, password string) (ret UserInfo, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.AddAdmin", tracing.SpanKindInternal)
+	defer span.End()
+	user, err := myStore.AddUser(ctx, User{0, email, firstName, lastName, hashString(password), []string{RoleAdmin}, false, false, true})
+	if err == nil {
+		ret = user.info()
 	}
+	span.SetError(err)
+	return ret, err
 }
 
-func initUsersDb() UsersDb {
-	util.LogEnter()
-	testUser1 := User{1, "kari.karttinen@foo.com", "Kari", "Karttinen", "2842551024"}
-	testUser2 := User{2, "timo.tillinen@foo.com", "Timo", "Tillinen", "3655654034"}
-	//testUser3 := User{3, "erkka.erkkila@foo.com", "Erkka", "Erkkila", "2077629983"}
-	// Used in testing manually.
-	testUser3 := User{3, "i", "Erkka", "Erkkila", "3960223172"} // password: "i"
-	userMap := make(map[int]User)
-	userMap[1] = testUser1
-	userMap[2] = testUser2
-	userMap[3] = testUser3
-	ret := UsersDb{userMap}
-	util.LogExit()
-	return ret
+// Returns the user if the email and the password are correct, ok is false if they are not.
+// NOTE: Checks only the email and the password, the caller has to check whether the account is disabled.
+// This way a disabled account is revealed only to someone who knows the password.
+func CheckCredentials(ctx context.Context, userEmail string, userPassword string) (ret UserInfo, ok bool, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.CheckCredentials", tracing.SpanKindInternal)
+	defer span.End()
+	user, ok, err := myStore.GetUserByEmail(ctx, userEmail)
+	if ok = err == nil && ok && user.hashedPassword == hashString(userPassword); ok {
+		ret = user.info()
+	}
+	span.SetAttribute("credentials.ok", ok)
+	span.SetError(err)
+	return ret, ok, err
 }
 
-func EmailAlreadyExists(givenEmail string) bool {
-	util.LogEnter()
-	ret := false
-	usersMap := myUsersDB.usersMap
-	for _, user := range usersMap {
-		if user.email == givenEmail {
-			ret = true
-			break
+// Returns the roles of the user, ok is false if the user was not found.
+func GetRoles(ctx context.Context, userEmail string) (roles []string, ok bool, err error) {
+	defer util.LogEnter().Exit()
+	user, ok, err := GetUserByEmail(ctx, userEmail)
+	return user.Roles, ok, err
+}
+
+// Tells whether the roles contain any of the wanted roles.
+func HasAnyRole(roles []string, wanted ...string) bool {
+	for _, role := range roles {
+		for _, w := range wanted {
+			if role == w {
+				return true
+			}
 		}
 	}
-	util.LogExit()
-	return ret
+	return false
 }
 
-func AddUser(email string, firstName string, lastName string, password string) (ret AddUserResponse, err error) {
-	util.LogEnter()
-	if EmailAlreadyExists(email) {
-		buf := "Email already exists: " + email
-		util.LogWarn(buf)
-		err = errors.New(buf)
-	} else {
-		id := nextId()
-		newUser := User{id, email, firstName, lastName, hashString(password)}
-		myUsersDB.usersMap[id] = newUser
-		ret = AddUserResponse{"ok", email}
+// Returns the users ordered by user id, starting from offset, at most limit users.
+// Also returns the total number of users for paging.
+func ListUsers(ctx context.Context, offset int, limit int) (users []UserInfo, total int, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.ListUsers", tracing.SpanKindInternal)
+	defer span.End()
+	stored, total, err := myStore.ListUsers(ctx, offset, limit)
+	users = []UserInfo{}
+	for _, user := range stored {
+		users = append(users, user.info())
+	}
+	span.SetError(err)
+	return users, total, err
+}
+
+// Returns the user, ok is false if the user was not found.
+func GetUserById(ctx context.Context, userId int) (ret UserInfo, ok bool, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.GetUserById", tracing.SpanKindInternal)
+	defer span.End()
+	user, ok, err := myStore.GetUserById(ctx, userId)
+	if ok = err == nil && ok; ok {
+		ret = user.info()
+	}
+	span.SetError(err)
+	return ret, ok, err
+}
+
+// Returns the user, ok is false if the user was not found.
+func GetUserByEmail(ctx context.Context, userEmail string) (ret UserInfo, ok bool, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.GetUserByEmail", tracing.SpanKindInternal)
+	defer span.End()
+	user, ok, err := myStore.GetUserByEmail(ctx, userEmail)
+	if ok = err == nil && ok; ok {
+		ret = user.info()
+	}
+	span.SetError(err)
+	return ret, ok, err
+}
+
+// Applies the modification to the user and returns the modified user.
+func updateUser(ctx context.Context, spanName string, userId int, modify func(user *User) error) (ret UserInfo, err error) {
+	_, span := tracing.StartSpan(ctx, spanName, tracing.SpanKindInternal)
+	defer span.End()
+	user, err := myStore.UpdateUser(ctx, userId, modify)
+	if err == nil {
+		ret = user.info()
 	}
-	util.LogExit()
+	span.SetError(err)
 	return ret, err
 }
 
-func CheckCredentials(userEmail string, userPassword string) bool {
-	util.LogEnter()
-	ret := false
-	usersMap := myUsersDB.usersMap
-	for _, user := range usersMap {
-		if user.email == userEmail && user.hashedPassword == hashString(userPassword) {
-			ret = true
-			break
+// Updates the user's own profile data.
+func UpdateProfile(ctx context.Context, userId int, firstName string, lastName string) (UserInfo, error) {
+	defer util.LogEnter().Exit()
+	return updateUser(ctx, "userdb.UpdateProfile", userId, func(user *User) error {
+		user.firstName = firstName
+		user.lastName = lastName
+		return nil
+	})
+}
+
+// Changes the password if the current password is correct. The caller validates the new password.
+// Changing the password also fulfills a forced password reset.
+func ChangePassword(ctx context.Context, userId int, currentPassword string, newPassword string) (UserInfo, error) {
+	defer util.LogEnter().Exit()
+	return updateUser(ctx, "userdb.ChangePassword", userId, func(user *User) error {
+		if user.hashedPassword != hashString(currentPassword) {
+			return WrongPasswordError{}
 		}
+		user.hashedPassword = hashString(newPassword)
+		user.passwordResetRequired = false
+		return nil
+	})
+}
+
+// Disables or enables the account. Disabled users cannot log in.
+func SetDisabled(ctx context.Context, userId int, disabled bool) (UserInfo, error) {
+	defer util.LogEnter().Exit()
+	return updateUser(ctx, "userdb.SetDisabled", userId, func(user *User) error {
+		user.disabled = disabled
+		return nil
+	})
+}
+
+// Forces the user to reset the password before logging in again.
+func RequirePasswordReset(ctx context.Context, userId int) (UserInfo, error) {
+	defer util.LogEnter().Exit()
+	return updateUser(ctx, "userdb.RequirePasswordReset", userId, func(user *User) error {
+		user.passwordResetRequired = true
+		return nil
+	})
+}
+
+// Replaces the roles of the user. The caller validates the roles.
+func SetRoles(ctx context.Context, userId int, roles []string) (UserInfo, error) {
+	defer util.LogEnter().Exit()
+	return updateUser(ctx, "userdb.SetRoles", userId, func(user *User) error {
+		user.roles = append([]string(nil), roles...)
+		return nil
+	})
+}
+
+// Deletes the user with the user's tokens and returns the deleted user.
+func DeleteUser(ctx context.Context, userId int) (ret UserInfo, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "userdb.DeleteUser", tracing.SpanKindInternal)
+	defer span.End()
+	user, err := myStore.DeleteUser(ctx, userId)
+	if err == nil {
+		ret = user.info()
 	}
-	util.LogExit()
-	return ret
+	span.SetError(err)
+	return ret, err
 }
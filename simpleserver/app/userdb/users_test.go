@@ -1,20 +1,24 @@
 package userdb
 
 import (
+	"context"
+	"errors"
 	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"log"
 	"strconv"
 	"testing"
 )
 
-func TestEmailAlreadyExists(t *testing.T) {
+func TestGetUserByEmail(t *testing.T) {
 	util.LogEnter()
 	testingEmail := "kari.karttinen@foo.com"
-	response := EmailAlreadyExists(testingEmail)
-	if !response {
-		t.Errorf("%s should have been found in the users DB", testingEmail)
+	_, response, err := GetUserByEmail(context.Background(), testingEmail)
+	if !response || err != nil {
+		t.Errorf("%s should have been found in the users DB, err: %v", testingEmail, err)
 	}
 	testingEmail = "not.found@foo.com"
-	response = EmailAlreadyExists(testingEmail)
+	_, response, _ = GetUserByEmail(context.Background(), testingEmail)
 	if response {
 		t.Errorf("%s should not have been found in the users DB", testingEmail)
 	}
@@ -23,11 +27,11 @@ func TestEmailAlreadyExists(t *testing.T) {
 
 func TestAddUser(t *testing.T) {
 	util.LogEnter()
-	response, err := AddUser("kari.karttinen@foo.com", "Kari", "Karttinen", "Kari")
+	response, err := AddUser(context.Background(), "kari.karttinen@foo.com", "Kari", "Karttinen", "Kari")
 	if err == nil {
 		t.Errorf("Adding user kari.karttinen@foo.com should have failed since it is in the user DB, response: %s", response)
 	}
-	response, err = AddUser("jamppa.jamppanen@foo.com", "Jamppa", "Jamppanen", "JampanSalasana")
+	response, err = AddUser(context.Background(), "jamppa.jamppanen@foo.com", "Jamppa", "Jamppanen", "JampanSalasana")
 	if err != nil {
 		t.Errorf("Adding user jamppa.jamppanen@foo.com should have succeeded, response: %s", response)
 	}
@@ -37,21 +41,259 @@ func TestAddUser(t *testing.T) {
 	util.LogExit()
 }
 
+func TestAddAdmin(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	if _, err := AddAdmin(ctx, "Admin@foo.com", "Second", "Admin", "SecondAdmin"); err == nil {
+		t.Errorf("Adding an admin with the email of a user should have failed")
+	}
+	admin, err := AddAdmin(ctx, "first.admin@foo.com", "First", "Admin", "FirstAdminPassword")
+	if err != nil || !HasAnyRole(admin.Roles, RoleAdmin) || HasAnyRole(admin.Roles, RoleCustomer) || !admin.EmailVerified {
+		t.Errorf("Adding the admin should have succeeded, got: %v, %v", admin, err)
+	}
+	if _, ok, _ := CheckCredentials(ctx, "first.admin@foo.com", "FirstAdminPassword"); !ok {
+		t.Errorf("Admin should have logged in with the password")
+	}
+}
+
 func TestCheckCredentials(t *testing.T) {
 	util.LogEnter()
-	response := CheckCredentials("kari.karttinen@foo.com", "Kari")
-	if !response {
-		t.Errorf("User kari.karttinen@foo.com should have succeeded since both email and password ok, response: %s", strconv.FormatBool(response))
+	user, response, err := CheckCredentials(context.Background(), "kari.karttinen@foo.com", "Kari")
+	if !response || err != nil || user.Email != "kari.karttinen@foo.com" {
+		t.Errorf("User kari.karttinen@foo.com should have succeeded since both email and password ok, response: %s, err: %v", strconv.FormatBool(response), err)
 	}
 	// Wrong password
-	response = CheckCredentials("kari.karttinen@foo.com", "WRONG-PASSWORD")
-	if response {
-		t.Errorf("User kari.karttinen@foo.com should have failed since wrong password response: %s", strconv.FormatBool(response))
+	user, response, err = CheckCredentials(context.Background(), "kari.karttinen@foo.com", "WRONG-PASSWORD")
+	if response || err != nil || user.UserId != 0 {
+		t.Errorf("User kari.karttinen@foo.com should have failed since wrong password response: %s, err: %v", strconv.FormatBool(response), err)
 	}
 	// Wrong email
-	response = CheckCredentials("WRONG.USERNAME@foo.com", "Kari")
-	if response {
-		t.Errorf("User kari.karttinen@foo.com should have failed since wrong email, response: %s", strconv.FormatBool(response))
+	_, response, err = CheckCredentials(context.Background(), "WRONG.USERNAME@foo.com", "Kari")
+	if response || err != nil {
+		t.Errorf("User kari.karttinen@foo.com should have failed since wrong email, response: %s, err: %v", strconv.FormatBool(response), err)
 	}
 	util.LogExit()
 }
+
+// A store whose lookups fail, e.g. because the database is down.
+type failingStore struct {
+	Store
+	err error
+}
+
+func (store failingStore) GetUserByEmail(ctx context.Context, email string) (User, bool, error) {
+	return User{}, false, store.err
+}
+
+func (store failingStore) GetUserById(ctx context.Context, userId int) (User, bool, error) {
+	return User{}, false, store.err
+}
+
+func (store failingStore) ListUsers(ctx context.Context, offset int, limit int) ([]User, int, error) {
+	return nil, 0, store.err
+}
+
+func TestStoreFailureIsReturned(t *testing.T) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	storeErr := errors.New("database is down")
+	previousStore := SetStore(failingStore{NewMemoryStore(), storeErr})
+	defer SetStore(previousStore)
+	if _, ok, err := CheckCredentials(ctx, "kari.karttinen@foo.com", "Kari"); ok || err != storeErr {
+		t.Errorf("CheckCredentials should have returned the store failure, got: %t, %v", ok, err)
+	}
+	if _, ok, err := GetUserByEmail(ctx, "kari.karttinen@foo.com"); ok || err != storeErr {
+		t.Errorf("GetUserByEmail should have returned the store failure, got: %t, %v", ok, err)
+	}
+	if _, ok, err := GetUserById(ctx, 1); ok || err != storeErr {
+		t.Errorf("GetUserById should have returned the store failure, got: %t, %v", ok, err)
+	}
+	if users, _, err := ListUsers(ctx, 0, 10); len(users) != 0 || err != storeErr {
+		t.Errorf("ListUsers should have returned the store failure, got: %v, %v", users, err)
+	}
+}
+
+func TestEmailCaseInsensitive(t *testing.T) {
+	util.LogEnter()
+	ctx := context.Background()
+	user, ok, _ := GetUserByEmail(ctx, " Kari.Karttinen@FOO.com ")
+	if !ok || user.Email != "kari.karttinen@foo.com" {
+		t.Errorf("Email lookup should have ignored case and whitespace, user: %v, ok: %t", user, ok)
+	}
+	if user, ok, _ := CheckCredentials(ctx, "KARI.KARTTINEN@foo.com", "Kari"); !ok || user.Email != "kari.karttinen@foo.com" {
+		t.Errorf("Credentials should have been checked case-insensitively")
+	}
+	if _, err := AddUser(ctx, "Timo.Tillinen@foo.com", "Timo", "Tillinen", "Timo"); err == nil {
+		t.Errorf("Adding Timo.Tillinen@foo.com should have failed since timo.tillinen@foo.com is in the users DB")
+	}
+	if _, err := AddUser(ctx, "Case.Test@foo.com", "Case", "Test", "CaseTestPassword"); err != nil {
+		t.Fatalf("Adding Case.Test@foo.com failed: %s", err.Error())
+	}
+	user, ok, _ = GetUserByEmail(ctx, "case.test@foo.com")
+	if !ok || user.Email != "Case.Test@foo.com" {
+		t.Errorf("User should have been found with the email as given in the signin, user: %v, ok: %t", user, ok)
+	}
+	if _, err := DeleteUser(ctx, user.UserId); err != nil {
+		t.Errorf("Deleting the user failed: %v", err)
+	}
+	if _, ok, _ = GetUserByEmail(ctx, "case.test@foo.com"); ok {
+		t.Errorf("Deleted user should have been removed from the email index")
+	}
+	util.LogExit()
+}
+
+func TestGetRoles(t *testing.T) {
+	util.LogEnter()
+	roles, ok, _ := GetRoles(context.Background(), "kari.karttinen@foo.com")
+	if !ok || len(roles) != 1 || roles[0] != RoleCustomer {
+		t.Errorf("kari.karttinen@foo.com should have been a customer, roles: %v, ok: %t", roles, ok)
+	}
+	roles, ok, _ = GetRoles(context.Background(), "admin@foo.com")
+	if !ok || !HasAnyRole(roles, RoleAdmin) {
+		t.Errorf("admin@foo.com should have been an admin, roles: %v, ok: %t", roles, ok)
+	}
+	if roles, ok, _ = GetRoles(context.Background(), "not.found@foo.com"); ok {
+		t.Errorf("not.found@foo.com should not have been found, roles: %v", roles)
+	}
+	if HasAnyRole([]string{RoleCustomer}, RoleAdmin) || HasAnyRole(nil, RoleCustomer) {
+		t.Errorf("HasAnyRole should have returned false")
+	}
+	util.LogExit()
+}
+
+func TestListUsers(t *testing.T) {
+	util.LogEnter()
+	users, total, err := ListUsers(context.Background(), 0, 2)
+	if err != nil || len(users) != 2 || total < 4 {
+		t.Errorf("Should have got 2 users of at least 4, got: %d of %d", len(users), total)
+	}
+	if users[0].UserId != 1 || users[1].UserId != 2 || users[0].Email != "kari.karttinen@foo.com" {
+		t.Errorf("Users should have been ordered by id, got: %v", users)
+	}
+	users, _, _ = ListUsers(context.Background(), 2, 2)
+	if len(users) != 2 || users[0].UserId != 3 {
+		t.Errorf("Second page should have started from user 3, got: %v", users)
+	}
+	if users, _, _ = ListUsers(context.Background(), total, 10); len(users) != 0 {
+		t.Errorf("Page after the last user should have been empty, got: %v", users)
+	}
+	util.LogExit()
+}
+
+func TestUserAdministration(t *testing.T) {
+	util.LogEnter()
+	ctx := context.Background()
+	email := "admin.test@foo.com"
+	if _, err := AddUser(ctx, email, "Admin", "Test", "AdminTestPassword"); err != nil {
+		t.Fatalf("Adding %s failed: %s", email, err.Error())
+	}
+	user, ok, _ := GetUserByEmail(ctx, email)
+	if !ok || user.Disabled || user.PasswordResetRequired || len(user.Roles) != 1 || user.Roles[0] != RoleCustomer {
+		t.Errorf("New user should have been an enabled customer, got: %v", user)
+	}
+	if byId, ok, _ := GetUserById(ctx, user.UserId); !ok || byId.Email != email {
+		t.Errorf("Getting user by id failed, got: %v", byId)
+	}
+	if user, _ = SetDisabled(ctx, user.UserId, true); !user.Disabled {
+		t.Errorf("User should have been disabled")
+	}
+	if user, _ = SetDisabled(ctx, user.UserId, false); user.Disabled {
+		t.Errorf("User should have been enabled")
+	}
+	if user, _ = RequirePasswordReset(ctx, user.UserId); !user.PasswordResetRequired {
+		t.Errorf("User should have been required to reset the password")
+	}
+	user, _ = SetRoles(ctx, user.UserId, []string{RoleCustomer, RoleAdmin})
+	user.Roles[0] = "modified"
+	if roles, _, _ := GetRoles(ctx, email); len(roles) != 2 || roles[0] != RoleCustomer || roles[1] != RoleAdmin {
+		t.Errorf("Roles should have been changed and not modifiable by the caller, got: %v", roles)
+	}
+	if _, err := DeleteUser(ctx, user.UserId); err != nil {
+		t.Errorf("Deleting user failed: %s", err.Error())
+	}
+	if _, ok, _ = GetUserByEmail(ctx, email); ok {
+		t.Errorf("%s should have been deleted", email)
+	}
+	if _, err := DeleteUser(ctx, user.UserId); err == nil {
+		t.Errorf("Deleting a deleted user should have failed")
+	}
+	if _, err := SetDisabled(ctx, 999999, true); err == nil {
+		t.Errorf("Disabling an unknown user should have failed")
+	} else if _, ok := err.(UserNotFoundError); !ok {
+		t.Errorf("Error should have been UserNotFoundError, got: %T", err)
+	}
+	util.LogExit()
+}
+
+func TestUpdateProfileAndChangePassword(t *testing.T) {
+	util.LogEnter()
+	ctx := context.Background()
+	email := "profile.test@foo.com"
+	AddUser(ctx, email, "Profile", "Test", "ProfilePassword")
+	user, _, _ := GetUserByEmail(ctx, email)
+	if user, _ = UpdateProfile(ctx, user.UserId, "Changed", "Name"); user.FirstName != "Changed" || user.LastName != "Name" {
+		t.Errorf("Profile should have been updated, got: %v", user)
+	}
+	if _, err := ChangePassword(ctx, user.UserId, "WRONG-PASSWORD", "NewPassword"); err == nil {
+		t.Errorf("Changing password with wrong current password should have failed")
+	} else if _, ok := err.(WrongPasswordError); !ok {
+		t.Errorf("Error should have been WrongPasswordError, got: %T", err)
+	}
+	if _, ok, _ := CheckCredentials(ctx, email, "ProfilePassword"); !ok {
+		t.Errorf("Failed change should not have changed the password")
+	}
+	RequirePasswordReset(ctx, user.UserId)
+	if user, err := ChangePassword(ctx, user.UserId, "ProfilePassword", "NewPassword"); err != nil || user.PasswordResetRequired {
+		t.Errorf("Changing password should have succeeded and cleared the reset flag, got: %v, %v", user, err)
+	}
+	if _, ok, _ := CheckCredentials(ctx, email, "NewPassword"); !ok {
+		t.Errorf("Password should have been changed")
+	}
+	if _, ok, _ := CheckCredentials(ctx, email, "ProfilePassword"); ok {
+		t.Errorf("Password should have been changed")
+	}
+	util.LogExit()
+}
+
+// Replaces the store with a memory store of count generated users for the benchmark.
+func useBenchmarkUsersDb(b *testing.B, count int) {
+	previousOutput := log.Writer()
+	// NOTE: Discard the log, otherwise we would benchmark writing the log.
+	log.SetOutput(ioutil.Discard)
+	previousStore := SetStore(NewMemoryStore())
+	for i := 0; i < count; i++ {
+		AddUser(context.Background(), "user"+strconv.Itoa(i)+"@foo.com", "Bench", "User", "BenchPassword")
+	}
+	b.Cleanup(func() {
+		SetStore(previousStore)
+		log.SetOutput(previousOutput)
+	})
+	b.ResetTimer()
+}
+
+func BenchmarkGetUserByEmail100k(b *testing.B) {
+	useBenchmarkUsersDb(b, 100000)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		email := "user" + strconv.Itoa(i%100000) + "@foo.com"
+		if _, ok, _ := GetUserByEmail(ctx, email); !ok {
+			b.Fatalf("%s not found", email)
+		}
+	}
+}
+
+func BenchmarkCheckCredentials100k(b *testing.B) {
+	useBenchmarkUsersDb(b, 100000)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		CheckCredentials(ctx, "user"+strconv.Itoa(i%100000)+"@foo.com", "BenchPassword")
+	}
+}
+
+func BenchmarkAddUser100k(b *testing.B) {
+	useBenchmarkUsersDb(b, 100000)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		AddUser(ctx, "new"+strconv.Itoa(i)+"@foo.com", "Bench", "User", "BenchPassword")
+	}
+}
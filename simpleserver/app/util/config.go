@@ -7,6 +7,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -62,3 +63,21 @@ func getFileName() string {
 	fmt.Println("simpleserver.util.config.go - getFileName - EXIT")
 	return filePath
 }
+
+// Returns the integer value of the property, defaultValue if the property is missing or not an integer.
+func (config Config) GetInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(config[key])
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Returns the boolean value of the property ("true" or "false"), defaultValue if the property is missing.
+func (config Config) GetBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(config[key])
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
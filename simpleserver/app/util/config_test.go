@@ -11,3 +11,14 @@ func TestMyConfig(t *testing.T) {
 	}
 	LogExit()
 }
+
+func TestConfigGetters(t *testing.T) {
+	defer LogEnter().Exit()
+	config := Config{"int": "42", "bad-int": "x", "bool": "true"}
+	if config.GetInt("int", 1) != 42 || config.GetInt("bad-int", 1) != 1 || config.GetInt("missing", 1) != 1 {
+		t.Error("GetInt returned wrong value")
+	}
+	if !config.GetBool("bool", false) || config.GetBool("missing", false) || !config.GetBool("int", true) {
+		t.Error("GetBool returned wrong value")
+	}
+}
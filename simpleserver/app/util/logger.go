@@ -2,18 +2,26 @@
 package util
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Logging configuration.
 // NOTE: In production code report caller (Report_caller) is expensive and should be turned off.
 // Provides two helper methods for logging function entry and exit.
+// NOTE: The level is checked before anything is formatted, so disabled levels
+// (e.g. LogEnter/LogExit when debug is turned off) cost practically nothing.
+
+// Set only if log_async=true.
+var myAsyncWriter *asyncWriter
 
 var myLogFileHandle = initLogger()
 
@@ -23,23 +31,103 @@ func initLogger() *os.File {
 	filename := MyConfig["log_file"]
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND,
 		0666)
+	var out io.Writer
 	if err == nil {
-		mw := io.MultiWriter(os.Stdout, file)
-		log.SetOutput(mw)
+		out = io.MultiWriter(os.Stdout, file)
 	} else {
 		log.Fatal("Failed to open log file " + filename + ", using just stdout, ERROR: " + err.Error())
-		log.SetOutput(os.Stdout)
+		out = os.Stdout
+	}
+	if MyConfig["log_async"] == "true" {
+		myAsyncWriter = newAsyncWriter(out, initAsyncBufferSize())
+		out = myAsyncWriter
 	}
+	log.SetOutput(out)
 	fmt.Println("simpleserver.util.logger - initLogger - EXIT")
 	return file
 }
 
+func initAsyncBufferSize() int {
+	size := 1024
+	sizeStr, ok := MyConfig["log_async_buffer_size"]
+	if ok {
+		value, err := strconv.Atoi(sizeStr)
+		if err != nil || value < 1 {
+			fmt.Println("simpleserver.util.logger.go - initAsyncBufferSize - ERROR: Invalid log_async_buffer_size: " + sizeStr)
+			os.Exit(500)
+		}
+		size = value
+	}
+	return size
+}
+
 func CloseLog() {
 	fmt.Println("simpleserver.util.logger - CloseLog - ENTER")
+	// NOTE: Flush the pending async entries before closing the file they are written to.
+	if myAsyncWriter != nil {
+		myAsyncWriter.Close()
+	}
 	myLogFileHandle.Close()
+	if myTraceWriter != nil {
+		myTraceWriter.close()
+	}
 	fmt.Println("simpleserver.util.logger - CloseLog - EXIT")
 }
 
+// asyncWriter hands log entries to a background goroutine which writes them
+// using a buffered writer. The caller blocks only if the entry channel is full.
+type asyncWriter struct {
+	mutex   sync.RWMutex
+	closed  bool
+	out     io.Writer
+	entries chan []byte
+	done    chan bool
+}
+
+func newAsyncWriter(out io.Writer, bufferSize int) *asyncWriter {
+	w := &asyncWriter{out: out, entries: make(chan []byte, bufferSize), done: make(chan bool)}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) Write(p []byte) (n int, err error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return w.out.Write(p)
+	}
+	// NOTE: The caller (log package) reuses p, so we have to take a copy.
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	w.entries <- entry
+	return len(p), nil
+}
+
+func (w *asyncWriter) run() {
+	buffered := bufio.NewWriter(w.out)
+	for entry := range w.entries {
+		buffered.Write(entry)
+		// Flush when there is nothing more to write right now, so the log never lags much behind.
+		if len(w.entries) == 0 {
+			buffered.Flush()
+		}
+	}
+	buffered.Flush()
+	w.done <- true
+}
+
+// Close writes all pending entries and stops the background goroutine.
+// Entries written after Close go directly to the underlying writer.
+func (w *asyncWriter) Close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.entries)
+		<-w.done
+	}
+}
+
 type SSLogLevel int
 
 const (
@@ -86,40 +174,105 @@ func initReportCaller() bool {
 	}
 }
 
+var levelNames = [...]string{
+	"TRACE",
+	"DEBUG",
+	"INFO",
+	"WARN",
+	"ERROR",
+	"FATAL"}
+
 // Provides string representation for log levels.
 func (level SSLogLevel) String() string {
-	levels := [...]string{
-		"TRACE",
-		"DEBUG",
-		"INFO",
-		"WARN",
-		"ERROR",
-		"FATAL"}
 	if level < SS_LOG_LEVEL_TRACE || level > SS_LOG_LEVEL_FATAL {
 		return "Unknown SS_LOG_LEVEL"
 	}
-	ret := levels[level]
-	return ret
+	return levelNames[level]
+}
+
+// Tells whether entries of the given level are logged at all.
+// Use it to guard expensive message building, e.g.:
+// if util.IsLogLevelEnabled(util.SS_LOG_LEVEL_TRACE) { util.LogTrace("data: " + expensiveDump()) }
+func IsLogLevelEnabled(level SSLogLevel) bool {
+	return level >= MyLogLevel
 }
 
+// Cache for caller lookups: program counter => function name.
+// NOTE: Resolving the function name is the expensive part of reporting the caller.
+// Program counters are stable for the lifetime of the process so the names can be cached.
+var callerInfos sync.Map
+
+type callerInfo struct {
+	name string
+	own  bool // A Simple Server function, not e.g. net/http or runtime.
+}
+
+func lookupCaller(pc uintptr) callerInfo {
+	if info, ok := callerInfos.Load(pc); ok {
+		return info.(callerInfo)
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	info := callerInfo{strings.Replace(frame.Function, modulePrefix, "", 1), strings.HasPrefix(frame.Function, modulePrefix)}
+	callerInfos.Store(pc, info)
+	return info
+}
+
+const modulePrefix = "github.com/karimarttila/go/simpleserver/"
+
+// Returns the name of the function skip frames above callerName.
+func callerName(skip int) string {
+	var pcs [1]uintptr
+	// NOTE: +2 skips runtime.Callers itself and callerName.
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return "unknown"
+	}
+	return lookupCaller(pcs[0]).name
+}
+
+// Buffers for formatting log entries.
+var entryBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
+// NOTE: Must be called directly by the public Log* functions:
+// the caller is looked up two frames above logIt.
 func logIt(msg string, level SSLogLevel) {
+	if level < MyLogLevel {
+		return
+	}
 	var caller string
-	var entry string
-	var timeStamp = fmt.Sprint(time.Now().UTC().Format("2006-01-02T15:04:05.999Z"))
-	if level >= MyLogLevel {
-		if MyReportCaller {
-			// NOTE: Skips just two stacks. I.e. if function A calls function B,
-			// and both log, then both log entries show just A as caller.
-			pc, _, _, _ := runtime.Caller(2)
-			fn := runtime.FuncForPC(pc)
-			caller = fn.Name()
-			caller = strings.Replace(caller, "github.com/karimarttila/go/simpleserver/", "", 1)
-			entry = fmt.Sprintf("[%s] - [%s] [%s] - %s", timeStamp, level, caller, msg)
-		} else {
-			entry = fmt.Sprintf("[%s] - [%s] - %s", timeStamp, level, msg)
-		}
-		log.Println(entry)
+	if MyReportCaller {
+		// NOTE: Skips just two stacks. I.e. if function A calls function B,
+		// and both log, then both log entries show just A as caller.
+		caller = callerName(2)
+	}
+	logItWithCaller(msg, level, caller)
+}
+
+func logItWithCaller(msg string, level SSLogLevel, caller string) {
+	if level < MyLogLevel {
+		return
+	}
+	bufPtr := entryBuffers.Get().(*[]byte)
+	entry := (*bufPtr)[:0]
+	entry = append(entry, '[')
+	entry = time.Now().UTC().AppendFormat(entry, "2006-01-02T15:04:05.999Z")
+	entry = append(entry, "] - ["...)
+	entry = append(entry, level.String()...)
+	entry = append(entry, ']')
+	if MyReportCaller && caller != "" {
+		entry = append(entry, " ["...)
+		entry = append(entry, caller...)
+		entry = append(entry, ']')
 	}
+	entry = append(entry, " - "...)
+	entry = append(entry, msg...)
+	log.Output(1, string(entry))
+	*bufPtr = entry
+	entryBuffers.Put(bufPtr)
 }
 
 // Log trace.
@@ -153,16 +306,30 @@ func LogFatal(msg string) {
 }
 
 // Log our custom function entry event.
-func LogEnter(msg ...string) {
-	buf := DEBUG_TYPE_ENTER
-	if len(msg) > 0 {
-		buf = buf + " - " + strings.Join(msg, " ")
+// Returns a Span: call its Exit to log the function exit with the elapsed time, e.g.:
+// defer util.LogEnter().Exit()
+func LogEnter(msg ...string) Span {
+	debug := SS_LOG_LEVEL_DEBUG >= MyLogLevel
+	if !debug && myTraceWriter == nil {
+		return Span{}
 	}
-	logIt(buf, SS_LOG_LEVEL_DEBUG)
+	span := startSpan(1)
+	if debug {
+		buf := DEBUG_TYPE_ENTER
+		if len(msg) > 0 {
+			buf = buf + " - " + strings.Join(msg, " ")
+		}
+		logItWithCaller(buf, SS_LOG_LEVEL_DEBUG, span.caller)
+	}
+	return span
 }
 
 // Log our custom function exit event.
+// NOTE: Prefer the Exit of the Span returned by LogEnter, it logs the elapsed time as well.
 func LogExit(msg ...string) {
+	if SS_LOG_LEVEL_DEBUG < MyLogLevel {
+		return
+	}
 	buf := DEBUG_TYPE_EXIT
 	if len(msg) > 0 {
 		buf = buf + " - " + strings.Join(msg, " ")
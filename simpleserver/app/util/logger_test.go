@@ -1,6 +1,11 @@
 package util
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
 	"testing"
 )
 
@@ -11,3 +16,56 @@ func TestLogger(t *testing.T) {
 	}
 	LogExit()
 }
+
+func TestCallerName(t *testing.T) {
+	LogEnter()
+	expected := "app/util.TestCallerName"
+	// Twice: the second lookup comes from the cache.
+	for i := 0; i < 2; i++ {
+		if name := callerName(0); name != expected {
+			t.Errorf("Wrong caller name, expected: %s, got: %s", expected, name)
+		}
+	}
+	LogExit()
+}
+
+func TestAsyncWriter(t *testing.T) {
+	LogEnter()
+	var out bytes.Buffer
+	writer := newAsyncWriter(&out, 2)
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(writer, "entry-%d\n", i)
+	}
+	writer.Close()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 10 || lines[0] != "entry-0" || lines[9] != "entry-9" {
+		t.Errorf("Async writer lost or reordered entries: %s", lines)
+	}
+	// After Close entries are written directly.
+	fmt.Fprint(writer, "entry-10\n")
+	if !strings.HasSuffix(out.String(), "entry-10\n") {
+		t.Error("Entry written after Close was lost")
+	}
+	LogExit()
+}
+
+func benchmarkLogEnterExit(b *testing.B, level SSLogLevel) {
+	log.SetOutput(ioutil.Discard)
+	originalLevel := MyLogLevel
+	defer func() { MyLogLevel = originalLevel }()
+	MyLogLevel = level
+	for i := 0; i < b.N; i++ {
+		span := LogEnter()
+		LogTrace("some trace message")
+		span.Exit()
+	}
+}
+
+// Debug is disabled: LogEnter/LogExit should cost almost nothing.
+func BenchmarkLogEnterExitDisabled(b *testing.B) {
+	benchmarkLogEnterExit(b, SS_LOG_LEVEL_INFO)
+}
+
+func BenchmarkLogEnterExitEnabled(b *testing.B) {
+	benchmarkLogEnterExit(b, SS_LOG_LEVEL_TRACE)
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Function timing.
+// LogEnter returns a Span, the idiom is:
+//
+//	defer util.LogEnter().Exit()
+//
+// Exit logs the elapsed time and (if report_caller=true) the nested depth of the function.
+// If trace_file is configured the spans are also written to that file in Chrome trace-event
+// JSON format: open the file in chrome://tracing (or https://ui.perfetto.dev) to see
+// e.g. where the /login time goes.
+
+// Span is a function call bracketed by LogEnter and Exit.
+type Span struct {
+	active bool // The zero-value Span (logging and tracing disabled) does nothing.
+	caller string
+	depth  int
+	start  time.Time
+}
+
+// NOTE: skip is the number of frames between startSpan and the function to be timed.
+func startSpan(skip int) Span {
+	span := Span{active: true, start: time.Now()}
+	if MyReportCaller || myTraceWriter != nil {
+		span.caller = callerName(skip + 1)
+		span.depth = stackDepth(skip + 1)
+	}
+	return span
+}
+
+// Logs the function exit with the elapsed time.
+func (span Span) Exit(msg ...string) {
+	if !span.active {
+		return
+	}
+	elapsed := time.Since(span.start)
+	if SS_LOG_LEVEL_DEBUG >= MyLogLevel {
+		buf := DEBUG_TYPE_EXIT
+		if len(msg) > 0 {
+			buf = buf + " - " + strings.Join(msg, " ")
+		}
+		buf = buf + " - elapsed: " + elapsed.String()
+		if span.depth > 0 {
+			buf = buf + ", depth: " + strconv.Itoa(span.depth)
+		}
+		logItWithCaller(buf, SS_LOG_LEVEL_DEBUG, span.caller)
+	}
+	if myTraceWriter != nil {
+		myTraceWriter.writeEvent(span, elapsed)
+	}
+}
+
+// Elapsed time since LogEnter.
+func (span Span) Elapsed() time.Duration {
+	if !span.active {
+		return 0
+	}
+	return time.Since(span.start)
+}
+
+// Counts our own functions in the call stack, i.e. how deep the function skip frames
+// above stackDepth is nested within Simple Server functions.
+func stackDepth(skip int) int {
+	var pcs [64]uintptr
+	count := runtime.Callers(skip+2, pcs[:])
+	depth := 0
+	for _, pc := range pcs[:count] {
+		if lookupCaller(pc).own {
+			depth++
+		}
+	}
+	return depth
+}
+
+// NOTE: Go deliberately does not expose goroutine ids. We need one just to put the
+// trace events of each goroutine in their own row in the trace viewer, so we parse
+// it from the stack trace header ("goroutine 123 [running]:"). Used only when tracing.
+func goroutineId() uint64 {
+	var buf [64]byte
+	count := runtime.Stack(buf[:], false)
+	fields := strings.Fields(string(buf[:count]))
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(fields[1], 10, 64)
+	return id
+}
+
+// A trace event in Chrome trace-event format. Ph "X" is a complete event, times in microseconds.
+type traceEvent struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat"`
+	Ph   string `json:"ph"`
+	Ts   int64  `json:"ts"`
+	Dur  int64  `json:"dur"`
+	Pid  int    `json:"pid"`
+	Tid  uint64 `json:"tid"`
+}
+
+type traceWriter struct {
+	mutex  sync.Mutex
+	file   *os.File
+	events int
+	pid    int
+}
+
+// Trace writer singleton, nil if trace_file is not configured.
+var myTraceWriter = initTraceWriter()
+
+func initTraceWriter() *traceWriter {
+	filename := MyConfig["trace_file"]
+	if filename == "" {
+		return nil
+	}
+	fmt.Println("simpleserver.util.span - initTraceWriter - ENTER")
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		fmt.Println("simpleserver.util.span.go - initTraceWriter - ERROR: Failed to open trace file " + filename + ": " + err.Error())
+		os.Exit(500)
+	}
+	// NOTE: The JSON array format does not require the closing bracket,
+	// so the file can be opened in the viewer even if the server was killed.
+	file.WriteString("[\n")
+	fmt.Println("simpleserver.util.span - initTraceWriter - EXIT")
+	return &traceWriter{file: file, pid: os.Getpid()}
+}
+
+func (w *traceWriter) writeEvent(span Span, elapsed time.Duration) {
+	event := traceEvent{
+		Name: span.caller,
+		Cat:  "simpleserver",
+		Ph:   "X",
+		Ts:   span.start.UnixNano() / int64(time.Microsecond),
+		Dur:  int64(elapsed / time.Microsecond),
+		Pid:  w.pid,
+		Tid:  goroutineId(),
+	}
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		LogError("Failed to marshal trace event: " + err.Error())
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return
+	}
+	if w.events > 0 {
+		w.file.WriteString(",\n")
+	}
+	w.file.Write(eventJson)
+	w.events++
+}
+
+func (w *traceWriter) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file != nil {
+		w.file.WriteString("\n]\n")
+		w.file.Close()
+		w.file = nil
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nestedSpan() Span {
+	defer LogEnter().Exit()
+	return LogEnter()
+}
+
+func TestSpan(t *testing.T) {
+	defer LogEnter().Exit()
+	outer := LogEnter()
+	if !outer.active {
+		t.Fatal("Span should be active since log level is trace")
+	}
+	if outer.caller != "app/util.TestSpan" {
+		t.Errorf("Wrong span caller, expected: app/util.TestSpan, got: %s", outer.caller)
+	}
+	inner := nestedSpan()
+	if inner.caller != "app/util.nestedSpan" {
+		t.Errorf("Wrong span caller, expected: app/util.nestedSpan, got: %s", inner.caller)
+	}
+	if inner.depth != outer.depth+1 {
+		t.Errorf("Nested span depth should be %d, got: %d", outer.depth+1, inner.depth)
+	}
+	time.Sleep(time.Millisecond)
+	if outer.Elapsed() < time.Millisecond {
+		t.Errorf("Elapsed time too short: %s", outer.Elapsed())
+	}
+	outer.Exit()
+	// Zero-value span does nothing.
+	var disabled Span
+	disabled.Exit()
+	if disabled.Elapsed() != 0 {
+		t.Error("Disabled span should not measure time")
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	defer LogEnter().Exit()
+	dir, err := ioutil.TempDir("", "simpleserver-trace")
+	if err != nil {
+		t.Fatalf("Couldn't create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	traceFile := filepath.Join(dir, "trace.json")
+	originalConfig := MyConfig["trace_file"]
+	MyConfig["trace_file"] = traceFile
+	myTraceWriter = initTraceWriter()
+	MyConfig["trace_file"] = originalConfig
+	nestedSpan().Exit()
+	LogEnter().Exit()
+	myTraceWriter.close()
+	myTraceWriter = nil
+	content, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatalf("Couldn't read trace file: %s", err.Error())
+	}
+	var events []traceEvent
+	err = json.Unmarshal(content, &events)
+	if err != nil {
+		t.Fatalf("Trace file is not valid JSON: %s, content: %s", err.Error(), content)
+	}
+	if len(events) != 3 {
+		t.Fatalf("There should be exactly 3 trace events, got: %d", len(events))
+	}
+	if events[0].Name != "app/util.nestedSpan" || events[0].Ph != "X" || events[0].Tid == 0 {
+		t.Errorf("Wrong trace event: %v", events[0])
+	}
+	if events[2].Name != "app/util.TestTraceWriter" {
+		t.Errorf("Wrong trace event name, expected: app/util.TestTraceWriter, got: %s", events[2].Name)
+	}
+}
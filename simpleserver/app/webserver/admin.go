@@ -0,0 +1,260 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Admin user management API. All routes require the admin role (see handleRequests):
+// GET    /admin/users?offset=0&limit=20  - list users ordered by id
+// GET    /admin/users?email=<email>       - get user by email
+// GET    /admin/users/<id>                - get user by id
+// POST   /admin/users/<id>/disable        - disable account and revoke its sessions
+// POST   /admin/users/<id>/enable         - enable account
+// POST   /admin/users/<id>/password-reset - force password reset and revoke sessions
+// PUT    /admin/users/<id>/roles          - change roles and revoke sessions, body: {"roles": ["admin"]}
+// DELETE /admin/users/<id>                - delete user and revoke sessions
+// NOTE: Sessions are revoked also when roles change, since the tokens carry the old roles.
+
+const defaultUsersPageSize = 20
+const maxUsersPageSize = 100
+
+type UserListResponse struct {
+	Ret    string            `json:"ret"`
+	Total  int               `json:"total"`
+	Offset int               `json:"offset"`
+	Limit  int               `json:"limit"`
+	Users  []userdb.UserInfo `json:"users"`
+}
+
+type UserResponse struct {
+	Ret             string          `json:"ret"`
+	User            userdb.UserInfo `json:"user"`
+	RevokedSessions int             `json:"revoked-sessions,omitempty"`
+}
+
+type RolesData struct {
+	Roles []string `json:"roles"`
+}
+
+// Parses an optional non-negative integer query parameter.
+func queryInt(request *http.Request, name string, defaultValue int, fieldErrors FieldErrors) int {
+	value := request.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue
+	}
+	ret, err := strconv.Atoi(value)
+	if err != nil || ret < 0 {
+		fieldErrors.add(name, "must be a non-negative integer")
+	}
+	return ret
+}
+
+func handleAdminUsers(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var response interface{}
+	var errorResponse ErrorResponse
+	// like: /admin/users, /admin/users/5, /admin/users/5/disable
+	path := strings.Trim(request.URL.Path[len("/admin/users"):], "/")
+	parts := strings.Split(path, "/")
+	if path == "" {
+		if request.Method != "GET" {
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		} else if email := request.URL.Query().Get("email"); email != "" {
+			response, errorResponse = getAdminUserByEmail(request, email)
+		} else {
+			response, errorResponse = listAdminUsers(request)
+		}
+	} else if len(parts) > 2 {
+		errorResponse = createErrorResponse(NOT_FOUND, "Not found: "+request.URL.Path)
+	} else if userId, err := strconv.Atoi(parts[0]); err != nil {
+		errorResponse = createErrorResponse(VALIDATION_FAILED, "User id was not an integer")
+	} else {
+		action := ""
+		if len(parts) == 2 {
+			action = parts[1]
+		}
+		switch request.Method + " " + action {
+		case "GET ":
+			response, errorResponse = getAdminUser(request, userId)
+		case "DELETE ":
+			response, errorResponse = deleteAdminUser(request, userId)
+		case "POST disable":
+			response, errorResponse = setAdminUserDisabled(request, userId, true)
+		case "POST enable":
+			response, errorResponse = setAdminUserDisabled(request, userId, false)
+		case "POST password-reset":
+			response, errorResponse = requireAdminUserPasswordReset(request, userId)
+		case "PUT roles":
+			response, errorResponse = setAdminUserRoles(writer, request, userId)
+		default:
+			if action == "" || action == "disable" || action == "enable" || action == "password-reset" || action == "roles" {
+				errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+			} else {
+				errorResponse = createErrorResponse(NOT_FOUND, "Not found: "+request.URL.Path)
+			}
+		}
+	}
+	if !errorResponse.Flag {
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(response)
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, err.Error())
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+func listAdminUsers(request *http.Request) (response UserListResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	fieldErrors := FieldErrors{}
+	offset := queryInt(request, "offset", 0, fieldErrors)
+	limit := queryInt(request, "limit", defaultUsersPageSize, fieldErrors)
+	if _, ok := fieldErrors["limit"]; !ok && (limit < 1 || limit > maxUsersPageSize) {
+		fieldErrors.add("limit", "must be between 1 and "+strconv.Itoa(maxUsersPageSize))
+	}
+	if len(fieldErrors) > 0 {
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	} else {
+		users, total, err := userdb.ListUsers(request.Context(), offset, limit)
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, "Couldn't list users: "+err.Error())
+		} else {
+			response = UserListResponse{"ok", total, offset, limit, users}
+		}
+	}
+	return response, errorResponse
+}
+
+func getAdminUser(request *http.Request, userId int) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	user, ok, err := userdb.GetUserById(request.Context(), userId)
+	if err != nil {
+		errorResponse = createErrorResponse(INTERNAL, "Couldn't get user: "+err.Error())
+	} else if !ok {
+		errorResponse = createErrorResponse(NOT_FOUND, "User not found: "+strconv.Itoa(userId))
+	} else {
+		response = UserResponse{"ok", user, 0}
+	}
+	return response, errorResponse
+}
+
+func getAdminUserByEmail(request *http.Request, email string) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	user, ok, err := userdb.GetUserByEmail(request.Context(), email)
+	if err != nil {
+		errorResponse = createErrorResponse(INTERNAL, "Couldn't get user: "+err.Error())
+	} else if !ok {
+		errorResponse = createErrorResponse(NOT_FOUND, "User not found: "+email)
+	} else {
+		response = UserResponse{"ok", user, 0}
+	}
+	return response, errorResponse
+}
+
+// Admins may not lock themselves out, otherwise the last admin could leave the server without admins.
+func checkNotSelf(request *http.Request, userId int) (errorResponse ErrorResponse) {
+	tokenResponse, _ := tokenFromContext(request.Context())
+	user, ok, err := userdb.GetUserById(request.Context(), userId)
+	if err != nil {
+		errorResponse = createErrorResponse(INTERNAL, "Couldn't get user: "+err.Error())
+	} else if ok && user.Email == tokenResponse.Email {
+		auditEvent(request, tokenResponse.Email, "ADMIN_CHANGE_SELF", user.Email, audit.Denied, request.Method+" "+request.URL.Path)
+		errorResponse = createErrorResponse(FORBIDDEN, "Admins cannot disable, delete or change the roles of themselves")
+	}
+	return errorResponse
+}
+
+// Audits the admin action and maps the userdb error to an error response.
+func adminUserResult(request *http.Request, event string, user userdb.UserInfo, err error, revokeSessions bool) (response UserResponse, errorResponse ErrorResponse) {
+	tokenResponse, _ := tokenFromContext(request.Context())
+	if _, ok := err.(userdb.UserNotFoundError); ok {
+		auditEvent(request, tokenResponse.Email, event, "", audit.Failure, err.Error())
+		errorResponse = createErrorResponse(NOT_FOUND, err.Error())
+	} else if err != nil {
+		auditEvent(request, tokenResponse.Email, event, "", audit.Failure, err.Error())
+		errorResponse = createErrorResponse(INTERNAL, err.Error())
+	} else {
+		response = UserResponse{"ok", user, 0}
+		if revokeSessions {
+			response.RevokedSessions = RevokeSessions(user.Email, "")
+		}
+		auditEvent(request, tokenResponse.Email, event, user.Email, audit.Success, "revoked sessions: "+strconv.Itoa(response.RevokedSessions))
+	}
+	return response, errorResponse
+}
+
+func setAdminUserDisabled(request *http.Request, userId int, disabled bool) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	if disabled {
+		errorResponse = checkNotSelf(request, userId)
+	}
+	if !errorResponse.Flag {
+		user, err := userdb.SetDisabled(request.Context(), userId, disabled)
+		if disabled {
+			response, errorResponse = adminUserResult(request, "ADMIN_DISABLE_USER", user, err, true)
+		} else {
+			response, errorResponse = adminUserResult(request, "ADMIN_ENABLE_USER", user, err, false)
+		}
+	}
+	return response, errorResponse
+}
+
+func requireAdminUserPasswordReset(request *http.Request, userId int) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	user, err := userdb.RequirePasswordReset(request.Context(), userId)
+	return adminUserResult(request, "ADMIN_REQUIRE_PASSWORD_RESET", user, err, true)
+}
+
+func setAdminUserRoles(writer http.ResponseWriter, request *http.Request, userId int) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	var rolesData RolesData
+	fieldErrors, errorResponse := bindJson(writer, request, &rolesData)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	if len(rolesData.Roles) == 0 {
+		fieldErrors.add("roles", "at least one role is required")
+	}
+	seen := make(map[string]bool)
+	for _, role := range rolesData.Roles {
+		if !userdb.HasAnyRole([]string{role}, userdb.Roles...) {
+			fieldErrors.add("roles", "unknown role: "+role+", valid roles: "+strings.Join(userdb.Roles, ", "))
+		} else if seen[role] {
+			fieldErrors.add("roles", "duplicate role: "+role)
+		}
+		seen[role] = true
+	}
+	if len(fieldErrors) > 0 {
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	} else {
+		errorResponse = checkNotSelf(request, userId)
+	}
+	if !errorResponse.Flag {
+		user, err := userdb.SetRoles(request.Context(), userId, rolesData.Roles)
+		response, errorResponse = adminUserResult(request, "ADMIN_SET_ROLES", user, err, true)
+	}
+	return response, errorResponse
+}
+
+func deleteAdminUser(request *http.Request, userId int) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	errorResponse = checkNotSelf(request, userId)
+	if !errorResponse.Flag {
+		user, err := userdb.DeleteUser(request.Context(), userId)
+		response, errorResponse = adminUserResult(request, "ADMIN_DELETE_USER", user, err, true)
+	}
+	return response, errorResponse
+}
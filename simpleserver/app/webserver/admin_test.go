@@ -0,0 +1,234 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Logs in with a throttle of its own, so that the tests do not throttle each other.
+func loginTestToken(t *testing.T, email string, password string) string {
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	recorder := postTestLogin(email, password)
+	var loginResponse LoginResponse
+	json.NewDecoder(recorder.Body).Decode(&loginResponse)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Login of %s failed: %d", email, recorder.Code)
+	}
+	return loginResponse.JsonWebToken
+}
+
+// Calls the admin API through the authorization middleware like handleRequests does.
+func doAdminRequest(token string, method string, path string, body string) (recorder *httptest.ResponseRecorder, responseMap map[string]interface{}) {
+	return doAuthorizedRequest(authorized(handleAdminUsers, adminRole...), token, method, path, body)
+}
+
+func addAdminTestUser(t *testing.T, email string) (userId int) {
+	if _, err := userdb.AddUser(context.Background(), email, "Admin", "Test", "AdminTestPassword"); err != nil {
+		t.Fatalf("Adding test user %s failed: %s", email, err.Error())
+	}
+	user, _, _ := userdb.GetUserByEmail(context.Background(), email)
+	return user.UserId
+}
+
+func userPath(userId int, action string) string {
+	return "/admin/users/" + strconv.Itoa(userId) + action
+}
+
+func TestAdminRequiresAdminRole(t *testing.T) {
+	defer util.LogEnter().Exit()
+	customerToken := loginTestToken(t, "kari.karttinen@foo.com", "Kari")
+	if recorder, _ := doAdminRequest(customerToken, "GET", "/admin/users", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Customer should have got 403, got: %d", recorder.Code)
+	}
+	if recorder, _ := doAdminRequest("", "GET", "/admin/users", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Request without token should have got 401, got: %d", recorder.Code)
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	defer util.LogEnter().Exit()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	recorder, responseMap := doAdminRequest(adminToken, "GET", "/admin/users?offset=1&limit=2", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Listing users failed: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	users, _ := responseMap["users"].([]interface{})
+	if len(users) != 2 || responseMap["offset"] != 1.0 || responseMap["limit"] != 2.0 || responseMap["total"].(float64) < 4 {
+		t.Errorf("Wrong page: %v", responseMap)
+	}
+	if first, _ := users[0].(map[string]interface{}); first["user-id"] != 2.0 || first["email"] != "timo.tillinen@foo.com" {
+		t.Errorf("Second page should have started from user 2, got: %v", first)
+	}
+	if strings.Contains(recorder.Body.String(), "assword\":\"") {
+		t.Errorf("Response should not contain passwords: %s", recorder.Body.String())
+	}
+	recorder, responseMap = doAdminRequest(adminToken, "GET", "/admin/users?offset=-1&limit=1000", "")
+	fields, _ := responseMap["fields"].(map[string]interface{})
+	if recorder.Code != http.StatusBadRequest || fields["offset"] == nil || fields["limit"] == nil {
+		t.Errorf("Invalid paging should have failed with both fields, got: %d, %v", recorder.Code, responseMap)
+	}
+}
+
+func TestAdminUserStoreErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	useLookupFailingUserStore(t)
+	for _, path := range []string{"/admin/users", "/admin/users/1", "/admin/users?email=kari.karttinen@foo.com"} {
+		if recorder, responseMap := doAdminRequest(adminToken, "GET", path, ""); recorder.Code != http.StatusInternalServerError || responseMap["code"] != string(INTERNAL) {
+			t.Errorf("%s should have failed with 500, got: %d, %v", path, recorder.Code, responseMap)
+		}
+	}
+	if recorder, responseMap := doAdminRequest(adminToken, "POST", userPath(1, "/disable"), ""); recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Disabling should have failed with 500, got: %d, %v", recorder.Code, responseMap)
+	}
+}
+
+func TestAdminGetUser(t *testing.T) {
+	defer util.LogEnter().Exit()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	recorder, responseMap := doAdminRequest(adminToken, "GET", "/admin/users/1", "")
+	user, _ := responseMap["user"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || user["email"] != "kari.karttinen@foo.com" || user["first-name"] != "Kari" {
+		t.Errorf("Getting user 1 failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doAdminRequest(adminToken, "GET", "/admin/users?email=timo.tillinen@foo.com", "")
+	user, _ = responseMap["user"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || user["user-id"] != 2.0 {
+		t.Errorf("Getting user by email failed: %d, %v", recorder.Code, responseMap)
+	}
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/admin/users/999999", http.StatusNotFound},
+		{"GET", "/admin/users?email=not.found@foo.com", http.StatusNotFound},
+		{"GET", "/admin/users/x", http.StatusBadRequest},
+		{"GET", "/admin/users/1/unknown", http.StatusNotFound},
+		{"GET", "/admin/users/1/roles/x", http.StatusNotFound},
+		{"POST", "/admin/users", http.StatusMethodNotAllowed},
+		{"POST", "/admin/users/1", http.StatusMethodNotAllowed},
+		{"GET", "/admin/users/1/disable", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		if recorder, _ := doAdminRequest(adminToken, test.method, test.path, ""); recorder.Code != test.status {
+			t.Errorf("%s %s should have returned %d, got: %d", test.method, test.path, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestAdminDisableAndEnableUser(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	email := "admin.disable.test@foo.com"
+	userId := addAdminTestUser(t, email)
+	userToken := loginTestToken(t, email, "AdminTestPassword")
+	recorder, responseMap := doAdminRequest(adminToken, "POST", userPath(userId, "/disable"), "")
+	user, _ := responseMap["user"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || user["disabled"] != true || responseMap["revoked-sessions"] != 1.0 {
+		t.Errorf("Disabling user failed: %d, %v", recorder.Code, responseMap)
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), userToken); err == nil {
+		t.Errorf("Disabled user's session should have been revoked")
+	}
+	recorder = postTestLogin(email, "AdminTestPassword")
+	json.NewDecoder(recorder.Body).Decode(&responseMap)
+	if recorder.Code != http.StatusForbidden || responseMap["code"] != string(ACCOUNT_DISABLED) {
+		t.Errorf("Disabled user should not have been able to log in, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doAdminRequest(adminToken, "POST", userPath(userId, "/enable"), ""); recorder.Code != http.StatusOK {
+		t.Errorf("Enabling user failed: %d", recorder.Code)
+	}
+	loginTestToken(t, email, "AdminTestPassword")
+	if recorder, _ = doAdminRequest(adminToken, "POST", "/admin/users/999999/disable", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Disabling unknown user should have returned 404, got: %d", recorder.Code)
+	}
+}
+
+func TestAdminForcePasswordReset(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	email := "admin.reset.test@foo.com"
+	userId := addAdminTestUser(t, email)
+	recorder, responseMap := doAdminRequest(adminToken, "POST", userPath(userId, "/password-reset"), "")
+	user, _ := responseMap["user"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || user["password-reset-required"] != true {
+		t.Errorf("Forcing password reset failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder = postTestLogin(email, "AdminTestPassword")
+	json.NewDecoder(recorder.Body).Decode(&responseMap)
+	if recorder.Code != http.StatusForbidden || responseMap["code"] != string(PASSWORD_RESET_REQUIRED) {
+		t.Errorf("Login should have required password reset, got: %d, %v", recorder.Code, responseMap)
+	}
+}
+
+func TestAdminSetRoles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	email := "admin.roles.test@foo.com"
+	userId := addAdminTestUser(t, email)
+	userToken := loginTestToken(t, email, "AdminTestPassword")
+	invalidBodies := []string{`{"roles":[]}`, `{"roles":["superuser"]}`, `{"roles":["admin","admin"]}`, `{"roles":["admin"],"x":1}`}
+	for _, body := range invalidBodies {
+		recorder, responseMap := doAdminRequest(adminToken, "PUT", userPath(userId, "/roles"), body)
+		if recorder.Code != http.StatusBadRequest || responseMap["code"] != string(VALIDATION_FAILED) {
+			t.Errorf("Roles %s should have been rejected, got: %d, %v", body, recorder.Code, responseMap)
+		}
+	}
+	recorder, responseMap := doAdminRequest(adminToken, "PUT", userPath(userId, "/roles"), `{"roles":["customer","admin"]}`)
+	if recorder.Code != http.StatusOK || responseMap["revoked-sessions"] != 1.0 {
+		t.Errorf("Setting roles failed: %d, %v", recorder.Code, responseMap)
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), userToken); err == nil {
+		t.Errorf("Token with the old roles should have been revoked")
+	}
+	// The new token has the admin role.
+	newToken := loginTestToken(t, email, "AdminTestPassword")
+	if recorder, _ = doAdminRequest(newToken, "GET", "/admin/users", ""); recorder.Code != http.StatusOK {
+		t.Errorf("New admin should have been able to list users, got: %d", recorder.Code)
+	}
+	// Admins cannot demote themselves.
+	if recorder, _ = doAdminRequest(newToken, "PUT", userPath(userId, "/roles"), `{"roles":["customer"]}`); recorder.Code != http.StatusForbidden {
+		t.Errorf("Admin should not have been able to demote themselves, got: %d", recorder.Code)
+	}
+}
+
+func TestAdminDeleteUser(t *testing.T) {
+	defer util.LogEnter().Exit()
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	email := "admin.delete.test@foo.com"
+	userId := addAdminTestUser(t, email)
+	userToken := loginTestToken(t, email, "AdminTestPassword")
+	recorder, responseMap := doAdminRequest(adminToken, "DELETE", userPath(userId, ""), "")
+	if recorder.Code != http.StatusOK || responseMap["revoked-sessions"] != 1.0 {
+		t.Errorf("Deleting user failed: %d, %v", recorder.Code, responseMap)
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), userToken); err == nil {
+		t.Errorf("Deleted user's session should have been revoked")
+	}
+	if recorder, _ = doAdminRequest(adminToken, "GET", userPath(userId, ""), ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Deleted user should not have been found, got: %d", recorder.Code)
+	}
+	if recorder, _ = doAdminRequest(adminToken, "DELETE", userPath(userId, ""), ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Deleting deleted user should have returned 404, got: %d", recorder.Code)
+	}
+	admin, _, _ := userdb.GetUserByEmail(context.Background(), "admin@foo.com")
+	if recorder, _ = doAdminRequest(adminToken, "DELETE", userPath(admin.UserId, ""), ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Admin should not have been able to delete themselves, got: %d", recorder.Code)
+	}
+}
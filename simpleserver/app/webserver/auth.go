@@ -0,0 +1,49 @@
+package webserver
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strings"
+)
+
+// Role requirements of the routes, see handleRequests.
+var anyRole = []string{userdb.RoleCustomer, userdb.RoleAdmin}
+var adminRole = []string{userdb.RoleAdmin}
+
+type tokenContextKey struct{}
+
+// Stores a token already validated by a middleware, so that the handlers do not have to validate it again.
+func contextWithToken(ctx context.Context, tokenResponse TokenResponse) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, tokenResponse)
+}
+
+func tokenFromContext(ctx context.Context) (tokenResponse TokenResponse, ok bool) {
+	tokenResponse, ok = ctx.Value(tokenContextKey{}).(TokenResponse)
+	return tokenResponse, ok
+}
+
+// Wraps the handler so that it is called only with a valid token having at least one of the roles.
+// An invalid token gets 401 and a valid token without the required role gets 403.
+func authorized(handler http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		defer util.LogEnter().Exit()
+		if request.Method == "OPTIONS" {
+			handler(writer, request)
+			return
+		}
+		tokenResponse, errorResponse := validateToken(request)
+		if !errorResponse.Flag && !userdb.HasAnyRole(tokenResponse.Roles, roles...) {
+			auditEvent(request, tokenResponse.Email, "FORBIDDEN", request.URL.Path, audit.Denied, "roles: "+strings.Join(tokenResponse.Roles, ","))
+			errorResponse = createErrorResponse(FORBIDDEN, "Access denied - required role: "+strings.Join(roles, " or "))
+		}
+		if errorResponse.Flag {
+			writeHeaders(writer)
+			writeError(writer, request, errorResponse)
+			return
+		}
+		handler(writer, request.WithContext(contextWithToken(request.Context(), tokenResponse)))
+	}
+}
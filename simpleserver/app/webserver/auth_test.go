@@ -0,0 +1,90 @@
+package webserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthorized(t *testing.T) {
+	defer util.LogEnter().Exit()
+	handler := authorized(func(writer http.ResponseWriter, request *http.Request) {
+		tokenResponse, ok := tokenFromContext(request.Context())
+		if !ok {
+			t.Errorf("Validated token should have been in the request context")
+		}
+		writer.Write([]byte(tokenResponse.Email))
+	}, adminRole...)
+	doRequest := func(email string, roles []string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/admin/test", nil)
+		if email != "" {
+			token, err := CreateJsonWebToken(email, roles)
+			if err != nil {
+				t.Errorf("Failed to get test token: %s", err.Error())
+			}
+			request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+	if recorder := doRequest("admin@foo.com", []string{userdb.RoleAdmin}); recorder.Code != http.StatusOK || recorder.Body.String() != "admin@foo.com" {
+		t.Errorf("Admin should have been authorized, got: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := doRequest("kari.karttinen@foo.com", []string{userdb.RoleCustomer}); recorder.Code != http.StatusForbidden {
+		t.Errorf("Customer should have got 403, got: %d", recorder.Code)
+	}
+	// Tokens without roles (e.g. created before roles were added) are not authorized.
+	if recorder := doRequest("kari.karttinen@foo.com", nil); recorder.Code != http.StatusForbidden {
+		t.Errorf("Token without roles should have got 403, got: %d", recorder.Code)
+	}
+	if recorder := doRequest("", nil); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Request without token should have got 401, got: %d", recorder.Code)
+	}
+}
+
+func TestLoginTokenRoles(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	for email, password := range map[string]string{"admin@foo.com": "Admin", "kari.karttinen@foo.com": "Kari"} {
+		recorder := postTestLogin(email, password)
+		var loginResponse LoginResponse
+		json.NewDecoder(recorder.Body).Decode(&loginResponse)
+		tokenResponse, err := ValidateJsonWebToken(context.Background(), loginResponse.JsonWebToken)
+		if err != nil {
+			t.Errorf("Login of %s should have returned a valid token, got: %d, %s", email, recorder.Code, err.Error())
+			continue
+		}
+		roles, _, _ := userdb.GetRoles(context.Background(), email)
+		if len(tokenResponse.Roles) != 1 || tokenResponse.Roles[0] != roles[0] {
+			t.Errorf("Token of %s should have had roles %v, got: %v", email, roles, tokenResponse.Roles)
+		}
+	}
+}
+
+// Calls the handler with the token and a JSON body, returns the response and the response body as a map.
+// The optional ifMatch is the version for the If-Match header, see the admin catalog API.
+func doAuthorizedRequest(handler http.HandlerFunc, token string, method string, path string, body string, ifMatch ...string) (recorder *httptest.ResponseRecorder, responseMap map[string]interface{}) {
+	request := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+	}
+	if len(ifMatch) > 0 && ifMatch[0] != "" {
+		request.Header.Set("If-Match", ifMatch[0])
+	}
+	recorder = httptest.NewRecorder()
+	handler(recorder, request)
+	json.Unmarshal(recorder.Body.Bytes(), &responseMap)
+	return recorder, responseMap
+}
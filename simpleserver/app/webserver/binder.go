@@ -0,0 +1,99 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Maximum request body size, configured in the properties file.
+var myMaxRequestBodyBytes = int64(util.MyConfig.GetInt("max_request_body_bytes", 64*1024))
+
+// Decodes the JSON request body into the entity (pointer to struct). Shared by all APIs which take a JSON body.
+// The request must have Content-Type application/json, the body must not exceed max_request_body_bytes
+// and must comprise exactly one JSON object.
+// Returns an ErrorResponse with Flag set if binding failed. Otherwise the fields in the object which are
+// not in the entity are returned as "unknown field" FieldErrors, so that the caller can report them
+// together with its own field validation errors.
+func bindJson(writer http.ResponseWriter, request *http.Request, entity interface{}) (fieldErrors FieldErrors, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return nil, createErrorResponse(UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json, got: "+request.Header.Get("Content-Type"))
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, myMaxRequestBodyBytes))
+	if err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			return nil, createErrorResponse(REQUEST_TOO_LARGE, "Request body is larger than "+strconv.FormatInt(myMaxRequestBodyBytes, 10)+" bytes")
+		}
+		return nil, createErrorResponse(VALIDATION_FAILED, "Reading request body failed: "+err.Error())
+	}
+	var rawFields map[string]json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	err = decoder.Decode(&rawFields)
+	if err == nil {
+		// NOTE: Decode reads just the first JSON value, anything after it but whitespace is an error.
+		// The reported offset is that of the first byte of the extra data.
+		end := decoder.InputOffset()
+		rest := body[end:]
+		if extra := bytes.TrimLeft(rest, " \t\r\n"); len(extra) > 0 {
+			offset := end + int64(len(rest)-len(extra))
+			return nil, createErrorResponse(VALIDATION_FAILED, "Request body must comprise exactly one JSON object, found more data at byte offset "+strconv.FormatInt(offset, 10))
+		}
+		err = json.Unmarshal(body, entity)
+	}
+	if err != nil {
+		return nil, createDecodingErrorResponse(err)
+	}
+	fieldErrors = FieldErrors{}
+	knownFields := jsonFieldNames(reflect.ValueOf(entity).Elem().Interface())
+	for name := range rawFields {
+		if !knownFields[name] {
+			fieldErrors.add(name, "unknown field")
+		}
+	}
+	return fieldErrors, errorResponse
+}
+
+// Reports JSON decoding errors with the byte offset where decoding failed.
+func createDecodingErrorResponse(err error) (errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	switch decodingError := err.(type) {
+	case *json.SyntaxError:
+		errorResponse = createErrorResponse(VALIDATION_FAILED, "Malformed JSON at byte offset "+strconv.FormatInt(decodingError.Offset, 10)+": "+decodingError.Error())
+	case *json.UnmarshalTypeError:
+		if decodingError.Field == "" {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "Request body must be a JSON object, got: "+decodingError.Value)
+		} else {
+			errorResponse = createValidationErrorResponse(FieldErrors{decodingError.Field: {"must be " + decodingError.Type.String() + ", got " + decodingError.Value +
+				" at byte offset " + strconv.FormatInt(decodingError.Offset, 10)}})
+		}
+	default:
+		if err == io.EOF {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "Request body is empty")
+		} else {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "Decoding request body failed: "+err.Error())
+		}
+	}
+	return errorResponse
+}
+
+// Returns the JSON field names of the struct, e.g. "first-name".
+func jsonFieldNames(entity interface{}) map[string]bool {
+	names := make(map[string]bool)
+	entityType := reflect.TypeOf(entity)
+	for i := 0; i < entityType.NumField(); i++ {
+		name := strings.Split(entityType.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
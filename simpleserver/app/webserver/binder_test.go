@@ -0,0 +1,80 @@
+package webserver
+
+import (
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBindJson(t *testing.T) {
+	defer util.LogEnter().Exit()
+	tooLarge := `{"email":"` + strings.Repeat("a", int(myMaxRequestBodyBytes)) + `"}`
+	tests := []struct {
+		contentType string
+		body        string
+		code        ErrorCode
+		msg         string
+	}{
+		{"application/json", `{"email":"kari@foo.com","password":"x"}`, "", ""},
+		{"application/json; charset=utf-8", `{"email":"kari@foo.com"}`, "", ""},
+		{"application/json", "{\"email\":\"kari@foo.com\"}\r\n", "", ""},
+		{"", `{"email":"kari@foo.com"}`, UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json"},
+		{"text/plain", `{"email":"kari@foo.com"}`, UNSUPPORTED_MEDIA_TYPE, "Content-Type must be application/json"},
+		{"application/json", tooLarge, REQUEST_TOO_LARGE, "Request body is larger than"},
+		{"application/json", ``, VALIDATION_FAILED, "Request body is empty"},
+		{"application/json", `{"email":"kari@foo.com",}`, VALIDATION_FAILED, "Malformed JSON at byte offset 25"},
+		{"application/json", `{"email":"kari@foo.com"} garbage`, VALIDATION_FAILED, "found more data at byte offset 25"},
+		{"application/json", "{\"email\":\"kari@foo.com\"}\r\n\t garbage", VALIDATION_FAILED, "found more data at byte offset 28"},
+		{"application/json", `{"email":"kari@foo.com"}{"email":"x"}`, VALIDATION_FAILED, "exactly one JSON object, found more data at byte offset 24"},
+		{"application/json", `["kari@foo.com"]`, VALIDATION_FAILED, "must be a JSON object"},
+		{"application/json", `{"email":42}`, VALIDATION_FAILED, "invalid fields: email"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("POST", "http://localhost/login", strings.NewReader(test.body))
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		recorder := httptest.NewRecorder()
+		var loginData LoginData
+		_, errorResponse := bindJson(recorder, request, &loginData)
+		if errorResponse.Code != test.code {
+			t.Errorf("Wrong error code for body %.40s: expected: %s, got: %s (%s)", test.body, test.code, errorResponse.Code, errorResponse.Msg)
+		}
+		if !strings.Contains(errorResponse.Msg, test.msg) {
+			t.Errorf("Wrong error message for body %.40s: expected: %s, got: %s", test.body, test.msg, errorResponse.Msg)
+		}
+		if test.code == "" && loginData.Email != "kari@foo.com" {
+			t.Errorf("Body was not bound: %s", test.body)
+		}
+	}
+}
+
+func TestBindJsonUnknownFields(t *testing.T) {
+	defer util.LogEnter().Exit()
+	request := httptest.NewRequest("POST", "http://localhost/login", strings.NewReader(`{"email":"kari@foo.com","admin":true,"role":"x"}`))
+	request.Header.Set("Content-Type", "application/json")
+	var loginData LoginData
+	fieldErrors, errorResponse := bindJson(httptest.NewRecorder(), request, &loginData)
+	if errorResponse.Flag {
+		t.Fatalf("Unknown fields should not fail binding: %s", errorResponse.Msg)
+	}
+	if len(fieldErrors) != 2 || fieldErrors["admin"][0] != "unknown field" || fieldErrors["role"][0] != "unknown field" {
+		t.Errorf("Unknown fields were not reported: %v", fieldErrors)
+	}
+	if loginData.Email != "kari@foo.com" {
+		t.Error("Known fields should have been bound")
+	}
+}
+
+func TestLoginUnsupportedMediaType(t *testing.T) {
+	defer util.LogEnter().Exit()
+	request := httptest.NewRequest("POST", "http://localhost/login", strings.NewReader(`{"email":"kari.karttinen@foo.com","password":"Kari"}`))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(postLogin).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("postLogin handler returned wrong status code: expected: %v actual: %v", http.StatusUnsupportedMediaType, recorder.Code)
+	}
+}
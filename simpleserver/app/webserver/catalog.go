@@ -0,0 +1,606 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/domaindb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Admin catalog API. All routes require the admin role (see handleRequests):
+// GET    /admin/product-groups             - list product groups with their versions
+// POST   /admin/product-groups             - add product group, body: {"name": "Music", "parent-id": 5, "attributes":
+//                                            [{"name": "artist", "type": "string", "required": true}]}, pg-id is
+//                                            optional, without parent-id the product group is at the top level, and
+//                                            without attributes it has the attributes of the books and movies
+// GET    /admin/product-groups/<pgId>      - get product group
+// PUT    /admin/product-groups/<pgId>      - rename product group, body: {"name": "Records"}, parent-id moves it
+//                                            (0 to the top level), the attributes can be changed only while
+//                                            the product group is empty
+// DELETE /admin/product-groups/<pgId>      - delete product group without products and product groups under it
+// GET    /admin/products/<pgId>            - list products of the product group with their versions
+// POST   /admin/products/<pgId>            - add product, body: {"title": "Kind of Blue", "price": "9.90",
+//                                            "attributes": {"artist": "Miles Davis"}}, p-id is optional
+// GET    /admin/products/<pgId>/<pId>      - get product
+// PUT    /admin/products/<pgId>/<pId>      - replace product
+// DELETE /admin/products/<pgId>/<pId>      - delete product
+// Optimistic concurrency: each product group and product has a version, also sent as the ETag header.
+// PUT and DELETE require the version the admin saw, in the If-Match header or the version field of the body,
+// and fail with VERSION_CONFLICT if somebody else has changed the product group or the product in between.
+
+// NOTE: The parent is left out for a top level product group, and a missing parent in the body of PUT keeps the parent.
+type ProductGroupData struct {
+	PgId       int             `json:"pg-id"`
+	ParentId   *int            `json:"parent-id,omitempty"`
+	Name       string          `json:"name"`
+	Attributes domaindb.Schema `json:"attributes"`
+	Version    string          `json:"version"`
+}
+
+// NOTE: The price is a string like in the TSV files and in the products API. The attribute values have the JSON type
+// of the attribute type: integers and years are numbers, booleans true or false, strings and decimals are strings,
+// so that a decimal keeps its text, e.g. 3.90. An empty optional attribute is left out, or null in the body.
+type ProductData struct {
+	PId        int                        `json:"p-id"`
+	PgId       int                        `json:"pg-id"`
+	Title      string                     `json:"title"`
+	Price      string                     `json:"price"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+	Version    string                     `json:"version"`
+}
+
+type ProductGroupListResponse struct {
+	Ret           string             `json:"ret"`
+	ProductGroups []ProductGroupData `json:"product-groups"`
+}
+
+type ProductGroupResponse struct {
+	Ret          string           `json:"ret"`
+	ProductGroup ProductGroupData `json:"product-group"`
+}
+
+type ProductListResponse struct {
+	Ret      string        `json:"ret"`
+	Products []ProductData `json:"products"`
+}
+
+type ProductResponse struct {
+	Ret     string      `json:"ret"`
+	Product ProductData `json:"product"`
+}
+
+func newProductGroupData(pgId int, parentId int, name string, schema domaindb.Schema) ProductGroupData {
+	data := ProductGroupData{pgId, nil, name, schema, domaindb.ProductGroupVersion(pgId, parentId, name, schema)}
+	if parentId != 0 {
+		data.ParentId = &parentId
+	}
+	return data
+}
+
+// Returns the parent of the product group, 0 for the top level.
+func (data ProductGroupData) parentId() int {
+	if data.ParentId == nil {
+		return 0
+	}
+	return *data.ParentId
+}
+
+func isNumberAttribute(attributeType domaindb.AttributeType) bool {
+	return attributeType == domaindb.IntegerAttribute || attributeType == domaindb.YearAttribute
+}
+
+// The JSON type of the values of the attribute type, see ProductData.
+func attributeJsonType(attributeType domaindb.AttributeType) string {
+	if isNumberAttribute(attributeType) {
+		return "a number"
+	} else if attributeType == domaindb.BooleanAttribute {
+		return "true or false"
+	}
+	return "a string"
+}
+
+func newProductData(product []string, schema domaindb.Schema) ProductData {
+	pId, _ := strconv.Atoi(product[0])
+	pgId, _ := strconv.Atoi(product[1])
+	data := ProductData{pId, pgId, product[2], product[3], make(map[string]json.RawMessage), domaindb.ProductVersion(product)}
+	for i, attribute := range schema {
+		value := product[len(domaindb.BaseProductFields)+i]
+		if value == "" {
+			continue
+		}
+		// NOTE: The store has checked the values, e.g. a year is digits, but a number has no leading zeros.
+		var raw json.RawMessage
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil && isNumberAttribute(attribute.Type) {
+			raw = json.RawMessage(strconv.FormatInt(number, 10))
+		} else if attribute.Type == domaindb.BooleanAttribute {
+			raw = json.RawMessage(value)
+		} else {
+			raw, _ = json.Marshal(value)
+		}
+		data.Attributes[attribute.Name] = raw
+	}
+	return data
+}
+
+// Returns the product of the data with the values of the attributes of the schema as text,
+// adding the attributes not in the schema and the values of a wrong JSON type to the field errors.
+func (data ProductData) product(schema domaindb.Schema, fieldErrors FieldErrors) []string {
+	pId := ""
+	if data.PId != 0 {
+		pId = strconv.Itoa(data.PId)
+	}
+	product := []string{pId, strconv.Itoa(data.PgId), data.Title, data.Price}
+	for _, attribute := range schema {
+		raw, value, ok := data.Attributes[attribute.Name], "", true
+		switch {
+		case raw == nil || string(raw) == "null":
+		case isNumberAttribute(attribute.Type):
+			var number json.Number
+			ok = raw[0] != '"' && json.Unmarshal(raw, &number) == nil
+			value = number.String()
+		case attribute.Type == domaindb.BooleanAttribute:
+			var flag bool
+			ok = json.Unmarshal(raw, &flag) == nil
+			value = strconv.FormatBool(flag)
+		default:
+			ok = json.Unmarshal(raw, &value) == nil
+			value = strings.TrimSpace(value)
+		}
+		if !ok {
+			fieldErrors.add("attributes."+attribute.Name, "must be "+attributeJsonType(attribute.Type))
+		}
+		product = append(product, value)
+	}
+	for name := range data.Attributes {
+		if !schema.Has(name) {
+			fieldErrors.add("attributes."+name, "is not an attribute of the product group")
+		}
+	}
+	return product
+}
+
+// Returns the version the client saw: the If-Match header, e.g. "3f1a..." or W/"3f1a...", or the version of the body.
+func requestVersion(request *http.Request, bodyVersion string) string {
+	if ifMatch := strings.TrimSpace(request.Header.Get("If-Match")); ifMatch != "" {
+		return strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	}
+	return bodyVersion
+}
+
+func setETag(writer http.ResponseWriter, version string) {
+	writer.Header().Set("ETag", `"`+version+`"`)
+}
+
+func handleAdminCatalog(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var response interface{}
+	var errorResponse ErrorResponse
+	// like: /admin/product-groups/1, /admin/products/1/2001
+	resource, path := "product-groups", strings.TrimPrefix(request.URL.Path, "/admin/product-groups")
+	if strings.HasPrefix(request.URL.Path, "/admin/products") {
+		resource, path = "products", strings.TrimPrefix(request.URL.Path, "/admin/products")
+	}
+	var ids []int
+	if path = strings.Trim(path, "/"); path != "" {
+		for _, part := range strings.Split(path, "/") {
+			id, err := strconv.Atoi(part)
+			if err != nil || id < 1 {
+				errorResponse = createErrorResponse(VALIDATION_FAILED, "Id was not a positive integer: "+part)
+				break
+			}
+			ids = append(ids, id)
+		}
+	}
+	editor, editable := myProductStore.(domaindb.CatalogEditor)
+	switch {
+	case errorResponse.Flag:
+		// The id was invalid.
+	case !editable:
+		errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "The catalog of this product store cannot be edited")
+	case resource == "product-groups" && len(ids) == 0:
+		switch request.Method {
+		case "GET":
+			response, errorResponse = listAdminProductGroups(request, editor)
+		case "POST":
+			response, errorResponse = addAdminProductGroup(writer, request, editor)
+		default:
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		}
+	case resource == "product-groups" && len(ids) == 1:
+		switch request.Method {
+		case "GET":
+			response, errorResponse = getAdminProductGroup(writer, request, editor, ids[0])
+		case "PUT":
+			response, errorResponse = updateAdminProductGroup(writer, request, editor, ids[0])
+		case "DELETE":
+			response, errorResponse = deleteAdminProductGroup(request, editor, ids[0])
+		default:
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		}
+	case resource == "products" && len(ids) == 1:
+		switch request.Method {
+		case "GET":
+			response, errorResponse = listAdminProducts(request, editor, ids[0])
+		case "POST":
+			response, errorResponse = addAdminProduct(writer, request, editor, ids[0])
+		default:
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		}
+	case resource == "products" && len(ids) == 2:
+		switch request.Method {
+		case "GET":
+			response, errorResponse = getAdminProduct(writer, request, editor, ids[0], ids[1])
+		case "PUT":
+			response, errorResponse = updateAdminProduct(writer, request, editor, ids[0], ids[1])
+		case "DELETE":
+			response, errorResponse = deleteAdminProduct(request, editor, ids[0], ids[1])
+		default:
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		}
+	default:
+		errorResponse = createErrorResponse(NOT_FOUND, "Not found: "+request.URL.Path)
+	}
+	if !errorResponse.Flag {
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(response)
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, err.Error())
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+// Audits the catalog change and maps the domaindb error to an error response.
+func catalogResult(request *http.Request, event string, target string, err error) (errorResponse ErrorResponse) {
+	tokenResponse, _ := tokenFromContext(request.Context())
+	switch err.(type) {
+	case nil:
+		auditEvent(request, tokenResponse.Email, event, target, audit.Success, "")
+		return errorResponse
+	case domaindb.ProductGroupNotFoundError, domaindb.ProductNotFoundError:
+		errorResponse = createErrorResponse(NOT_FOUND, err.Error())
+	case domaindb.AlreadyExistsError:
+		errorResponse = createErrorResponse(ALREADY_EXISTS, err.Error())
+	case domaindb.VersionConflictError:
+		errorResponse = createErrorResponse(VERSION_CONFLICT, err.Error())
+	case domaindb.ProductGroupNotEmptyError, domaindb.ProductGroupHasChildrenError:
+		errorResponse = createErrorResponse(PRODUCT_GROUP_NOT_EMPTY, err.Error())
+	case domaindb.InvalidParentError:
+		fieldErrors := FieldErrors{}
+		fieldErrors.add("parent-id", err.(domaindb.InvalidParentError).Problem)
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	case domaindb.InvalidProductError:
+		fieldErrors := FieldErrors{}
+		addProductFieldErrors(fieldErrors, err.(domaindb.InvalidProductError).Fields)
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	case domaindb.UnsupportedAttributesError, domaindb.UnsupportedCategoriesError:
+		errorResponse = createErrorResponse(VALIDATION_FAILED, err.Error())
+	default:
+		errorResponse = createErrorResponse(INTERNAL, err.Error())
+	}
+	auditEvent(request, tokenResponse.Email, event, target, audit.Failure, err.Error())
+	return errorResponse
+}
+
+func productGroupTarget(pgId int) string {
+	return "product-group " + strconv.Itoa(pgId)
+}
+
+func productTarget(pgId int, pId int) string {
+	return "product " + strconv.Itoa(pgId) + "/" + strconv.Itoa(pId)
+}
+
+// Adds the errors of the domaindb validation to the field errors.
+func addFieldErrors(fieldErrors FieldErrors, errors map[string][]string) {
+	for field, msgs := range errors {
+		for _, msg := range msgs {
+			fieldErrors.add(field, msg)
+		}
+	}
+}
+
+// Adds the errors of domaindb.ValidateProduct to the field errors, the attributes like "attributes.year".
+// NOTE: A field that already has errors, e.g. a value of a wrong JSON type, gets no more.
+func addProductFieldErrors(fieldErrors FieldErrors, errors map[string][]string) {
+	for field, msgs := range errors {
+		key := "attributes." + field
+		for _, baseField := range append(domaindb.BaseProductFields, "attributes") {
+			if field == baseField {
+				key = field
+			}
+		}
+		if len(fieldErrors[key]) > 0 {
+			continue
+		}
+		for _, msg := range msgs {
+			fieldErrors.add(key, msg)
+		}
+	}
+}
+
+func listAdminProductGroups(request *http.Request, editor domaindb.CatalogEditor) (response ProductGroupListResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	categories, err := editor.GetCategories(request.Context())
+	if err != nil {
+		return response, createErrorResponse(INTERNAL, "Couldn't get product groups: "+err.Error())
+	}
+	response = ProductGroupListResponse{"ok", []ProductGroupData{}}
+	for _, category := range categories.Categories {
+		schema, err := editor.GetSchema(request.Context(), category.PgId)
+		if err != nil {
+			return response, createErrorResponse(INTERNAL, "Couldn't get schema: "+err.Error())
+		} else if schema.Ret != "ok" {
+			// NOTE: Deleted in between.
+			continue
+		}
+		response.ProductGroups = append(response.ProductGroups,
+			newProductGroupData(category.PgId, category.ParentId, category.Name, schema.Attributes))
+	}
+	return response, errorResponse
+}
+
+// Returns the product group, NOT_FOUND error response if there is no such group.
+func findProductGroup(request *http.Request, editor domaindb.CatalogEditor, pgId int) (data ProductGroupData, errorResponse ErrorResponse) {
+	categories, err := editor.GetCategories(request.Context())
+	if err != nil {
+		return data, createErrorResponse(INTERNAL, "Couldn't get product groups: "+err.Error())
+	}
+	category, ok := categories.Find(pgId)
+	schema, errorResponse := findSchema(request, editor, pgId)
+	if errorResponse.Flag {
+		return data, errorResponse
+	} else if !ok {
+		return data, createErrorResponse(NOT_FOUND, domaindb.ProductGroupNotFoundError{PgId: pgId}.Error())
+	}
+	return newProductGroupData(pgId, category.ParentId, category.Name, schema), errorResponse
+}
+
+// Returns the schema of the product group, NOT_FOUND error response if there is no such group.
+func findSchema(request *http.Request, editor domaindb.CatalogEditor, pgId int) (schema domaindb.Schema, errorResponse ErrorResponse) {
+	productGroupSchema, err := editor.GetSchema(request.Context(), pgId)
+	if err != nil {
+		return nil, createErrorResponse(INTERNAL, "Couldn't get schema: "+err.Error())
+	} else if productGroupSchema.Ret != "ok" {
+		return nil, createErrorResponse(NOT_FOUND, domaindb.ProductGroupNotFoundError{PgId: pgId}.Error())
+	}
+	return productGroupSchema.Attributes, errorResponse
+}
+
+func getAdminProductGroup(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, pgId int) (response ProductGroupResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	data, errorResponse := findProductGroup(request, editor, pgId)
+	if !errorResponse.Flag {
+		setETag(writer, data.Version)
+		response = ProductGroupResponse{"ok", data}
+	}
+	return response, errorResponse
+}
+
+// Binds and validates the product group of the body. pgId is the id of the path, 0 if none.
+func bindProductGroup(writer http.ResponseWriter, request *http.Request, pgId int) (data ProductGroupData, errorResponse ErrorResponse) {
+	fieldErrors, errorResponse := bindJson(writer, request, &data)
+	if errorResponse.Flag {
+		return data, errorResponse
+	}
+	data.Name = strings.TrimSpace(data.Name)
+	for _, msg := range domaindb.ValidateProductGroupName(data.Name) {
+		fieldErrors.add("name", msg)
+	}
+	if data.PgId < 0 || (pgId != 0 && data.PgId != 0 && data.PgId != pgId) {
+		fieldErrors.add("pg-id", "must be a positive integer, the same as in the path if given")
+	}
+	if data.parentId() < 0 {
+		fieldErrors.add("parent-id", "must be the id of a product group, or 0 for the top level")
+	}
+	addFieldErrors(fieldErrors, domaindb.ValidateSchema(data.Attributes))
+	if len(fieldErrors) > 0 {
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	}
+	return data, errorResponse
+}
+
+func addAdminProductGroup(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor) (response ProductGroupResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	data, errorResponse := bindProductGroup(writer, request, 0)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	if data.Attributes == nil {
+		data.Attributes = domaindb.DefaultSchema
+	}
+	pgId, err := editor.AddProductGroup(request.Context(), data.PgId, data.parentId(), data.Name, data.Attributes)
+	if errorResponse = catalogResult(request, "ADMIN_ADD_PRODUCT_GROUP", productGroupTarget(pgId), err); !errorResponse.Flag {
+		response = ProductGroupResponse{"ok", newProductGroupData(pgId, data.parentId(), data.Name, data.Attributes)}
+		setETag(writer, response.ProductGroup.Version)
+	}
+	return response, errorResponse
+}
+
+func updateAdminProductGroup(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, pgId int) (response ProductGroupResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	data, errorResponse := bindProductGroup(writer, request, pgId)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	version := requestVersion(request, data.Version)
+	if version == "" {
+		return response, createErrorResponse(VERSION_REQUIRED, "The version of the product group is required in the If-Match header or the version field")
+	}
+	// NOTE: The version is checked with the schema and the parent, so the update fails if they have changed since.
+	// A missing product group is reported by the update.
+	current, _ := findProductGroup(request, editor, pgId)
+	schema, parentId := data.Attributes, current.parentId()
+	if schema == nil {
+		schema = current.Attributes
+	}
+	if data.ParentId != nil {
+		parentId = *data.ParentId
+	}
+	err := editor.UpdateProductGroup(request.Context(), pgId, parentId, data.Name, data.Attributes, version)
+	if errorResponse = catalogResult(request, "ADMIN_UPDATE_PRODUCT_GROUP", productGroupTarget(pgId), err); !errorResponse.Flag {
+		response = ProductGroupResponse{"ok", newProductGroupData(pgId, parentId, data.Name, schema)}
+		setETag(writer, response.ProductGroup.Version)
+	}
+	return response, errorResponse
+}
+
+func deleteAdminProductGroup(request *http.Request, editor domaindb.CatalogEditor, pgId int) (response ProductGroupResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	version := requestVersion(request, "")
+	if version == "" {
+		return response, createErrorResponse(VERSION_REQUIRED, "The version of the product group is required in the If-Match header")
+	}
+	data, errorResponse := findProductGroup(request, editor, pgId)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	err := editor.DeleteProductGroup(request.Context(), pgId, version)
+	if errorResponse = catalogResult(request, "ADMIN_DELETE_PRODUCT_GROUP", productGroupTarget(pgId), err); !errorResponse.Flag {
+		response = ProductGroupResponse{"ok", data}
+	}
+	return response, errorResponse
+}
+
+func listAdminProducts(request *http.Request, editor domaindb.CatalogEditor, pgId int) (response ProductListResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	schema, errorResponse := findSchema(request, editor, pgId)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	products, err := editor.GetProducts(request.Context(), pgId)
+	if err != nil {
+		return response, createErrorResponse(INTERNAL, "Couldn't get products: "+err.Error())
+	} else if products.Ret != "ok" {
+		return response, createErrorResponse(NOT_FOUND, domaindb.ProductGroupNotFoundError{PgId: pgId}.Error())
+	}
+	response = ProductListResponse{"ok", []ProductData{}}
+	// NOTE: GetProducts has just the ids, the title and the price, the versions need the whole products.
+	for _, listed := range products.ProductsList {
+		pId, _ := strconv.Atoi(listed[0])
+		product, err := editor.GetProduct(request.Context(), pgId, pId)
+		if err != nil {
+			return response, createErrorResponse(INTERNAL, "Couldn't get product: "+err.Error())
+		} else if len(product.Product) > 0 {
+			response.Products = append(response.Products, newProductData(product.Product, schema))
+		}
+	}
+	return response, errorResponse
+}
+
+// Returns the product, NOT_FOUND error response if there is no such product.
+func findProduct(request *http.Request, editor domaindb.CatalogEditor, pgId int, pId int) (data ProductData, errorResponse ErrorResponse) {
+	product, err := editor.GetProduct(request.Context(), pgId, pId)
+	if err != nil {
+		return data, createErrorResponse(INTERNAL, "Couldn't get product: "+err.Error())
+	} else if len(product.Product) == 0 {
+		return data, createErrorResponse(NOT_FOUND, domaindb.ProductNotFoundError{PgId: pgId, PId: pId}.Error())
+	}
+	schema, errorResponse := findSchema(request, editor, pgId)
+	if errorResponse.Flag {
+		return data, errorResponse
+	}
+	return newProductData(product.Product, schema), errorResponse
+}
+
+func getAdminProduct(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, pgId int, pId int) (response ProductResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	data, errorResponse := findProduct(request, editor, pgId, pId)
+	if !errorResponse.Flag {
+		setETag(writer, data.Version)
+		response = ProductResponse{"ok", data}
+	}
+	return response, errorResponse
+}
+
+// Binds and validates the product of the body with the schema of its product group, returns the product as text.
+// The ids of the path win, the ids of the body must be the same if given.
+func bindProduct(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, pgId int, pId int) (data ProductData, product []string, schema domaindb.Schema, errorResponse ErrorResponse) {
+	if schema, errorResponse = findSchema(request, editor, pgId); errorResponse.Flag {
+		return data, nil, nil, errorResponse
+	}
+	fieldErrors, errorResponse := bindJson(writer, request, &data)
+	if errorResponse.Flag {
+		return data, nil, nil, errorResponse
+	}
+	if data.PgId != 0 && data.PgId != pgId {
+		fieldErrors.add("pg-id", "must be the same as in the path if given")
+	}
+	if data.PId < 0 || (pId != 0 && data.PId != 0 && data.PId != pId) {
+		fieldErrors.add("p-id", "must be a positive integer, the same as in the path if given")
+	}
+	data.PgId = pgId
+	if pId != 0 {
+		data.PId = pId
+	}
+	data.Title, data.Price = strings.TrimSpace(data.Title), strings.TrimSpace(data.Price)
+	product = data.product(schema, fieldErrors)
+	addProductFieldErrors(fieldErrors, domaindb.ValidateProduct(product, schema))
+	if len(fieldErrors) > 0 {
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	}
+	return data, product, schema, errorResponse
+}
+
+func addAdminProduct(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, pgId int) (response ProductResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	data, product, schema, errorResponse := bindProduct(writer, request, editor, pgId, 0)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	product, err := editor.AddProduct(request.Context(), product)
+	target := productTarget(pgId, data.PId)
+	if err == nil {
+		data = newProductData(product, schema)
+		target = productTarget(pgId, data.PId)
+	}
+	if errorResponse = catalogResult(request, "ADMIN_ADD_PRODUCT", target, err); !errorResponse.Flag {
+		response = ProductResponse{"ok", data}
+		setETag(writer, data.Version)
+	}
+	return response, errorResponse
+}
+
+func updateAdminProduct(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, pgId int, pId int) (response ProductResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	data, product, schema, errorResponse := bindProduct(writer, request, editor, pgId, pId)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	version := requestVersion(request, data.Version)
+	if version == "" {
+		return response, createErrorResponse(VERSION_REQUIRED, "The version of the product is required in the If-Match header or the version field")
+	}
+	err := editor.UpdateProduct(request.Context(), product, version)
+	if errorResponse = catalogResult(request, "ADMIN_UPDATE_PRODUCT", productTarget(pgId, pId), err); !errorResponse.Flag {
+		response = ProductResponse{"ok", newProductData(product, schema)}
+		setETag(writer, response.Product.Version)
+	}
+	return response, errorResponse
+}
+
+func deleteAdminProduct(request *http.Request, editor domaindb.CatalogEditor, pgId int, pId int) (response ProductResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	version := requestVersion(request, "")
+	if version == "" {
+		return response, createErrorResponse(VERSION_REQUIRED, "The version of the product is required in the If-Match header")
+	}
+	data, errorResponse := findProduct(request, editor, pgId, pId)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	err := editor.DeleteProduct(request.Context(), pgId, pId, version)
+	if errorResponse = catalogResult(request, "ADMIN_DELETE_PRODUCT", productTarget(pgId, pId), err); !errorResponse.Flag {
+		response = ProductResponse{"ok", data}
+	}
+	return response, errorResponse
+}
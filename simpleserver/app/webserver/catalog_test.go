@@ -0,0 +1,300 @@
+package webserver
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/domaindb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Serves a catalog of its own for the test, since the TSV store writes the changes to its files.
+func useTestCatalog(t *testing.T) (dir string) {
+	dir = t.TempDir()
+	files := map[string]string{
+		"product-groups.csv": "1\tBooks\n2\tMovies\n",
+		"pg-1-products.csv":  "2001\t1\tKalevala\t3.95\tElias Lönnrot\t1835\tFinland\tFinnish\n",
+		"pg-2-products.csv":  "",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Writing %s failed: %s", name, err.Error())
+		}
+	}
+	store, err := domaindb.NewTsvStore(dir)
+	if err != nil {
+		t.Fatalf("Loading products failed: %s", err.Error())
+	}
+	previous := myProductStore
+	myProductStore = store
+	t.Cleanup(func() { myProductStore = previous })
+	return dir
+}
+
+// Calls the admin catalog API through the authorization middleware like handleRequests does.
+func doCatalogRequest(token string, method string, path string, body string, ifMatch string) (recorder *httptest.ResponseRecorder, responseMap map[string]interface{}) {
+	return doAuthorizedRequest(authorized(handleAdminCatalog, adminRole...), token, method, path, body, ifMatch)
+}
+
+func TestAdminCatalogRequiresAdminRole(t *testing.T) {
+	defer util.LogEnter().Exit()
+	useTestCatalog(t)
+	customerToken := loginTestToken(t, "kari.karttinen@foo.com", "Kari")
+	if recorder, _ := doCatalogRequest(customerToken, "POST", "/admin/product-groups", `{"name": "Music"}`, ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("Customer should have got 403, got: %d", recorder.Code)
+	}
+}
+
+func TestAdminProductGroups(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := useTestCatalog(t)
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	recorder, responseMap := doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"name": " Music "}`, "")
+	group, _ := responseMap["product-group"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || group["pg-id"] != 3.0 || group["name"] != "Music" {
+		t.Fatalf("Adding the product group failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"pg-id": 1, "name": "Books"}`, "")
+	if recorder.Code != http.StatusConflict || responseMap["code"] != string(ALREADY_EXISTS) {
+		t.Errorf("Adding an existing product group should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"name": "Mu\tsic"}`, "")
+	if fields, _ := responseMap["fields"].(map[string]interface{}); recorder.Code != http.StatusBadRequest || fields["name"] == nil {
+		t.Errorf("Invalid name should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/product-groups/3", "", "")
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag != `"`+group["version"].(string)+`"` {
+		t.Errorf("Product group should have had its version as the ETag, got: %d, %s", recorder.Code, etag)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/3", `{"name": "Records"}`, "")
+	if recorder.Code != http.StatusPreconditionRequired || responseMap["code"] != string(VERSION_REQUIRED) {
+		t.Errorf("Update without a version should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/3", `{"name": "Records"}`, etag); recorder.Code != http.StatusOK {
+		t.Errorf("Update with the ETag failed: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	// The other admin still has the old version.
+	recorder, responseMap = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/3", `{"name": "Vinyls", "version": "`+group["version"].(string)+`"}`, "")
+	if recorder.Code != http.StatusPreconditionFailed || responseMap["code"] != string(VERSION_CONFLICT) {
+		t.Errorf("Update with a stale version should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "GET", "/admin/product-groups", "", "")
+	if groups, _ := responseMap["product-groups"].([]interface{}); recorder.Code != http.StatusOK || len(groups) != 3 ||
+		groups[2].(map[string]interface{})["name"] != "Records" {
+		t.Errorf("Wrong product groups: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "DELETE", "/admin/product-groups/1", "", `"`+domaindb.ProductGroupVersion(1, 0, "Books", domaindb.DefaultSchema)+`"`)
+	if recorder.Code != http.StatusConflict || responseMap["code"] != string(PRODUCT_GROUP_NOT_EMPTY) {
+		t.Errorf("Deleting a product group with products should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doCatalogRequest(adminToken, "DELETE", "/admin/product-groups/3", "", `W/"`+domaindb.ProductGroupVersion(3, 0, "Records", domaindb.DefaultSchema)+`"`); recorder.Code != http.StatusOK {
+		t.Errorf("Deleting the product group failed: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	if groups, _ := ioutil.ReadFile(filepath.Join(dir, "product-groups.csv")); string(groups) != "1\tBooks\n2\tMovies\n" {
+		t.Errorf("Changes should have been saved to the file, got: %q", groups)
+	}
+}
+
+func TestAdminProducts(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := useTestCatalog(t)
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	body := `{"title": "Moby Dick", "price": "45.35", "attributes": {"author-or-director": "Herman Melville", "year": 1851, "country": "United States", "genre-or-language": "English"}}`
+	recorder, responseMap := doCatalogRequest(adminToken, "POST", "/admin/products/1", body, "")
+	product, _ := responseMap["product"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || product["p-id"] != 2002.0 || product["pg-id"] != 1.0 {
+		t.Fatalf("Adding the product failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/products/9", body, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Adding to a missing product group should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/products/1", `{"pg-id": 2, "title": "", "price": "free", "attributes": {"year": "MCMLXVIII", "isbn": "951-1-12345-6"}}`, "")
+	fields, _ := responseMap["fields"].(map[string]interface{})
+	for _, field := range []string{"pg-id", "title", "price", "attributes.author-or-director", "attributes.year", "attributes.country", "attributes.genre-or-language", "attributes.isbn"} {
+		if fields[field] == nil {
+			t.Errorf("Field %s should have been invalid, got: %d, %v", field, recorder.Code, responseMap)
+		}
+	}
+	recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/products/1/2002", "", "")
+	etag := recorder.Header().Get("ETag")
+	changed := strings.Replace(body, "45.35", "39.90", 1)
+	if recorder, _ = doCatalogRequest(adminToken, "PUT", "/admin/products/1/2002", changed, etag); recorder.Code != http.StatusOK {
+		t.Errorf("Update with the ETag failed: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder, _ = doCatalogRequest(adminToken, "PUT", "/admin/products/1/2002", changed, etag); recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("Update with a stale ETag should have failed, got: %d", recorder.Code)
+	}
+	// The change is visible in the products API and saved to the file.
+	if product, _ := myProductStore.GetProduct(context.Background(), 1, 2002); product.Product[3] != "39.90" {
+		t.Errorf("Product should have been updated, got: %v", product)
+	}
+	if products, _ := ioutil.ReadFile(filepath.Join(dir, "pg-1-products.csv")); !strings.Contains(string(products), "2002\t1\tMoby Dick\t39.90\t") {
+		t.Errorf("Product should have been saved to the file, got: %q", products)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "GET", "/admin/products/1", "", "")
+	if products, _ := responseMap["products"].([]interface{}); recorder.Code != http.StatusOK || len(products) != 2 {
+		t.Errorf("Product group should have had 2 products, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doCatalogRequest(adminToken, "DELETE", "/admin/products/1/2002", "", ""); recorder.Code != http.StatusPreconditionRequired {
+		t.Errorf("Delete without a version should have failed, got: %d", recorder.Code)
+	}
+	recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/products/1/2002", "", "")
+	if recorder, _ = doCatalogRequest(adminToken, "DELETE", "/admin/products/1/2002", "", recorder.Header().Get("ETag")); recorder.Code != http.StatusOK {
+		t.Errorf("Deleting the product failed: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/products/1/2002", "", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Deleted product should not have been found, got: %d", recorder.Code)
+	}
+}
+
+func TestAdminProductGroupAttributes(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := useTestCatalog(t)
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	attributes := `[{"name": "artist", "type": "string", "required": true}, {"name": "tracks", "type": "integer", "required": true}, ` +
+		`{"name": "vinyl", "type": "boolean", "required": false}]`
+	recorder, responseMap := doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"name": "Music", "attributes": `+attributes+`}`, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Adding the product group failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"name": "Games", "attributes": [{"name": "Title", "type": "text"}]}`, "")
+	if fields, _ := responseMap["fields"].(map[string]interface{}); recorder.Code != http.StatusBadRequest || fields["attributes[0]"] == nil {
+		t.Errorf("Invalid attributes should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	body := `{"title": "Kind of Blue", "price": "19.90", "attributes": {"artist": "Miles Davis", "tracks": 5}}`
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/products/3", body, "")
+	product, _ := responseMap["product"].(map[string]interface{})
+	if values, _ := product["attributes"].(map[string]interface{}); recorder.Code != http.StatusOK || values["tracks"] != 5.0 || values["vinyl"] != nil {
+		t.Fatalf("Adding the product failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/products/3", `{"title": "Blue Train", "price": "9.90", "attributes": {"artist": "John Coltrane", "tracks": "five", "vinyl": 1}}`, "")
+	fields, _ := responseMap["fields"].(map[string]interface{})
+	if recorder.Code != http.StatusBadRequest || fields["attributes.tracks"] == nil || fields["attributes.vinyl"] == nil {
+		t.Errorf("Values of a wrong type should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	if files, _ := ioutil.ReadFile(filepath.Join(dir, "pg-3-attributes.csv")); string(files) != "artist\tstring\ttrue\ntracks\tinteger\ttrue\nvinyl\tboolean\tfalse\n" {
+		t.Errorf("Attributes should have been saved to the file, got: %q", files)
+	}
+	// The schema of a product group with products can't be changed.
+	recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/product-groups/3", "", "")
+	etag := recorder.Header().Get("ETag")
+	recorder, responseMap = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/3", `{"name": "Music", "attributes": [{"name": "artist", "type": "string"}]}`, etag)
+	if recorder.Code != http.StatusConflict || responseMap["code"] != string(PRODUCT_GROUP_NOT_EMPTY) {
+		t.Errorf("Changing the attributes of a product group with products should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/3", `{"name": "Records"}`, etag); recorder.Code != http.StatusOK {
+		t.Errorf("Renaming the product group should have kept its attributes, got: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	recorder, responseMap = doAuthorizedRequest(authorized(getSchema, anyRole...), adminToken, "GET", "/schema/3", "")
+	if schema, _ := responseMap["attributes"].([]interface{}); recorder.Code != http.StatusOK || len(schema) != 3 {
+		t.Errorf("Wrong schema: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doAuthorizedRequest(authorized(getSchema, anyRole...), adminToken, "GET", "/schema/9", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Schema of a missing product group should not have been found, got: %d", recorder.Code)
+	}
+}
+
+func TestAdminProductGroupParents(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := useTestCatalog(t)
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	recorder, responseMap := doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"name": "Drama", "parent-id": 2}`, "")
+	group, _ := responseMap["product-group"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || group["pg-id"] != 3.0 || group["parent-id"] != 2.0 {
+		t.Fatalf("Adding the product group failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/product-groups", `{"name": "Crime", "parent-id": 9}`, "")
+	if fields, _ := responseMap["fields"].(map[string]interface{}); recorder.Code != http.StatusBadRequest || fields["parent-id"] == nil {
+		t.Errorf("Missing parent should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	body := `{"title": "Ikiru", "price": "14.90", "attributes": {"author-or-director": "Akira Kurosawa", "year": 1952, "country": "Japan", "genre-or-language": "Japanese"}}`
+	if recorder, responseMap = doCatalogRequest(adminToken, "POST", "/admin/products/3", body, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("Adding the product failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/product-groups/2", "", "")
+	etag := recorder.Header().Get("ETag")
+	recorder, responseMap = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/2", `{"name": "Movies", "parent-id": 3}`, etag)
+	if fields, _ := responseMap["fields"].(map[string]interface{}); recorder.Code != http.StatusBadRequest || fields["parent-id"] == nil {
+		t.Errorf("Moving a product group under itself should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	// Without parent-id the product group stays where it is.
+	recorder, _ = doCatalogRequest(adminToken, "GET", "/admin/product-groups/3", "", "")
+	recorder, responseMap = doCatalogRequest(adminToken, "PUT", "/admin/product-groups/3", `{"name": "Dramas"}`, recorder.Header().Get("ETag"))
+	if group, _ = responseMap["product-group"].(map[string]interface{}); recorder.Code != http.StatusOK || group["parent-id"] != 2.0 {
+		t.Errorf("Renaming the product group should have kept its parent, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doCatalogRequest(adminToken, "DELETE", "/admin/product-groups/2", "", etag)
+	if recorder.Code != http.StatusConflict || responseMap["code"] != string(PRODUCT_GROUP_NOT_EMPTY) {
+		t.Errorf("Deleting a product group with product groups under it should have failed, got: %d, %v", recorder.Code, responseMap)
+	}
+	if parents, _ := ioutil.ReadFile(filepath.Join(dir, "product-group-parents.csv")); string(parents) != "3\t2\n" {
+		t.Errorf("Parents should have been saved to the file, got: %q", parents)
+	}
+	customerToken := loginTestToken(t, "kari.karttinen@foo.com", "Kari")
+	recorder, responseMap = doAuthorizedRequest(authorized(getCategories, anyRole...), customerToken, "GET", "/categories", "")
+	categories, _ := responseMap["categories"].([]interface{})
+	if recorder.Code != http.StatusOK || len(categories) != 2 {
+		t.Fatalf("Wrong categories: %d, %v", recorder.Code, responseMap)
+	}
+	if children, _ := categories[1].(map[string]interface{})["children"].([]interface{}); len(children) != 1 || children[0].(map[string]interface{})["name"] != "Dramas" {
+		t.Errorf("Movies should have had Dramas under it, got: %v", categories[1])
+	}
+	recorder, responseMap = doAuthorizedRequest(authorized(getBreadcrumb, anyRole...), customerToken, "GET", "/breadcrumb/3/1", "")
+	if breadcrumb, _ := responseMap["breadcrumb"].([]interface{}); recorder.Code != http.StatusOK || len(breadcrumb) != 2 ||
+		breadcrumb[0].(map[string]interface{})["name"] != "Movies" || responseMap["title"] != "Ikiru" {
+		t.Errorf("Wrong breadcrumb: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doAuthorizedRequest(authorized(getBreadcrumb, anyRole...), customerToken, "GET", "/breadcrumb/3/2", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Breadcrumb of a missing product should not have been found, got: %d", recorder.Code)
+	}
+	recorder, responseMap = doAuthorizedRequest(authorized(getProducts, anyRole...), customerToken, "GET", "/products/2?descendants=true", "")
+	if products, _ := responseMap["products"].([]interface{}); recorder.Code != http.StatusOK || len(products) != 1 {
+		t.Errorf("Movies should have had the products of Dramas, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doAuthorizedRequest(authorized(getProducts, anyRole...), customerToken, "GET", "/products/2", "")
+	if products, _ := responseMap["products"].([]interface{}); recorder.Code != http.StatusOK || len(products) != 0 {
+		t.Errorf("Movies should have had only its own products, got: %d, %v", recorder.Code, responseMap)
+	}
+	// The flat product groups are as before.
+	recorder, responseMap = doAuthorizedRequest(authorized(getProductGroups, anyRole...), customerToken, "GET", "/product-groups", "")
+	if groups, _ := responseMap["product-groups"].(map[string]interface{}); recorder.Code != http.StatusOK || groups["3"] != "Dramas" {
+		t.Errorf("Wrong product groups: %d, %v", recorder.Code, responseMap)
+	}
+}
+
+// Only the ProductStore methods, i.e. not a CatalogEditor.
+type readOnlyProductStore struct {
+	domaindb.ProductStore
+}
+
+func TestAdminCatalogErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	useTestCatalog(t)
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/admin/product-groups/x", http.StatusBadRequest},
+		{"GET", "/admin/products/1/0", http.StatusBadRequest},
+		{"GET", "/admin/products/1/2/3", http.StatusNotFound},
+		{"PATCH", "/admin/product-groups/1", http.StatusMethodNotAllowed},
+		{"PUT", "/admin/products/1", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		if recorder, _ := doCatalogRequest(adminToken, test.method, test.path, "", ""); recorder.Code != test.status {
+			t.Errorf("%s %s should have got %d, got: %d", test.method, test.path, test.status, recorder.Code)
+		}
+	}
+	myProductStore = readOnlyProductStore{myProductStore}
+	if recorder, _ := doCatalogRequest(adminToken, "GET", "/admin/product-groups", "", ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Catalog of a read-only store should not have been editable, got: %d", recorder.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/domaindb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Catalog import and export API. All routes require the admin role (see handleRequests):
+// GET  /admin/catalog/export?format=ods - the whole catalog as a file, format: json (default), csv, zip or ods
+// POST /admin/catalog/import?format=ods - replaces the whole catalog with the file of the body
+// The file of an import is checked fully first, and if it has problems nothing is imported: the problems are
+// returned in the fields of the VALIDATION_FAILED error by their position, e.g. "catalog.csv:3".
+
+// Maximum size of an imported catalog file, configured in the properties file.
+var myMaxCatalogImportBytes = int64(util.MyConfig.GetInt("max_catalog_import_bytes", 10*1024*1024))
+
+type CatalogImportResponse struct {
+	Ret           string `json:"ret"`
+	ProductGroups int    `json:"product-groups"`
+	Products      int    `json:"products"`
+}
+
+func handleAdminCatalogTransfer(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var response interface{}
+	var errorResponse ErrorResponse
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	editor, editable := myProductStore.(domaindb.CatalogEditor)
+	switch {
+	case request.URL.Path != "/admin/catalog/export" && request.URL.Path != "/admin/catalog/import":
+		errorResponse = createErrorResponse(NOT_FOUND, "Not found: "+request.URL.Path)
+	case domaindb.CatalogContentType(format) == "":
+		errorResponse = createValidationErrorResponse(FieldErrors{"format": {"must be one of: " + strings.Join(domaindb.CatalogFormats(), ", ")}})
+	case !editable:
+		errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "The catalog of this product store cannot be exported or imported")
+	case request.URL.Path == "/admin/catalog/export" && request.Method == "GET":
+		// NOTE: Writes the file itself.
+		errorResponse = exportAdminCatalog(writer, request, editor, format)
+	case request.URL.Path == "/admin/catalog/import" && request.Method == "POST":
+		response, errorResponse = importAdminCatalog(writer, request, editor, format)
+	default:
+		errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+	}
+	if !errorResponse.Flag && response != nil {
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(response)
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, err.Error())
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+func exportAdminCatalog(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, format string) (errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	catalog, err := editor.ExportCatalog(request.Context())
+	// NOTE: Written to a buffer first, so that a failure can still be sent as an error response.
+	var buf bytes.Buffer
+	if err == nil {
+		err = domaindb.WriteCatalog(&buf, format, catalog)
+	}
+	if errorResponse = catalogResult(request, "ADMIN_EXPORT_CATALOG", "catalog", err); errorResponse.Flag {
+		return errorResponse
+	}
+	writer.Header().Set("Content-Type", domaindb.CatalogContentType(format))
+	writer.Header().Set("Content-Disposition", `attachment; filename="catalog.`+format+`"`)
+	writer.Write(buf.Bytes())
+	return errorResponse
+}
+
+func importAdminCatalog(writer http.ResponseWriter, request *http.Request, editor domaindb.CatalogEditor, format string) (response CatalogImportResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	catalog, err := domaindb.ReadCatalog(http.MaxBytesReader(writer, request.Body, myMaxCatalogImportBytes), format)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return response, createErrorResponse(REQUEST_TOO_LARGE, "Catalog file is larger than "+strconv.FormatInt(myMaxCatalogImportBytes, 10)+" bytes")
+	}
+	if catalogErrors, ok := err.(domaindb.CatalogErrors); ok {
+		tokenResponse, _ := tokenFromContext(request.Context())
+		auditEvent(request, tokenResponse.Email, "ADMIN_IMPORT_CATALOG", "catalog", audit.Failure, strconv.Itoa(len(catalogErrors))+" problems in the "+format+" file")
+		fieldErrors := FieldErrors{}
+		for _, catalogError := range catalogErrors {
+			position := catalogError.File
+			if catalogError.Line > 0 {
+				position += ":" + strconv.Itoa(catalogError.Line)
+			}
+			fieldErrors.add(position, catalogError.Problem)
+		}
+		errorResponse = createErrorResponse(VALIDATION_FAILED, "The catalog file has "+strconv.Itoa(len(catalogErrors))+" problems, nothing was imported")
+		errorResponse.Fields = fieldErrors
+		return response, errorResponse
+	}
+	if err == nil {
+		err = editor.ImportCatalog(request.Context(), catalog)
+	}
+	if errorResponse = catalogResult(request, "ADMIN_IMPORT_CATALOG", "catalog", err); errorResponse.Flag {
+		return response, errorResponse
+	}
+	return CatalogImportResponse{"ok", len(catalog.ProductGroups), len(catalog.Products)}, errorResponse
+}
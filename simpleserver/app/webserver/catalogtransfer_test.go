@@ -0,0 +1,85 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Calls the catalog import and export API through the authorization middleware like handleRequests does.
+// NOTE: The body is a catalog file, the Content-Type that doAuthorizedRequest sets for it does not matter.
+func doCatalogTransferRequest(token string, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder, _ := doAuthorizedRequest(authorized(handleAdminCatalogTransfer, adminRole...), token, method, path, body)
+	return recorder
+}
+
+func TestAdminCatalogExportImport(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := useTestCatalog(t)
+	original, _ := ioutil.ReadFile(filepath.Join(dir, "pg-1-products.csv"))
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	for _, format := range []string{"json", "csv", "zip", "ods"} {
+		recorder := doCatalogTransferRequest(adminToken, "GET", "/admin/catalog/export?format="+format, "")
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Disposition") != `attachment; filename="catalog.`+format+`"` {
+			t.Fatalf("Exporting %s failed: %d, %s", format, recorder.Code, recorder.Body.String())
+		}
+		exported := recorder.Body.String()
+		ioutil.WriteFile(filepath.Join(dir, "pg-1-products.csv"), nil, 0600)
+		recorder = doCatalogTransferRequest(adminToken, "POST", "/admin/catalog/import?format="+format, exported)
+		var response CatalogImportResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		if recorder.Code != http.StatusOK || response.ProductGroups != 2 || response.Products != 1 {
+			t.Errorf("Importing %s failed: %d, %s", format, recorder.Code, recorder.Body.String())
+		}
+		if imported, _ := ioutil.ReadFile(filepath.Join(dir, "pg-1-products.csv")); string(imported) != string(original) {
+			t.Errorf("Import of %s should have written the original file, got: %q", format, imported)
+		}
+	}
+	if recorder := doCatalogTransferRequest(adminToken, "GET", "/admin/catalog/export", ""); !strings.Contains(recorder.Body.String(), `"name": "Books"`) {
+		t.Errorf("Catalog should have been exported as JSON by default, got: %s", recorder.Body.String())
+	}
+}
+
+func TestAdminCatalogImportProblems(t *testing.T) {
+	defer util.LogEnter().Exit()
+	dir := useTestCatalog(t)
+	adminToken := loginTestToken(t, "admin@foo.com", "Admin")
+	body := "pg-id,product-group,p-id,title,price,author-or-director,year,country,genre-or-language\n" +
+		"1,Books,1,Kalevala,free,Elias Lönnrot,1835,Finland,Finnish\n1,Books,2,Short\n"
+	recorder := doCatalogTransferRequest(adminToken, "POST", "/admin/catalog/import?format=csv", body)
+	var responseMap map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &responseMap)
+	fields, _ := responseMap["fields"].(map[string]interface{})
+	if recorder.Code != http.StatusBadRequest || fields["catalog.csv:2"] == nil || fields["catalog.csv:3"] == nil {
+		t.Errorf("Invalid catalog should have failed with the problems, got: %d, %s", recorder.Code, recorder.Body.String())
+	}
+	if groups, _ := ioutil.ReadFile(filepath.Join(dir, "product-groups.csv")); string(groups) != "1\tBooks\n2\tMovies\n" {
+		t.Errorf("Invalid catalog should not have been imported, got: %q", groups)
+	}
+	tests := []struct {
+		token  string
+		method string
+		path   string
+		status int
+	}{
+		{adminToken, "GET", "/admin/catalog/export?format=xlsx", http.StatusBadRequest},
+		{adminToken, "POST", "/admin/catalog/export", http.StatusMethodNotAllowed},
+		{adminToken, "GET", "/admin/catalog/import", http.StatusMethodNotAllowed},
+		{adminToken, "GET", "/admin/catalog/other", http.StatusNotFound},
+		{loginTestToken(t, "kari.karttinen@foo.com", "Kari"), "GET", "/admin/catalog/export", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if recorder := doCatalogTransferRequest(test.token, test.method, test.path, ""); recorder.Code != test.status {
+			t.Errorf("%s %s should have got %d, got: %d", test.method, test.path, test.status, recorder.Code)
+		}
+	}
+	myProductStore = readOnlyProductStore{myProductStore}
+	if recorder := doCatalogTransferRequest(adminToken, "GET", "/admin/catalog/export", ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Catalog of a read-only store should not have been exported, got: %d", recorder.Code)
+	}
+}
@@ -0,0 +1,200 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stable machine-readable error codes. Clients should match on these, not on the messages.
+type ErrorCode string
+
+const (
+	VALIDATION_FAILED       ErrorCode = "VALIDATION_FAILED"
+	INVALID_CREDENTIALS     ErrorCode = "INVALID_CREDENTIALS"
+	INVALID_TOKEN           ErrorCode = "INVALID_TOKEN"
+	TOKEN_EXPIRED           ErrorCode = "TOKEN_EXPIRED"
+	FORBIDDEN               ErrorCode = "FORBIDDEN"
+	ACCOUNT_DISABLED        ErrorCode = "ACCOUNT_DISABLED"
+	PASSWORD_RESET_REQUIRED ErrorCode = "PASSWORD_RESET_REQUIRED"
+	EMAIL_NOT_VERIFIED      ErrorCode = "EMAIL_NOT_VERIFIED"
+	NOT_FOUND               ErrorCode = "NOT_FOUND"
+	METHOD_NOT_ALLOWED      ErrorCode = "METHOD_NOT_ALLOWED"
+	ALREADY_EXISTS          ErrorCode = "ALREADY_EXISTS"
+	REQUEST_TOO_LARGE       ErrorCode = "REQUEST_TOO_LARGE"
+	UNSUPPORTED_MEDIA_TYPE  ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	TOO_MANY_REQUESTS       ErrorCode = "TOO_MANY_REQUESTS"
+	ACCOUNT_LOCKED          ErrorCode = "ACCOUNT_LOCKED"
+	VERSION_CONFLICT        ErrorCode = "VERSION_CONFLICT"
+	VERSION_REQUIRED        ErrorCode = "VERSION_REQUIRED"
+	PRODUCT_GROUP_NOT_EMPTY ErrorCode = "PRODUCT_GROUP_NOT_EMPTY"
+	INTERNAL                ErrorCode = "INTERNAL"
+)
+
+// HTTP status for each error code.
+var errorCodeStatuses = map[ErrorCode]int{
+	VALIDATION_FAILED:       http.StatusBadRequest,
+	INVALID_CREDENTIALS:     http.StatusUnauthorized,
+	INVALID_TOKEN:           http.StatusUnauthorized,
+	TOKEN_EXPIRED:           http.StatusUnauthorized,
+	FORBIDDEN:               http.StatusForbidden,
+	ACCOUNT_DISABLED:        http.StatusForbidden,
+	PASSWORD_RESET_REQUIRED: http.StatusForbidden,
+	EMAIL_NOT_VERIFIED:      http.StatusForbidden,
+	NOT_FOUND:               http.StatusNotFound,
+	METHOD_NOT_ALLOWED:      http.StatusMethodNotAllowed,
+	ALREADY_EXISTS:          http.StatusConflict,
+	REQUEST_TOO_LARGE:       http.StatusRequestEntityTooLarge,
+	UNSUPPORTED_MEDIA_TYPE:  http.StatusUnsupportedMediaType,
+	TOO_MANY_REQUESTS:       http.StatusTooManyRequests,
+	ACCOUNT_LOCKED:          http.StatusTooManyRequests,
+	VERSION_CONFLICT:        http.StatusPreconditionFailed,
+	VERSION_REQUIRED:        http.StatusPreconditionRequired,
+	PRODUCT_GROUP_NOT_EMPTY: http.StatusConflict,
+	INTERNAL:                http.StatusInternalServerError,
+}
+
+// Returns the HTTP status for the error code, 500 for unknown codes.
+func (code ErrorCode) Status() int {
+	status, ok := errorCodeStatuses[code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// Using ErrorResponder interface we can make error handling generic for all API calls.
+// NOTE: writeError encodes the ErrorResponder itself, so an entity which embeds ErrorResponse
+// (e.g. SigninErrorResponse) gets its own fields in the response without implementing any methods.
+type ErrorResponder interface {
+	GetFlag() bool
+	GetMsg() string
+	GetCode() ErrorCode
+	GetRetryAfter() time.Duration
+}
+
+// Used by all ErrorResponder entities.
+func getEncoder(writer http.ResponseWriter) (encoder *json.Encoder) {
+	encoder = json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	return encoder
+}
+
+// ErrorResponse is the base struct for all web layer Error response entities.
+type ErrorResponse struct {
+	Flag bool      `json:"-"` // Just to tell the whether we have initialized this struct or not (zero-value for bool is false, i.e. if the value is ready we know that we have initialized the struct).
+	Ret  string    `json:"ret"`
+	Code ErrorCode `json:"code"`
+	Msg  string    `json:"msg"`
+	// Validation errors per request field, if any.
+	Fields FieldErrors `json:"fields,omitempty"`
+	// When the client may try again (429 responses), written as Retry-After header.
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e ErrorResponse) GetRetryAfter() time.Duration {
+	return e.RetryAfter
+}
+
+func (e ErrorResponse) GetFlag() bool {
+	return e.Flag
+}
+
+func (e ErrorResponse) GetMsg() string {
+	return e.Msg
+}
+
+func (e ErrorResponse) GetCode() ErrorCode {
+	return e.Code
+}
+
+// SigninErrorResponse is the /signin API error response entity.
+type SigninErrorResponse struct {
+	ErrorResponse
+	Email string `json:"email"`
+}
+
+func createErrorResponse(code ErrorCode, msg string) (errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	errorResponse = ErrorResponse{Flag: true, Ret: "failed", Code: code, Msg: msg}
+	// Client errors are business as usual, server errors are not.
+	if code.Status() >= http.StatusInternalServerError {
+		util.LogError(string(code) + ": " + msg)
+	} else {
+		util.LogWarn(string(code) + ": " + msg)
+	}
+	return errorResponse
+}
+
+func createThrottledErrorResponse(code ErrorCode, msg string, retryAfter time.Duration) (errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	errorResponse = createErrorResponse(code, msg)
+	errorResponse.RetryAfter = retryAfter
+	return errorResponse
+}
+
+func createSigninErrorResponse(code ErrorCode, msg string, email string) (signinErrorResponse SigninErrorResponse) {
+	defer util.LogEnter().Exit()
+	return SigninErrorResponse{createErrorResponse(code, msg), email}
+}
+
+// Content type of RFC 7807 problem details.
+const problemJsonContentType = "application/problem+json"
+
+// Clients ask for RFC 7807 problem details with the Accept header.
+func wantsProblemJson(request *http.Request) bool {
+	return request != nil && strings.Contains(request.Header.Get("Accept"), problemJsonContentType)
+}
+
+// Converts the error response entity to RFC 7807 problem details.
+// The extra fields of the entity (e.g. email) are kept as extension members.
+func toProblemDetails(errorResponder ErrorResponder, status int) (problem map[string]interface{}, err error) {
+	defer util.LogEnter().Exit()
+	buf, err := json.Marshal(errorResponder)
+	if err == nil {
+		err = json.Unmarshal(buf, &problem)
+	}
+	if err != nil {
+		return nil, err
+	}
+	delete(problem, "ret")
+	delete(problem, "msg")
+	problem["type"] = "urn:simpleserver:error:" + strings.ToLower(string(errorResponder.GetCode()))
+	problem["title"] = http.StatusText(status)
+	problem["status"] = status
+	problem["detail"] = errorResponder.GetMsg()
+	return problem, nil
+}
+
+// Writes the error response with the HTTP status of its error code.
+func writeError(writer http.ResponseWriter, request *http.Request, errorResponder ErrorResponder) {
+	defer util.LogEnter().Exit()
+	status := errorResponder.GetCode().Status()
+	if errorResponder.GetRetryAfter() > 0 {
+		// NOTE: Retry-After is in whole seconds, round up so that the client does not come back too early.
+		seconds := int64(math.Ceil(errorResponder.GetRetryAfter().Seconds()))
+		writer.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	var body interface{} = errorResponder
+	if wantsProblemJson(request) {
+		problem, err := toProblemDetails(errorResponder, status)
+		if err != nil {
+			util.LogError("Couldn't create problem details: " + err.Error())
+		} else {
+			writer.Header().Set("Content-Type", problemJsonContentType)
+			body = problem
+		}
+	}
+	// NOTE: StatusOK is implicitely written first time writer.Write is called
+	// unless other status code set.
+	writer.WriteHeader(status)
+	err := getEncoder(writer).Encode(body)
+	if err != nil {
+		// Everything else failed, just write the json as string to http.ResponseWriter.
+		writer.Write([]byte(`{"ret":"failed","code":"` + string(INTERNAL) + `","msg":"A total failure, original error: ` + errorResponder.GetMsg() + `"}`))
+	}
+}
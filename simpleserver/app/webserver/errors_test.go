@@ -0,0 +1,110 @@
+package webserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorCodeStatus(t *testing.T) {
+	defer util.LogEnter().Exit()
+	expected := map[ErrorCode]int{
+		VALIDATION_FAILED:   400,
+		INVALID_CREDENTIALS: 401,
+		INVALID_TOKEN:       401,
+		TOKEN_EXPIRED:       401,
+		FORBIDDEN:           403,
+		NOT_FOUND:           404,
+		ALREADY_EXISTS:      409,
+		VERSION_CONFLICT:    412,
+		VERSION_REQUIRED:    428,
+		INTERNAL:            500,
+		"SOMETHING_UNKNOWN": 500,
+	}
+	for code, status := range expected {
+		if code.Status() != status {
+			t.Errorf("Wrong status for %s, expected: %d, got: %d", code, status, code.Status())
+		}
+	}
+}
+
+func TestWriteErrorProblemJson(t *testing.T) {
+	defer util.LogEnter().Exit()
+	request := httptest.NewRequest("POST", "http://localhost/signin", nil)
+	request.Header.Set("Accept", "application/problem+json")
+	recorder := httptest.NewRecorder()
+	writeHeaders(recorder)
+	writeError(recorder, request, createSigninErrorResponse(ALREADY_EXISTS, "Email already exists: x@foo.com", "x@foo.com"))
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Wrong status code: expected: %d, actual: %d", http.StatusConflict, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("Wrong content type: %s", contentType)
+	}
+	var problem map[string]interface{}
+	err := json.NewDecoder(recorder.Body).Decode(&problem)
+	if err != nil {
+		t.Fatalf("Decoding problem details failed: %s", err.Error())
+	}
+	if problem["type"] != "urn:simpleserver:error:already_exists" || problem["title"] != "Conflict" ||
+		problem["status"] != 409.0 || problem["detail"] != "Email already exists: x@foo.com" {
+		t.Errorf("Wrong problem details: %v", problem)
+	}
+	// Extension members.
+	if problem["code"] != "ALREADY_EXISTS" || problem["email"] != "x@foo.com" {
+		t.Errorf("Problem details should comprise code and email: %v", problem)
+	}
+}
+
+func getProductWithToken(token string, path string) *httptest.ResponseRecorder {
+	encoded := base64.StdEncoding.EncodeToString([]byte(token))
+	request := httptest.NewRequest("GET", "http://localhost"+path, nil)
+	request.Header.Add("authorization", "Basic "+encoded)
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(getProduct).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestErrorStatuses(t *testing.T) {
+	defer util.LogEnter().Exit()
+	token, err := CreateJsonWebToken("kari.karttinen@foo.com", []string{userdb.RoleCustomer})
+	if err != nil {
+		t.Fatalf("Failed to get test token: %s", err.Error())
+	}
+	// Expired token, we have to add it to the sessions ourselves.
+	claim := SSClaim{"kari.karttinen@foo.com", []string{userdb.RoleCustomer}, jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()}}
+	expiredToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString(superSecret)
+	mySessions.AddSession(context.Background(), expiredToken, userdb.Session{Email: "kari.karttinen@foo.com", Expires: time.Unix(claim.ExpiresAt, 0)})
+	tests := []struct {
+		token  string
+		path   string
+		status int
+		code   ErrorCode
+	}{
+		{token, "/product/2/49", http.StatusOK, ""},
+		{token, "/product/2/999999", http.StatusNotFound, NOT_FOUND},
+		{token, "/product/2/x", http.StatusBadRequest, VALIDATION_FAILED},
+		{"not-a-token", "/product/2/49", http.StatusUnauthorized, INVALID_TOKEN},
+		{expiredToken, "/product/2/49", http.StatusUnauthorized, TOKEN_EXPIRED},
+	}
+	for _, test := range tests {
+		recorder := getProductWithToken(test.token, test.path)
+		if recorder.Code != test.status {
+			t.Errorf("%s: wrong status code: expected: %d, actual: %d", test.path, test.status, recorder.Code)
+		}
+		if test.code != "" {
+			var responseMap map[string]string
+			json.NewDecoder(recorder.Body).Decode(&responseMap)
+			if responseMap["code"] != string(test.code) || responseMap["ret"] != "failed" {
+				t.Errorf("%s: wrong error response, expected code: %s, got: %v", test.path, test.code, responseMap)
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Self-service API of the logged in user (see handleRequests):
+// GET    /me          - own user data
+// PATCH  /me          - change first and/or last name, body: {"first-name": "Kari"}
+// DELETE /me          - delete own account, body: {"password": "..."}
+// POST   /me/password - change password, body: {"current-password": "...", "new-password": "..."}
+// GET    /me/export   - all stored data of the user as a JSON file
+
+// Pointers tell which fields were given, i.e. PATCH changes only the given fields.
+type ProfileData struct {
+	FirstName *string `json:"first-name"`
+	LastName  *string `json:"last-name"`
+}
+
+type PasswordChangeData struct {
+	CurrentPassword string `json:"current-password"`
+	NewPassword     string `json:"new-password"`
+}
+
+type AccountDeleteData struct {
+	Password string `json:"password"`
+}
+
+// Everything we store about the user. The password is stored only as a hash, so it is not exported.
+type PersonalDataExport struct {
+	Ret          string             `json:"ret"`
+	ExportedAt   time.Time          `json:"exported-at"`
+	User         userdb.UserInfo    `json:"user"`
+	Sessions     []SessionInfo      `json:"sessions"`
+	Tokens       []userdb.TokenInfo `json:"pending-tokens"`
+	FailedLogins int                `json:"failed-logins"`
+}
+
+// Returns the user of the token validated by the authorized middleware.
+func currentUser(request *http.Request) (user userdb.UserInfo, errorResponse ErrorResponse) {
+	tokenResponse, _ := tokenFromContext(request.Context())
+	user, ok, err := userdb.GetUserByEmail(request.Context(), tokenResponse.Email)
+	if err != nil {
+		errorResponse = userStoreErrorResponse(err)
+	} else if !ok {
+		// NOTE: Deleting a user revokes the sessions, so this should not happen.
+		errorResponse = createErrorResponse(NOT_FOUND, "User not found: "+tokenResponse.Email)
+	}
+	return user, errorResponse
+}
+
+// A missing user is NOT_FOUND (deleted in between), any other failure of the user store is INTERNAL.
+func userStoreErrorResponse(err error) ErrorResponse {
+	if _, ok := err.(userdb.UserNotFoundError); ok {
+		return createErrorResponse(NOT_FOUND, err.Error())
+	}
+	return createErrorResponse(INTERNAL, err.Error())
+}
+
+func handleMe(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var response UserResponse
+	user, errorResponse := currentUser(request)
+	if !errorResponse.Flag {
+		switch request.Method {
+		case "GET":
+			response = UserResponse{"ok", user, 0}
+		case "PATCH":
+			response, errorResponse = patchMe(writer, request, user)
+		case "DELETE":
+			response, errorResponse = deleteMe(writer, request, user)
+		default:
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		}
+	}
+	if !errorResponse.Flag {
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(response)
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, err.Error())
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+func patchMe(writer http.ResponseWriter, request *http.Request, user userdb.UserInfo) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	var profileData ProfileData
+	fieldErrors, errorResponse := bindJson(writer, request, &profileData)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	if profileData.FirstName == nil && profileData.LastName == nil {
+		fieldErrors.add("first-name", "first-name or last-name is required")
+	}
+	firstName, lastName := user.FirstName, user.LastName
+	if profileData.FirstName != nil {
+		for _, msg := range validateName(*profileData.FirstName) {
+			fieldErrors.add("first-name", msg)
+		}
+		firstName = strings.TrimSpace(*profileData.FirstName)
+	}
+	if profileData.LastName != nil {
+		for _, msg := range validateName(*profileData.LastName) {
+			fieldErrors.add("last-name", msg)
+		}
+		lastName = strings.TrimSpace(*profileData.LastName)
+	}
+	if len(fieldErrors) > 0 {
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	} else {
+		user, err := userdb.UpdateProfile(request.Context(), user.UserId, firstName, lastName)
+		if err != nil {
+			errorResponse = userStoreErrorResponse(err)
+		} else {
+			response = UserResponse{"ok", user, 0}
+		}
+	}
+	return response, errorResponse
+}
+
+// Changes the password and revokes the other sessions of the user, the session of this request stays valid.
+// NOTE: Wrong current passwords count as failed logins, so that a stolen token cannot be used to guess the password.
+func postMePassword(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var response UserResponse
+	var passwordChangeData PasswordChangeData
+	var fieldErrors FieldErrors
+	ip := clientIp(request)
+	tokenResponse, _ := tokenFromContext(request.Context())
+	throttleKey := normalizeEmail(tokenResponse.Email)
+	user, errorResponse := currentUser(request)
+	if !errorResponse.Flag {
+		if request.Method != "POST" {
+			errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+		} else if retryAfter, locked := myLoginThrottle.AllowEmail(throttleKey); locked || retryAfter > 0 {
+			errorResponse = createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many failed password attempts, try again later", retryAfter)
+		} else {
+			fieldErrors, errorResponse = bindJson(writer, request, &passwordChangeData)
+		}
+	}
+	if !errorResponse.Flag {
+		if passwordChangeData.CurrentPassword == "" {
+			fieldErrors.add("current-password", "is required")
+		}
+		if passwordChangeData.NewPassword == "" {
+			fieldErrors.add("new-password", "is required")
+		} else {
+			for _, msg := range myPasswordPolicy.Validate(passwordChangeData.NewPassword) {
+				fieldErrors.add("new-password", msg)
+			}
+			if passwordChangeData.NewPassword == passwordChangeData.CurrentPassword {
+				fieldErrors.add("new-password", "must differ from the current password")
+			}
+		}
+		if len(fieldErrors) > 0 {
+			errorResponse = createValidationErrorResponse(fieldErrors)
+		} else {
+			changed, err := userdb.ChangePassword(request.Context(), user.UserId, passwordChangeData.CurrentPassword, passwordChangeData.NewPassword)
+			if _, ok := err.(userdb.WrongPasswordError); ok {
+				myLoginThrottle.RecordFailure(throttleKey, ip)
+				auditEvent(request, user.Email, "PASSWORD_CHANGED", user.Email, audit.Failure, "wrong current password")
+				fieldErrors.add("current-password", "is not correct")
+				errorResponse = createValidationErrorResponse(fieldErrors)
+			} else if err != nil {
+				errorResponse = userStoreErrorResponse(err)
+			} else {
+				myLoginThrottle.RecordSuccess(throttleKey)
+				response = UserResponse{"ok", changed, RevokeSessions(changed.Email, tokenResponse.Token)}
+				auditEvent(request, changed.Email, "PASSWORD_CHANGED", changed.Email, audit.Success, "revoked other sessions: "+strconv.Itoa(response.RevokedSessions))
+				encoder := json.NewEncoder(writer)
+				encoder.SetEscapeHTML(false)
+				err := encoder.Encode(response)
+				if err != nil {
+					errorResponse = createErrorResponse(INTERNAL, err.Error())
+				}
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+// Deletes the account with its tokens and sessions. Needs the password like changing the password does.
+// NOTE: The deletion is audited with the email, the audit log is our record that the request was honored.
+func deleteMe(writer http.ResponseWriter, request *http.Request, user userdb.UserInfo) (response UserResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	var deleteData AccountDeleteData
+	var fieldErrors FieldErrors
+	ip := clientIp(request)
+	throttleKey := normalizeEmail(user.Email)
+	if retryAfter, locked := myLoginThrottle.AllowEmail(throttleKey); locked || retryAfter > 0 {
+		return response, createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many failed password attempts, try again later", retryAfter)
+	}
+	fieldErrors, errorResponse = bindJson(writer, request, &deleteData)
+	if errorResponse.Flag {
+		return response, errorResponse
+	}
+	if deleteData.Password == "" {
+		fieldErrors.add("password", "is required")
+	} else if _, ok, err := userdb.CheckCredentials(request.Context(), user.Email, deleteData.Password); err != nil {
+		// NOTE: A failure of the user store is not a wrong password, it must not lock out the user.
+		return response, createErrorResponse(INTERNAL, "Couldn't check the password: "+err.Error())
+	} else if !ok {
+		myLoginThrottle.RecordFailure(throttleKey, ip)
+		auditEvent(request, user.Email, "ACCOUNT_DELETED", user.Email, audit.Failure, "wrong password")
+		fieldErrors.add("password", "is not correct")
+	}
+	if len(fieldErrors) > 0 {
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	} else {
+		deleted, err := userdb.DeleteUser(request.Context(), user.UserId)
+		if err != nil {
+			errorResponse = userStoreErrorResponse(err)
+		} else {
+			// NOTE: Also the failed logins are data about the user.
+			myLoginThrottle.RecordSuccess(throttleKey)
+			response = UserResponse{"ok", deleted, RevokeSessions(deleted.Email, "")}
+			auditEvent(request, deleted.Email, "ACCOUNT_DELETED", deleted.Email, audit.Success, "user id: "+strconv.Itoa(deleted.UserId)+", revoked sessions: "+strconv.Itoa(response.RevokedSessions))
+		}
+	}
+	return response, errorResponse
+}
+
+func getMeExport(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	user, errorResponse := currentUser(request)
+	if !errorResponse.Flag && request.Method != "GET" {
+		errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+	}
+	var tokens []userdb.TokenInfo
+	if !errorResponse.Flag {
+		var err error
+		// NOTE: An export without the tokens would be incomplete, so a failure of the user store fails the export.
+		if tokens, err = userdb.GetUserTokens(request.Context(), user.UserId); err != nil {
+			errorResponse = createErrorResponse(INTERNAL, "Couldn't read the tokens: "+err.Error())
+		}
+	}
+	if !errorResponse.Flag {
+		tokenResponse, _ := tokenFromContext(request.Context())
+		export := PersonalDataExport{
+			Ret:          "ok",
+			ExportedAt:   time.Now().UTC(),
+			User:         user,
+			Sessions:     ListSessions(user.Email, tokenResponse.Token),
+			Tokens:       tokens,
+			FailedLogins: myLoginThrottle.Failures(normalizeEmail(user.Email)),
+		}
+		auditEvent(request, user.Email, "PERSONAL_DATA_EXPORTED", user.Email, audit.Success, "user id: "+strconv.Itoa(user.UserId))
+		writer.Header().Set("Content-Disposition", "attachment; filename=\"simpleserver-export-"+strconv.Itoa(user.UserId)+".json\"")
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(export)
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, err.Error())
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
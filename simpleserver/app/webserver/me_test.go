@@ -0,0 +1,290 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func doMeRequest(token string, method string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return doAuthorizedRequest(authorized(handleMe, anyRole...), token, method, "/me", body)
+}
+
+func doMePasswordRequest(token string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return doAuthorizedRequest(authorized(postMePassword, anyRole...), token, "POST", "/me/password", body)
+}
+
+func TestGetMe(t *testing.T) {
+	defer util.LogEnter().Exit()
+	token := loginTestToken(t, "timo.tillinen@foo.com", "Timo")
+	recorder, responseMap := doMeRequest(token, "GET", "")
+	user, _ := responseMap["user"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || user["email"] != "timo.tillinen@foo.com" || user["first-name"] != "Timo" || user["last-name"] != "Tillinen" {
+		t.Errorf("Getting own data failed: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doMeRequest("", "GET", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Request without token should have got 401, got: %d", recorder.Code)
+	}
+	if recorder, _ = doMeRequest(token, "PUT", ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT should have got 405, got: %d", recorder.Code)
+	}
+}
+
+func TestPatchMe(t *testing.T) {
+	defer util.LogEnter().Exit()
+	email := "me.patch.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Patch", "Test", "PatchTestPassword")
+	token := loginTestToken(t, email, "PatchTestPassword")
+	recorder, responseMap := doMeRequest(token, "PATCH", `{"first-name":" Changed "}`)
+	user, _ := responseMap["user"].(map[string]interface{})
+	if recorder.Code != http.StatusOK || user["first-name"] != "Changed" || user["last-name"] != "Test" {
+		t.Errorf("Patching first name failed: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, responseMap = doMeRequest(token, "PATCH", `{"last-name":"Name"}`); recorder.Code != http.StatusOK {
+		t.Errorf("Patching last name failed: %d, %v", recorder.Code, responseMap)
+	}
+	if user, _, _ := userdb.GetUserByEmail(context.Background(), email); user.FirstName != "Changed" || user.LastName != "Name" {
+		t.Errorf("Names should have been changed in userdb, got: %v", user)
+	}
+	invalidBodies := []string{`{}`, `{"first-name":""}`, `{"last-name":"<script>"}`, `{"email":"x@foo.com"}`}
+	for _, body := range invalidBodies {
+		if recorder, responseMap = doMeRequest(token, "PATCH", body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("Patch %s should have been rejected, got: %d, %v", body, recorder.Code, responseMap)
+		}
+	}
+}
+
+// A user store whose changes fail with err, e.g. the database is down.
+type failingUserStore struct {
+	userdb.Store
+	err error
+}
+
+func (store failingUserStore) UpdateUser(ctx context.Context, userId int, modify func(user *userdb.User) error) (userdb.User, error) {
+	return userdb.User{}, store.err
+}
+
+func (store failingUserStore) DeleteUser(ctx context.Context, userId int) (userdb.User, error) {
+	return userdb.User{}, store.err
+}
+
+// Uses a failing store for the changes until the end of the test.
+func useFailingUserStore(t *testing.T, err error) {
+	previous := userdb.SetStore(nil)
+	userdb.SetStore(failingUserStore{previous, err})
+	t.Cleanup(func() { userdb.SetStore(previous) })
+}
+
+func TestMeStoreErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	email := "me.store.errors.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Store", "Errors", "StoreErrorsPassword")
+	token := loginTestToken(t, email, "StoreErrorsPassword")
+	tests := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{userdb.UserNotFoundError{UserId: 1}, NOT_FOUND},
+		{errors.New("database is down"), INTERNAL},
+	}
+	for _, test := range tests {
+		t.Run(string(test.code), func(t *testing.T) {
+			useFailingUserStore(t, test.err)
+			if _, responseMap := doMeRequest(token, "PATCH", `{"first-name":"Changed"}`); responseMap["code"] != string(test.code) {
+				t.Errorf("Patch should have failed with %s, got: %v", test.code, responseMap)
+			}
+			if _, responseMap := doMePasswordRequest(token, `{"current-password":"StoreErrorsPassword","new-password":"NewStorePassword"}`); responseMap["code"] != string(test.code) {
+				t.Errorf("Password change should have failed with %s, got: %v", test.code, responseMap)
+			}
+			if _, responseMap := doMeRequest(token, "DELETE", `{"password":"StoreErrorsPassword"}`); responseMap["code"] != string(test.code) {
+				t.Errorf("Delete should have failed with %s, got: %v", test.code, responseMap)
+			}
+		})
+	}
+}
+
+// A failure of the user store is INTERNAL, not NOT_FOUND, also when the current user is looked up.
+func TestMeLookupStoreError(t *testing.T) {
+	defer util.LogEnter().Exit()
+	token := loginTestToken(t, "kari.karttinen@foo.com", "Kari")
+	useLookupFailingUserStore(t)
+	if recorder, responseMap := doMeRequest(token, "GET", ""); recorder.Code != http.StatusInternalServerError || responseMap["code"] != string(INTERNAL) {
+		t.Errorf("Getting me should have failed with 500, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, responseMap := doAuthorizedRequest(authorized(getMeExport, anyRole...), token, "GET", "/me/export", ""); recorder.Code != http.StatusInternalServerError || responseMap["code"] != string(INTERNAL) {
+		t.Errorf("Export should have failed with 500, got: %d, %v", recorder.Code, responseMap)
+	}
+}
+
+// A store whose lookups fail after the first ones, i.e. after the current user has been looked up.
+type lateFailingUserStore struct {
+	userdb.Store
+	lookups *int32
+	err     error
+}
+
+func (store lateFailingUserStore) GetUserByEmail(ctx context.Context, email string) (userdb.User, bool, error) {
+	if atomic.AddInt32(store.lookups, -1) < 0 {
+		return userdb.User{}, false, store.err
+	}
+	return store.Store.GetUserByEmail(ctx, email)
+}
+
+// A failure of the user store in checking the password is not a wrong password.
+func TestDeleteMeCredentialsStoreError(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	email := "me.delete.store.error.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Delete", "StoreError", "DeleteStoreErrorPassword")
+	token := loginTestToken(t, email, "DeleteStoreErrorPassword")
+	readRecords := useTestAuditLog(t)
+	lookups := int32(1)
+	previousStore := userdb.SetStore(nil)
+	userdb.SetStore(lateFailingUserStore{previousStore, &lookups, errors.New("database is down")})
+	defer userdb.SetStore(previousStore)
+	recorder, responseMap := doMeRequest(token, "DELETE", `{"password":"DeleteStoreErrorPassword"}`)
+	if recorder.Code != http.StatusInternalServerError || responseMap["code"] != string(INTERNAL) {
+		t.Errorf("Delete should have failed with 500, got: %d, %v", recorder.Code, responseMap)
+	}
+	if failures := myLoginThrottle.Failures(normalizeEmail(email)); failures != 0 {
+		t.Errorf("Store failure should not have been recorded as a wrong password, failures: %d", failures)
+	}
+	if records := readRecords(); len(records) != 0 {
+		t.Errorf("Store failure should not have been audited as a wrong password, got: %v", records)
+	}
+}
+
+func TestChangeMyPassword(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	email := "me.password.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Password", "Test", "OldPassword")
+	token := loginTestToken(t, email, "OldPassword")
+	otherToken := loginTestToken(t, email, "OldPassword")
+	readRecords := useTestAuditLog(t)
+	recorder, responseMap := doMePasswordRequest(token, `{"current-password":"WRONG-PASSWORD","new-password":"NewPassword"}`)
+	fields, _ := responseMap["fields"].(map[string]interface{})
+	if recorder.Code != http.StatusBadRequest || fields["current-password"] == nil {
+		t.Errorf("Wrong current password should have been rejected, got: %d, %v", recorder.Code, responseMap)
+	}
+	if records := readRecords(); len(records) != 1 || records[0].Actor != email || records[0].Target != email || records[0].Outcome != audit.Failure {
+		t.Errorf("Wrong current password should have been audited with the user as the actor, got: %v", records)
+	}
+	recorder, responseMap = doMePasswordRequest(token, `{"current-password":"OldPassword","new-password":"weak"}`)
+	fields, _ = responseMap["fields"].(map[string]interface{})
+	if recorder.Code != http.StatusBadRequest || fields["new-password"] == nil {
+		t.Errorf("Weak new password should have been rejected, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doMePasswordRequest(token, `{"current-password":"OldPassword","new-password":"NewPassword"}`)
+	if recorder.Code != http.StatusOK || responseMap["revoked-sessions"] != 1.0 {
+		t.Errorf("Changing password failed: %d, %v", recorder.Code, responseMap)
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), otherToken); err == nil {
+		t.Errorf("Other session should have been revoked")
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), token); err != nil {
+		t.Errorf("Current session should have stayed valid, got: %s", err.Error())
+	}
+	_, oldOk, _ := userdb.CheckCredentials(context.Background(), email, "OldPassword")
+	if _, newOk, _ := userdb.CheckCredentials(context.Background(), email, "NewPassword"); oldOk || !newOk {
+		t.Errorf("Password should have been changed")
+	}
+}
+
+func TestChangeMyPasswordThrottled(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	token := loginTestToken(t, "kari.karttinen@foo.com", "Kari")
+	for i := 0; i <= testThrottleConfig.BackoffFreeAttempts; i++ {
+		doMePasswordRequest(token, `{"current-password":"WRONG-PASSWORD","new-password":"NewPassword1"}`)
+	}
+	if recorder, _ := doMePasswordRequest(token, `{"current-password":"Kari","new-password":"NewPassword1"}`); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Guessing the current password should have been throttled, got: %d", recorder.Code)
+	}
+}
+
+func TestExportMe(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	email := "me.export.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Export", "Test", "ExportPassword")
+	userdb.CreateEmailVerificationToken(context.Background(), email, time.Hour)
+	otherToken := loginTestToken(t, email, "ExportPassword")
+	token := loginTestToken(t, email, "ExportPassword")
+	myLoginThrottle.RecordFailure(normalizeEmail(email), "192.0.2.1")
+	recorder, responseMap := doAuthorizedRequest(authorized(getMeExport, anyRole...), token, "GET", "/me/export", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("Export failed: %d, %v", recorder.Code, responseMap)
+	}
+	user, _ := responseMap["user"].(map[string]interface{})
+	sessions, _ := responseMap["sessions"].([]interface{})
+	tokens, _ := responseMap["pending-tokens"].([]interface{})
+	if user["email"] != email || user["first-name"] != "Export" || user["email-verified"] != false {
+		t.Errorf("Export should have had the user, got: %v", user)
+	}
+	if len(sessions) != 2 || len(tokens) != 1 || responseMap["failed-logins"] != 1.0 {
+		t.Errorf("Export should have had 2 sessions, 1 token and 1 failed login, got: %v", responseMap)
+	}
+	current := 0
+	for _, session := range sessions {
+		if session.(map[string]interface{})["current"] == true {
+			current++
+		}
+	}
+	body := recorder.Body.String()
+	if current != 1 || strings.Contains(body, token) || strings.Contains(body, otherToken) || strings.Contains(body, "assword\":\"") {
+		t.Errorf("Export should have marked the current session and not contained tokens or passwords: %s", body)
+	}
+	if recorder, _ = doAuthorizedRequest(authorized(getMeExport, anyRole...), token, "POST", "/me/export", ""); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST should have got 405, got: %d", recorder.Code)
+	}
+}
+
+func TestDeleteMe(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	email := "me.delete.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Delete", "Test", "DeletePassword")
+	token := loginTestToken(t, email, "DeletePassword")
+	otherToken := loginTestToken(t, email, "DeletePassword")
+	for _, body := range []string{`{}`, `{"password":"WRONG-PASSWORD"}`} {
+		recorder, responseMap := doMeRequest(token, "DELETE", body)
+		fields, _ := responseMap["fields"].(map[string]interface{})
+		if recorder.Code != http.StatusBadRequest || fields["password"] == nil {
+			t.Errorf("Delete with %s should have been rejected, got: %d, %v", body, recorder.Code, responseMap)
+		}
+	}
+	recorder, responseMap := doMeRequest(token, "DELETE", `{"password":"DeletePassword"}`)
+	if recorder.Code != http.StatusOK || responseMap["revoked-sessions"] != 2.0 {
+		t.Errorf("Deleting account failed: %d, %v", recorder.Code, responseMap)
+	}
+	if _, found, _ := userdb.GetUserByEmail(context.Background(), email); found || myLoginThrottle.Failures(normalizeEmail(email)) != 0 {
+		t.Errorf("User and the failed logins should have been deleted")
+	}
+	for _, sessionToken := range []string{token, otherToken} {
+		if _, err := ValidateJsonWebToken(context.Background(), sessionToken); err == nil {
+			t.Errorf("Sessions of the deleted user should have been revoked")
+		}
+	}
+	if recorder = postTestLogin(email, "DeletePassword"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Deleted user should not have been able to log in, got: %d", recorder.Code)
+	}
+}
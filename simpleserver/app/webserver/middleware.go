@@ -0,0 +1,110 @@
+package webserver
+
+import (
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Returns the client IP address of the request. X-Forwarded-For is used only if
+// trust_forwarded_for=true, i.e. the server is behind trusted_proxy_hops proxies we trust to append to it.
+// NOTE: The client can send any X-Forwarded-For, so only the entries appended by our proxies count:
+// the address the outermost proxy got the request from is the entry trusted_proxy_hops from the right.
+func clientIp(request *http.Request) string {
+	if myTrustForwardedFor {
+		var entries []string
+		for _, forwarded := range request.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(forwarded, ",")...)
+		}
+		if len(entries) > 0 {
+			i := len(entries) - myTrustedProxyHops
+			if i < 0 {
+				i = 0
+			}
+			return strings.TrimSpace(entries[i])
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+var myTrustForwardedFor = util.MyConfig.GetBool("trust_forwarded_for", false)
+var myTrustedProxyHops = trustedProxyHops(util.MyConfig.GetInt("trusted_proxy_hops", 1))
+
+func trustedProxyHops(hops int) int {
+	if hops < 1 {
+		return 1
+	}
+	return hops
+}
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Returns the X-Request-Id header of the request, e.g. set by a proxy, or a new random id if the header is missing or invalid.
+func requestId(request *http.Request) string {
+	if id := request.Header.Get("X-Request-Id"); validRequestId.MatchString(id) {
+		return id
+	}
+	return newTokenId()
+}
+
+// Writes a record of the security event to the audit log, see the audit package.
+// Actor is who did the action, target the account (or e.g. the route) the action was done to.
+func auditEvent(request *http.Request, actor string, action string, target string, outcome string, details string) {
+	audit.Log(request.Context(), audit.Record{Actor: actor, Action: action, Target: target, Outcome: outcome, Ip: clientIp(request), Details: details})
+}
+
+// Records the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Wraps the handler in a server span. If the request has a W3C traceparent header
+// the span continues that trace, otherwise a new trace is started.
+// The span is in the request context, so the handlers can start child spans from request.Context().
+// The client IP and the request id (returned in the X-Request-Id header) are in the context for the audit log.
+func traced(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id := requestId(request)
+		writer.Header().Set("X-Request-Id", id)
+		ctx := audit.ContextWithSource(request.Context(), audit.Source{Ip: clientIp(request), RequestId: id})
+		if traceParent := request.Header.Get("traceparent"); traceParent != "" {
+			parent, err := tracing.ParseTraceParent(traceParent)
+			if err != nil {
+				util.LogWarn("Ignoring traceparent: " + err.Error())
+			} else {
+				ctx = tracing.ContextWithRemoteParent(ctx, parent)
+			}
+		}
+		ctx, span := tracing.StartSpan(ctx, request.Method+" "+route, tracing.SpanKindServer)
+		defer span.End()
+		if span == nil {
+			handler(writer, request.WithContext(ctx))
+			return
+		}
+		span.SetAttribute("http.request_id", id)
+		span.SetAttribute("http.method", request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", request.URL.Path)
+		recorder := &statusRecorder{writer, http.StatusOK}
+		handler(recorder, request.WithContext(ctx))
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, "HTTP status "+strconv.Itoa(recorder.status))
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/tracing/tracingtest"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraced(t *testing.T) {
+	defer util.LogEnter().Exit()
+	collector := tracingtest.NewCollector()
+	defer collector.Close()
+	exporter, err := tracing.NewOtlpHttpExporter(collector.Url(), time.Second)
+	if err != nil {
+		t.Fatalf("Creating exporter failed: %s", err.Error())
+	}
+	tracer := tracing.NewTracer(exporter, "simpleserver", 100, time.Hour)
+	previous := tracing.SetTracer(tracer)
+	defer tracing.SetTracer(previous)
+	token, err := CreateJsonWebToken("kari.karttinen@foo.com", []string{userdb.RoleCustomer})
+	if err != nil {
+		t.Fatalf("Failed to get test token: %s", err.Error())
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(token))
+	request := httptest.NewRequest("GET", "http://localhost/product-groups", nil)
+	request.Header.Add("authorization", "Basic "+encoded)
+	request.Header.Add("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	recorder := httptest.NewRecorder()
+	traced("/product-groups", getProductGroups).ServeHTTP(recorder, request)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("getProductGroups handler returned wrong status code: expected: %v actual: %v",
+			http.StatusOK, status)
+	}
+	tracer.Shutdown()
+	server := collector.Span("GET /product-groups")
+	if server == nil {
+		t.Fatal("Collector didn't receive the server span")
+	}
+	if server.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanId != "00f067aa0ba902b7" {
+		t.Errorf("Server span should continue the trace in traceparent, got: %v", server)
+	}
+	if server.Attributes["http.status_code"] != "200" {
+		t.Errorf("Wrong http.status_code attribute: %v", server.Attributes["http.status_code"])
+	}
+	for _, name := range []string{"webserver.ValidateJsonWebToken", "domaindb.GetProductGroups"} {
+		child := collector.Span(name)
+		if child == nil {
+			t.Errorf("Collector didn't receive span: %s", name)
+		} else if child.TraceId != server.TraceId || child.ParentSpanId != server.SpanId {
+			t.Errorf("Span %s should be a child of the server span, got: %v", name, child)
+		}
+	}
+}
+
+// Replaces the audit log with a temporary file and returns a function reading the records written to it.
+func useTestAuditLog(t *testing.T) func() []audit.Record {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.NewLogger(filename)
+	if err != nil {
+		t.Fatalf("Creating audit logger failed: %s", err.Error())
+	}
+	previous := audit.SetLogger(logger)
+	t.Cleanup(func() {
+		audit.SetLogger(previous)
+		logger.Close()
+	})
+	return func() (records []audit.Record) {
+		if _, _, err := audit.VerifyFile(filename); err != nil {
+			t.Errorf("Audit log should have been intact: %s", err.Error())
+		}
+		content, _ := ioutil.ReadFile(filename)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if line == "" {
+				continue
+			}
+			var record audit.Record
+			json.Unmarshal([]byte(line), &record)
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+func TestTracedAuditsRequests(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	readRecords := useTestAuditLog(t)
+	login := func(password string, requestId string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": "Kari.Karttinen@foo.com", "password": password})
+		request := httptest.NewRequest("POST", "http://localhost/login", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if requestId != "" {
+			request.Header.Set("X-Request-Id", requestId)
+		}
+		recorder := httptest.NewRecorder()
+		traced("/login", postLogin).ServeHTTP(recorder, request)
+		return recorder
+	}
+	if recorder := login("WRONG-PASSWORD", "test-request-1"); recorder.Header().Get("X-Request-Id") != "test-request-1" {
+		t.Errorf("Request id of the request should have been returned, got: %s", recorder.Header().Get("X-Request-Id"))
+	}
+	recorder := login("Kari", "not a valid id")
+	generatedId := recorder.Header().Get("X-Request-Id")
+	if generatedId == "not a valid id" || len(generatedId) != 32 {
+		t.Errorf("Invalid request id should have been replaced, got: %s", generatedId)
+	}
+	request := httptest.NewRequest("GET", "http://localhost/product-groups", nil)
+	request.Header.Add("authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("not-a-session-token")))
+	traced("/product-groups", getProductGroups).ServeHTTP(httptest.NewRecorder(), request)
+	records := readRecords()
+	if len(records) != 3 {
+		t.Fatalf("Should have had 3 audit records, got: %v", records)
+	}
+	failure, success, rejected := records[0], records[1], records[2]
+	if failure.Action != "LOGIN" || failure.Outcome != audit.Failure || failure.RequestId != "test-request-1" || failure.Ip != "192.0.2.1" {
+		t.Errorf("Failed login should have been audited with the request id and IP, got: %v", failure)
+	}
+	if success.Action != "LOGIN" || success.Outcome != audit.Success || success.Actor != "kari.karttinen@foo.com" || success.RequestId != generatedId {
+		t.Errorf("Successful login should have been audited with the stored email, got: %v", success)
+	}
+	if rejected.Action != "TOKEN_REJECTED" || rejected.Outcome != audit.Denied || rejected.RequestId == "" || strings.Contains(rejected.Details, "not-a-session-token") {
+		t.Errorf("Rejected token should have been audited without the token, got: %v", rejected)
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousTrust, previousHops := myTrustForwardedFor, myTrustedProxyHops
+	defer func() { myTrustForwardedFor, myTrustedProxyHops = previousTrust, previousHops }()
+	request := httptest.NewRequest("GET", "http://localhost/info", nil)
+	request.RemoteAddr = "10.0.0.1:4321"
+	// The client sent the first entry, the proxy appended the address it got the request from.
+	request.Header.Add("X-Forwarded-For", "6.6.6.6, 203.0.113.7")
+	myTrustForwardedFor = false
+	if ip := clientIp(request); ip != "10.0.0.1" {
+		t.Errorf("X-Forwarded-For should not have been trusted, got: %s", ip)
+	}
+	myTrustForwardedFor, myTrustedProxyHops = true, 1
+	if ip := clientIp(request); ip != "203.0.113.7" {
+		t.Errorf("The spoofed entry should have been skipped, got: %s", ip)
+	}
+	request.Header.Add("X-Forwarded-For", "10.0.0.2")
+	myTrustedProxyHops = 2
+	if ip := clientIp(request); ip != "203.0.113.7" {
+		t.Errorf("The entry of the outermost of 2 proxies should have been used, got: %s", ip)
+	}
+	myTrustedProxyHops = 5
+	if ip := clientIp(request); ip != "6.6.6.6" {
+		t.Errorf("The first entry should have been used when there are fewer entries than proxies, got: %s", ip)
+	}
+}
@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/mail"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Password reset flow for users who have forgotten their password (see handleRequests):
+// POST /password/forgot - mails a single-use reset token, body: {"email": "..."}
+// POST /password/reset  - sets the new password, body: {"token": "...", "new-password": "..."}
+
+type PasswordForgotData struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetData struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new-password"`
+}
+
+type PasswordResponse struct {
+	Ret string `json:"ret"`
+	Msg string `json:"msg"`
+}
+
+var myPasswordResetTtl = time.Duration(util.MyConfig.GetInt("password_reset_token_minutes", 30)) * time.Minute
+
+func passwordResetMail(email string, token string) mail.Message {
+	link := util.MyConfig["password_reset_url"] + token
+	body := "Hello,\n\n" +
+		"Someone (hopefully you) asked to reset the password of your account " + email + ".\n" +
+		"Reset the password using the link below within " + strconv.Itoa(int(myPasswordResetTtl.Minutes())) + " minutes:\n\n" +
+		link + "\n\n" +
+		"The link can be used only once. If you did not ask for a password reset, you can ignore this mail.\n"
+	return mail.Message{To: email, Subject: "Password reset", Body: body}
+}
+
+// NOTE: The response is the same whether the email is registered or not, so the API cannot be used to find out
+// which emails are registered. The requests use the login IP throttle, so the API cannot be used to flood mails.
+func postPasswordForgot(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var errorResponse ErrorResponse
+	var forgotData PasswordForgotData
+	var fieldErrors FieldErrors
+	ip := clientIp(request)
+	if retryAfter := myLoginThrottle.AllowIp(ip); retryAfter > 0 {
+		errorResponse = createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many requests from "+ip, retryAfter)
+	} else {
+		fieldErrors, errorResponse = bindJson(writer, request, &forgotData)
+	}
+	if !errorResponse.Flag {
+		email, errors := validateEmail(forgotData.Email)
+		for _, msg := range errors {
+			fieldErrors.add("email", msg)
+		}
+		if len(fieldErrors) > 0 {
+			errorResponse = createValidationErrorResponse(fieldErrors)
+		} else {
+			token, ok, err := userdb.CreatePasswordResetToken(request.Context(), email, myPasswordResetTtl)
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, "Couldn't create password reset token: "+err.Error())
+			} else {
+				if ok {
+					auditEvent(request, email, "PASSWORD_RESET_REQUESTED", email, audit.Success, "token valid: "+myPasswordResetTtl.String())
+					// NOTE: Sent in the background and failures are not reported to the client, since waiting for the
+					// mail server or its errors would reveal that the email is registered.
+					mail.SendInBackground(request.Context(), passwordResetMail(email, token))
+				}
+				encoder := json.NewEncoder(writer)
+				encoder.SetEscapeHTML(false)
+				err := encoder.Encode(PasswordResponse{"ok", "If the email is registered, a password reset link has been sent to it"})
+				if err != nil {
+					errorResponse = createErrorResponse(INTERNAL, err.Error())
+				}
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+// Resetting the password revokes all sessions of the user and clears the failed logins.
+func postPasswordReset(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var errorResponse ErrorResponse
+	var resetData PasswordResetData
+	var fieldErrors FieldErrors
+	ip := clientIp(request)
+	if retryAfter := myLoginThrottle.AllowIp(ip); retryAfter > 0 {
+		errorResponse = createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many requests from "+ip, retryAfter)
+	} else {
+		fieldErrors, errorResponse = bindJson(writer, request, &resetData)
+	}
+	if !errorResponse.Flag {
+		if resetData.Token == "" {
+			fieldErrors.add("token", "is required")
+		}
+		if resetData.NewPassword == "" {
+			fieldErrors.add("new-password", "is required")
+		} else {
+			for _, msg := range myPasswordPolicy.Validate(resetData.NewPassword) {
+				fieldErrors.add("new-password", msg)
+			}
+		}
+		if len(fieldErrors) > 0 {
+			errorResponse = createValidationErrorResponse(fieldErrors)
+		} else {
+			user, err := userdb.ResetPassword(request.Context(), resetData.Token, resetData.NewPassword)
+			if _, ok := err.(userdb.InvalidTokenError); ok {
+				auditEvent(request, "", "PASSWORD_RESET", "", audit.Failure, "invalid or expired token")
+				fieldErrors.add("token", "is invalid or expired")
+				errorResponse = createValidationErrorResponse(fieldErrors)
+			} else if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, err.Error())
+			} else {
+				revoked := RevokeSessions(user.Email, "")
+				myLoginThrottle.RecordSuccess(normalizeEmail(user.Email))
+				auditEvent(request, user.Email, "PASSWORD_RESET", user.Email, audit.Success, "revoked sessions: "+strconv.Itoa(revoked))
+				encoder := json.NewEncoder(writer)
+				encoder.SetEscapeHTML(false)
+				err := encoder.Encode(PasswordResponse{"ok", "Password has been reset, please log in"})
+				if err != nil {
+					errorResponse = createErrorResponse(INTERNAL, err.Error())
+				}
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
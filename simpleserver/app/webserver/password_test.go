@@ -0,0 +1,140 @@
+package webserver
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/mail"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Replaces the mailer with a file mailer writing to a temporary outbox.
+func useTestOutbox(t *testing.T) *mail.FileMailer {
+	mailer, err := mail.NewFileMailer(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %s", err.Error())
+	}
+	previous := mail.SetMailer(mailer)
+	t.Cleanup(func() { mail.SetMailer(previous) })
+	return mailer
+}
+
+// Returns the mails in the outbox once the mails being sent in the background have been sent.
+func sentMails(t *testing.T, outbox *mail.FileMailer) []mail.Message {
+	mail.Wait()
+	messages, err := outbox.Messages()
+	if err != nil {
+		t.Fatalf("Reading outbox failed: %s", err.Error())
+	}
+	return messages
+}
+
+// Returns the token from the link in the latest mail to the email.
+func tokenFromOutbox(t *testing.T, outbox *mail.FileMailer, email string, linkPrefix string) string {
+	messages := sentMails(t, outbox)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To == email {
+			start := strings.Index(messages[i].Body, linkPrefix)
+			if start == -1 {
+				t.Fatalf("Link not found in mail: %s", messages[i].Body)
+			}
+			return strings.Fields(messages[i].Body[start+len(linkPrefix):])[0]
+		}
+	}
+	t.Fatalf("No mail to %s in outbox", email)
+	return ""
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	myLoginThrottle.config.IpBurst = 100
+	defer func() { myLoginThrottle = previous }()
+	outbox := useTestOutbox(t)
+	email := "password.reset.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Reset", "Test", "ForgottenPassword")
+	oldToken := loginTestToken(t, email, "ForgottenPassword")
+	recorder, responseMap := doAuthorizedRequest(postPasswordForgot, "", "POST", "/password/forgot", `{"email":"Password.Reset.Test@foo.com"}`)
+	if recorder.Code != http.StatusOK || responseMap["ret"] != "ok" {
+		t.Fatalf("Forgot password failed: %d, %v", recorder.Code, responseMap)
+	}
+	messages := sentMails(t, outbox)
+	if len(messages) != 1 || messages[0].To != email || messages[0].Subject != "Password reset" {
+		t.Fatalf("Reset mail should have been sent to %s, got: %v", email, messages)
+	}
+	token := tokenFromOutbox(t, outbox, email, util.MyConfig["password_reset_url"])
+	// Weak password does not consume the token.
+	recorder, responseMap = doAuthorizedRequest(postPasswordReset, "", "POST", "/password/reset", `{"token":"`+token+`","new-password":"weak"}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Weak password should have been rejected, got: %d, %v", recorder.Code, responseMap)
+	}
+	recorder, responseMap = doAuthorizedRequest(postPasswordReset, "", "POST", "/password/reset", `{"token":"`+token+`","new-password":"RememberedPassword"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Reset password failed: %d, %v", recorder.Code, responseMap)
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), oldToken); err == nil {
+		t.Errorf("Reset should have revoked the old sessions")
+	}
+	loginTestToken(t, email, "RememberedPassword")
+	recorder, responseMap = doAuthorizedRequest(postPasswordReset, "", "POST", "/password/reset", `{"token":"`+token+`","new-password":"AnotherPassword"}`)
+	fields, _ := responseMap["fields"].(map[string]interface{})
+	if recorder.Code != http.StatusBadRequest || fields["token"] == nil {
+		t.Errorf("Used token should have been rejected, got: %d, %v", recorder.Code, responseMap)
+	}
+}
+
+func TestPasswordForgotUnknownEmail(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	outbox := useTestOutbox(t)
+	known, knownMap := doAuthorizedRequest(postPasswordForgot, "", "POST", "/password/forgot", `{"email":"kari.karttinen@foo.com"}`)
+	unknown, unknownMap := doAuthorizedRequest(postPasswordForgot, "", "POST", "/password/forgot", `{"email":"not.found@foo.com"}`)
+	if known.Code != http.StatusOK || unknown.Code != http.StatusOK || knownMap["msg"] != unknownMap["msg"] {
+		t.Errorf("Known and unknown emails should have got the same response, got: %v, %v", knownMap, unknownMap)
+	}
+	if messages := sentMails(t, outbox); len(messages) != 1 || messages[0].To != "kari.karttinen@foo.com" {
+		t.Errorf("Only the known email should have got a mail, got: %v", messages)
+	}
+	if recorder, _ := doAuthorizedRequest(postPasswordForgot, "", "POST", "/password/forgot", `{"email":"not an email"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Invalid email should have been rejected, got: %d", recorder.Code)
+	}
+	// IP throttle: testThrottleConfig.IpBurst requests are allowed.
+	var recorder = known
+	for i := 0; i < testThrottleConfig.IpBurst; i++ {
+		recorder, _ = doAuthorizedRequest(postPasswordForgot, "", "POST", "/password/forgot", `{"email":"not.found@foo.com"}`)
+	}
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Requests should have been throttled, got: %d", recorder.Code)
+	}
+}
+
+// A mailer that blocks until released, like a slow mail server.
+type blockingMailer struct {
+	release chan bool
+}
+
+func (mailer blockingMailer) Send(ctx context.Context, message mail.Message) error {
+	<-mailer.release
+	return nil
+}
+
+func TestPasswordForgotDoesNotWaitForMail(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	mailer := blockingMailer{make(chan bool)}
+	previousMailer := mail.SetMailer(mailer)
+	defer mail.SetMailer(previousMailer)
+	// NOTE: The response would never come if the handler waited for the mail.
+	if recorder, responseMap := doAuthorizedRequest(postPasswordForgot, "", "POST", "/password/forgot", `{"email":"kari.karttinen@foo.com"}`); recorder.Code != http.StatusOK {
+		t.Errorf("Forgot password failed: %d, %v", recorder.Code, responseMap)
+	}
+	close(mailer.release)
+	mail.Wait()
+}
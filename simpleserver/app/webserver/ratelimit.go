@@ -0,0 +1,179 @@
+package webserver
+
+import (
+	"errors"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting of the authenticated API routes, per user (the email in the token) and per client IP.
+// The limits are configured per route in the properties file, e.g.:
+// rate_limit.product-groups.user=120/1m
+// rate_limit.product-groups.ip=300/1m
+// A missing limit means no limit.
+
+// RateLimitStore keeps the request counters. Fixed-window counters are easy to implement
+// in a shared store too (e.g. Redis INCR + EXPIRE), so the in-memory store can be replaced later.
+type RateLimitStore interface {
+	// Increments the counter of the key in its current window and returns the count so far
+	// and when the window resets. A new window starts if the previous one has expired.
+	Increment(key string, window time.Duration, now time.Time) (count int, reset time.Time, err error)
+}
+
+type rateLimitWindow struct {
+	count int
+	reset time.Time
+}
+
+// MemoryRateLimitStore is a RateLimitStore for a single server instance.
+type MemoryRateLimitStore struct {
+	mutex      sync.Mutex
+	windows    map[string]*rateLimitWindow
+	operations int
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{windows: make(map[string]*rateLimitWindow)}
+}
+
+func (store *MemoryRateLimitStore) Increment(key string, window time.Duration, now time.Time) (count int, reset time.Time, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.operations++
+	// Remove the expired windows every now and then so that the map does not grow forever.
+	if store.operations%1000 == 0 {
+		for oldKey, oldWindow := range store.windows {
+			if !now.Before(oldWindow.reset) {
+				delete(store.windows, oldKey)
+			}
+		}
+	}
+	current, ok := store.windows[key]
+	if !ok || !now.Before(current.reset) {
+		current = &rateLimitWindow{0, now.Add(window)}
+		store.windows[key] = current
+	}
+	current.count++
+	return current.count, current.reset, nil
+}
+
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Parses limit like "120/1m", i.e. 120 requests per minute.
+func ParseRateLimit(value string) (rateLimit RateLimit, err error) {
+	parts := strings.Split(strings.TrimSpace(value), "/")
+	if len(parts) != 2 {
+		return rateLimit, errors.New("Rate limit must be in format <limit>/<window>, e.g. 120/1m, got: " + value)
+	}
+	rateLimit.Limit, err = strconv.Atoi(parts[0])
+	if err == nil {
+		rateLimit.Window, err = time.ParseDuration(parts[1])
+	}
+	if err == nil && (rateLimit.Limit < 1 || rateLimit.Window <= 0) {
+		err = errors.New("Rate limit and window must be positive, got: " + value)
+	}
+	return rateLimit, err
+}
+
+type RouteRateLimits struct {
+	User *RateLimit
+	Ip   *RateLimit
+}
+
+func loadRouteRateLimits(route string) (limits RouteRateLimits) {
+	defer util.LogEnter().Exit()
+	for _, kind := range []string{"user", "ip"} {
+		key := "rate_limit." + route + "." + kind
+		value, ok := util.MyConfig[key]
+		if !ok || value == "" {
+			continue
+		}
+		rateLimit, err := ParseRateLimit(value)
+		if err != nil {
+			util.LogError("Ignoring " + key + ": " + err.Error())
+			continue
+		}
+		if kind == "user" {
+			limits.User = &rateLimit
+		} else {
+			limits.Ip = &rateLimit
+		}
+	}
+	return limits
+}
+
+// Rate limit store singleton.
+var myRateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+
+// Result of the most restrictive limit checked so far.
+type rateLimitStatus struct {
+	limit     int
+	remaining int
+	reset     time.Time
+	exceeded  bool
+}
+
+func checkRateLimit(key string, rateLimit *RateLimit, status *rateLimitStatus) {
+	count, reset, err := myRateLimitStore.Increment(key, rateLimit.Window, time.Now())
+	if err != nil {
+		// NOTE: Fail open: a broken shared store must not take the whole API down.
+		util.LogError("Rate limit store failed: " + err.Error())
+		return
+	}
+	remaining := rateLimit.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	if status.limit == 0 || remaining < status.remaining {
+		status.limit = rateLimit.Limit
+		status.remaining = remaining
+		status.reset = reset
+	}
+	if count > rateLimit.Limit {
+		status.exceeded = true
+	}
+}
+
+// Wraps the handler with the rate limits configured for the route.
+// The IP limit is checked first so that floods are rejected before the token is validated.
+func rateLimited(route string, handler http.HandlerFunc) http.HandlerFunc {
+	limits := loadRouteRateLimits(route)
+	return func(writer http.ResponseWriter, request *http.Request) {
+		defer util.LogEnter().Exit()
+		if request.Method == "OPTIONS" || (limits.User == nil && limits.Ip == nil) {
+			handler(writer, request)
+			return
+		}
+		var status rateLimitStatus
+		if limits.Ip != nil {
+			checkRateLimit(route+"|ip|"+clientIp(request), limits.Ip, &status)
+		}
+		if limits.User != nil && !status.exceeded {
+			// NOTE: Invalid tokens are not counted here, the handler rejects them anyway.
+			tokenResponse, errorResponse := validateToken(request)
+			if !errorResponse.Flag {
+				request = request.WithContext(contextWithToken(request.Context(), tokenResponse))
+				checkRateLimit(route+"|user|"+tokenResponse.Email, limits.User, &status)
+			}
+		}
+		if status.limit > 0 {
+			writer.Header().Set("X-RateLimit-Limit", strconv.Itoa(status.limit))
+			writer.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.remaining))
+			writer.Header().Set("X-RateLimit-Reset", strconv.FormatInt(status.reset.Unix(), 10))
+		}
+		if status.exceeded {
+			writeHeaders(writer)
+			errorResponse := createThrottledErrorResponse(TOO_MANY_REQUESTS, "Rate limit exceeded for "+route, time.Until(status.reset))
+			writeError(writer, request, errorResponse)
+			return
+		}
+		handler(writer, request)
+	}
+}
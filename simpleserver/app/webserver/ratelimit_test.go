@@ -0,0 +1,106 @@
+package webserver
+
+import (
+	"encoding/base64"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	defer util.LogEnter().Exit()
+	rateLimit, err := ParseRateLimit("120/1m")
+	if err != nil || rateLimit.Limit != 120 || rateLimit.Window != time.Minute {
+		t.Errorf("Parsing 120/1m failed, got: %v, err: %v", rateLimit, err)
+	}
+	for _, value := range []string{"", "120", "x/1m", "120/x", "0/1m", "10/0s", "1/2/3"} {
+		if _, err := ParseRateLimit(value); err == nil {
+			t.Errorf("Parsing '%s' should have failed", value)
+		}
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	defer util.LogEnter().Exit()
+	store := NewMemoryRateLimitStore()
+	now := time.Date(2018, 11, 6, 20, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		count, reset, _ := store.Increment("a", time.Minute, now.Add(time.Duration(i)*time.Second))
+		if count != i || !reset.Equal(now.Add(61*time.Second)) {
+			t.Errorf("Increment %d: wrong count or reset, got: %d, %s", i, count, reset)
+		}
+	}
+	if count, _, _ := store.Increment("b", time.Minute, now); count != 1 {
+		t.Errorf("Keys should have separate counters, got: %d", count)
+	}
+	// The window of "a" started at 20:00:01.
+	count, reset, _ := store.Increment("a", time.Minute, now.Add(61*time.Second))
+	if count != 1 || !reset.Equal(now.Add(121*time.Second)) {
+		t.Errorf("A new window should have started, got: %d, %s", count, reset)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	defer util.LogEnter().Exit()
+	util.MyConfig["rate_limit.test-route.user"] = "2/1m"
+	util.MyConfig["rate_limit.test-route.ip"] = "3/1m"
+	previous := myRateLimitStore
+	myRateLimitStore = NewMemoryRateLimitStore()
+	defer func() {
+		delete(util.MyConfig, "rate_limit.test-route.user")
+		delete(util.MyConfig, "rate_limit.test-route.ip")
+		myRateLimitStore = previous
+	}()
+	handler := rateLimited("test-route", func(writer http.ResponseWriter, request *http.Request) {
+		email, errorResponse := isValidToken(request)
+		if errorResponse.Flag {
+			writeError(writer, request, errorResponse)
+			return
+		}
+		writer.Write([]byte(email))
+	})
+	doRequest := func(email string, ip string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/test-route", nil)
+		request.RemoteAddr = ip + ":12345"
+		if email != "" {
+			token, _ := CreateJsonWebToken(email, []string{userdb.RoleCustomer})
+			request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+	// Per user limit: the third request of the same user is rejected.
+	for i, expectedRemaining := range []string{"1", "0"} {
+		recorder := doRequest("kari.karttinen@foo.com", "10.0.0.1")
+		if recorder.Code != http.StatusOK || recorder.Body.String() != "kari.karttinen@foo.com" {
+			t.Errorf("Request %d should have been passed to the handler, got: %d, %s", i+1, recorder.Code, recorder.Body.String())
+		}
+		if recorder.Header().Get("X-RateLimit-Limit") != "2" || recorder.Header().Get("X-RateLimit-Remaining") != expectedRemaining {
+			t.Errorf("Request %d: wrong rate limit headers: %v", i+1, recorder.Header())
+		}
+		reset, _ := strconv.ParseInt(recorder.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if reset < time.Now().Unix() {
+			t.Errorf("Request %d: X-RateLimit-Reset should be in the future, got: %d", i+1, reset)
+		}
+	}
+	recorder := doRequest("kari.karttinen@foo.com", "10.0.0.2")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("User limit should have been exceeded, got: %d, headers: %v", recorder.Code, recorder.Header())
+	}
+	// Another user from the first IP gets one more request before the IP limit.
+	if recorder = doRequest("timo.tillinen@foo.com", "10.0.0.1"); recorder.Code != http.StatusOK {
+		t.Errorf("Other user should not have been limited, got: %d", recorder.Code)
+	}
+	if recorder = doRequest("timo.tillinen@foo.com", "10.0.0.1"); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("IP limit should have been exceeded, got: %d", recorder.Code)
+	}
+	// Requests without a token are limited only per IP and rejected by the handler.
+	if recorder = doRequest("", "10.0.0.3"); recorder.Code != http.StatusUnauthorized || recorder.Header().Get("X-RateLimit-Limit") != "3" {
+		t.Errorf("Request without token should be counted per IP, got: %d, headers: %v", recorder.Code, recorder.Header())
+	}
+}
@@ -4,17 +4,25 @@
 package webserver
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
 	"github.com/karimarttila/go/simpleserver/app/domaindb"
 	"github.com/karimarttila/go/simpleserver/app/userdb"
 	"github.com/karimarttila/go/simpleserver/app/util"
-	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// The products are served from this store, given to StartServer.
+var myProductStore domaindb.ProductStore
+
 type InfoMessage struct {
 	Info string `json:"info"`
 }
@@ -31,65 +39,12 @@ type LoginData struct {
 	Password string `json:"password"`
 }
 
-// Using ErrorResponder interface we can make error handling generic for all API calls.
-type ErrorResponder interface {
-	GetFlag() bool
-	GetMsg() string
-	WriteError(writer http.ResponseWriter) (err error)
-}
-
-// Used by all ErrorResponder entities.
-func getEncoder(writer http.ResponseWriter) (encoder *json.Encoder) {
-	encoder = json.NewEncoder(writer)
-	encoder.SetEscapeHTML(false)
-	return encoder
-}
-
-// ErrorResponse is the base struct for all web layer Error response entities.
-type ErrorResponse struct {
-	Flag bool   `json:"-"` // Just to tell the whether we have initialized this struct or not (zero-value for bool is false, i.e. if the value is ready we know that we have initialized the struct).
-	Ret  string `json:"ret"`
-	Msg  string `json:"msg"`
-}
-
-func (e ErrorResponse) GetFlag() bool {
-	return e.Flag
-}
-
-func (e ErrorResponse) GetMsg() string {
-	return e.Msg
-}
-
-func (e ErrorResponse) WriteError(writer http.ResponseWriter) (err error) {
-	encoder := getEncoder(writer)
-	err = encoder.Encode(e)
-	return err
-}
-
-// SigninErrorResponse is the /signin API error response entity.
-type SigninErrorResponse struct {
-	ErrorResponse
-	Email string `json:"email"`
-}
-
-func (e SigninErrorResponse) GetFlag() bool {
-	return e.Flag
-}
-
-func (e SigninErrorResponse) GetMsg() string {
-	return e.Msg
-}
-
-func (e SigninErrorResponse) WriteError(writer http.ResponseWriter) (err error) {
-	encoder := getEncoder(writer)
-	err = encoder.Encode(e)
-	return err
-}
-
 type SigninResponse struct {
 	Flag  bool   `json:"-"`
 	Ret   string `json:"ret"`
 	Email string `json:"email"`
+	// The user has to verify the email before logging in, see verification.go.
+	VerificationRequired bool `json:"verification-required,omitempty"`
 }
 
 type LoginResponse struct {
@@ -99,51 +54,17 @@ type LoginResponse struct {
 	JsonWebToken string `json:"json-web-token"`
 }
 
-func writeError(writer http.ResponseWriter, errorResponder ErrorResponder) {
-	util.LogEnter()
-	// NOTE: StatusOK is implicitely written first time writer.Write is called
-	// unless other status code set.
-	writer.WriteHeader(http.StatusBadRequest)
-	err := errorResponder.WriteError(writer)
-	if err != nil {
-		// Everything else failed, just write the json as string to http.ResponseWriter.
-		writer.Write([]byte(`{"ret":"failed","msg":"A total failure, original error: ` + errorResponder.GetMsg() + `"}`))
-	}
-	util.LogExit()
-}
-
-func createErrorResponse(msg string) (errorResponse ErrorResponse) {
-	util.LogEnter()
-	ret := &ErrorResponse{true, "failed", msg}
-	util.LogError(ret.GetMsg())
-	errorResponse = *ret
-	util.LogExit()
-	return errorResponse
-}
-
-// TODO: it would be nice to make this generic as well.
-func createSigninErrorResponse(msg string, email string) (signinErrorResponse SigninErrorResponse) {
-	util.LogEnter()
-	ret := &SigninErrorResponse{
-		ErrorResponse: ErrorResponse{true, "failed", msg},
-		Email:         email,
-	}
-	util.LogError(ret.GetMsg())
-	signinErrorResponse = *ret
-	util.LogExit()
-	return signinErrorResponse
-}
-
 func writeHeaders(writer http.ResponseWriter) {
 	writer.Header().Set("Content-Type", "application/json")
 	writer.Header().Set("Access-Control-Allow-Origin", "*")
 	writer.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 
 }
 
 // /info API.
 func getInfo(writer http.ResponseWriter, request *http.Request) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
 	writeHeaders(writer)
 	var errorResponse ErrorResponse // Generic ErrorResponse will do for /info just fine.
 	infoMsg := &InfoMessage{Info: "index.html => Info in HTML format"}
@@ -151,17 +72,16 @@ func getInfo(writer http.ResponseWriter, request *http.Request) {
 	encoder.SetEscapeHTML(false)
 	err := encoder.Encode(infoMsg)
 	if err != nil {
-		errorResponse = createErrorResponse("JSON encoder returned error: " + err.Error())
+		errorResponse = createErrorResponse(INTERNAL, "JSON encoder returned error: "+err.Error())
 	}
 
 	if errorResponse.Flag {
-		writeError(writer, errorResponse)
+		writeError(writer, request, errorResponse)
 	}
-	util.LogExit()
 }
 
 func postSignin(writer http.ResponseWriter, request *http.Request) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
 	writeHeaders(writer)
 	if request.Method == "OPTIONS" {
 		return
@@ -169,38 +89,43 @@ func postSignin(writer http.ResponseWriter, request *http.Request) {
 	var signinErrorResponse SigninErrorResponse
 	var signinData SigninData
 	var signinResponse SigninResponse
-	decoder := json.NewDecoder(request.Body)
-	err := decoder.Decode(&signinData)
-	if err != nil {
-		signinErrorResponse = createSigninErrorResponse("Decoding request body failed", "")
+	fieldErrors, errorResponse := bindJson(writer, request, &signinData)
+	if errorResponse.Flag {
+		signinErrorResponse = SigninErrorResponse{errorResponse, signinData.Email}
 	} else {
-		if signinData.FirstName == "" || signinData.LastName == "" || signinData.Email == "" || signinData.Password == "" {
-			signinErrorResponse = createSigninErrorResponse("Validation failed - some fields were empty", "")
+		validateSigninData(&signinData, fieldErrors)
+		if len(fieldErrors) > 0 {
+			signinErrorResponse = SigninErrorResponse{createValidationErrorResponse(fieldErrors), signinData.Email}
 		} else {
-			var ret userdb.AddUserResponse
-			ret, err = userdb.AddUser(signinData.Email, signinData.FirstName, signinData.LastName, signinData.Password)
-			if err != nil {
-				signinErrorResponse = createSigninErrorResponse(err.Error(), signinData.Email)
+			ret, err := userdb.AddUser(request.Context(), signinData.Email, signinData.FirstName, signinData.LastName, signinData.Password)
+			if _, ok := err.(userdb.EmailExistsError); ok {
+				auditEvent(request, signinData.Email, "SIGNIN", signinData.Email, audit.Failure, "email already exists")
+				signinErrorResponse = createSigninErrorResponse(ALREADY_EXISTS, err.Error(), signinData.Email)
+			} else if err != nil {
+				signinErrorResponse = createSigninErrorResponse(INTERNAL, err.Error(), signinData.Email)
 			} else {
 				util.LogTrace("AddUser returned: Ret: " + ret.Ret + ", Email: " + ret.Email)
-				signinResponse = SigninResponse{true, "ok", signinData.Email}
+				auditEvent(request, signinData.Email, "SIGNIN", signinData.Email, audit.Success, "")
+				if myEmailVerificationRequired {
+					sendVerificationMail(request.Context(), signinData.Email)
+				}
+				signinResponse = SigninResponse{true, "ok", signinData.Email, myEmailVerificationRequired}
 				encoder := json.NewEncoder(writer)
 				encoder.SetEscapeHTML(false)
 				err := encoder.Encode(signinResponse)
 				if err != nil {
-					signinErrorResponse = createSigninErrorResponse(err.Error(), signinData.Email)
+					signinErrorResponse = createSigninErrorResponse(INTERNAL, err.Error(), signinData.Email)
 				}
 			}
 		}
 	}
 	if signinErrorResponse.Flag {
-		writeError(writer, signinErrorResponse)
+		writeError(writer, request, signinErrorResponse)
 	}
-	util.LogExit()
 }
 
 func postLogin(writer http.ResponseWriter, request *http.Request) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
 	writeHeaders(writer)
 	if request.Method == "OPTIONS" {
 		return
@@ -209,50 +134,93 @@ func postLogin(writer http.ResponseWriter, request *http.Request) {
 	var loginData LoginData
 	var loginResponse LoginResponse
 	var jsonWebToken string
-	decoder := json.NewDecoder(request.Body)
-	err := decoder.Decode(&loginData)
-	if err != nil {
-		errorResponse = createErrorResponse("Decoding request body failed")
+	var fieldErrors FieldErrors
+	ip := clientIp(request)
+	if retryAfter := myLoginThrottle.AllowIp(ip); retryAfter > 0 {
+		errorResponse = createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many login attempts from "+ip, retryAfter)
 	} else {
-		if loginData.Email == "" || loginData.Password == "" {
-			errorResponse = createErrorResponse("Validation failed - some fields were empty")
+		fieldErrors, errorResponse = bindJson(writer, request, &loginData)
+	}
+	throttleKey := normalizeEmail(loginData.Email)
+	if !errorResponse.Flag {
+		if len(fieldErrors) > 0 {
+			errorResponse = createValidationErrorResponse(fieldErrors)
+		} else if loginData.Email == "" || loginData.Password == "" {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "Validation failed - some fields were empty")
+		} else if retryAfter, locked := myLoginThrottle.AllowEmail(throttleKey); locked {
+			auditEvent(request, loginData.Email, "LOGIN", loginData.Email, audit.Denied, "account locked")
+			errorResponse = createThrottledErrorResponse(ACCOUNT_LOCKED, "Account is locked because of too many failed logins, try again later", retryAfter)
+		} else if retryAfter > 0 {
+			auditEvent(request, loginData.Email, "LOGIN", loginData.Email, audit.Denied, "throttled")
+			errorResponse = createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many failed logins, try again later", retryAfter)
 		} else {
-			credentialsOk := userdb.CheckCredentials(loginData.Email, loginData.Password)
-			if !credentialsOk {
-				errorResponse = createErrorResponse("Credentials are not good - either email or password is not correct")
+			user, credentialsOk, err := userdb.CheckCredentials(request.Context(), loginData.Email, loginData.Password)
+			if err != nil {
+				// NOTE: A failure of the user store is not a failed login, otherwise an outage would lock out the users.
+				errorResponse = createErrorResponse(INTERNAL, "Couldn't check credentials: "+err.Error())
+			} else if !credentialsOk {
+				myLoginThrottle.RecordFailure(throttleKey, ip)
+				auditEvent(request, loginData.Email, "LOGIN", loginData.Email, audit.Failure, "invalid credentials")
+				errorResponse = createErrorResponse(INVALID_CREDENTIALS, "Credentials are not good - either email or password is not correct")
 			} else {
-				jsonWebToken, err = CreateJsonWebToken(loginData.Email)
-				if err != nil {
-					errorResponse = createErrorResponse("Couldn't create token: " + err.Error())
+				myLoginThrottle.RecordSuccess(throttleKey)
+				if user.Disabled {
+					auditEvent(request, user.Email, "LOGIN", user.Email, audit.Denied, "account disabled")
+					errorResponse = createErrorResponse(ACCOUNT_DISABLED, "Account is disabled")
+				} else if myEmailVerificationRequired && !user.EmailVerified {
+					auditEvent(request, user.Email, "LOGIN", user.Email, audit.Denied, "email not verified")
+					errorResponse = createErrorResponse(EMAIL_NOT_VERIFIED, "Email is not verified, please open the link in the verification mail")
+				} else if user.PasswordResetRequired {
+					auditEvent(request, user.Email, "LOGIN", user.Email, audit.Denied, "password reset required")
+					errorResponse = createErrorResponse(PASSWORD_RESET_REQUIRED, "Password has to be reset before logging in")
 				} else {
-					loginResponse = LoginResponse{true, "ok", "Credentials ok", jsonWebToken}
-					encoder := json.NewEncoder(writer)
-					encoder.SetEscapeHTML(false)
-					err := encoder.Encode(loginResponse)
+					// NOTE: The stored email, since the login email may differ in case, and sessions are revoked by the stored email.
+					jsonWebToken, err = CreateJsonWebToken(user.Email, user.Roles)
 					if err != nil {
-						errorResponse = createErrorResponse(err.Error())
+						errorResponse = createErrorResponse(INTERNAL, "Couldn't create token: "+err.Error())
+					} else {
+						auditEvent(request, user.Email, "LOGIN", user.Email, audit.Success, "")
+						loginResponse = LoginResponse{true, "ok", "Credentials ok", jsonWebToken}
+						encoder := json.NewEncoder(writer)
+						encoder.SetEscapeHTML(false)
+						err := encoder.Encode(loginResponse)
+						if err != nil {
+							errorResponse = createErrorResponse(INTERNAL, err.Error())
+						}
 					}
 				}
 			}
 		}
 	}
 	if errorResponse.Flag {
-		writeError(writer, errorResponse)
+		writeError(writer, request, errorResponse)
 	}
-	util.LogExit()
 }
 
 func isValidToken(request *http.Request) (email string, errorResponse ErrorResponse) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
+	tokenResponse, errorResponse := validateToken(request)
+	return tokenResponse.Email, errorResponse
+}
+
+// Validates the token in the Authorization header. A token already validated by
+// a middleware is taken from the request context instead of validating it again.
+func validateToken(request *http.Request) (tokenResponse TokenResponse, errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
 	auth := request.Header.Get("Authorization")
-	if auth == "" {
-		errorResponse = createErrorResponse("Authorization not found in the header parameters")
+	var ok bool
+	if tokenResponse, ok = tokenFromContext(request.Context()); ok {
+		util.LogTrace("Token already validated, email: " + tokenResponse.Email)
+	} else if auth == "" {
+		errorResponse = createErrorResponse(INVALID_TOKEN, "Authorization not found in the header parameters")
+	} else if len(auth) < 6 {
+		errorResponse = createErrorResponse(INVALID_TOKEN, "Authorization header is not in format: Basic <token>")
 	} else {
 		util.LogTrace("Got auth: " + auth)
 		authRest := auth[6:] // Get rid of "Basic "
 		decodedBytes, err := base64.StdEncoding.DecodeString(authRest)
 		if err != nil {
-			errorResponse = createErrorResponse("Couldn't base64 decode auth string: " + err.Error())
+			errorResponse = createErrorResponse(INVALID_TOKEN, "Couldn't base64 decode auth string: "+err.Error())
 		} else {
 			decoded := string(decodedBytes)
 			util.LogTrace("decoded: " + decoded)
@@ -264,22 +232,21 @@ func isValidToken(request *http.Request) (email string, errorResponse ErrorRespo
 				token = decoded[0:index]
 			}
 			util.LogTrace("token: " + token)
-			var tokenResponse TokenResponse
-			tokenResponse, err = ValidateJsonWebToken(token)
-			if err != nil {
-				errorResponse = createErrorResponse("Couldn't validate token: " + err.Error())
+			tokenResponse, err = ValidateJsonWebToken(request.Context(), token)
+			if isTokenExpired(err) {
+				errorResponse = createErrorResponse(TOKEN_EXPIRED, "Token expired: "+err.Error())
+			} else if err != nil {
+				errorResponse = createErrorResponse(INVALID_TOKEN, "Couldn't validate token: "+err.Error())
 			} else {
 				util.LogTrace("tokenResponse.email: " + tokenResponse.Email)
-				email = tokenResponse.Email
 			}
 		}
 	}
-	util.LogExit()
-	return email, errorResponse
+	return tokenResponse, errorResponse
 }
 
 func getProductGroups(writer http.ResponseWriter, request *http.Request) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
 	writeHeaders(writer)
 	if request.Method == "OPTIONS" {
 		return
@@ -288,22 +255,26 @@ func getProductGroups(writer http.ResponseWriter, request *http.Request) {
 	var productGroups domaindb.ProductGroups
 	if !errorResponse.Flag {
 		util.LogTrace("parsedEmail from token: " + parsedEmail)
-		productGroups = domaindb.GetProductGroups()
-		encoder := json.NewEncoder(writer)
-		encoder.SetEscapeHTML(false)
-		err := encoder.Encode(productGroups)
+		var err error
+		productGroups, err = myProductStore.GetProductGroups(request.Context())
 		if err != nil {
-			errorResponse = createErrorResponse(err.Error())
+			errorResponse = createErrorResponse(INTERNAL, "Couldn't get product groups: "+err.Error())
+		} else {
+			encoder := json.NewEncoder(writer)
+			encoder.SetEscapeHTML(false)
+			err := encoder.Encode(productGroups)
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, err.Error())
+			}
 		}
 	}
 	if errorResponse.Flag {
-		writeError(writer, errorResponse)
+		writeError(writer, request, errorResponse)
 	}
-	util.LogExit()
 }
 
 func getProducts(writer http.ResponseWriter, request *http.Request) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
 	writeHeaders(writer)
 	if request.Method == "OPTIONS" {
 		return
@@ -319,31 +290,54 @@ func getProducts(writer http.ResponseWriter, request *http.Request) {
 		// like: /products/1
 		pgIdStr := request.URL.Path[len("/products/"):]
 		if len(pgIdStr) < 1 {
-			errorResponse = createErrorResponse("pgId was less than 1")
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "pgId was less than 1")
 		} else {
 			pgId, err = strconv.Atoi(pgIdStr)
 			if err != nil {
-				errorResponse = createErrorResponse("pgId was not an integer")
+				errorResponse = createErrorResponse(VALIDATION_FAILED, "pgId was not an integer")
 			} else {
-				util.LogTrace("pgId: " + string(pgId))
-				products = domaindb.GetProducts(pgId)
-				encoder := json.NewEncoder(writer)
-				encoder.SetEscapeHTML(false)
-				err := encoder.Encode(products)
+				util.LogTrace("pgId: " + strconv.Itoa(pgId))
+				// like: /products/2?descendants=true, also the products of the product groups under it
+				if request.URL.Query().Get("descendants") == "true" {
+					products, err = domaindb.GetCategoryProducts(request.Context(), myProductStore, pgId)
+				} else {
+					products, err = myProductStore.GetProducts(request.Context(), pgId)
+				}
+				// NOTE: Zero-value Products (Ret is empty) means that there is no such product group.
 				if err != nil {
-					errorResponse = createErrorResponse(err.Error())
+					errorResponse = createErrorResponse(INTERNAL, "Couldn't get products: "+err.Error())
+				} else if products.Ret != "ok" {
+					errorResponse = createErrorResponse(NOT_FOUND, "Product group not found: "+pgIdStr)
+				} else {
+					encoder := json.NewEncoder(writer)
+					encoder.SetEscapeHTML(false)
+					err := encoder.Encode(products)
+					if err != nil {
+						errorResponse = createErrorResponse(INTERNAL, err.Error())
+					}
 				}
 			}
 		}
 	}
 	if errorResponse.Flag {
-		writeError(writer, errorResponse)
+		writeError(writer, request, errorResponse)
 	}
-	util.LogExit()
+}
+
+// The product of /product: the fields in the order of the schema of the product group in product, for the old clients,
+// and the attributes also by name in attributes, with the JSON types of the admin API (see ProductData).
+type ProductWithAttributes struct {
+	domaindb.Product
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// NOTE: If the product group was deleted in between, schema is nil and the attributes are empty.
+func newProductWithAttributes(product domaindb.Product, schema domaindb.Schema) ProductWithAttributes {
+	return ProductWithAttributes{product, newProductData(product.Product, schema).Attributes}
 }
 
 func getProduct(writer http.ResponseWriter, request *http.Request) {
-	util.LogEnter()
+	defer util.LogEnter().Exit()
 	writeHeaders(writer)
 	if request.Method == "OPTIONS" {
 		return
@@ -359,27 +353,38 @@ func getProduct(writer http.ResponseWriter, request *http.Request) {
 		// like: /product/1
 		idsStr := request.URL.Path[len("/product/"):]
 		if len(idsStr) < 1 {
-			errorResponse = createErrorResponse("idsStr was less than 1")
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "idsStr was less than 1")
 		} else {
 			ids := strings.Split(idsStr, "/")
 			if len(ids) != 2 {
-				errorResponse = createErrorResponse("We didn't find both product group id and product id in the url parameters")
+				errorResponse = createErrorResponse(VALIDATION_FAILED, "We didn't find both product group id and product id in the url parameters")
 			} else {
 				pgId, err = strconv.Atoi(ids[0])
 				if err != nil {
-					errorResponse = createErrorResponse("pgId was not an integer")
+					errorResponse = createErrorResponse(VALIDATION_FAILED, "pgId was not an integer")
 				} else {
 					pId, err = strconv.Atoi(ids[1])
 					if err != nil {
-						errorResponse = createErrorResponse("pId was not an integer")
+						errorResponse = createErrorResponse(VALIDATION_FAILED, "pId was not an integer")
 					} else {
-						util.LogTrace("pgId: " + string(pgId) + ", pId: " + string(pId))
-						product = domaindb.GetProduct(pgId, pId)
-						encoder := json.NewEncoder(writer)
-						encoder.SetEscapeHTML(false)
-						err := encoder.Encode(product)
+						util.LogTrace("pgId: " + strconv.Itoa(pgId) + ", pId: " + strconv.Itoa(pId))
+						product, err = myProductStore.GetProduct(request.Context(), pgId, pId)
+						var schema domaindb.ProductGroupSchema
+						if err == nil && len(product.Product) > 0 {
+							schema, err = myProductStore.GetSchema(request.Context(), pgId)
+						}
+						// NOTE: A product without fields means that the product was not found.
 						if err != nil {
-							errorResponse = createErrorResponse(err.Error())
+							errorResponse = createErrorResponse(INTERNAL, "Couldn't get product: "+err.Error())
+						} else if len(product.Product) == 0 {
+							errorResponse = createErrorResponse(NOT_FOUND, "Product not found: "+idsStr)
+						} else {
+							encoder := json.NewEncoder(writer)
+							encoder.SetEscapeHTML(false)
+							err := encoder.Encode(newProductWithAttributes(product, schema.Attributes))
+							if err != nil {
+								errorResponse = createErrorResponse(INTERNAL, err.Error())
+							}
 						}
 					}
 				}
@@ -387,29 +392,198 @@ func getProduct(writer http.ResponseWriter, request *http.Request) {
 		}
 	}
 	if errorResponse.Flag {
-		writeError(writer, errorResponse)
+		writeError(writer, request, errorResponse)
+	}
+}
+
+// The attributes of the products of the product group, i.e. the fields of /product after the id, product group id,
+// title and price.
+func getSchema(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var schema domaindb.ProductGroupSchema
+	parsedEmail, errorResponse := isValidToken(request)
+	util.LogTrace("parsedEmail: " + parsedEmail)
+	if !errorResponse.Flag {
+		// like: /schema/1
+		pgIdStr := request.URL.Path[len("/schema/"):]
+		pgId, err := strconv.Atoi(pgIdStr)
+		if err != nil {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "pgId was not an integer")
+		} else {
+			schema, err = myProductStore.GetSchema(request.Context(), pgId)
+			// NOTE: Empty Ret means that there is no such product group.
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, "Couldn't get schema: "+err.Error())
+			} else if schema.Ret != "ok" {
+				errorResponse = createErrorResponse(NOT_FOUND, "Product group not found: "+pgIdStr)
+			} else {
+				encoder := json.NewEncoder(writer)
+				encoder.SetEscapeHTML(false)
+				err := encoder.Encode(schema)
+				if err != nil {
+					errorResponse = createErrorResponse(INTERNAL, err.Error())
+				}
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+type CategoriesResponse struct {
+	Ret        string                  `json:"ret"`
+	Categories []domaindb.CategoryTree `json:"categories"`
+}
+
+type BreadcrumbResponse struct {
+	Ret        string              `json:"ret"`
+	PgId       int                 `json:"pg-id"`
+	PId        int                 `json:"p-id"`
+	Title      string              `json:"title"`
+	Breadcrumb []domaindb.Category `json:"breadcrumb"`
+}
+
+// The product groups as a tree of nested categories, see domaindb.CategoryTree. /product-groups has the same
+// product groups without the nesting.
+func getCategories(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	parsedEmail, errorResponse := isValidToken(request)
+	util.LogTrace("parsedEmail: " + parsedEmail)
+	if !errorResponse.Flag {
+		categories, err := myProductStore.GetCategories(request.Context())
+		if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, "Couldn't get categories: "+err.Error())
+		} else {
+			encoder := json.NewEncoder(writer)
+			encoder.SetEscapeHTML(false)
+			err := encoder.Encode(CategoriesResponse{"ok", categories.Tree()})
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, err.Error())
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+// The path of the product from the top level product group to its product group, e.g. Movies, Drama, Crime.
+func getBreadcrumb(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	parsedEmail, errorResponse := isValidToken(request)
+	util.LogTrace("parsedEmail: " + parsedEmail)
+	if !errorResponse.Flag {
+		// like: /breadcrumb/2/49
+		idsStr := request.URL.Path[len("/breadcrumb/"):]
+		ids := strings.Split(idsStr, "/")
+		var pgId, pId int
+		var err error
+		if len(ids) != 2 {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "We didn't find both product group id and product id in the url parameters")
+		} else if pgId, err = strconv.Atoi(ids[0]); err != nil {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "pgId was not an integer")
+		} else if pId, err = strconv.Atoi(ids[1]); err != nil {
+			errorResponse = createErrorResponse(VALIDATION_FAILED, "pId was not an integer")
+		} else {
+			var product domaindb.Product
+			var categories domaindb.Categories
+			product, err = myProductStore.GetProduct(request.Context(), pgId, pId)
+			if err == nil {
+				categories, err = myProductStore.GetCategories(request.Context())
+			}
+			// NOTE: A product without fields means that the product was not found.
+			breadcrumb := categories.Breadcrumb(pgId)
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, "Couldn't get breadcrumb: "+err.Error())
+			} else if len(product.Product) == 0 || len(breadcrumb) == 0 {
+				errorResponse = createErrorResponse(NOT_FOUND, "Product not found: "+idsStr)
+			} else {
+				encoder := json.NewEncoder(writer)
+				encoder.SetEscapeHTML(false)
+				err := encoder.Encode(BreadcrumbResponse{"ok", pgId, pId, product.Product[2], breadcrumb})
+				if err != nil {
+					errorResponse = createErrorResponse(INTERNAL, err.Error())
+				}
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
 	}
-	util.LogExit()
 }
 
 // Registers the API calls.
 func handleRequests() {
-	util.LogEnter()
-	http.HandleFunc("/info", getInfo)
-	http.HandleFunc("/signin", postSignin)
-	http.HandleFunc("/login", postLogin)
-	http.HandleFunc("/product-groups", getProductGroups)
-	http.HandleFunc("/products/", getProducts)
-	http.HandleFunc("/product/", getProduct)
+	defer util.LogEnter().Exit()
+	http.HandleFunc("/info", traced("/info", getInfo))
+	http.HandleFunc("/signin", traced("/signin", postSignin))
+	http.HandleFunc("/login", traced("/login", postLogin))
+	http.HandleFunc("/verify", traced("/verify", getVerify))
+	http.HandleFunc("/verify/resend", traced("/verify/resend", postVerifyResend))
+	http.HandleFunc("/password/forgot", traced("/password/forgot", postPasswordForgot))
+	http.HandleFunc("/password/reset", traced("/password/reset", postPasswordReset))
+	http.HandleFunc("/product-groups", traced("/product-groups", rateLimited("product-groups", authorized(getProductGroups, anyRole...))))
+	http.HandleFunc("/products/", traced("/products/", rateLimited("products", authorized(getProducts, anyRole...))))
+	http.HandleFunc("/product/", traced("/product/", rateLimited("product", authorized(getProduct, anyRole...))))
+	http.HandleFunc("/schema/", traced("/schema/", rateLimited("schema", authorized(getSchema, anyRole...))))
+	http.HandleFunc("/categories", traced("/categories", rateLimited("categories", authorized(getCategories, anyRole...))))
+	http.HandleFunc("/breadcrumb/", traced("/breadcrumb/", rateLimited("breadcrumb", authorized(getBreadcrumb, anyRole...))))
+	http.HandleFunc("/me", traced("/me", authorized(handleMe, anyRole...)))
+	http.HandleFunc("/me/password", traced("/me/password", authorized(postMePassword, anyRole...)))
+	http.HandleFunc("/me/export", traced("/me/export", authorized(getMeExport, anyRole...)))
+	http.HandleFunc("/admin/users", traced("/admin/users", authorized(handleAdminUsers, adminRole...)))
+	http.HandleFunc("/admin/users/", traced("/admin/users/", authorized(handleAdminUsers, adminRole...)))
+	http.HandleFunc("/admin/product-groups", traced("/admin/product-groups", authorized(handleAdminCatalog, adminRole...)))
+	http.HandleFunc("/admin/product-groups/", traced("/admin/product-groups/", authorized(handleAdminCatalog, adminRole...)))
+	http.HandleFunc("/admin/products/", traced("/admin/products/", authorized(handleAdminCatalog, adminRole...)))
+	http.HandleFunc("/admin/catalog/", traced("/admin/catalog/", authorized(handleAdminCatalogTransfer, adminRole...)))
 	http.Handle("/", http.FileServer(http.Dir("./src/github.com/karimarttila/go/simpleserver/static")))
-	log.Fatal(http.ListenAndServe(":"+util.MyConfig["port"], nil))
-	util.LogExit()
+}
+
+// Serves until the server fails or a signal arrives in stop. After a signal, stops accepting new connections and
+// waits at most shutdown_timeout_ms for the requests being served. Returns nil after a graceful shutdown.
+func serve(server *http.Server, stop <-chan os.Signal) error {
+	defer util.LogEnter().Exit()
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-stop:
+		util.LogInfo("Shutting down the server, signal: " + sig.String())
+	}
+	timeout := time.Duration(util.MyConfig.GetInt("shutdown_timeout_ms", 10000)) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return server.Shutdown(ctx)
 }
 
 // The main entry point to the file.
 // Remember that exportable functions begin with a capital letter.
-func StartServer() {
-	util.LogEnter()
+// Starts the server with the products and the sessions of the given stores. Returns when the server has been
+// shut down with SIGINT or SIGTERM, or fails, so that the caller can export the spans, close the logs etc.
+func StartServer(productStore domaindb.ProductStore, sessionStore userdb.SessionStore) error {
+	defer util.LogEnter().Exit()
+	myProductStore = productStore
+	mySessions = sessionStore
 	handleRequests()
-	util.LogExit()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	return serve(&http.Server{Addr: ":" + util.MyConfig["port"]}, stop)
 }
@@ -2,16 +2,40 @@ package webserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/karimarttila/go/simpleserver/app/domaindb"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
 	"github.com/karimarttila/go/simpleserver/app/util"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
 
+// The handlers serve the products of the resources directory like the server does in development.
+// The memory store has no admin, so the admin of the tests is added like add-admin adds the first admin.
+func TestMain(m *testing.M) {
+	store, err := domaindb.NewTsvStore(domaindb.ResourceDir())
+	if err != nil {
+		fmt.Println("Loading products failed: " + err.Error())
+		os.Exit(1)
+	}
+	myProductStore = store
+	if _, err = userdb.AddAdmin(context.Background(), "admin@foo.com", "Admin", "Adminen", "Admin"); err != nil {
+		fmt.Println("Adding the test admin failed: " + err.Error())
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
 func TestGetInfo(t *testing.T) {
 	util.LogEnter()
 	port := util.MyConfig["port"]
@@ -52,6 +76,7 @@ func addTestUser(t *testing.T, firstNameMissing bool) (recorder *httptest.Respon
 	}
 	myBody, _ := json.Marshal(bodyMap)
 	request = httptest.NewRequest("POST", "http://localhost:"+port+"/signin", bytes.NewReader(myBody))
+	request.Header.Set("Content-Type", "application/json")
 	recorder = httptest.NewRecorder()
 	util.LogEnter()
 	return recorder, request, testEmail
@@ -77,9 +102,16 @@ func TestPostSignin(t *testing.T) {
 	if responseMap["ret"] != "failed" {
 		t.Errorf("The response ret value should have been 'failed', map: %s", responseMap)
 	}
-	if responseMap["msg"] != "Validation failed - some fields were empty" {
+	if responseMap["code"] != string(VALIDATION_FAILED) {
+		t.Errorf("The response error code was not correct, map: %s", responseMap)
+	}
+	if responseMap["msg"] != "Validation failed - invalid fields: first-name" {
 		t.Errorf("The validation should have comprised error message, map: %s", responseMap)
 	}
+	fields, ok := responseMap["fields"].(map[string]interface{})
+	if !ok || fields["first-name"] == nil {
+		t.Errorf("The validation should have reported the missing first-name field, map: %s", responseMap)
+	}
 	// First time adding the user, should go smoothly.
 	recorder, request, testEmail = addTestUser(t, false)
 	http.HandlerFunc(postSignin).ServeHTTP(recorder, request)
@@ -102,9 +134,9 @@ func TestPostSignin(t *testing.T) {
 	// Second time the user should be in the db already and signin should fail.
 	recorder, request, testEmail = addTestUser(t, false)
 	http.HandlerFunc(postSignin).ServeHTTP(recorder, request)
-	if status := recorder.Code; status != http.StatusBadRequest {
+	if status := recorder.Code; status != http.StatusConflict {
 		t.Errorf("postSignin handler returned wrong status code: expected: %v actual: %v",
-			http.StatusBadRequest, status)
+			http.StatusConflict, status)
 	}
 	responseStr = recorder.Body.String()
 	util.LogDebug("Got response: " + responseStr)
@@ -115,6 +147,9 @@ func TestPostSignin(t *testing.T) {
 	if responseMap["ret"] != "failed" {
 		t.Errorf("The response ret value should have been 'failed', map: %s", responseMap)
 	}
+	if responseMap["code"] != string(ALREADY_EXISTS) {
+		t.Errorf("The response error code was not correct, map: %s", responseMap)
+	}
 	if responseMap["msg"] != "Email already exists: "+testEmail {
 		t.Errorf("The response error msg was not correct, map: %s", responseMap)
 	}
@@ -134,12 +169,13 @@ func TestLogin(t *testing.T) {
 	}
 	myBody, _ := json.Marshal(bodyMap)
 	request := httptest.NewRequest("POST", "http://localhost:"+port+"/login", bytes.NewReader(myBody))
+	request.Header.Set("Content-Type", "application/json")
 	recorder := httptest.NewRecorder()
 	// NOTE: Here we actually call directly the getInfo handler!
 	http.HandlerFunc(postLogin).ServeHTTP(recorder, request)
-	if status := recorder.Code; status != http.StatusBadRequest {
+	if status := recorder.Code; status != http.StatusUnauthorized {
 		t.Errorf("postLogin handler returned wrong status code: expected: %v actual: %v",
-			http.StatusBadRequest, status)
+			http.StatusUnauthorized, status)
 	}
 	responseStr := recorder.Body.String()
 	util.LogDebug("Got response: " + responseStr)
@@ -151,6 +187,9 @@ func TestLogin(t *testing.T) {
 	if responseMap["ret"] != "failed" {
 		t.Errorf("The response ret value should have been 'failed', map: %s", responseMap)
 	}
+	if responseMap["code"] != string(INVALID_CREDENTIALS) {
+		t.Errorf("The response error code was not correct, map: %s", responseMap)
+	}
 	if responseMap["msg"] != "Credentials are not good - either email or password is not correct" {
 		t.Errorf("The response msg was not correct, map: %s", responseMap)
 	}
@@ -163,6 +202,7 @@ func TestLogin(t *testing.T) {
 	}
 	myBody, _ = json.Marshal(bodyMap)
 	request = httptest.NewRequest("POST", "http://localhost:"+port+"/login", bytes.NewReader(myBody))
+	request.Header.Set("Content-Type", "application/json")
 	recorder = httptest.NewRecorder()
 	// NOTE: Here we actually call directly the getInfo handler!
 	http.HandlerFunc(postLogin).ServeHTTP(recorder, request)
@@ -189,11 +229,74 @@ func TestLogin(t *testing.T) {
 	util.LogEnter()
 }
 
+// The token carries the stored email, so that the sessions of the user are found whatever case was used in the login.
+func TestLoginEmailCaseInsensitive(t *testing.T) {
+	util.LogEnter()
+	email := "login.case.test@foo.com"
+	addAdminTestUser(t, email)
+	token := loginTestToken(t, "Login.CASE.Test@foo.com", "AdminTestPassword")
+	tokenResponse, err := ValidateJsonWebToken(context.Background(), token)
+	if err != nil || tokenResponse.Email != email {
+		t.Errorf("Token should have had the stored email %s, got: %v, err: %v", email, tokenResponse, err)
+	}
+	if revoked := RevokeSessions(email, ""); revoked != 1 {
+		t.Errorf("The session should have been revoked by the stored email, revoked: %d", revoked)
+	}
+	util.LogExit()
+}
+
+// A user store whose lookups fail, e.g. because the database is down.
+type lookupFailingUserStore struct {
+	userdb.Store
+	err error
+}
+
+func (store lookupFailingUserStore) GetUserByEmail(ctx context.Context, email string) (userdb.User, bool, error) {
+	return userdb.User{}, false, store.err
+}
+
+func (store lookupFailingUserStore) GetUserById(ctx context.Context, userId int) (userdb.User, bool, error) {
+	return userdb.User{}, false, store.err
+}
+
+func (store lookupFailingUserStore) ListUsers(ctx context.Context, offset int, limit int) ([]userdb.User, int, error) {
+	return nil, 0, store.err
+}
+
+// Uses a store with failing lookups until the end of the test.
+func useLookupFailingUserStore(t *testing.T) {
+	previous := userdb.SetStore(nil)
+	userdb.SetStore(lookupFailingUserStore{previous, errors.New("database is down")})
+	t.Cleanup(func() { userdb.SetStore(previous) })
+}
+
+// An outage of the user store is not a failed login: it must not lock out the user.
+func TestLoginStoreError(t *testing.T) {
+	defer util.LogEnter().Exit()
+	readRecords := useTestAuditLog(t)
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	useLookupFailingUserStore(t)
+	recorder := postTestLogin("kari.karttinen@foo.com", "Kari")
+	var responseMap map[string]interface{}
+	json.NewDecoder(recorder.Body).Decode(&responseMap)
+	if recorder.Code != http.StatusInternalServerError || responseMap["code"] != string(INTERNAL) {
+		t.Errorf("Login should have failed with 500, got: %d, %v", recorder.Code, responseMap)
+	}
+	if failures := myLoginThrottle.Failures(normalizeEmail("kari.karttinen@foo.com")); failures != 0 {
+		t.Errorf("Store failure should not have been recorded as a failed login, failures: %d", failures)
+	}
+	if records := readRecords(); len(records) != 0 {
+		t.Errorf("Store failure should not have been audited as a failed login, got: %v", records)
+	}
+}
+
 func TestGetProductGroups(t *testing.T) {
 	util.LogEnter()
 	port := util.MyConfig["port"]
 	// We could implement get this by querying /login, but let's make a shortcut.
-	token, err := CreateJsonWebToken("kari.karttinen@foo.com")
+	token, err := CreateJsonWebToken("kari.karttinen@foo.com", []string{userdb.RoleCustomer})
 	if err != nil {
 		t.Errorf("Failed to get test token: %s", err.Error())
 	}
@@ -242,7 +345,7 @@ func TestGetProducts(t *testing.T) {
 	util.LogEnter()
 	port := util.MyConfig["port"]
 	// We could implement get this by querying /login, but let's make a shortcut.
-	token, err := CreateJsonWebToken("kari.karttinen@foo.com")
+	token, err := CreateJsonWebToken("kari.karttinen@foo.com", []string{userdb.RoleCustomer})
 	if err != nil {
 		t.Errorf("Failed to get test token: %s", err.Error())
 	}
@@ -288,7 +391,7 @@ func TestGetProduct(t *testing.T) {
 	util.LogEnter()
 	port := util.MyConfig["port"]
 	// We could implement get this by querying /login, but let's make a shortcut.
-	token, err := CreateJsonWebToken("kari.karttinen@foo.com")
+	token, err := CreateJsonWebToken("kari.karttinen@foo.com", []string{userdb.RoleCustomer})
 	if err != nil {
 		t.Errorf("Failed to get test token: %s", err.Error())
 	}
@@ -324,5 +427,103 @@ func TestGetProduct(t *testing.T) {
 	if product.Product[2] != "Once Upon a Time in the West" {
 		t.Errorf("Got wrong movie, expected Once Upon a Time in the West, but got: %s", product.Product[2])
 	}
+	// The attributes are also by name, with numbers as numbers.
+	var withAttributes struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	}
+	json.Unmarshal([]byte(response), &withAttributes)
+	expected := map[string]interface{}{"author-or-director": "Leone, Sergio", "year": 1968.0, "country": "Italy-USA", "genre-or-language": "Western"}
+	if !reflect.DeepEqual(withAttributes.Attributes, expected) {
+		t.Errorf("Wrong attributes: %v", withAttributes.Attributes)
+	}
 	util.LogEnter()
 }
+
+// Measures the logging overhead on the /product path.
+// Run e.g.: go test -run NONE -bench GetProduct github.com/karimarttila/go/simpleserver/app/webserver
+func BenchmarkGetProduct(b *testing.B) {
+	token, err := CreateJsonWebToken("kari.karttinen@foo.com", []string{userdb.RoleCustomer})
+	if err != nil {
+		b.Fatalf("Failed to get test token: %s", err.Error())
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(token))
+	// NOTE: We measure the cost of the logger itself, not the cost of the disk or terminal.
+	log.SetOutput(ioutil.Discard)
+	originalLevel := util.MyLogLevel
+	defer func() { util.MyLogLevel = originalLevel }()
+	for _, level := range []util.SSLogLevel{util.SS_LOG_LEVEL_INFO, util.SS_LOG_LEVEL_TRACE} {
+		b.Run(level.String(), func(b *testing.B) {
+			util.MyLogLevel = level
+			for i := 0; i < b.N; i++ {
+				request := httptest.NewRequest("GET", "http://localhost/product/2/49", nil)
+				request.Header.Add("authorization", "Basic "+encoded)
+				recorder := httptest.NewRecorder()
+				http.HandlerFunc(getProduct).ServeHTTP(recorder, request)
+				if recorder.Code != http.StatusOK {
+					b.Fatalf("getProduct returned wrong status code: %d", recorder.Code)
+				}
+			}
+		})
+	}
+}
+
+// A store that fails like e.g. a database store does when the database is down.
+type failingProductStore struct{}
+
+func (failingProductStore) GetProductGroups(ctx context.Context) (domaindb.ProductGroups, error) {
+	return domaindb.ProductGroups{}, errors.New("store is down")
+}
+
+func (failingProductStore) GetProducts(ctx context.Context, pgId int) (domaindb.Products, error) {
+	return domaindb.Products{}, errors.New("store is down")
+}
+
+func (failingProductStore) GetProduct(ctx context.Context, pgId int, pId int) (domaindb.Product, error) {
+	return domaindb.Product{}, errors.New("store is down")
+}
+
+func (failingProductStore) GetSchema(ctx context.Context, pgId int) (domaindb.ProductGroupSchema, error) {
+	return domaindb.ProductGroupSchema{}, errors.New("store is down")
+}
+
+func (failingProductStore) GetCategories(ctx context.Context) (domaindb.Categories, error) {
+	return domaindb.Categories{}, errors.New("store is down")
+}
+
+func TestProductStoreErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myProductStore
+	myProductStore = failingProductStore{}
+	defer func() { myProductStore = previous }()
+	token := loginTestToken(t, "kari.karttinen@foo.com", "Kari")
+	tests := []struct {
+		handler http.HandlerFunc
+		path    string
+	}{
+		{getProductGroups, "/product-groups"},
+		{getProducts, "/products/1"},
+		{getProduct, "/product/2/49"},
+		{getSchema, "/schema/1"},
+		{getCategories, "/categories"},
+		{getBreadcrumb, "/breadcrumb/2/49"},
+		{getProducts, "/products/1?descendants=true"},
+	}
+	for _, test := range tests {
+		recorder, responseMap := doAuthorizedRequest(authorized(test.handler, anyRole...), token, "GET", test.path, "")
+		if recorder.Code != http.StatusInternalServerError || responseMap["code"] != string(INTERNAL) {
+			t.Errorf("%s should have failed with 500, got: %d, %v", test.path, recorder.Code, responseMap)
+		}
+	}
+}
+
+func TestServeUntilSignal(t *testing.T) {
+	defer util.LogEnter().Exit()
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	if err := serve(&http.Server{Addr: "127.0.0.1:0"}, stop); err != nil {
+		t.Errorf("Server should have shut down gracefully, got: %s", err.Error())
+	}
+	if err := serve(&http.Server{Addr: "127.0.0.1:-1"}, make(chan os.Signal)); err == nil {
+		t.Errorf("Server with an invalid address should have failed")
+	}
+}
@@ -1,9 +1,16 @@
 package webserver
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/tracing"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
 	"github.com/karimarttila/go/simpleserver/app/util"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -12,20 +19,63 @@ import (
 var superSecret = []byte("SuperSecret")
 
 type SSClaim struct {
-	Email string `json:"email"`
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
 	jwt.StandardClaims
 }
 
 type TokenResponse struct {
-	Flag  bool   // Just to tell the whether we have initialized this struct or not (zero-value for bool is false, i.e. if the value is ready we know that we have initialized the struct).
-	Email string `json:"email"`
+	Flag  bool     // Just to tell the whether we have initialized this struct or not (zero-value for bool is false, i.e. if the value is ready we know that we have initialized the struct).
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+	Token string   `json:"-"` // The validated token itself.
 }
 
-// NOTE: Go does not have native set. We use map to simulate set.
-var mySessions = make(map[string]bool)
+// The token id (jti) and the expiration time of a session, i.e. what the user can see of their sessions.
+type SessionInfo struct {
+	Id      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+	Current bool      `json:"current"` // The session of the request.
+}
+
+// The tokens we have created, given to StartServer.
+var mySessions userdb.SessionStore = userdb.NewMemorySessionStore()
+
+// Revokes all sessions of the user except the given token (empty: revoke all).
+// Returns the number of revoked sessions.
+func RevokeSessions(email string, exceptToken string) int {
+	defer util.LogEnter().Exit()
+	count, err := mySessions.RemoveSessions(context.Background(), email, exceptToken)
+	if err != nil {
+		util.LogError("Couldn't revoke the sessions of " + email + ": " + err.Error())
+	}
+	return count
+}
 
-func CreateJsonWebToken(userEmail string) (ret string, err error) {
-	util.LogEnter()
+// Returns the unexpired sessions of the user ordered by expiration time. currentToken marks the session of the request.
+// NOTE: The tokens themselves are not returned, the token id is enough to tell the sessions apart.
+func ListSessions(email string, currentToken string) (ret []SessionInfo) {
+	defer util.LogEnter().Exit()
+	ctx := context.Background()
+	sessions, err := mySessions.ListSessions(ctx, email)
+	if err != nil {
+		util.LogError("Couldn't list the sessions of " + email + ": " + err.Error())
+	}
+	current, currentFound, _ := mySessions.GetSession(ctx, currentToken)
+	now := time.Now()
+	ret = []SessionInfo{}
+	for _, value := range sessions {
+		if now.Before(value.Expires) {
+			ret = append(ret, SessionInfo{value.Id, value.Expires, currentFound && value.Id == current.Id})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Expires.Before(ret[j].Expires) })
+	return ret
+}
+
+// Creates a token with the user's email and roles as claims.
+func CreateJsonWebToken(userEmail string, roles []string) (ret string, err error) {
+	defer util.LogEnter().Exit()
 	expStr := util.MyConfig["json_web_token_expiration_as_seconds"]
 	expiration, err := strconv.Atoi(expStr)
 	if err != nil {
@@ -33,30 +83,51 @@ func CreateJsonWebToken(userEmail string) (ret string, err error) {
 	} else {
 		ttl := time.Duration(expiration) * time.Second
 		claimExp := time.Now().UTC().Add(ttl).Unix()
+		// NOTE: Random token id, otherwise tokens created within the same second for the same user would be equal.
+		tokenId := newTokenId()
 		myClaim := SSClaim{
 			userEmail,
+			roles,
 			jwt.StandardClaims{
 				ExpiresAt: int64(claimExp),
+				Id:        tokenId,
 			},
 		}
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, myClaim)
 		ret, err = token.SignedString(superSecret)
 		if err != nil {
 			util.LogError("error signing json web token: " + err.Error())
-		} else {
-			mySessions[ret] = true
+		} else if err = mySessions.AddSession(context.Background(), ret, userdb.Session{Email: userEmail, Id: tokenId, Expires: time.Unix(claimExp, 0).UTC()}); err != nil {
+			util.LogError("Couldn't add session: " + err.Error())
+			ret = ""
 		}
 	}
-	util.LogExit()
 	return ret, err
 }
 
-func validationErrorHandler(msg string, token string) (err error) {
-	util.LogEnter()
+func newTokenId() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		util.LogError("Couldn't create random token id: " + err.Error())
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// Tells whether ValidateJsonWebToken failed because the token has expired.
+func isTokenExpired(err error) bool {
+	validationError, ok := err.(*jwt.ValidationError)
+	return ok && validationError.Errors&jwt.ValidationErrorExpired != 0
+}
+
+// Removes the rejected token from the sessions and audits the rejection.
+func validationErrorHandler(ctx context.Context, msg string, token string) (err error) {
+	defer util.LogEnter().Exit()
 	util.LogError(msg)
+	audit.Log(ctx, audit.Record{Action: "TOKEN_REJECTED", Outcome: audit.Denied, Details: msg})
 	err = errors.New(msg)
-	delete(mySessions, token)
-	util.LogExit()
+	if removeErr := mySessions.RemoveSession(ctx, token); removeErr != nil {
+		util.LogError("Couldn't remove session: " + removeErr.Error())
+	}
 	return err
 }
 
@@ -64,14 +135,21 @@ func validationErrorHandler(msg string, token string) (err error) {
 // Token validation has two parts:
 // 1. Check that we actually created the token in the first place (should find it in my-sessions set.
 // 2. Validate the actual token (can unsign it, token is not expired)."""
-func ValidateJsonWebToken(myToken string) (ret TokenResponse, err error) {
-	util.LogEnter()
+func ValidateJsonWebToken(ctx context.Context, myToken string) (ret TokenResponse, err error) {
+	defer util.LogEnter().Exit()
+	_, span := tracing.StartSpan(ctx, "webserver.ValidateJsonWebToken", tracing.SpanKindInternal)
+	defer span.End()
 	var parsedToken *jwt.Token
 	var buf string
 	// Validation #1.
-	if _, ok := mySessions[myToken]; !ok {
-		buf = "Token not found in sessions: " + myToken
-		err = validationErrorHandler(buf, myToken)
+	// NOTE: A failing session store rejects the token.
+	if _, found, sessionErr := mySessions.GetSession(ctx, myToken); sessionErr != nil {
+		buf = "Couldn't get session: " + sessionErr.Error()
+		err = validationErrorHandler(ctx, buf, myToken)
+	} else if !found {
+		// NOTE: The token itself is not logged, it could be a valid token of a revoked session.
+		buf = "Token not found in sessions"
+		err = validationErrorHandler(ctx, buf, myToken)
 	} else {
 		// Validation #2.
 		parsedToken, err = jwt.Parse(myToken, func(token *jwt.Token) (interface{}, error) {
@@ -79,28 +157,37 @@ func ValidateJsonWebToken(myToken string) (ret TokenResponse, err error) {
 		})
 		if err != nil {
 			util.LogError("Couldn't parse token, error: " + err.Error())
+			audit.Log(ctx, audit.Record{Action: "TOKEN_REJECTED", Outcome: audit.Denied, Details: "Couldn't parse token: " + err.Error()})
 		} else {
 			claim, ok := parsedToken.Claims.(jwt.MapClaims) // ; ok && token.Valid
 			if !ok {
 				buf = "Couldn't parse token, Claims returned false"
-				err = validationErrorHandler(buf, myToken)
+				err = validationErrorHandler(ctx, buf, myToken)
 			} else {
 				if !parsedToken.Valid {
 					buf = "Token was not valid, parsedToken.Valid is false"
-					err = validationErrorHandler(buf, myToken)
+					err = validationErrorHandler(ctx, buf, myToken)
 				} else {
 					userEmail := claim["email"]
 					userEmailStr, ok := userEmail.(string)
 					if !ok {
 						buf = "Couldn't convert userEmail to string"
-						err = validationErrorHandler(buf, myToken)
+						err = validationErrorHandler(ctx, buf, myToken)
 					} else {
-						ret = TokenResponse{true, userEmailStr}
+						// NOTE: JSON arrays are parsed as []interface{}. Tokens without the roles claim have no roles.
+						var roles []string
+						rolesClaim, _ := claim["roles"].([]interface{})
+						for _, role := range rolesClaim {
+							if roleStr, ok := role.(string); ok {
+								roles = append(roles, roleStr)
+							}
+						}
+						ret = TokenResponse{true, userEmailStr, roles, myToken}
 					}
 				}
 			}
 		}
 	}
-	util.LogExit()
+	span.SetError(err)
 	return ret, err
 }
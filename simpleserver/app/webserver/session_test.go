@@ -1,6 +1,8 @@
 package webserver
 
 import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
 	"github.com/karimarttila/go/simpleserver/app/util"
 	"testing"
 )
@@ -8,7 +10,7 @@ import (
 func TestJsonWebToken(t *testing.T) {
 	util.LogEnter()
 	testEmail := "kari.karttinen@foo.com"
-	jsonWebToken, err := CreateJsonWebToken(testEmail)
+	jsonWebToken, err := CreateJsonWebToken(testEmail, []string{userdb.RoleCustomer, userdb.RoleAdmin})
 	if err != nil {
 		t.Error("CreateJsonWebToken returned error: " + err.Error())
 	}
@@ -18,7 +20,7 @@ func TestJsonWebToken(t *testing.T) {
 	if len(jsonWebToken) < 20 {
 		t.Error("jsonWebToken is too short")
 	}
-	response, err := ValidateJsonWebToken(jsonWebToken)
+	response, err := ValidateJsonWebToken(context.Background(), jsonWebToken)
 	if err != nil {
 		t.Error("ValidateJsonWebToken returned error: " + err.Error())
 	}
@@ -29,5 +31,34 @@ func TestJsonWebToken(t *testing.T) {
 	if gotEmail != testEmail {
 		t.Errorf("Didn't get the same email back, expected: %s, got: %s", testEmail, gotEmail)
 	}
+	if len(response.Roles) != 2 || response.Roles[0] != userdb.RoleCustomer || response.Roles[1] != userdb.RoleAdmin {
+		t.Errorf("Didn't get the same roles back, got: %v", response.Roles)
+	}
+	util.LogExit()
+}
+
+func TestRevokeSessions(t *testing.T) {
+	util.LogEnter()
+	email := "revoke.test@foo.com"
+	roles := []string{userdb.RoleCustomer}
+	token1, _ := CreateJsonWebToken(email, roles)
+	token2, _ := CreateJsonWebToken(email, roles)
+	otherToken, _ := CreateJsonWebToken("kari.karttinen@foo.com", roles)
+	if count := RevokeSessions(email, token2); count != 1 {
+		t.Errorf("Should have revoked one session, revoked: %d", count)
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), token1); err == nil {
+		t.Errorf("Revoked token should not have been valid")
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), token2); err != nil {
+		t.Errorf("Excepted token should have been valid, got: %s", err.Error())
+	}
+	if _, err := ValidateJsonWebToken(context.Background(), otherToken); err != nil {
+		t.Errorf("Other user's token should have been valid, got: %s", err.Error())
+	}
+	RevokeSessions(email, "")
+	if _, err := ValidateJsonWebToken(context.Background(), token2); err == nil {
+		t.Errorf("All sessions should have been revoked")
+	}
 	util.LogExit()
 }
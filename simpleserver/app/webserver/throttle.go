@@ -0,0 +1,195 @@
+package webserver
+
+import (
+	"context"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Login brute-force protection.
+// - Per email: after login_backoff_free_attempts failed logins each new attempt must wait
+//   an exponentially growing delay (login_backoff_base_ms doubled per failure, max login_backoff_max_ms).
+// - Per email: after login_lockout_threshold failed logins the account is locked for login_lockout_minutes.
+// - Per IP: a token bucket of login_ip_burst attempts refilled with login_ip_per_minute attempts per minute.
+// A successful login resets the email state.
+
+type LoginThrottleConfig struct {
+	BackoffFreeAttempts int
+	BackoffBase         time.Duration
+	BackoffMax          time.Duration
+	LockoutThreshold    int
+	LockoutDuration     time.Duration
+	IpBurst             int
+	IpPerMinute         int
+}
+
+func loadLoginThrottleConfig() LoginThrottleConfig {
+	defer util.LogEnter().Exit()
+	return LoginThrottleConfig{
+		BackoffFreeAttempts: util.MyConfig.GetInt("login_backoff_free_attempts", 3),
+		BackoffBase:         time.Duration(util.MyConfig.GetInt("login_backoff_base_ms", 1000)) * time.Millisecond,
+		BackoffMax:          time.Duration(util.MyConfig.GetInt("login_backoff_max_ms", 60000)) * time.Millisecond,
+		LockoutThreshold:    util.MyConfig.GetInt("login_lockout_threshold", 10),
+		LockoutDuration:     time.Duration(util.MyConfig.GetInt("login_lockout_minutes", 15)) * time.Minute,
+		IpBurst:             util.MyConfig.GetInt("login_ip_burst", 20),
+		IpPerMinute:         util.MyConfig.GetInt("login_ip_per_minute", 20),
+	}
+}
+
+type emailAttempts struct {
+	failures    int
+	lastFailure time.Time
+	nextAllowed time.Time
+	lockedUntil time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Takes one token if there is one, otherwise returns how long until there is.
+func (bucket *tokenBucket) take(now time.Time, burst int, perMinute int) (retryAfter time.Duration) {
+	refillPerSecond := float64(perMinute) / 60
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*refillPerSecond)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	if refillPerSecond <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - bucket.tokens) / refillPerSecond * float64(time.Second))
+}
+
+type LoginThrottle struct {
+	mutex      sync.Mutex
+	config     LoginThrottleConfig
+	emails     map[string]*emailAttempts
+	ips        map[string]*tokenBucket
+	operations int
+	now        func() time.Time // Replaced in tests.
+}
+
+func NewLoginThrottle(config LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		config: config,
+		emails: make(map[string]*emailAttempts),
+		ips:    make(map[string]*tokenBucket),
+		now:    time.Now,
+	}
+}
+
+// Login throttle singleton.
+var myLoginThrottle = NewLoginThrottle(loadLoginThrottleConfig())
+
+// Takes an attempt from the IP's token bucket. Returns the time to wait if the IP has made too many attempts.
+func (throttle *LoginThrottle) AllowIp(ip string) (retryAfter time.Duration) {
+	defer util.LogEnter().Exit()
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	now := throttle.now()
+	throttle.prune(now)
+	bucket, ok := throttle.ips[ip]
+	if !ok {
+		bucket = &tokenBucket{float64(throttle.config.IpBurst), now}
+		throttle.ips[ip] = bucket
+	}
+	retryAfter = bucket.take(now, throttle.config.IpBurst, throttle.config.IpPerMinute)
+	if retryAfter > 0 {
+		audit.Log(context.Background(), audit.Record{Action: "LOGIN_IP_THROTTLED", Outcome: audit.Denied, Ip: ip, Details: "retry after " + retryAfter.String()})
+	}
+	return retryAfter
+}
+
+// Tells whether the email may try to log in now. Returns the time to wait and whether the account is locked.
+func (throttle *LoginThrottle) AllowEmail(email string) (retryAfter time.Duration, locked bool) {
+	defer util.LogEnter().Exit()
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	now := throttle.now()
+	attempts, ok := throttle.emails[email]
+	if !ok {
+		return 0, false
+	}
+	if now.Before(attempts.lockedUntil) {
+		return attempts.lockedUntil.Sub(now), true
+	}
+	if now.Before(attempts.nextAllowed) {
+		return attempts.nextAllowed.Sub(now), false
+	}
+	return 0, false
+}
+
+// Records a failed login, computes the next backoff and locks the account when the threshold is reached.
+func (throttle *LoginThrottle) RecordFailure(email string, ip string) {
+	defer util.LogEnter().Exit()
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	now := throttle.now()
+	attempts, ok := throttle.emails[email]
+	if !ok {
+		attempts = &emailAttempts{}
+		throttle.emails[email] = attempts
+	}
+	// A previous lockout has expired: start from a clean slate.
+	if !attempts.lockedUntil.IsZero() && !now.Before(attempts.lockedUntil) {
+		*attempts = emailAttempts{}
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+	config := throttle.config
+	if config.LockoutThreshold > 0 && attempts.failures >= config.LockoutThreshold {
+		attempts.lockedUntil = now.Add(config.LockoutDuration)
+		audit.Log(context.Background(), audit.Record{Action: "ACCOUNT_LOCKED", Target: email, Outcome: audit.Denied, Ip: ip,
+			Details: "locked until " + attempts.lockedUntil.UTC().Format(time.RFC3339) + " after " + strconv.Itoa(attempts.failures) + " failed logins"})
+	} else if attempts.failures > config.BackoffFreeAttempts {
+		exponent := float64(attempts.failures - config.BackoffFreeAttempts - 1)
+		backoff := time.Duration(math.Min(float64(config.BackoffBase)*math.Pow(2, exponent), float64(config.BackoffMax)))
+		attempts.nextAllowed = now.Add(backoff)
+	}
+}
+
+// Returns the number of failed logins recorded for the email since the last successful login.
+func (throttle *LoginThrottle) Failures(email string) int {
+	defer util.LogEnter().Exit()
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	if attempts, ok := throttle.emails[email]; ok {
+		return attempts.failures
+	}
+	return 0
+}
+
+// Resets the email state after a successful login.
+func (throttle *LoginThrottle) RecordSuccess(email string) {
+	defer util.LogEnter().Exit()
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	delete(throttle.emails, email)
+}
+
+// Removes stale state every now and then so that the maps do not grow forever.
+// NOTE: Caller must hold the mutex.
+func (throttle *LoginThrottle) prune(now time.Time) {
+	throttle.operations++
+	if throttle.operations%1000 != 0 {
+		return
+	}
+	for email, attempts := range throttle.emails {
+		if now.After(attempts.lockedUntil) && now.Sub(attempts.lastFailure) > throttle.config.LockoutDuration+throttle.config.BackoffMax {
+			delete(throttle.emails, email)
+		}
+	}
+	for ip, bucket := range throttle.ips {
+		// Full again.
+		if now.Sub(bucket.updated) > time.Hour {
+			delete(throttle.ips, ip)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testThrottleConfig = LoginThrottleConfig{
+	BackoffFreeAttempts: 2,
+	BackoffBase:         time.Second,
+	BackoffMax:          4 * time.Second,
+	LockoutThreshold:    6,
+	LockoutDuration:     15 * time.Minute,
+	IpBurst:             3,
+	IpPerMinute:         6,
+}
+
+func newTestThrottle() (throttle *LoginThrottle, clock *time.Time) {
+	now := time.Date(2018, 11, 6, 20, 0, 0, 0, time.UTC)
+	throttle = NewLoginThrottle(testThrottleConfig)
+	throttle.now = func() time.Time { return now }
+	return throttle, &now
+}
+
+func TestLoginThrottleBackoffAndLockout(t *testing.T) {
+	defer util.LogEnter().Exit()
+	throttle, clock := newTestThrottle()
+	email := "kari.karttinen@foo.com"
+	// Free attempts, then 1s, 2s, 4s, 4s (max).
+	expectedBackoffs := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, expected := range expectedBackoffs {
+		throttle.RecordFailure(email, "10.0.0.1")
+		retryAfter, locked := throttle.AllowEmail(email)
+		if retryAfter != expected || locked {
+			t.Errorf("Failure %d: wrong backoff, expected: %s, got: %s, locked: %t", i+1, expected, retryAfter, locked)
+		}
+		*clock = clock.Add(retryAfter)
+	}
+	// Sixth failure reaches the threshold.
+	throttle.RecordFailure(email, "10.0.0.1")
+	retryAfter, locked := throttle.AllowEmail(email)
+	if !locked || retryAfter != 15*time.Minute {
+		t.Errorf("Account should have been locked for 15 minutes, got: %s, locked: %t", retryAfter, locked)
+	}
+	*clock = clock.Add(15 * time.Minute)
+	if retryAfter, locked = throttle.AllowEmail(email); retryAfter != 0 || locked {
+		t.Errorf("Lockout should have expired, got: %s, locked: %t", retryAfter, locked)
+	}
+	// Lockout expired: the next failure starts from a clean slate.
+	throttle.RecordFailure(email, "10.0.0.1")
+	if retryAfter, _ = throttle.AllowEmail(email); retryAfter != 0 {
+		t.Errorf("First failure after lockout should be free, got: %s", retryAfter)
+	}
+	throttle.RecordFailure(email, "10.0.0.1")
+	throttle.RecordFailure(email, "10.0.0.1")
+	throttle.RecordSuccess(email)
+	if retryAfter, _ = throttle.AllowEmail(email); retryAfter != 0 {
+		t.Errorf("Successful login should have reset the backoff, got: %s", retryAfter)
+	}
+}
+
+func TestLoginThrottleIpBucket(t *testing.T) {
+	defer util.LogEnter().Exit()
+	throttle, clock := newTestThrottle()
+	for i := 0; i < 3; i++ {
+		if retryAfter := throttle.AllowIp("10.0.0.1"); retryAfter != 0 {
+			t.Errorf("Attempt %d should have been allowed by the burst, got: %s", i+1, retryAfter)
+		}
+	}
+	// Refill rate 6/min: one token every 10 seconds.
+	if retryAfter := throttle.AllowIp("10.0.0.1"); retryAfter != 10*time.Second {
+		t.Errorf("Bucket should be empty, expected retry after 10s, got: %s", retryAfter)
+	}
+	if retryAfter := throttle.AllowIp("10.0.0.2"); retryAfter != 0 {
+		t.Errorf("Other IP should have its own bucket, got: %s", retryAfter)
+	}
+	*clock = clock.Add(10 * time.Second)
+	if retryAfter := throttle.AllowIp("10.0.0.1"); retryAfter != 0 {
+		t.Errorf("Bucket should have been refilled, got: %s", retryAfter)
+	}
+}
+
+func postTestLogin(email string, password string) *httptest.ResponseRecorder {
+	myBody, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	request := httptest.NewRequest("POST", "http://localhost/login", bytes.NewReader(myBody))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(postLogin).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestLoginThrottled(t *testing.T) {
+	defer util.LogEnter().Exit()
+	throttle, _ := newTestThrottle()
+	throttle.config.IpBurst = 100
+	previous := myLoginThrottle
+	myLoginThrottle = throttle
+	defer func() { myLoginThrottle = previous }()
+	for i := 0; i < testThrottleConfig.BackoffFreeAttempts+1; i++ {
+		if recorder := postTestLogin("kari.karttinen@foo.com", "WRONG-PASSWORD"); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Failed login %d should have returned 401, got: %d", i+1, recorder.Code)
+		}
+	}
+	// Backoff: even the right password is not checked now.
+	recorder := postTestLogin("Kari.Karttinen@foo.com", "Kari")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Throttled login should have returned 429, got: %d", recorder.Code)
+	}
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Wrong Retry-After header: %s", retryAfter)
+	}
+	var responseMap map[string]string
+	json.NewDecoder(recorder.Body).Decode(&responseMap)
+	if responseMap["code"] != string(TOO_MANY_REQUESTS) {
+		t.Errorf("Wrong error code: %s", responseMap["code"])
+	}
+	// Lock the account.
+	throttle.emails["kari.karttinen@foo.com"].failures = testThrottleConfig.LockoutThreshold - 1
+	throttle.emails["kari.karttinen@foo.com"].nextAllowed = time.Time{}
+	postTestLogin("kari.karttinen@foo.com", "WRONG-PASSWORD")
+	recorder = postTestLogin("kari.karttinen@foo.com", "Kari")
+	json.NewDecoder(recorder.Body).Decode(&responseMap)
+	if recorder.Code != http.StatusTooManyRequests || responseMap["code"] != string(ACCOUNT_LOCKED) {
+		t.Errorf("Locked account should have returned 429 ACCOUNT_LOCKED, got: %d %s", recorder.Code, responseMap["code"])
+	}
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != "900" {
+		t.Errorf("Wrong Retry-After header: %s", retryAfter)
+	}
+}
@@ -0,0 +1,168 @@
+package webserver
+
+import (
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FieldErrors maps a request field (the JSON name) to its validation errors.
+type FieldErrors map[string][]string
+
+func (fieldErrors FieldErrors) add(field string, msg string) {
+	fieldErrors[field] = append(fieldErrors[field], msg)
+}
+
+// Sorted field names, so that the error messages are deterministic.
+func (fieldErrors FieldErrors) fieldNames() []string {
+	var names []string
+	for name := range fieldErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func createValidationErrorResponse(fieldErrors FieldErrors) (errorResponse ErrorResponse) {
+	defer util.LogEnter().Exit()
+	errorResponse = createErrorResponse(VALIDATION_FAILED, "Validation failed - invalid fields: "+strings.Join(fieldErrors.fieldNames(), ", "))
+	errorResponse.Fields = fieldErrors
+	return errorResponse
+}
+
+// Password policy, configured in the properties file.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	RequireLower   bool
+	RequireUpper   bool
+	RequireDigit   bool
+	RequireSpecial bool
+}
+
+var myPasswordPolicy = initPasswordPolicy()
+
+func initPasswordPolicy() PasswordPolicy {
+	defer util.LogEnter().Exit()
+	return PasswordPolicy{
+		MinLength:      util.MyConfig.GetInt("password_min_length", 8),
+		MaxLength:      util.MyConfig.GetInt("password_max_length", 128),
+		RequireLower:   util.MyConfig.GetBool("password_require_lower", false),
+		RequireUpper:   util.MyConfig.GetBool("password_require_upper", false),
+		RequireDigit:   util.MyConfig.GetBool("password_require_digit", false),
+		RequireSpecial: util.MyConfig.GetBool("password_require_special", false),
+	}
+}
+
+// Returns all the ways the password violates the policy.
+func (policy PasswordPolicy) Validate(password string) (errors []string) {
+	length := len([]rune(password))
+	if length < policy.MinLength {
+		errors = append(errors, "must be at least "+strconv.Itoa(policy.MinLength)+" characters")
+	}
+	if length > policy.MaxLength {
+		errors = append(errors, "must be at most "+strconv.Itoa(policy.MaxLength)+" characters")
+	}
+	var lower, upper, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	if policy.RequireLower && !lower {
+		errors = append(errors, "must contain a lowercase letter")
+	}
+	if policy.RequireUpper && !upper {
+		errors = append(errors, "must contain an uppercase letter")
+	}
+	if policy.RequireDigit && !digit {
+		errors = append(errors, "must contain a digit")
+	}
+	if policy.RequireSpecial && !special {
+		errors = append(errors, "must contain a special character")
+	}
+	return errors
+}
+
+const maxEmailLength = 254
+
+// Trims and lowercases the email.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Returns the normalized email and what is wrong with it.
+func validateEmail(email string) (normalized string, errors []string) {
+	normalized = normalizeEmail(email)
+	if normalized == "" {
+		return normalized, []string{"is required"}
+	}
+	if len(normalized) > maxEmailLength {
+		return normalized, []string{"must be at most " + strconv.Itoa(maxEmailLength) + " characters"}
+	}
+	// NOTE: ParseAddress also accepts e.g. "Kari <kari@foo.com>", we want just the bare address.
+	address, err := mail.ParseAddress(normalized)
+	if err != nil || address.Address != normalized {
+		return normalized, []string{"is not a valid email address"}
+	}
+	at := strings.LastIndex(normalized, "@")
+	if !strings.Contains(normalized[at+1:], ".") {
+		errors = append(errors, "must have a domain like foo.com")
+	}
+	return normalized, errors
+}
+
+var myNameMaxLength = util.MyConfig.GetInt("name_max_length", 50)
+
+// Names may contain letters, spaces, hyphens, apostrophes and periods, e.g. "Anna-Liisa" or "O'Neil".
+func validateName(name string) (errors []string) {
+	trimmed := strings.TrimSpace(name)
+	if trimmed == "" {
+		return []string{"is required"}
+	}
+	if len([]rune(trimmed)) > myNameMaxLength {
+		errors = append(errors, "must be at most "+strconv.Itoa(myNameMaxLength)+" characters")
+	}
+	for _, r := range trimmed {
+		if !unicode.IsLetter(r) && !unicode.IsMark(r) && r != ' ' && r != '-' && r != '\'' && r != '.' {
+			errors = append(errors, "may contain only letters, spaces, hyphens, apostrophes and periods")
+			break
+		}
+	}
+	return errors
+}
+
+// Validates the signin data and normalizes it in place (trimmed names, normalized email).
+func validateSigninData(signinData *SigninData, fieldErrors FieldErrors) {
+	defer util.LogEnter().Exit()
+	var errors []string
+	signinData.Email, errors = validateEmail(signinData.Email)
+	for _, msg := range errors {
+		fieldErrors.add("email", msg)
+	}
+	if signinData.Password == "" {
+		fieldErrors.add("password", "is required")
+	} else {
+		for _, msg := range myPasswordPolicy.Validate(signinData.Password) {
+			fieldErrors.add("password", msg)
+		}
+	}
+	for _, msg := range validateName(signinData.FirstName) {
+		fieldErrors.add("first-name", msg)
+	}
+	for _, msg := range validateName(signinData.LastName) {
+		fieldErrors.add("last-name", msg)
+	}
+	signinData.FirstName = strings.TrimSpace(signinData.FirstName)
+	signinData.LastName = strings.TrimSpace(signinData.LastName)
+}
@@ -0,0 +1,96 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateEmail(t *testing.T) {
+	defer util.LogEnter().Exit()
+	normalized, errors := validateEmail("  Kari.Karttinen@Foo.COM ")
+	if len(errors) > 0 || normalized != "kari.karttinen@foo.com" {
+		t.Errorf("Valid email was rejected or not normalized: %s, errors: %s", normalized, errors)
+	}
+	invalidEmails := []string{"", "i", "kari@", "@foo.com", "kari@foo", "Kari <kari@foo.com>", "kari@@foo.com",
+		strings.Repeat("a", 250) + "@foo.com"}
+	for _, email := range invalidEmails {
+		if _, errors := validateEmail(email); len(errors) == 0 {
+			t.Errorf("Invalid email was accepted: %s", email)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	defer util.LogEnter().Exit()
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 16, RequireLower: true, RequireUpper: true, RequireDigit: true, RequireSpecial: true}
+	if errors := policy.Validate("Salasana1!"); len(errors) > 0 {
+		t.Errorf("Valid password was rejected: %s", errors)
+	}
+	// All the violations are reported.
+	errors := policy.Validate("sala")
+	if len(errors) != 4 {
+		t.Errorf("There should be exactly 4 policy violations, got: %s", errors)
+	}
+	if errors := policy.Validate("Salasana1!Salasana1!"); len(errors) != 1 {
+		t.Errorf("Too long password should have been rejected, got: %s", errors)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	defer util.LogEnter().Exit()
+	for _, name := range []string{"Kari", "Anna-Liisa", "O'Neil", "Jr. Smith", "Åsa", "Mäkelä"} {
+		if errors := validateName(name); len(errors) > 0 {
+			t.Errorf("Valid name was rejected: %s, errors: %s", name, errors)
+		}
+	}
+	for _, name := range []string{"", "   ", "Kari1", "<script>", strings.Repeat("a", myNameMaxLength+1)} {
+		if errors := validateName(name); len(errors) == 0 {
+			t.Errorf("Invalid name was accepted: %s", name)
+		}
+	}
+}
+
+func TestSigninFieldErrors(t *testing.T) {
+	defer util.LogEnter().Exit()
+	bodyMap := map[string]interface{}{
+		"first-name": "Kari1",
+		"last-name":  "",
+		"email":      "i",
+		"password":   "a",
+		"admin":      true,
+	}
+	myBody, _ := json.Marshal(bodyMap)
+	request := httptest.NewRequest("POST", "http://localhost/signin", bytes.NewReader(myBody))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(postSignin).ServeHTTP(recorder, request)
+	if status := recorder.Code; status != http.StatusBadRequest {
+		t.Errorf("postSignin handler returned wrong status code: expected: %v actual: %v",
+			http.StatusBadRequest, status)
+	}
+	var response struct {
+		Code   string              `json:"code"`
+		Fields map[string][]string `json:"fields"`
+	}
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Decoding response failed: %s", err.Error())
+	}
+	if response.Code != string(VALIDATION_FAILED) {
+		t.Errorf("Wrong error code: %s", response.Code)
+	}
+	// Every failing field is reported in one response.
+	for _, field := range []string{"first-name", "last-name", "email", "password", "admin"} {
+		if len(response.Fields[field]) == 0 {
+			t.Errorf("Field %s should have been reported, fields: %v", field, response.Fields)
+		}
+	}
+	if response.Fields["admin"][0] != "unknown field" {
+		t.Errorf("Field admin should have been reported as unknown, got: %s", response.Fields["admin"])
+	}
+}
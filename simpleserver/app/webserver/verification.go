@@ -0,0 +1,126 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/audit"
+	"github.com/karimarttila/go/simpleserver/app/mail"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Email verification (see handleRequests). If email_verification_required=true /signin mails
+// a verification link to the user and /login gives EMAIL_NOT_VERIFIED until the link is opened:
+// GET  /verify?token=<token> - verifies the email
+// POST /verify/resend        - mails a new verification link, body: {"email": "..."}
+// NOTE: Users created while the switch is off are not verified either, so turning the switch on
+// later requires them to verify their emails too (they can ask for a new link with /verify/resend).
+
+var myEmailVerificationRequired = util.MyConfig.GetBool("email_verification_required", false)
+var myEmailVerificationTtl = time.Duration(util.MyConfig.GetInt("email_verification_token_hours", 48)) * time.Hour
+
+type VerifyResponse struct {
+	Ret   string `json:"ret"`
+	Msg   string `json:"msg"`
+	Email string `json:"email,omitempty"`
+}
+
+func verificationMail(email string, token string) mail.Message {
+	link := util.MyConfig["email_verification_url"] + token
+	body := "Hello,\n\n" +
+		"Please verify your email " + email + " by opening the link below within " + strconv.Itoa(int(myEmailVerificationTtl.Hours())) + " hours:\n\n" +
+		link + "\n\n" +
+		"If you did not sign in, you can ignore this mail.\n"
+	return mail.Message{To: email, Subject: "Verify your email", Body: body}
+}
+
+// Mails a verification link to the user if the email is not verified yet.
+// NOTE: Both the token is created and the mail is sent in the background, so that the response time of /verify/resend
+// does not reveal which emails are registered. Failures are only logged: the user can ask for a new link with /verify/resend.
+func sendVerificationMail(ctx context.Context, email string) {
+	defer util.LogEnter().Exit()
+	mail.ComposeInBackground(ctx, func(ctx context.Context) (mail.Message, bool) {
+		token, ok, err := userdb.CreateEmailVerificationToken(ctx, email, myEmailVerificationTtl)
+		if err != nil {
+			util.LogError("Couldn't create email verification token for " + email + ": " + err.Error())
+		}
+		return verificationMail(email, token), ok && err == nil
+	})
+}
+
+func getVerify(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var errorResponse ErrorResponse
+	fieldErrors := FieldErrors{}
+	token := request.URL.Query().Get("token")
+	if request.Method != "GET" {
+		errorResponse = createErrorResponse(METHOD_NOT_ALLOWED, "Method not allowed: "+request.Method)
+	} else if token == "" {
+		fieldErrors.add("token", "is required")
+		errorResponse = createValidationErrorResponse(fieldErrors)
+	} else {
+		user, err := userdb.VerifyEmail(request.Context(), token)
+		if _, ok := err.(userdb.InvalidTokenError); ok {
+			fieldErrors.add("token", "is invalid or expired")
+			errorResponse = createValidationErrorResponse(fieldErrors)
+		} else if err != nil {
+			errorResponse = createErrorResponse(INTERNAL, err.Error())
+		} else {
+			auditEvent(request, user.Email, "EMAIL_VERIFIED", user.Email, audit.Success, "")
+			encoder := json.NewEncoder(writer)
+			encoder.SetEscapeHTML(false)
+			err := encoder.Encode(VerifyResponse{"ok", "Email verified, please log in", user.Email})
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, err.Error())
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
+
+// NOTE: Like /password/forgot the response does not reveal whether the email is registered or verified.
+func postVerifyResend(writer http.ResponseWriter, request *http.Request) {
+	defer util.LogEnter().Exit()
+	writeHeaders(writer)
+	if request.Method == "OPTIONS" {
+		return
+	}
+	var errorResponse ErrorResponse
+	var resendData PasswordForgotData
+	var fieldErrors FieldErrors
+	ip := clientIp(request)
+	if retryAfter := myLoginThrottle.AllowIp(ip); retryAfter > 0 {
+		errorResponse = createThrottledErrorResponse(TOO_MANY_REQUESTS, "Too many requests from "+ip, retryAfter)
+	} else {
+		fieldErrors, errorResponse = bindJson(writer, request, &resendData)
+	}
+	if !errorResponse.Flag {
+		email, errors := validateEmail(resendData.Email)
+		for _, msg := range errors {
+			fieldErrors.add("email", msg)
+		}
+		if len(fieldErrors) > 0 {
+			errorResponse = createValidationErrorResponse(fieldErrors)
+		} else {
+			sendVerificationMail(request.Context(), email)
+			encoder := json.NewEncoder(writer)
+			encoder.SetEscapeHTML(false)
+			err := encoder.Encode(VerifyResponse{"ok", "If the email is registered and not verified, a verification link has been sent to it", ""})
+			if err != nil {
+				errorResponse = createErrorResponse(INTERNAL, err.Error())
+			}
+		}
+	}
+	if errorResponse.Flag {
+		writeError(writer, request, errorResponse)
+	}
+}
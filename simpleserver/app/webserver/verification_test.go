@@ -0,0 +1,154 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/karimarttila/go/simpleserver/app/mail"
+	"github.com/karimarttila/go/simpleserver/app/userdb"
+	"github.com/karimarttila/go/simpleserver/app/util"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestEmailVerificationFlow(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousRequired := myEmailVerificationRequired
+	myEmailVerificationRequired = true
+	previousThrottle := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	myLoginThrottle.config.IpBurst = 100
+	defer func() {
+		myEmailVerificationRequired = previousRequired
+		myLoginThrottle = previousThrottle
+	}()
+	outbox := useTestOutbox(t)
+	email := "verification.flow.test@foo.com"
+	recorder, responseMap := doAuthorizedRequest(postSignin, "", "POST", "/signin",
+		`{"email":"`+email+`","first-name":"Verification","last-name":"Test","password":"VerificationPassword"}`)
+	if recorder.Code != http.StatusOK || responseMap["verification-required"] != true {
+		t.Fatalf("Signin failed: %d, %v", recorder.Code, responseMap)
+	}
+	recorder = postTestLogin(email, "VerificationPassword")
+	json.NewDecoder(recorder.Body).Decode(&responseMap)
+	if recorder.Code != http.StatusForbidden || responseMap["code"] != string(EMAIL_NOT_VERIFIED) {
+		t.Errorf("Unverified user should not have been able to log in, got: %d, %v", recorder.Code, responseMap)
+	}
+	// Wrong password does not reveal that the email is not verified.
+	if recorder = postTestLogin(email, "WRONG-PASSWORD"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong password should have got 401, got: %d", recorder.Code)
+	}
+	token := tokenFromOutbox(t, outbox, email, util.MyConfig["email_verification_url"])
+	recorder, responseMap = doAuthorizedRequest(getVerify, "", "GET", "/verify?token="+url.QueryEscape(token), "")
+	if recorder.Code != http.StatusOK || responseMap["email"] != email {
+		t.Fatalf("Verification failed: %d, %v", recorder.Code, responseMap)
+	}
+	loginTestToken(t, email, "VerificationPassword")
+	recorder, responseMap = doAuthorizedRequest(getVerify, "", "GET", "/verify?token="+url.QueryEscape(token), "")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Used token should have been rejected, got: %d, %v", recorder.Code, responseMap)
+	}
+	if recorder, _ = doAuthorizedRequest(getVerify, "", "GET", "/verify", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Missing token should have been rejected, got: %d", recorder.Code)
+	}
+}
+
+func TestVerifyResend(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previousRequired := myEmailVerificationRequired
+	myEmailVerificationRequired = false
+	previousThrottle := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	myLoginThrottle.config.IpBurst = 100
+	defer func() {
+		myEmailVerificationRequired = previousRequired
+		myLoginThrottle = previousThrottle
+	}()
+	outbox := useTestOutbox(t)
+	// The user signs in while verification is not required and is not mailed.
+	email := "verify.resend.test@foo.com"
+	recorder, responseMap := doAuthorizedRequest(postSignin, "", "POST", "/signin",
+		`{"email":"`+email+`","first-name":"Resend","last-name":"Test","password":"ResendPassword"}`)
+	if recorder.Code != http.StatusOK || responseMap["verification-required"] != nil {
+		t.Fatalf("Signin failed: %d, %v", recorder.Code, responseMap)
+	}
+	if messages := sentMails(t, outbox); len(messages) != 0 {
+		t.Errorf("No mail should have been sent, got: %v", messages)
+	}
+	loginTestToken(t, email, "ResendPassword")
+	// Turning the switch on requires verification.
+	myEmailVerificationRequired = true
+	if recorder = postTestLogin(email, "ResendPassword"); recorder.Code != http.StatusForbidden {
+		t.Errorf("Unverified user should not have been able to log in, got: %d", recorder.Code)
+	}
+	var msgs []interface{}
+	for _, resendEmail := range []string{email, "kari.karttinen@foo.com", "not.found@foo.com"} {
+		recorder, responseMap = doAuthorizedRequest(postVerifyResend, "", "POST", "/verify/resend", `{"email":"`+resendEmail+`"}`)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Resend to %s failed: %d", resendEmail, recorder.Code)
+		}
+		msgs = append(msgs, responseMap["msg"])
+	}
+	if msgs[0] != msgs[1] || msgs[1] != msgs[2] {
+		t.Errorf("All resend responses should have been the same, got: %v", msgs)
+	}
+	if messages := sentMails(t, outbox); len(messages) != 1 || messages[0].To != email {
+		t.Errorf("Only the unverified user should have got a mail, got: %v", messages)
+	}
+	token := tokenFromOutbox(t, outbox, email, util.MyConfig["email_verification_url"])
+	if recorder, _ = doAuthorizedRequest(getVerify, "", "GET", "/verify?token="+url.QueryEscape(token), ""); recorder.Code != http.StatusOK {
+		t.Errorf("Verification with the resent token failed: %d", recorder.Code)
+	}
+	loginTestToken(t, email, "ResendPassword")
+}
+
+func TestVerifyResendDoesNotWaitForMail(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	email := "verify.slow.mail.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Slow", "Mail", "SlowMailPassword")
+	mailer := blockingMailer{make(chan bool)}
+	previousMailer := mail.SetMailer(mailer)
+	defer mail.SetMailer(previousMailer)
+	// NOTE: The response would never come if the handler waited for the mail.
+	if recorder, responseMap := doAuthorizedRequest(postVerifyResend, "", "POST", "/verify/resend", `{"email":"`+email+`"}`); recorder.Code != http.StatusOK {
+		t.Errorf("Resend failed: %d, %v", recorder.Code, responseMap)
+	}
+	close(mailer.release)
+	mail.Wait()
+}
+
+// A user store whose email lookups wait until released, like a slow database.
+type blockingUserStore struct {
+	userdb.Store
+	release chan bool
+}
+
+func (store blockingUserStore) GetUserByEmail(ctx context.Context, email string) (userdb.User, bool, error) {
+	<-store.release
+	return store.Store.GetUserByEmail(ctx, email)
+}
+
+// Creating the token writes only for registered users, so also it must not delay the response.
+func TestVerifyResendDoesNotWaitForToken(t *testing.T) {
+	defer util.LogEnter().Exit()
+	previous := myLoginThrottle
+	myLoginThrottle, _ = newTestThrottle()
+	defer func() { myLoginThrottle = previous }()
+	outbox := useTestOutbox(t)
+	email := "verify.slow.token.test@foo.com"
+	userdb.AddUser(context.Background(), email, "Slow", "Token", "SlowTokenPassword")
+	store := blockingUserStore{userdb.SetStore(nil), make(chan bool)}
+	userdb.SetStore(store)
+	defer userdb.SetStore(store.Store)
+	// NOTE: The response would never come if the handler created the token before responding.
+	if recorder, responseMap := doAuthorizedRequest(postVerifyResend, "", "POST", "/verify/resend", `{"email":"`+email+`"}`); recorder.Code != http.StatusOK {
+		t.Errorf("Resend failed: %d, %v", recorder.Code, responseMap)
+	}
+	close(store.release)
+	if messages := sentMails(t, outbox); len(messages) != 1 || messages[0].To != email {
+		t.Errorf("Verification mail should have been sent, got: %v", messages)
+	}
+}